@@ -1,42 +1,91 @@
 package transit
 
 import (
+	"fmt"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/vault/helper/consts"
 	"github.com/hashicorp/vault/helper/keysutil"
 	"github.com/hashicorp/vault/logical"
 	"github.com/hashicorp/vault/logical/framework"
 )
 
+// defaultPolicyStoragePrefix is the storage prefix of policies unless the
+// policy_storage_prefix option is given when mounting the backend
+const defaultPolicyStoragePrefix = "policy/"
+
 func Factory(conf *logical.BackendConfig) (logical.Backend, error) {
 	b := Backend(conf)
+	if err := validatePolicyStoragePrefix(b.policyStoragePrefix); err != nil {
+		return nil, err
+	}
 	if err := b.Setup(conf); err != nil {
 		return nil, err
 	}
+
+	// Apply the configured cache size, if any
+	if conf.StorageView != nil {
+		if err := b.applyCacheConfig(conf.StorageView); err != nil {
+			return nil, err
+		}
+	}
 	return b, nil
 }
 
 func Backend(conf *logical.BackendConfig) *backend {
 	var b backend
+	b.policyStoragePrefix = policyStoragePrefix(conf.Config)
 	b.Backend = &framework.Backend{
 		PathsSpecial: &logical.Paths{
 			SealWrapStorage: []string{
 				"archive/",
-				"policy/",
+				b.policyStoragePrefix,
+				wrappingKeyStorageKey,
+				attestationKeyStorageKey,
 			},
 		},
 
 		Paths: []*framework.Path{
 			// Rotate/Config needs to come before Keys
 			// as the handler is greedy
+			b.pathConfigKeys(),
 			b.pathConfig(),
 			b.pathRotate(),
+			b.pathRotatePrefix(),
+			b.pathBatchDelete(),
+			b.pathTrim(),
+			b.pathKeyDelete(),
+			b.pathRename(),
+			b.pathClone(),
+			b.pathBackup(),
 			b.pathRewrap(),
+			b.pathRestore(),
+			b.pathImport(),
+			b.pathImportVersion(),
+			b.pathRekey(),
+			b.pathConvergentUpgrade(),
+			b.pathWrappingKey(),
+			b.pathAttestationKey(),
+			b.pathSelfTest(),
+			b.pathPublicKey(),
+			b.pathJWKS(),
+			b.pathKeyVersion(),
+			b.pathKeyVersions(),
+			b.pathDerivationVector(),
+			b.pathCachePreload(),
+			b.pathCacheStats(),
+			b.pathCacheConfig(),
+			b.pathCapabilities(),
 			b.pathKeys(),
 			b.pathListKeys(),
 			b.pathExportKeys(),
 			b.pathEncrypt(),
 			b.pathDecrypt(),
+			b.pathCiphertextInfo(),
 			b.pathDatakey(),
 			b.pathRandom(),
 			b.pathHash(),
@@ -45,12 +94,15 @@ func Backend(conf *logical.BackendConfig) *backend {
 			b.pathVerify(),
 		},
 
-		Secrets:     []*framework.Secret{},
-		Invalidate:  b.invalidate,
-		BackendType: logical.TypeLogical,
+		Secrets:      []*framework.Secret{},
+		Invalidate:   b.invalidate,
+		PeriodicFunc: b.periodicFunc,
+		BackendType:  logical.TypeLogical,
 	}
 
 	b.lm = keysutil.NewLockManager(conf.System.CachingDisabled())
+	b.rateLimiter = newKeyRateLimiter()
+	b.idempotency = newIdempotencyCache()
 
 	return &b
 }
@@ -58,6 +110,38 @@ func Backend(conf *logical.BackendConfig) *backend {
 type backend struct {
 	*framework.Backend
 	lm *keysutil.LockManager
+
+	// The storage prefix of policies, which is policy/ unless configured
+	// otherwise when mounting the backend
+	policyStoragePrefix string
+
+	// Guards generation of the key used to wrap imported key material
+	wrappingKeyLock sync.Mutex
+
+	// The key used to sign key attestations, cached once read from storage
+	attestationKey atomic.Value
+
+	// Held for reading by every request and for writing while the namespace
+	// prefix changes
+	namespaceLock sync.RWMutex
+
+	// The settings of the keys config used by most requests, loaded from
+	// storage on first use: the namespace prefix of key storage, and the
+	// length below which derivation contexts are warned about
+	namespacePrefix  string
+	minContextLength int
+	keysConfigLoaded bool
+	keysConfigLock   sync.Mutex
+
+	// Enforces the operation rate limits of keys
+	rateLimiter *keyRateLimiter
+
+	// Remembers the results of key creations by idempotency token
+	idempotency *idempotencyCache
+
+	// Set when the cache config has changed on another node, so that the
+	// next request applies it
+	cacheConfigChanged uint32
 }
 
 func (b *backend) invalidate(key string) {
@@ -65,8 +149,151 @@ func (b *backend) invalidate(key string) {
 		b.Logger().Trace("transit: invalidating key", "key", key)
 	}
 	switch {
-	case strings.HasPrefix(key, "policy/"):
-		name := strings.TrimPrefix(key, "policy/")
+	case strings.HasPrefix(key, b.policyStoragePrefix):
+		name := strings.TrimPrefix(key, b.policyStoragePrefix)
+		b.keysConfigLock.Lock()
+		if b.keysConfigLoaded && b.namespacePrefix != "" {
+			name = strings.TrimPrefix(name, b.namespacePrefix+"/")
+		}
+		b.keysConfigLock.Unlock()
+		// Key names cannot contain slashes, so what is left is a key of
+		// another namespace, which cannot be cached
+		if strings.Contains(name, "/") {
+			return
+		}
 		b.lm.InvalidatePolicy(name)
+		// The key may have been deleted or renamed on another node, so a
+		// later creation of it is not a retry
+		b.idempotency.forget(name)
+	case key == keysConfigStorageKey:
+		b.resetNamespacePrefix()
+	case key == attestationKeyStorageKey:
+		b.resetAttestationKey()
+	case key == cacheConfigStorageKey:
+		atomic.StoreUint32(&b.cacheConfigChanged, 1)
+	}
+}
+
+func (b *backend) periodicFunc(req *logical.Request) error {
+	// Rotation writes new key material, which is not possible on a
+	// performance secondary; it will receive the rotated keys from the primary
+	if b.System().ReplicationState().HasState(consts.ReplicationPerformanceSecondary) {
+		return nil
+	}
+
+	var errs *multierror.Error
+	if err := b.ensureAttestationKey(req.Storage); err != nil {
+		errs = multierror.Append(errs, fmt.Errorf("failed to generate attestation key: %v", err))
+	}
+
+	// The storage of the request is already scoped to the namespace by
+	// HandleRequest
+	if err := b.autoRotateKeys(req.Storage); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+	return errs.ErrorOrNil()
+}
+
+// policyStoragePrefix returns the storage prefix of policies given by the
+// mount options, with a trailing slash
+func policyStoragePrefix(config map[string]string) string {
+	prefix := strings.Trim(config["policy_storage_prefix"], "/")
+	if prefix == "" {
+		return defaultPolicyStoragePrefix
+	}
+	return prefix + "/"
+}
+
+// validatePolicyStoragePrefix checks that policies stored under the given
+// prefix cannot be confused with any other data of the backend
+func validatePolicyStoragePrefix(prefix string) error {
+	for _, reserved := range []string{"archive/", "config/", wrappingKeyStorageKey, attestationKeyStorageKey} {
+		if strings.HasPrefix(prefix, reserved) || strings.HasPrefix(reserved, prefix) {
+			return fmt.Errorf("policy storage prefix %q overlaps with the storage of %s", prefix, strings.TrimSuffix(reserved, "/"))
+		}
+	}
+	return nil
+}
+
+// autoRotateKeys rotates every key whose auto rotate period has elapsed since
+// the creation of its latest version. A failure to rotate one key does not
+// prevent the others from being rotated.
+func (b *backend) autoRotateKeys(storage logical.Storage) error {
+	names, err := storage.List("policy/")
+	if err != nil {
+		return err
+	}
+
+	var errs *multierror.Error
+	for _, name := range names {
+		if err := b.rotateIfRequired(storage, name); err != nil {
+			errs = multierror.Append(errs, fmt.Errorf("failed to auto rotate key %s: %v", name, err))
+		}
+	}
+
+	return errs.ErrorOrNil()
+}
+
+func (b *backend) rotateIfRequired(storage logical.Storage, name string) error {
+	// Check under a shared lock first so that keys which are not due for
+	// rotation do not block crypto operations
+	p, lock, err := b.lm.GetPolicyShared(storage, name)
+	if err != nil {
+		return err
+	}
+	if p == nil {
+		return nil
+	}
+	due := rotationDue(p)
+	lock.RUnlock()
+	if !due {
+		return nil
+	}
+
+	p, lock, err = b.lm.GetPolicyExclusive(storage, name)
+	if lock != nil {
+		defer lock.Unlock()
+	}
+	if err != nil {
+		return err
+	}
+	// Another rotation or deletion may have happened while the lock was given up
+	if p == nil || !rotationDue(p) {
+		return nil
+	}
+
+	if b.Logger().IsDebug() {
+		b.Logger().Debug("transit: automatically rotating key", "key", name)
+	}
+
+	return p.Rotate(storage)
+}
+
+func rotationDue(p *keysutil.Policy) bool {
+	if p.Imported && !p.AllowImportedKeyRotation {
+		return false
+	}
+	nextRotation := p.NextRotationTime()
+	return !nextRotation.IsZero() && !time.Now().Before(nextRotation)
+}
+
+// requireEnabledKey wraps the callback of a path that uses the key given by
+// its name field, failing the request if the key has been disabled. Keys that
+// do not exist are left to the callback, which may create them.
+func (b *backend) requireEnabledKey(callback framework.OperationFunc) framework.OperationFunc {
+	return func(req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+		name := d.Get("name").(string)
+		p, lock, err := b.lm.GetPolicyShared(req.Storage, name)
+		if lock != nil {
+			lock.RUnlock()
+		}
+		if err != nil {
+			return nil, err
+		}
+		if p != nil && p.Disabled {
+			return logical.ErrorResponse(fmt.Sprintf("key %s is disabled", name)), logical.ErrInvalidRequest
+		}
+
+		return callback(req, d)
 	}
 }
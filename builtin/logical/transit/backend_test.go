@@ -4,6 +4,7 @@ import (
 	"encoding/base64"
 	"fmt"
 	"math/rand"
+	"net/http"
 	"reflect"
 	"strconv"
 	"strings"
@@ -38,8 +39,47 @@ func createBackendWithStorage(t *testing.T) (*backend, logical.Storage) {
 	return b, config.StorageView
 }
 
+// mustHandle handles a request against the backend and fails the test if it
+// returns an error
+func mustHandle(t *testing.T, b logical.Backend, storage logical.Storage, op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+	return mustHandleRequest(t, b, &logical.Request{
+		Storage:   storage,
+		Operation: op,
+		Path:      path,
+		Data:      data,
+	})
+}
+
+// mustFail handles a request against the backend and fails the test unless
+// it returns an error
+func mustFail(t *testing.T, b logical.Backend, storage logical.Storage, op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+	return mustFailRequest(t, b, &logical.Request{
+		Storage:   storage,
+		Operation: op,
+		Path:      path,
+		Data:      data,
+	})
+}
+
+func mustHandleRequest(t *testing.T, b logical.Backend, req *logical.Request) *logical.Response {
+	resp, err := b.HandleRequest(req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("%s: got err:\n%#v\nresp:\n%#v\n", req.Path, err, resp)
+	}
+	return resp
+}
+
+func mustFailRequest(t *testing.T, b logical.Backend, req *logical.Request) *logical.Response {
+	resp, err := b.HandleRequest(req)
+	if err == nil && (resp == nil || !resp.IsError()) {
+		t.Fatalf("%s: expected error, got %#v", req.Path, resp)
+	}
+	return resp
+}
+
 func TestTransit_RSA(t *testing.T) {
 	testTransit_RSA(t, "rsa-2048")
+	testTransit_RSA(t, "rsa-3072")
 	testTransit_RSA(t, "rsa-4096")
 }
 
@@ -806,6 +846,190 @@ func testAccStepDecryptDatakey(t *testing.T, name string,
 	}
 }
 
+func TestTransit_ChaCha20(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	plaintext := "dGhlIHF1aWNrIGJyb3duIGZveA=="
+	context := "dGVzdGNvbnRleHQ="
+
+	req := &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "keys/chacha",
+		Data: map[string]interface{}{
+			"type": "chacha20-poly1305",
+		},
+	}
+	mustHandleRequest(t, b, req)
+	req.Path = "keys/chacha-derived"
+	req.Data["derived"] = true
+	mustHandleRequest(t, b, req)
+	req.Path = "keys/aes"
+	req.Data = nil
+	mustHandleRequest(t, b, req)
+
+	req.Operation = logical.ReadOperation
+	req.Path = "keys/chacha"
+	resp := mustHandleRequest(t, b, req)
+	if resp.Data["type"] != "chacha20-poly1305" {
+		t.Fatalf("bad key type: %#v", resp.Data["type"])
+	}
+
+	roundTrip := func(name string, data map[string]interface{}) string {
+		req.Operation = logical.UpdateOperation
+		req.Path = "encrypt/" + name
+		req.Data = map[string]interface{}{
+			"plaintext": plaintext,
+		}
+		for k, v := range data {
+			req.Data[k] = v
+		}
+		ciphertext := mustHandleRequest(t, b, req).Data["ciphertext"].(string)
+
+		req.Path = "decrypt/" + name
+		delete(req.Data, "plaintext")
+		req.Data["ciphertext"] = ciphertext
+		resp := mustHandleRequest(t, b, req)
+		if resp.Data["plaintext"] != plaintext {
+			t.Fatalf("%s: bad plaintext: %#v", name, resp.Data)
+		}
+		return ciphertext
+	}
+
+	chachaCiphertext := roundTrip("chacha", nil)
+	if !strings.HasPrefix(chachaCiphertext, "vault:cc:v1:") {
+		t.Fatalf("bad ciphertext prefix: %s", chachaCiphertext)
+	}
+	roundTrip("chacha-derived", map[string]interface{}{
+		"context": context,
+	})
+	aesCiphertext := roundTrip("aes", nil)
+
+	// Derived keys require a context
+	req.Path = "encrypt/chacha-derived"
+	req.Data = map[string]interface{}{
+		"plaintext": plaintext,
+	}
+	mustFailRequest(t, b, req)
+
+	// Ciphertext from one key type must not decrypt under the other
+	req.Path = "decrypt/chacha"
+	req.Data = map[string]interface{}{
+		"ciphertext": aesCiphertext,
+	}
+	mustFailRequest(t, b, req)
+	req.Path = "decrypt/aes"
+	req.Data = map[string]interface{}{
+		"ciphertext": chachaCiphertext,
+	}
+	mustFailRequest(t, b, req)
+
+	// Keys can also be created on upsert
+	req.Operation = logical.CreateOperation
+	req.Path = "encrypt/chacha-upsert"
+	req.Data = map[string]interface{}{
+		"plaintext": plaintext,
+		"type":      "chacha20-poly1305",
+	}
+	mustHandleRequest(t, b, req)
+	req.Operation = logical.ReadOperation
+	req.Path = "keys/chacha-upsert"
+	req.Data = nil
+	resp = mustHandleRequest(t, b, req)
+	if resp.Data["type"] != "chacha20-poly1305" {
+		t.Fatalf("bad upserted key type: %#v", resp.Data["type"])
+	}
+}
+
+func TestTransit_AES128(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	plaintext := "dGhlIHF1aWNrIGJyb3duIGZveA=="
+
+	req := &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "keys/aes128",
+		Data: map[string]interface{}{
+			"type":       "aes128-gcm96",
+			"exportable": true,
+		},
+	}
+	mustHandleRequest(t, b, req)
+	req.Path = "keys/aes128-derived"
+	req.Data["derived"] = true
+	mustHandleRequest(t, b, req)
+	req.Path = "keys/aes256"
+	req.Data = nil
+	mustHandleRequest(t, b, req)
+
+	req.Operation = logical.ReadOperation
+	req.Path = "keys/aes128"
+	resp := mustHandleRequest(t, b, req)
+	if resp.Data["type"] != "aes128-gcm96" {
+		t.Fatalf("bad key type: %#v", resp.Data["type"])
+	}
+
+	// Generated keys must be 128 bits
+	req.Path = "export/encryption-key/aes128/1"
+	resp = mustHandleRequest(t, b, req)
+	key, err := base64.StdEncoding.DecodeString(resp.Data["keys"].(map[string]string)["1"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(key) != 16 {
+		t.Fatalf("expected a 16 byte key, got %d bytes", len(key))
+	}
+
+	encrypt := func(name string, data map[string]interface{}) string {
+		req.Operation = logical.UpdateOperation
+		req.Path = "encrypt/" + name
+		req.Data = map[string]interface{}{
+			"plaintext": plaintext,
+		}
+		for k, v := range data {
+			req.Data[k] = v
+		}
+		return mustHandleRequest(t, b, req).Data["ciphertext"].(string)
+	}
+	decrypt := func(name, ciphertext string, data map[string]interface{}) {
+		req.Operation = logical.UpdateOperation
+		req.Path = "decrypt/" + name
+		req.Data = map[string]interface{}{
+			"ciphertext": ciphertext,
+		}
+		for k, v := range data {
+			req.Data[k] = v
+		}
+		resp := mustHandleRequest(t, b, req)
+		if resp.Data["plaintext"] != plaintext {
+			t.Fatalf("%s: bad plaintext: %#v", name, resp.Data)
+		}
+	}
+
+	aes128Ciphertext := encrypt("aes128", nil)
+	decrypt("aes128", aes128Ciphertext, nil)
+
+	derivedData := map[string]interface{}{
+		"context": "dGVzdGNvbnRleHQ=",
+	}
+	decrypt("aes128-derived", encrypt("aes128-derived", derivedData), derivedData)
+
+	aes256Ciphertext := encrypt("aes256", nil)
+
+	// Ciphertext must not be cross-decryptable between key sizes
+	req.Path = "decrypt/aes128"
+	req.Data = map[string]interface{}{
+		"ciphertext": aes256Ciphertext,
+	}
+	mustFailRequest(t, b, req)
+	req.Path = "decrypt/aes256"
+	req.Data = map[string]interface{}{
+		"ciphertext": aes128Ciphertext,
+	}
+	mustFailRequest(t, b, req)
+}
+
 func TestKeyUpgrade(t *testing.T) {
 	key, _ := uuid.GenerateRandomBytes(32)
 	p := &keysutil.Policy{
@@ -882,11 +1106,15 @@ func TestDerivedKeyUpgrade(t *testing.T) {
 }
 
 func TestConvergentEncryption(t *testing.T) {
-	testConvergentEncryptionCommon(t, 0)
-	testConvergentEncryptionCommon(t, 2)
+	testConvergentEncryptionCommon(t, 0, keysutil.KeyType_AES256_GCM96)
+	testConvergentEncryptionCommon(t, 2, keysutil.KeyType_AES256_GCM96)
+	testConvergentEncryptionCommon(t, 0, keysutil.KeyType_ChaCha20_Poly1305)
+	testConvergentEncryptionCommon(t, 2, keysutil.KeyType_ChaCha20_Poly1305)
+	testConvergentEncryptionCommon(t, 0, keysutil.KeyType_AES128_GCM96)
+	testConvergentEncryptionCommon(t, 2, keysutil.KeyType_AES128_GCM96)
 }
 
-func testConvergentEncryptionCommon(t *testing.T, ver int) {
+func testConvergentEncryptionCommon(t *testing.T, ver int, keyType keysutil.KeyType) {
 	var b *backend
 	sysView := logical.TestSystemView()
 	storage := &logical.InmemStorage{}
@@ -907,8 +1135,8 @@ func testConvergentEncryptionCommon(t *testing.T, ver int) {
 	}
 
 	resp, err := b.HandleRequest(req)
-	if err != nil {
-		t.Fatal(err)
+	if err != logical.ErrInvalidRequest {
+		t.Fatalf("expected invalid request error, got %v", err)
 	}
 	if resp == nil {
 		t.Fatal("expected non-nil response")
@@ -919,7 +1147,7 @@ func testConvergentEncryptionCommon(t *testing.T, ver int) {
 
 	p := &keysutil.Policy{
 		Name:                 "testkey",
-		Type:                 keysutil.KeyType_AES256_GCM96,
+		Type:                 keyType,
 		Derived:              true,
 		ConvergentEncryption: true,
 		ConvergentVersion:    ver,
@@ -1311,3 +1539,573 @@ func TestBadInput(t *testing.T) {
 		t.Fatal("expected error")
 	}
 }
+
+func TestTransit_AutoRotateKeys(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	// Keys are rotated within the namespace, whose storage is scoped by
+	// HandleRequest as it is for any other request
+	mustHandle(t, b, storage, logical.UpdateOperation, "config/keys", map[string]interface{}{
+		"namespace_prefix": "tenant",
+	})
+	scoped, err := b.scopedStorage(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rollback := func() {
+		_, err := b.HandleRequest(&logical.Request{
+			Storage:   storage,
+			Operation: logical.RollbackOperation,
+		})
+		// The backend has no WAL rollback, which the rollback manager
+		// ignores
+		if err != nil && err != logical.ErrUnsupportedOperation {
+			t.Fatal(err)
+		}
+	}
+
+	for name, period := range map[string]string{"due": "1h", "notdue": "24h", "disabled": "0"} {
+		resp, err := b.HandleRequest(&logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "keys/" + name,
+			Data: map[string]interface{}{
+				"auto_rotate_period": period,
+			},
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err: %v, resp: %#v", err, resp)
+		}
+	}
+
+	// Age the first version of every key by two hours
+	for _, name := range []string{"due", "notdue", "disabled"} {
+		p, lock, err := b.lm.GetPolicyExclusive(scoped, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry := p.Keys[1]
+		entry.CreationTime = entry.CreationTime.Add(-2 * time.Hour)
+		entry.DeprecatedCreationTime = entry.CreationTime.Unix()
+		p.Keys[1] = entry
+		if err := p.Persist(scoped); err != nil {
+			t.Fatal(err)
+		}
+		lock.Unlock()
+	}
+
+	rollback()
+
+	expected := map[string]int{"due": 2, "notdue": 1, "disabled": 1}
+	for name, version := range expected {
+		p, lock, err := b.lm.GetPolicyShared(scoped, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.LatestVersion != version {
+			t.Fatalf("expected key %s to be at version %d, got %d", name, version, p.LatestVersion)
+		}
+		lock.RUnlock()
+	}
+
+	// The freshly rotated key should not be rotated again
+	rollback()
+	p, lock, err := b.lm.GetPolicyShared(scoped, "due")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.RUnlock()
+	if p.LatestVersion != 2 {
+		t.Fatalf("expected key to remain at version 2, got %d", p.LatestVersion)
+	}
+}
+
+func TestTransit_PolicyStoragePrefix(t *testing.T) {
+	config := logical.TestBackendConfig()
+	config.StorageView = &logical.InmemStorage{}
+	config.Config = map[string]string{
+		"policy_storage_prefix": "shared/transit/",
+	}
+	b := Backend(config)
+	if err := b.Backend.Setup(config); err != nil {
+		t.Fatal(err)
+	}
+	storage := config.StorageView
+
+	// Data of others sharing the mount is left alone
+	if err := storage.Put(&logical.StorageEntry{Key: "shared/other", Value: []byte("other")}); err != nil {
+		t.Fatal(err)
+	}
+
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo", nil)
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/bar", nil)
+	if keys := mustHandle(t, b, storage, logical.ListOperation, "keys", nil).Data["keys"].([]string); !reflect.DeepEqual(keys, []string{"bar", "foo"}) {
+		t.Fatalf("bad keys: %v", keys)
+	}
+	if resp := mustHandle(t, b, storage, logical.ReadOperation, "keys/foo", nil); resp == nil || resp.Data["name"] != "foo" {
+		t.Fatalf("bad read: %#v", resp)
+	}
+
+	for key, exists := range map[string]bool{
+		"shared/transit/foo": true,
+		"archive/foo":        true,
+		"policy/foo":         false,
+	} {
+		entry, err := storage.Get(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (entry != nil) != exists {
+			t.Fatalf("expected %s to exist: %t", key, exists)
+		}
+	}
+
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo/config", map[string]interface{}{
+		"deletion_allowed": true,
+	})
+	mustHandle(t, b, storage, logical.DeleteOperation, "keys/foo", nil)
+	if entry, err := storage.Get("shared/transit/foo"); err != nil || entry != nil {
+		t.Fatalf("expected key to be deleted, got %#v (err: %v)", entry, err)
+	}
+	if keys := mustHandle(t, b, storage, logical.ListOperation, "keys", nil).Data["keys"].([]string); !reflect.DeepEqual(keys, []string{"bar"}) {
+		t.Fatalf("bad keys after delete: %v", keys)
+	}
+	if entry, err := storage.Get("shared/other"); err != nil || entry == nil {
+		t.Fatalf("expected other data to remain, got %#v (err: %v)", entry, err)
+	}
+
+	if prefix := policyStoragePrefix(nil); prefix != "policy/" {
+		t.Fatalf("bad default prefix: %s", prefix)
+	}
+	for _, prefix := range []string{"archive", "config/keys", "wrapping_key"} {
+		config.Config["policy_storage_prefix"] = prefix
+		if _, err := Factory(config); err == nil {
+			t.Fatalf("expected prefix %s to be rejected", prefix)
+		}
+	}
+}
+
+// testManagedKeys is a managed key backend that "encrypts" by prefixing the
+// plaintext with the key name and "signs" by prefixing the input, recording
+// the operations it performs
+type testManagedKeys struct {
+	operations []string
+}
+
+func (m *testManagedKeys) Encrypt(keyName string, plaintext []byte) ([]byte, error) {
+	m.operations = append(m.operations, "encrypt:"+keyName)
+	return append([]byte(keyName+":"), plaintext...), nil
+}
+
+func (m *testManagedKeys) Decrypt(keyName string, ciphertext []byte) ([]byte, error) {
+	m.operations = append(m.operations, "decrypt:"+keyName)
+	if !strings.HasPrefix(string(ciphertext), keyName+":") {
+		return nil, fmt.Errorf("ciphertext was not produced by %s", keyName)
+	}
+	return ciphertext[len(keyName)+1:], nil
+}
+
+func (m *testManagedKeys) Sign(keyName string, input []byte, algorithm string) ([]byte, error) {
+	m.operations = append(m.operations, "sign:"+keyName)
+	return append([]byte(keyName+":"), input...), nil
+}
+
+func (m *testManagedKeys) Verify(keyName string, input, sig []byte, algorithm string) (bool, error) {
+	m.operations = append(m.operations, "verify:"+keyName)
+	return string(sig) == keyName+":"+string(input), nil
+}
+
+// createManagedKey creates a managed key through the lock manager, since the
+// key paths do not take managed_key_name until a managed key backend can be
+// configured for a mount
+func createManagedKey(b *backend, storage logical.Storage, name string, keyType keysutil.KeyType, managedKeyName string) error {
+	return upsertPolicy(b, keysutil.PolicyRequest{
+		Storage:        storage,
+		Name:           name,
+		KeyType:        keyType,
+		ManagedKeyName: managedKeyName,
+	})
+}
+
+func upsertPolicy(b *backend, req keysutil.PolicyRequest) error {
+	p, lock, _, err := b.lm.GetPolicyUpsert(req)
+	if lock != nil {
+		lock.RUnlock()
+	}
+	if err != nil {
+		return err
+	}
+	if p == nil {
+		return fmt.Errorf("key %s was not created", req.Name)
+	}
+	return nil
+}
+
+func TestTransit_ManagedKey(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+	managedKeys := &testManagedKeys{}
+	b.lm.SetManagedKeyBackend(managedKeys)
+
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/local", nil)
+	if err := createManagedKey(b, storage, "hsm-aes", keysutil.KeyType_AES256_GCM96, "hsm-aes-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := createManagedKey(b, storage, "hsm-ecdsa", keysutil.KeyType_ECDSA_P256, "hsm-ecdsa-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	resp := mustHandle(t, b, storage, logical.ReadOperation, "keys/hsm-aes", nil)
+	if resp.Data["managed"] != true || resp.Data["managed_key_name"] != "hsm-aes-1" || resp.Data["latest_version"] != 1 {
+		t.Fatalf("bad managed key: %#v", resp.Data)
+	}
+	resp = mustHandle(t, b, storage, logical.ReadOperation, "keys/local", nil)
+	if _, ok := resp.Data["managed_key_name"]; ok || resp.Data["managed"] != false {
+		t.Fatalf("bad local key: %#v", resp.Data)
+	}
+
+	// No key material is held locally
+	p, lock, err := b.lm.GetPolicyShared(storage, "hsm-aes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry := p.Keys[1]; entry.Key != nil || entry.HMACKey != nil {
+		t.Fatalf("expected no local key material: %#v", entry)
+	}
+	lock.RUnlock()
+
+	// Operations are routed to the managed key backend
+	plaintext := base64.StdEncoding.EncodeToString([]byte(testPlaintext))
+	resp = mustHandle(t, b, storage, logical.UpdateOperation, "encrypt/hsm-aes", map[string]interface{}{
+		"plaintext": plaintext,
+	})
+	ciphertext := resp.Data["ciphertext"].(string)
+	if ciphertext != "vault:v1:"+base64.StdEncoding.EncodeToString([]byte("hsm-aes-1:"+testPlaintext)) {
+		t.Fatalf("ciphertext not produced by the managed key backend: %s", ciphertext)
+	}
+	resp = mustHandle(t, b, storage, logical.UpdateOperation, "decrypt/hsm-aes", map[string]interface{}{
+		"ciphertext": ciphertext,
+	})
+	if resp.Data["plaintext"] != plaintext {
+		t.Fatalf("bad plaintext: %#v", resp.Data)
+	}
+
+	input := base64.StdEncoding.EncodeToString([]byte("signed input"))
+	resp = mustHandle(t, b, storage, logical.UpdateOperation, "sign/hsm-ecdsa", map[string]interface{}{
+		"input": input,
+	})
+	signature := resp.Data["signature"].(string)
+	resp = mustHandle(t, b, storage, logical.UpdateOperation, "verify/hsm-ecdsa", map[string]interface{}{
+		"input":     input,
+		"signature": signature,
+	})
+	if resp.Data["valid"] != true {
+		t.Fatalf("expected signature to verify: %#v", resp.Data)
+	}
+
+	// Operations with local keys are not routed
+	mustHandle(t, b, storage, logical.UpdateOperation, "encrypt/local", map[string]interface{}{
+		"plaintext": plaintext,
+	})
+	expected := []string{"encrypt:hsm-aes-1", "decrypt:hsm-aes-1", "sign:hsm-ecdsa-1", "verify:hsm-ecdsa-1"}
+	if !reflect.DeepEqual(managedKeys.operations, expected) {
+		t.Fatalf("expected operations %v, got %v", expected, managedKeys.operations)
+	}
+
+	// Nothing can generate or reveal local key material
+	mustFail(t, b, storage, logical.UpdateOperation, "keys/hsm-aes/rotate", nil)
+	mustFail(t, b, storage, logical.UpdateOperation, "keys/hsm-aes/config", map[string]interface{}{
+		"exportable": true,
+	})
+	mustFail(t, b, storage, logical.UpdateOperation, "keys/hsm-aes/config", map[string]interface{}{
+		"allow_plaintext_backup": true,
+	})
+	mustFail(t, b, storage, logical.UpdateOperation, "keys/hsm-aes/config", map[string]interface{}{
+		"auto_rotate_period": 3600,
+	})
+	for _, req := range []keysutil.PolicyRequest{
+		{Derived: true},
+		{Exportable: true},
+		{AllowedExportVersions: []int{1}},
+		{AllowPlaintextBackup: true},
+		{NumVersions: 2},
+		{AutoRotatePeriod: time.Hour},
+	} {
+		req.Storage = storage
+		req.Name = "hsm-other"
+		req.ManagedKeyName = "hsm-other"
+		if err := upsertPolicy(b, req); err == nil {
+			t.Fatalf("expected managed key request %#v to be rejected", req)
+		}
+	}
+	if resp := mustHandle(t, b, storage, logical.ReadOperation, "keys/hsm-other", nil); resp != nil {
+		t.Fatalf("expected no key to be created, got %#v", resp)
+	}
+	if resp := mustHandle(t, b, storage, logical.ReadOperation, "keys/hsm-aes", nil); resp.Data["latest_version"] != 1 {
+		t.Fatalf("managed key was rotated: %#v", resp.Data)
+	}
+
+	// Without a managed key backend, operations with managed keys fail
+	// rather than falling back to local material
+	b.lm.SetManagedKeyBackend(nil)
+	b.lm.InvalidateAllPolicies()
+	mustFail(t, b, storage, logical.UpdateOperation, "encrypt/hsm-aes", map[string]interface{}{
+		"plaintext": plaintext,
+	})
+}
+
+func TestTransit_ManagedKeyNotCreatable(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	// Managed keys cannot be created without a managed key backend
+	err := createManagedKey(b, storage, "hsm", keysutil.KeyType_AES256_GCM96, "hsm-1")
+	if err == nil || !strings.Contains(err.Error(), "no managed key backend is configured") {
+		t.Fatalf("expected managed key to be rejected, got %v", err)
+	}
+	if resp := mustHandle(t, b, storage, logical.ReadOperation, "keys/hsm", nil); resp != nil {
+		t.Fatalf("expected no key to be created, got %#v", resp)
+	}
+
+	// The key paths do not take a managed key name, so keys created through
+	// them hold their own key material
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/local", map[string]interface{}{
+		"managed_key_name": "hsm-1",
+	})
+	resp := mustHandle(t, b, storage, logical.ReadOperation, "keys/local", nil)
+	if _, ok := resp.Data["managed_key_name"]; ok || resp.Data["managed"] != false {
+		t.Fatalf("bad local key: %#v", resp.Data)
+	}
+}
+
+func TestTransit_ManagedKeyPublicKey(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	// The public keys of managed asymmetric keys are held by the managed key
+	// backend, so reads that would return them fail instead of using the
+	// missing local material
+	b.lm.SetManagedKeyBackend(&testManagedKeys{})
+	for _, keyType := range []keysutil.KeyType{keysutil.KeyType_RSA2048, keysutil.KeyType_ECDSA_P256, keysutil.KeyType_ED25519} {
+		name := "hsm-" + keyType.String()
+		if err := createManagedKey(b, storage, name, keyType, name+"-1"); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, path := range []string{"keys/" + name + "/public", "keys/" + name + "/version/1"} {
+			resp, err := b.HandleRequest(&logical.Request{
+				Storage:   storage,
+				Operation: logical.ReadOperation,
+				Path:      path,
+			})
+			if err != logical.ErrInvalidRequest || resp == nil || !strings.Contains(resp.Data["error"].(string), "must be read from the managed key backend") {
+				t.Fatalf("%s: expected managed public key error, got %#v (err: %v)", path, resp, err)
+			}
+		}
+
+		keys := mustHandle(t, b, storage, logical.ReadOperation, "keys/"+name, nil).Data["keys"].(map[string]map[string]interface{})
+		if _, ok := keys["1"]["public_key"]; ok {
+			t.Fatalf("%s: expected no public key in the key read: %#v", name, keys)
+		}
+	}
+}
+
+func TestTransit_ManagedKeyNotExportable(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+	b.lm.SetManagedKeyBackend(&testManagedKeys{})
+
+	if err := createManagedKey(b, storage, "hsm-aes", keysutil.KeyType_AES256_GCM96, "hsm-aes-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Even if the stored key claims otherwise, reads never report a managed
+	// key as exportable
+	p, lock, err := b.lm.GetPolicyExclusive(storage, "hsm-aes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Exportable = true
+	p.AllowedExportVersions = []int{1}
+	lock.Unlock()
+
+	resp, err := b.HandleRequest(&logical.Request{
+		Storage:   storage,
+		Operation: logical.ReadOperation,
+		Path:      "keys/hsm-aes",
+	})
+	if err != nil || resp == nil {
+		t.Fatalf("got err: %v resp: %#v", err, resp)
+	}
+	if resp.Data["exportable"] != false || len(resp.Data["exportable_versions"].([]int)) != 0 {
+		t.Fatalf("managed key reported as exportable: %#v", resp.Data)
+	}
+}
+
+func TestTransit_CreateKeyIdempotencyToken(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	now := time.Now()
+	b.idempotency.now = func() time.Time {
+		return now
+	}
+
+	create := func(token string) *logical.Response {
+		data := map[string]interface{}{}
+		if token != "" {
+			data["idempotency_token"] = token
+		}
+		return mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo", data)
+	}
+	existed := func(resp *logical.Response) bool {
+		return resp != nil && len(resp.Warnings) == 1 && strings.Contains(resp.Warnings[0], "already existed")
+	}
+
+	if resp := create("abc"); resp != nil {
+		t.Fatalf("unexpected response on creation: %#v", resp)
+	}
+
+	// A retry gets the original result, while other requests find the key
+	// already existing
+	if resp := create("abc"); resp != nil {
+		t.Fatalf("expected the original result on retry, got %#v", resp)
+	}
+	if resp := create("def"); !existed(resp) {
+		t.Fatalf("expected an existing key with a new token, got %#v", resp)
+	}
+	if resp := create(""); !existed(resp) {
+		t.Fatalf("expected an existing key without a token, got %#v", resp)
+	}
+
+	// The original result of the second token is that the key existed
+	if resp := create("def"); !existed(resp) {
+		t.Fatalf("expected the original result on retry, got %#v", resp)
+	}
+
+	now = now.Add(idempotencyTokenTTL)
+	if resp := create("abc"); !existed(resp) {
+		t.Fatalf("expected the token to have expired, got %#v", resp)
+	}
+
+	// A key created again after deletion is not a retry
+	create("ghi")
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo/config", map[string]interface{}{
+		"deletion_allowed": true,
+	})
+	mustHandle(t, b, storage, logical.DeleteOperation, "keys/foo", nil)
+	create("ghi")
+	if resp := mustHandle(t, b, storage, logical.ReadOperation, "keys/foo", nil); resp == nil || resp.Data["deletion_allowed"] != false {
+		t.Fatalf("expected the key to be created again, got %#v", resp)
+	}
+
+	// Reusing a token with other parameters is a conflict, while the
+	// parameters given as their defaults are the same
+	req := &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "keys/foo",
+		Data: map[string]interface{}{
+			"idempotency_token": "ghi",
+			"exportable":        true,
+		},
+	}
+	resp, err := b.HandleRequest(req)
+	if status, _ := logical.RespondErrorCommon(req, resp, err); status != http.StatusConflict {
+		t.Fatalf("expected a conflict, got status %d: %#v (err: %v)", status, resp, err)
+	}
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo", map[string]interface{}{
+		"idempotency_token": "ghi",
+		"exportable":        false,
+		"type":              "aes256-gcm96",
+	})
+
+	// Tokens are forgotten when the key changes on another node
+	b.invalidate("policy/foo")
+	if resp := create("ghi"); !existed(resp) {
+		t.Fatalf("expected the token to be forgotten on invalidation, got %#v", resp)
+	}
+}
+
+func TestTransit_IdempotencyCacheExpiry(t *testing.T) {
+	c := newIdempotencyCache()
+	now := time.Now()
+	c.now = func() time.Time {
+		return now
+	}
+
+	c.put("foo", "a", "hash", true, nil)
+	now = now.Add(idempotencyTokenTTL / 2)
+	c.put("bar", "b", "hash", true, nil)
+	// Replacing a result pushes back its expiry
+	c.put("foo", "a", "hash", false, nil)
+	c.forget("bar")
+	c.put("bar", "b", "hash", true, nil)
+
+	now = now.Add(idempotencyTokenTTL / 2)
+	for _, key := range []idempotencyKey{{"foo", "a"}, {"bar", "b"}} {
+		if result, err := c.get(key.name, key.token, "hash"); err != nil || result == nil {
+			t.Fatalf("expected %v to be remembered, got %#v (err: %v)", key, result, err)
+		}
+	}
+	if result, _ := c.get("foo", "a", "hash"); result.created {
+		t.Fatal("expected the replaced result")
+	}
+	if _, err := c.get("foo", "a", "other"); err != errIdempotencyMismatch {
+		t.Fatalf("expected a mismatch, got %v", err)
+	}
+
+	now = now.Add(idempotencyTokenTTL / 2)
+	if result, err := c.get("foo", "a", "hash"); err != nil || result != nil {
+		t.Fatalf("expected the result to expire, got %#v (err: %v)", result, err)
+	}
+	if len(c.results) != 0 || len(c.expiries) != 0 {
+		t.Fatalf("expected every result to expire: %#v %#v", c.results, c.expiries)
+	}
+}
+
+func TestTransit_CreateKeyNameValidation(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	write := func(name string) (*logical.Response, error) {
+		return b.pathPolicyWrite(&logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "keys/" + name,
+		}, &framework.FieldData{
+			Raw: map[string]interface{}{
+				"name": name,
+			},
+			Schema: b.pathKeys().Fields,
+		})
+	}
+
+	for name, expected := range map[string]string{
+		"":         "missing key name",
+		"foo/bar":  "path separators",
+		"../foo":   "path separators",
+		`foo\bar`:  "path separators",
+		"foo\nbar": "control characters",
+		"foo\x00":  "control characters",
+		"foo\x7f":  "control characters",
+	} {
+		resp, err := write(name)
+		if err != logical.ErrInvalidRequest || resp == nil || !strings.Contains(resp.Data["error"].(string), expected) {
+			t.Fatalf("%q: expected error containing %q, got %#v (err: %v)", name, expected, resp, err)
+		}
+	}
+	entries, err := storage.List("policy/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("expected no keys to be stored, got %v", entries)
+	}
+
+	// Names allowed by the path pattern are still accepted
+	for _, name := range []string{"a", "foo", "foo-bar", "foo.bar", "foo_bar", "Foo1"} {
+		resp, err := b.HandleRequest(&logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "keys/" + name,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("%q: err: %v\nresp: %#v", name, err, resp)
+		}
+	}
+}
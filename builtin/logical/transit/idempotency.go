@@ -0,0 +1,199 @@
+package transit
+
+import (
+	"container/heap"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+// idempotencyTokenTTL is how long the result of a key creation is remembered
+// for its idempotency token
+const idempotencyTokenTTL = 10 * time.Minute
+
+// errIdempotencyMismatch is returned when an idempotency token is reused for
+// a key creation with different parameters
+var errIdempotencyMismatch = errors.New("idempotency token was already used with different parameters")
+
+// idempotencyCache remembers the results of key creations by name and
+// idempotency token, so that a retried creation returns the original result.
+// Results are kept in memory only, so they are forgotten on restart and are
+// not shared between nodes.
+type idempotencyCache struct {
+	lock    sync.Mutex
+	results map[idempotencyKey]idempotentResult
+
+	// The remembered results ordered by expiry, so that expired results can
+	// be dropped without going through all of them
+	expiries idempotencyExpiries
+
+	// Returns the current time; replaced in tests
+	now func() time.Time
+}
+
+type idempotencyKey struct {
+	name  string
+	token string
+}
+
+// idempotentResult is the outcome of a successful key creation, stored as
+// its warnings rather than the response itself so that every retry gets a
+// fresh response, along with a hash of the parameters of the creation
+type idempotentResult struct {
+	paramsHash string
+	created    bool
+	warnings   []string
+	expires    time.Time
+}
+
+func newIdempotencyCache() *idempotencyCache {
+	return &idempotencyCache{
+		results: map[idempotencyKey]idempotentResult{},
+		now:     time.Now,
+	}
+}
+
+// get returns the result originally returned for the token, or nil if the
+// token was not seen for the named key within the TTL. It fails with
+// errIdempotencyMismatch if the token was seen with different parameters.
+func (c *idempotencyCache) get(name, token, paramsHash string) (*idempotentResult, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.expireLocked()
+
+	result, ok := c.results[idempotencyKey{name: name, token: token}]
+	if !ok {
+		return nil, nil
+	}
+	if result.paramsHash != paramsHash {
+		return nil, errIdempotencyMismatch
+	}
+	return &result, nil
+}
+
+// response returns a fresh copy of the response of the creation
+func (r *idempotentResult) response() *logical.Response {
+	if len(r.warnings) == 0 {
+		return nil
+	}
+
+	resp := &logical.Response{}
+	for _, warning := range r.warnings {
+		resp.AddWarning(warning)
+	}
+	return resp
+}
+
+// put remembers the result returned for the token, dropping any results that
+// have expired
+func (c *idempotencyCache) put(name, token, paramsHash string, created bool, resp *logical.Response) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.expireLocked()
+
+	key := idempotencyKey{name: name, token: token}
+	result := idempotentResult{
+		paramsHash: paramsHash,
+		created:    created,
+		expires:    c.now().Add(idempotencyTokenTTL),
+	}
+	if resp != nil {
+		result.warnings = append(result.warnings, resp.Warnings...)
+	}
+	c.results[key] = result
+	heap.Push(&c.expiries, idempotencyExpiry{key: key, expires: result.expires})
+}
+
+// expireLocked drops the results whose TTL has passed. The lock must be
+// held.
+func (c *idempotencyCache) expireLocked() {
+	now := c.now()
+	for len(c.expiries) != 0 && !now.Before(c.expiries[0].expires) {
+		expiry := heap.Pop(&c.expiries).(idempotencyExpiry)
+		// The result may have been forgotten or replaced since
+		if result, ok := c.results[expiry.key]; ok && result.expires.Equal(expiry.expires) {
+			delete(c.results, expiry.key)
+		}
+	}
+}
+
+// forget drops the results remembered for the named key, so that a key
+// created again after being deleted or renamed is not mistaken for a retry
+func (c *idempotencyCache) forget(name string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for key := range c.results {
+		if key.name == name {
+			delete(c.results, key)
+		}
+	}
+}
+
+// reset drops all remembered results
+func (c *idempotencyCache) reset() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.results = map[idempotencyKey]idempotentResult{}
+	c.expiries = nil
+}
+
+type idempotencyExpiry struct {
+	key     idempotencyKey
+	expires time.Time
+}
+
+// idempotencyExpiries is a heap of results ordered by expiry
+type idempotencyExpiries []idempotencyExpiry
+
+func (h idempotencyExpiries) Len() int           { return len(h) }
+func (h idempotencyExpiries) Less(i, j int) bool { return h[i].expires.Before(h[j].expires) }
+func (h idempotencyExpiries) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *idempotencyExpiries) Push(x interface{}) {
+	*h = append(*h, x.(idempotencyExpiry))
+}
+
+func (h *idempotencyExpiries) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
+
+// idempotencyParamsHash returns a hash of the parameters of a key creation
+// other than its idempotency token. Fields are hashed as parsed, so that
+// omitting a parameter is the same as giving its default.
+func idempotencyParamsHash(d *framework.FieldData) (string, error) {
+	fields := make([]string, 0, len(d.Schema))
+	for field := range d.Schema {
+		if field == "idempotency_token" || field == "batch_input" {
+			continue
+		}
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	params := make([]interface{}, 0, 2*len(fields))
+	for _, field := range fields {
+		params = append(params, field, d.Get(field))
+	}
+	encoded, err := json.Marshal(params)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode parameters: %v", err)
+	}
+	hash := sha256.Sum256(encoded)
+	return hex.EncodeToString(hash[:]), nil
+}
@@ -0,0 +1,201 @@
+package transit
+
+import (
+	"strings"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+// namespacedPrefixes are the storage prefixes holding key data, which are
+// isolated per namespace
+var namespacedPrefixes = []string{"policy/", "archive/"}
+
+// HandleRequest routes the request with storage scoped to the configured
+// namespace prefix, so that handlers and the lock manager work with plain key
+// names while the keys of each namespace are stored separately. Requests hold
+// the namespace lock until they are done, so that no request can cache a
+// policy of the previous namespace once it has been changed.
+func (b *backend) HandleRequest(req *logical.Request) (*logical.Response, error) {
+	if req.Storage == nil {
+		return b.Backend.HandleRequest(req)
+	}
+
+	if isKeysConfigWrite(req) {
+		b.namespaceLock.Lock()
+		defer b.namespaceLock.Unlock()
+	} else {
+		b.namespaceLock.RLock()
+		defer b.namespaceLock.RUnlock()
+	}
+
+	if err := b.applyChangedCacheConfig(req.Storage); err != nil {
+		return nil, err
+	}
+
+	scoped, err := b.scopedStorage(req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	storage := req.Storage
+	req.Storage = scoped
+	defer func() {
+		req.Storage = storage
+	}()
+
+	return b.Backend.HandleRequest(req)
+}
+
+// scopedStorage returns the given storage with keys placed under the policy
+// storage prefix and the namespace prefix
+func (b *backend) scopedStorage(storage logical.Storage) (logical.Storage, error) {
+	prefix, err := b.getNamespacePrefix(storage)
+	if err != nil {
+		return nil, err
+	}
+
+	return &namespacedStorage{
+		Storage:      storage,
+		prefix:       prefix,
+		policyPrefix: b.policyStoragePrefix,
+	}, nil
+}
+
+// isKeysConfigWrite returns whether the request may change the namespace
+// prefix
+func isKeysConfigWrite(req *logical.Request) bool {
+	if req.Path != "config/keys" {
+		return false
+	}
+	return req.Operation == logical.UpdateOperation || req.Operation == logical.CreateOperation
+}
+
+// getNamespacePrefix returns the namespace prefix from the keys config,
+// reading it from storage only the first time
+func (b *backend) getNamespacePrefix(storage logical.Storage) (string, error) {
+	b.keysConfigLock.Lock()
+	defer b.keysConfigLock.Unlock()
+	if err := b.loadKeysConfigLocked(storage); err != nil {
+		return "", err
+	}
+	return b.namespacePrefix, nil
+}
+
+// getMinContextLength returns the minimum context length from the keys
+// config, reading it from storage only the first time
+func (b *backend) getMinContextLength(storage logical.Storage) (int, error) {
+	b.keysConfigLock.Lock()
+	defer b.keysConfigLock.Unlock()
+	if err := b.loadKeysConfigLocked(storage); err != nil {
+		return 0, err
+	}
+	return b.minContextLength, nil
+}
+
+// loadKeysConfigLocked caches the settings of the keys config used by most
+// requests unless they are cached already. The keys config lock must be held.
+func (b *backend) loadKeysConfigLocked(storage logical.Storage) error {
+	if b.keysConfigLoaded {
+		return nil
+	}
+
+	config, err := b.readKeysConfig(storage)
+	if err != nil {
+		return err
+	}
+	b.namespacePrefix = config.NamespacePrefix
+	b.minContextLength = config.minContextLength()
+	b.keysConfigLoaded = true
+	return nil
+}
+
+// resetKeysConfig forces the cached settings of the keys config to be read
+// again
+func (b *backend) resetKeysConfig() {
+	b.keysConfigLock.Lock()
+	defer b.keysConfigLock.Unlock()
+	b.keysConfigLoaded = false
+}
+
+// resetNamespacePrefix forces the namespace prefix to be read again once the
+// requests in flight, which may use the previous namespace, are done
+func (b *backend) resetNamespacePrefix() {
+	b.namespaceLock.Lock()
+	defer b.namespaceLock.Unlock()
+	b.resetNamespacePrefixLocked()
+}
+
+// resetNamespacePrefixLocked forces the keys config, including the namespace
+// prefix, to be read again. Cached policies belong to the previous namespace, so they are dropped as
+// well. The namespace lock must be held for writing.
+func (b *backend) resetNamespacePrefixLocked() {
+	b.keysConfigLock.Lock()
+	defer b.keysConfigLock.Unlock()
+	b.keysConfigLoaded = false
+	b.lm.InvalidateAllPolicies()
+	b.idempotency.reset()
+}
+
+// namespacedStorage places key data under the namespace prefix, so that for
+// instance policy/foo is stored at policy/<prefix>/foo. Other entries, such as
+// the backend configuration, are shared by all namespaces. Policies are stored
+// under the policy storage prefix instead of policy/ if one is configured.
+type namespacedStorage struct {
+	logical.Storage
+	prefix       string
+	policyPrefix string
+}
+
+func (s *namespacedStorage) path(key string) string {
+	for _, prefix := range namespacedPrefixes {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		name := strings.TrimPrefix(key, prefix)
+		if s.prefix != "" {
+			name = s.prefix + "/" + name
+		}
+		if prefix == "policy/" && s.policyPrefix != "" {
+			prefix = s.policyPrefix
+		}
+		return prefix + name
+	}
+	return key
+}
+
+func (s *namespacedStorage) List(prefix string) ([]string, error) {
+	entries, err := s.Storage.List(s.path(prefix))
+	if err != nil {
+		return nil, err
+	}
+
+	// Key names cannot contain slashes, so any nested entries of key
+	// storage are namespaces rather than keys
+	for _, namespaced := range namespacedPrefixes {
+		if prefix != namespaced {
+			continue
+		}
+		keys := entries[:0]
+		for _, entry := range entries {
+			if !strings.HasSuffix(entry, "/") {
+				keys = append(keys, entry)
+			}
+		}
+		entries = keys
+	}
+	return entries, nil
+}
+
+func (s *namespacedStorage) Get(key string) (*logical.StorageEntry, error) {
+	return s.Storage.Get(s.path(key))
+}
+
+func (s *namespacedStorage) Put(entry *logical.StorageEntry) error {
+	namespaced := *entry
+	namespaced.Key = s.path(entry.Key)
+	return s.Storage.Put(&namespaced)
+}
+
+func (s *namespacedStorage) Delete(key string) error {
+	return s.Storage.Delete(s.path(key))
+}
@@ -0,0 +1,181 @@
+package transit
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/ed25519"
+
+	"github.com/hashicorp/vault/helper/errutil"
+	"github.com/hashicorp/vault/helper/jsonutil"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+const attestationKeyStorageKey = "attestation_key"
+
+// attestationKeyEntry is the storage format of the key used to sign
+// attestations of key configurations
+type attestationKeyEntry struct {
+	Key []byte `json:"key"`
+}
+
+func (b *backend) pathAttestationKey() *framework.Path {
+	return &framework.Path{
+		Pattern: "attestation_key",
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation:   b.pathAttestationKeyRead,
+			logical.UpdateOperation: b.pathAttestationKeyWrite,
+		},
+
+		HelpSynopsis:    pathAttestationKeyHelpSyn,
+		HelpDescription: pathAttestationKeyHelpDesc,
+	}
+}
+
+func (b *backend) pathAttestationKeyRead(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	key, err := b.getAttestationKey(req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, nil
+	}
+
+	return attestationKeyResponse(key)
+}
+
+func (b *backend) pathAttestationKeyWrite(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	if err := b.ensureAttestationKey(req.Storage); err != nil {
+		return nil, err
+	}
+
+	return b.pathAttestationKeyRead(req, d)
+}
+
+func attestationKeyResponse(key ed25519.PrivateKey) (*logical.Response, error) {
+	derBytes, err := marshalPublicKeySPKI(key.Public().(ed25519.PublicKey))
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"algorithm": "ed25519",
+			"public_key": string(pem.EncodeToMemory(&pem.Block{
+				Type:  "PUBLIC KEY",
+				Bytes: derBytes,
+			})),
+		},
+	}, nil
+}
+
+// getAttestationKey returns the ed25519 key used to sign attestations, or nil
+// if it has not been generated yet. The key is read from storage once and
+// then cached until it is invalidated.
+func (b *backend) getAttestationKey(storage logical.Storage) (ed25519.PrivateKey, error) {
+	if key, _ := b.attestationKey.Load().(ed25519.PrivateKey); key != nil {
+		return key, nil
+	}
+
+	raw, err := storage.Get(attestationKeyStorageKey)
+	if err != nil {
+		return nil, err
+	}
+	if raw == nil {
+		return nil, nil
+	}
+
+	var entry attestationKeyEntry
+	if err := jsonutil.DecodeJSON(raw.Value, &entry); err != nil {
+		return nil, err
+	}
+	if len(entry.Key) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("stored attestation key has an invalid length")
+	}
+	key := ed25519.PrivateKey(entry.Key)
+	b.attestationKey.Store(key)
+	return key, nil
+}
+
+// ensureAttestationKey generates and stores the attestation key unless it
+// exists already. It is called by the periodic function and by writes to the
+// attestation_key path, so that the key is only ever written on the active
+// node.
+func (b *backend) ensureAttestationKey(storage logical.Storage) error {
+	key, err := b.getAttestationKey(storage)
+	if err != nil || key != nil {
+		return err
+	}
+
+	_, key, err = ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	storageEntry, err := logical.StorageEntryJSON(attestationKeyStorageKey, &attestationKeyEntry{
+		Key: key,
+	})
+	if err != nil {
+		return err
+	}
+	if err := storage.Put(storageEntry); err != nil {
+		return err
+	}
+
+	// Leave the key to be loaded from storage, in case another generation
+	// raced with this one
+	b.resetAttestationKey()
+	return nil
+}
+
+// resetAttestationKey forces the attestation key to be read from storage
+// again
+func (b *backend) resetAttestationKey() {
+	b.attestationKey.Store(ed25519.PrivateKey(nil))
+}
+
+// attestKeyRead returns an attestation of the data of a key read: the JSON
+// payload that was signed, holding the data and the time of the attestation,
+// along with its signature by the attestation key. The payload is returned
+// as signed so that verifiers do not have to reproduce its encoding.
+func (b *backend) attestKeyRead(storage logical.Storage, data map[string]interface{}) (map[string]interface{}, error) {
+	key, err := b.getAttestationKey(storage)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, errutil.UserError{Err: "the attestation key has not been generated yet; write to attestation_key to generate it"}
+	}
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"key":       data,
+		"issued_at": time.Now().UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode attestation payload: %v", err)
+	}
+
+	return map[string]interface{}{
+		"algorithm": "ed25519",
+		"payload":   string(payload),
+		"signature": base64.StdEncoding.EncodeToString(ed25519.Sign(key, payload)),
+	}, nil
+}
+
+const pathAttestationKeyHelpSyn = `Returns the public key used to verify key attestations`
+
+const pathAttestationKeyHelpDesc = `
+This path is used to retrieve the ed25519 public key that verifies the
+attestations returned when reading a key with attest set. The key is generated
+by the periodic function of the backend, or by a write to this path, which
+returns the public key as a read does. Until then, reads of this path return
+nothing and attested key reads fail.
+`
@@ -0,0 +1,163 @@
+package transit
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestTransit_AttestKeyRead(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	attestationKey := func() ed25519.PublicKey {
+		resp := mustHandle(t, b, storage, logical.ReadOperation, "attestation_key", nil)
+		block, _ := pem.Decode([]byte(resp.Data["public_key"].(string)))
+		if block == nil {
+			t.Fatalf("bad PEM: %#v", resp.Data)
+		}
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pub.(ed25519.PublicKey)
+	}
+
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo", map[string]interface{}{
+		"exportable": true,
+	})
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo/rotate", nil)
+
+	// Plain reads are not attested
+	resp := mustHandle(t, b, storage, logical.ReadOperation, "keys/foo", nil)
+	if _, ok := resp.Data["attestation"]; ok {
+		t.Fatalf("unexpected attestation: %#v", resp.Data)
+	}
+
+	// Reads do not generate the attestation key, so attested reads fail
+	// until it has been generated
+	if resp := mustHandle(t, b, storage, logical.ReadOperation, "attestation_key", nil); resp != nil {
+		t.Fatalf("expected no attestation key, got %#v", resp)
+	}
+	mustFail(t, b, storage, logical.ReadOperation, "keys/foo", map[string]interface{}{
+		"attest": true,
+	})
+	if raw, err := storage.Get(attestationKeyStorageKey); err != nil || raw != nil {
+		t.Fatalf("expected no stored attestation key, got %#v (err: %v)", raw, err)
+	}
+
+	written := mustHandle(t, b, storage, logical.UpdateOperation, "attestation_key", nil)
+	if again := mustHandle(t, b, storage, logical.UpdateOperation, "attestation_key", nil); again.Data["public_key"] != written.Data["public_key"] {
+		t.Fatal("expected a second write to keep the attestation key")
+	}
+
+	resp = mustHandle(t, b, storage, logical.ReadOperation, "keys/foo", map[string]interface{}{
+		"attest": true,
+		"etag":   resp.Data["etag"],
+	})
+	attestation := resp.Data["attestation"].(map[string]interface{})
+	if attestation["algorithm"] != "ed25519" {
+		t.Fatalf("bad attestation: %#v", attestation)
+	}
+	payload := []byte(attestation["payload"].(string))
+	signature, err := base64.StdEncoding.DecodeString(attestation["signature"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pub := attestationKey()
+	if !ed25519.Verify(pub, payload, signature) {
+		t.Fatal("attestation signature does not verify")
+	}
+	tampered := []byte(string(payload))
+	tampered[len(tampered)/2] ^= 0x01
+	if ed25519.Verify(pub, tampered, signature) {
+		t.Fatal("tampered payload verified")
+	}
+
+	// The payload holds the full key information despite the etag, along
+	// with the time of the attestation
+	var attested struct {
+		Key      map[string]interface{} `json:"key"`
+		IssuedAt string                 `json:"issued_at"`
+	}
+	if err := json.Unmarshal(payload, &attested); err != nil {
+		t.Fatal(err)
+	}
+	if attested.Key["name"] != "foo" || attested.Key["fingerprint"] != resp.Data["fingerprint"] ||
+		attested.Key["latest_version"] != float64(2) || attested.Key["exportable"] != true {
+		t.Fatalf("bad attested key: %#v", attested.Key)
+	}
+	if attested.Key["not_modified"] != false {
+		t.Fatalf("expected a full response to be attested: %#v", attested.Key)
+	}
+	if _, err := time.Parse(time.RFC3339, attested.IssuedAt); err != nil {
+		t.Fatalf("bad issue time: %v", err)
+	}
+
+	if resp := mustHandle(t, b, storage, logical.ReadOperation, "attestation_key", nil); resp.Data["public_key"] != written.Data["public_key"] {
+		t.Fatal("expected the written attestation key to be read")
+	}
+
+	// The attestation key is cached by other backends until it is
+	// invalidated
+	config := logical.TestBackendConfig()
+	config.StorageView = storage
+	other := Backend(config)
+	if err := other.Backend.Setup(config); err != nil {
+		t.Fatal(err)
+	}
+	key, err := other.getAttestationKey(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(key[32:], pub) {
+		t.Fatal("expected the attestation key to be kept")
+	}
+
+	if err := storage.Delete(attestationKeyStorageKey); err != nil {
+		t.Fatal(err)
+	}
+	b.invalidate(attestationKeyStorageKey)
+	if err := b.ensureAttestationKey(storage); err != nil {
+		t.Fatal(err)
+	}
+	if key, err := other.getAttestationKey(storage); err != nil || !bytes.Equal(key[32:], pub) {
+		t.Fatalf("expected the cached attestation key (err: %v)", err)
+	}
+	other.invalidate(attestationKeyStorageKey)
+	key, err = other.getAttestationKey(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(key[32:], pub) || !bytes.Equal(key[32:], attestationKey()) {
+		t.Fatal("expected the new attestation key after invalidation")
+	}
+}
+
+func TestTransit_AttestationKeyPeriodic(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	_, err := b.HandleRequest(&logical.Request{
+		Storage:   storage,
+		Operation: logical.RollbackOperation,
+	})
+	if err != nil && err != logical.ErrUnsupportedOperation {
+		t.Fatal(err)
+	}
+	resp := mustHandle(t, b, storage, logical.ReadOperation, "attestation_key", nil)
+	if resp == nil || resp.Data["public_key"] == "" {
+		t.Fatalf("expected the periodic function to generate the attestation key, got %#v", resp)
+	}
+
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo", nil)
+	mustHandle(t, b, storage, logical.ReadOperation, "keys/foo", map[string]interface{}{
+		"attest": true,
+	})
+}
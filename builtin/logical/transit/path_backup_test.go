@@ -0,0 +1,422 @@
+package transit
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestTransit_BackupRestore(t *testing.T) {
+	// Test encryption/decryption after a restore for supported keys
+	testBackupRestore(t, "aes256-gcm96", "encrypt-decrypt")
+	testBackupRestore(t, "rsa-2048", "encrypt-decrypt")
+
+	// Test signing/verification after a restore for supported keys
+	testBackupRestore(t, "ecdsa-p256", "sign-verify")
+	testBackupRestore(t, "ed25519", "sign-verify")
+	testBackupRestore(t, "rsa-2048", "sign-verify")
+}
+
+func testBackupRestore(t *testing.T, keyType, feature string) {
+	b, storage := createBackendWithStorage(t)
+
+	// A key that is not exportable cannot be backed up
+	req := &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "keys/unexportable",
+		Data: map[string]interface{}{
+			"type": keyType,
+		},
+	}
+	mustHandleRequest(t, b, req)
+	req.Operation = logical.ReadOperation
+	req.Path = "keys/unexportable/backup"
+	req.Data = nil
+	mustFailRequest(t, b, req)
+
+	// An exportable key cannot be backed up until plaintext backup is allowed
+	req.Operation = logical.UpdateOperation
+	req.Path = "keys/test"
+	req.Data = map[string]interface{}{
+		"type":       keyType,
+		"exportable": true,
+	}
+	mustHandleRequest(t, b, req)
+	req.Operation = logical.ReadOperation
+	req.Path = "keys/test/backup"
+	req.Data = nil
+	mustFailRequest(t, b, req)
+
+	req.Operation = logical.UpdateOperation
+	req.Path = "keys/test/config"
+	req.Data = map[string]interface{}{
+		"allow_plaintext_backup": true,
+	}
+	mustHandleRequest(t, b, req)
+
+	// Rotate a few times so that the backup has to carry archived versions
+	req.Path = "keys/test/rotate"
+	req.Data = nil
+	for i := 0; i < 3; i++ {
+		mustHandleRequest(t, b, req)
+	}
+
+	plaintext := "dGhlIHF1aWNrIGJyb3duIGZveA=="
+	var ciphertext, signature string
+	switch feature {
+	case "encrypt-decrypt":
+		req.Path = "encrypt/test"
+		req.Data = map[string]interface{}{
+			"plaintext":   plaintext,
+			"key_version": 1,
+		}
+		ciphertext = mustHandleRequest(t, b, req).Data["ciphertext"].(string)
+	case "sign-verify":
+		req.Path = "sign/test"
+		req.Data = map[string]interface{}{
+			"input": plaintext,
+		}
+		signature = mustHandleRequest(t, b, req).Data["signature"].(string)
+	}
+
+	req.Operation = logical.ReadOperation
+	req.Path = "keys/test/backup"
+	req.Data = nil
+	backup := mustHandleRequest(t, b, req).Data["backup"].(string)
+
+	// Turning the flag back off blocks further backups
+	req.Operation = logical.UpdateOperation
+	req.Path = "keys/test/config"
+	req.Data = map[string]interface{}{
+		"allow_plaintext_backup": false,
+	}
+	mustHandleRequest(t, b, req)
+	req.Operation = logical.ReadOperation
+	req.Path = "keys/test/backup"
+	req.Data = nil
+	mustFailRequest(t, b, req)
+
+	// Restoring over the existing key requires force, and deletion to be
+	// allowed for it
+	req.Operation = logical.UpdateOperation
+	req.Path = "restore"
+	req.Data = map[string]interface{}{
+		"backup": backup,
+	}
+	mustFailRequest(t, b, req)
+	req.Data["force"] = true
+	mustFailRequest(t, b, req)
+	req.Path = "keys/test/config"
+	req.Data = map[string]interface{}{
+		"deletion_allowed": true,
+	}
+	mustHandleRequest(t, b, req)
+	req.Path = "restore"
+	req.Data = map[string]interface{}{
+		"backup": backup,
+		"force":  true,
+	}
+	mustHandleRequest(t, b, req)
+
+	// Restore under a new name into a fresh backend
+	b, storage = createBackendWithStorage(t)
+	req.Storage = storage
+	req.Path = "restore/restored"
+	req.Data = map[string]interface{}{
+		"backup": backup,
+	}
+	mustHandleRequest(t, b, req)
+
+	req.Operation = logical.ReadOperation
+	req.Path = "keys/restored"
+	req.Data = nil
+	resp := mustHandleRequest(t, b, req)
+	if resp.Data["name"] != "restored" || resp.Data["latest_version"] != 4 ||
+		resp.Data["allow_plaintext_backup"] != true {
+		t.Fatalf("bad restored key: %#v", resp.Data)
+	}
+
+	req.Operation = logical.UpdateOperation
+	switch feature {
+	case "encrypt-decrypt":
+		req.Path = "decrypt/restored"
+		req.Data = map[string]interface{}{
+			"ciphertext": ciphertext,
+		}
+		resp = mustHandleRequest(t, b, req)
+		if resp.Data["plaintext"] != plaintext {
+			t.Fatalf("bad plaintext: %#v", resp.Data)
+		}
+	case "sign-verify":
+		req.Path = "verify/restored"
+		req.Data = map[string]interface{}{
+			"input":     plaintext,
+			"signature": signature,
+		}
+		resp = mustHandleRequest(t, b, req)
+		if resp.Data["valid"] != true {
+			t.Fatalf("signature did not verify after restore: %#v", resp.Data)
+		}
+	}
+
+	// Garbage backups are rejected
+	req.Path = "restore/garbage"
+	req.Data = map[string]interface{}{
+		"backup": "not a backup",
+	}
+	mustFailRequest(t, b, req)
+}
+
+func TestTransit_RestoreMerge(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	doErrReq := func(path string, data map[string]interface{}, expected string) {
+		resp, err := b.HandleRequest(&logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Data:      data,
+		})
+		if err != logical.ErrInvalidRequest || resp == nil || !strings.Contains(resp.Data["error"].(string), expected) {
+			t.Fatalf("%s: expected error containing %q, got %#v (err: %v)", path, expected, resp, err)
+		}
+	}
+	backup := func(name string) string {
+		return mustHandle(t, b, storage, logical.ReadOperation, "keys/"+name+"/backup", nil).Data["backup"].(string)
+	}
+	encrypt := func(name string) string {
+		return mustHandle(t, b, storage, logical.UpdateOperation, "encrypt/"+name, map[string]interface{}{
+			"plaintext": "dGhlIHF1aWNrIGJyb3duIGZveA==",
+		}).Data["ciphertext"].(string)
+	}
+	// checkMerged verifies that the key has exactly the given versions and
+	// can decrypt ciphertext of the first and last of them
+	checkMerged := func(name string, versions []int, ciphertexts ...string) {
+		resp := mustHandle(t, b, storage, logical.ReadOperation, "keys/"+name+"/versions", nil)
+		var live []int
+		for _, entry := range resp.Data["versions"].([]map[string]interface{}) {
+			live = append(live, entry["version"].(int))
+		}
+		if !reflect.DeepEqual(live, versions) {
+			t.Fatalf("%s: expected versions %v, got %v", name, versions, live)
+		}
+		for _, ciphertext := range ciphertexts {
+			resp := mustHandle(t, b, storage, logical.UpdateOperation, "decrypt/"+name, map[string]interface{}{
+				"ciphertext": ciphertext,
+			})
+			if resp.Data["plaintext"] != "dGhlIHF1aWNrIGJyb3duIGZveA==" {
+				t.Fatalf("%s: bad plaintext: %#v", name, resp.Data)
+			}
+		}
+	}
+
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/orig", map[string]interface{}{
+		"exportable":             true,
+		"allow_plaintext_backup": true,
+	})
+	ciphertext1 := encrypt("orig")
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/orig/rotate", nil)
+	earlyBackup := backup("orig")
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/orig/rotate", nil)
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/orig/rotate", nil)
+	ciphertext4 := encrypt("orig")
+	fullBackup := backup("orig")
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/orig/config", map[string]interface{}{
+		"min_decryption_version": 3,
+	})
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/orig/trim", map[string]interface{}{
+		"min_available_version": 3,
+	})
+	trimmedBackup := backup("orig")
+
+	// Disjoint versions are joined into a single key
+	mustHandle(t, b, storage, logical.UpdateOperation, "restore/disjoint", map[string]interface{}{
+		"backup": earlyBackup,
+	})
+	mustHandle(t, b, storage, logical.UpdateOperation, "restore/disjoint", map[string]interface{}{
+		"backup": trimmedBackup,
+		"merge":  true,
+	})
+	checkMerged("disjoint", []int{1, 2, 3, 4}, ciphertext1, ciphertext4)
+
+	// Overlapping versions with the same material are kept once
+	mustHandle(t, b, storage, logical.UpdateOperation, "restore/overlap", map[string]interface{}{
+		"backup": earlyBackup,
+	})
+	mustHandle(t, b, storage, logical.UpdateOperation, "restore/overlap", map[string]interface{}{
+		"backup": fullBackup,
+		"merge":  true,
+	})
+	checkMerged("overlap", []int{1, 2, 3, 4}, ciphertext1, ciphertext4)
+	if resp := mustHandle(t, b, storage, logical.UpdateOperation, "encrypt/overlap", map[string]interface{}{
+		"plaintext": "dGhlIHF1aWNrIGJyb3duIGZveA==",
+	}); !strings.HasPrefix(resp.Data["ciphertext"].(string), "vault:v4:") {
+		t.Fatalf("expected encryption with the latest merged version, got %#v", resp.Data)
+	}
+
+	// Different material for the same version fails without changing the key
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/other", map[string]interface{}{
+		"exportable":             true,
+		"allow_plaintext_backup": true,
+	})
+	doErrReq("restore/overlap", map[string]interface{}{
+		"backup": backup("other"),
+		"merge":  true,
+	}, "version 1 of the backup has different key material")
+	checkMerged("overlap", []int{1, 2, 3, 4}, ciphertext1, ciphertext4)
+
+	// Merging must not leave gaps between versions
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/orig/config", map[string]interface{}{
+		"min_decryption_version": 4,
+	})
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/orig/trim", map[string]interface{}{
+		"min_available_version": 4,
+	})
+	mustHandle(t, b, storage, logical.UpdateOperation, "restore/gap", map[string]interface{}{
+		"backup": earlyBackup,
+	})
+	doErrReq("restore/gap", map[string]interface{}{
+		"backup": backup("orig"),
+		"merge":  true,
+	}, "missing version 3")
+	checkMerged("gap", []int{1, 2}, ciphertext1)
+
+	// Keys of a different type cannot be merged
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/ecdsa", map[string]interface{}{
+		"type":                   "ecdsa-p256",
+		"exportable":             true,
+		"allow_plaintext_backup": true,
+	})
+	doErrReq("restore/gap", map[string]interface{}{
+		"backup": backup("ecdsa"),
+		"merge":  true,
+	}, "of type")
+
+	// Merging into a missing key restores it
+	mustHandle(t, b, storage, logical.UpdateOperation, "restore/new", map[string]interface{}{
+		"backup": trimmedBackup,
+		"merge":  true,
+	})
+	checkMerged("new", []int{3, 4}, ciphertext4)
+
+	doErrReq("restore/new", map[string]interface{}{
+		"backup": trimmedBackup,
+		"merge":  true,
+		"force":  true,
+	}, "cannot both be set")
+}
+
+func TestTransit_RestoreCraftedName(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo", map[string]interface{}{
+		"exportable":             true,
+		"allow_plaintext_backup": true,
+	})
+	resp := mustHandle(t, b, storage, logical.ReadOperation, "keys/foo/backup", nil)
+
+	// Replace the name stored in the backup
+	craft := func(name string) string {
+		backupBytes, err := base64.StdEncoding.DecodeString(resp.Data["backup"].(string))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var keyData map[string]interface{}
+		if err := json.Unmarshal(backupBytes, &keyData); err != nil {
+			t.Fatal(err)
+		}
+		keyData["policy"].(map[string]interface{})["name"] = name
+		backupBytes, err = json.Marshal(keyData)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.StdEncoding.EncodeToString(backupBytes)
+	}
+
+	for _, name := range []string{"../config/keys", "foo/bar", `foo\bar`, "..", "foo\nbar", "foo\x00", "foo bar", "-x", "a+b", "foo-", ".foo"} {
+		for _, merge := range []bool{false, true} {
+			resp, err := b.HandleRequest(&logical.Request{
+				Storage:   storage,
+				Operation: logical.UpdateOperation,
+				Path:      "restore",
+				Data: map[string]interface{}{
+					"backup": craft(name),
+					"merge":  merge,
+				},
+			})
+			if err != logical.ErrInvalidRequest || resp == nil || !strings.Contains(resp.Data["error"].(string), "invalid policy name") {
+				t.Fatalf("%q (merge %t): expected invalid name error, got %#v (err: %v)", name, merge, resp, err)
+			}
+		}
+	}
+
+	entries, err := storage.List("policy/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(entries, []string{"foo"}) {
+		t.Fatalf("expected only foo to be stored, got %v", entries)
+	}
+
+	// A name in the URL replaces the crafted one
+	mustHandle(t, b, storage, logical.UpdateOperation, "restore/bar", map[string]interface{}{
+		"backup": craft("../config/keys"),
+	})
+	if resp := mustHandle(t, b, storage, logical.ReadOperation, "keys/bar", nil); resp == nil || resp.Data["name"] != "bar" {
+		t.Fatalf("bad restored key: %#v", resp)
+	}
+
+	// Names the key paths accept are restored from the backup, and the
+	// restored keys can be managed through those paths
+	for _, name := range []string{"a", "foo-bar", "foo.bar", "foo_bar", "Foo1"} {
+		mustHandle(t, b, storage, logical.UpdateOperation, "restore", map[string]interface{}{
+			"backup": craft(name),
+		})
+		if resp := mustHandle(t, b, storage, logical.ReadOperation, "keys/"+name, nil); resp == nil || resp.Data["name"] != name {
+			t.Fatalf("%q: bad restored key: %#v", name, resp)
+		}
+		mustHandle(t, b, storage, logical.UpdateOperation, "keys/"+name+"/config", map[string]interface{}{
+			"deletion_allowed": true,
+		})
+		mustHandle(t, b, storage, logical.DeleteOperation, "keys/"+name, nil)
+	}
+}
+
+func TestTransit_RestoreForceProtectedKey(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/protected", nil)
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/other", map[string]interface{}{
+		"exportable":             true,
+		"allow_plaintext_backup": true,
+	})
+	backup := mustHandle(t, b, storage, logical.ReadOperation, "keys/other/backup", nil).Data["backup"].(string)
+	before := mustHandle(t, b, storage, logical.ReadOperation, "keys/protected", nil).Data["fingerprint"]
+
+	resp, err := b.HandleRequest(&logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "restore/protected",
+		Data: map[string]interface{}{
+			"backup": backup,
+			"force":  true,
+		},
+	})
+	if err != logical.ErrInvalidRequest || resp == nil || !strings.Contains(resp.Data["error"].(string), "deletion is not allowed") {
+		t.Fatalf("expected the forced restore to be refused, got %#v (err: %v)", resp, err)
+	}
+
+	// The key is left as it was, in the cache as well as in storage
+	if after := mustHandle(t, b, storage, logical.ReadOperation, "keys/protected", nil).Data["fingerprint"]; after != before {
+		t.Fatalf("expected fingerprint %v, got %v", before, after)
+	}
+	b.lm.InvalidatePolicy("protected")
+	if after := mustHandle(t, b, storage, logical.ReadOperation, "keys/protected", nil).Data["fingerprint"]; after != before {
+		t.Fatalf("expected stored fingerprint %v, got %v", before, after)
+	}
+}
@@ -0,0 +1,77 @@
+package transit
+
+import (
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+func (b *backend) pathBatchDelete() *framework.Path {
+	return &framework.Path{
+		Pattern: "delete",
+		Fields: map[string]*framework.FieldSchema{
+			"keys": &framework.FieldSchema{
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Names of the keys to delete",
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.pathBatchDeleteWrite,
+		},
+
+		HelpSynopsis:    pathBatchDeleteHelpSyn,
+		HelpDescription: pathBatchDeleteHelpDesc,
+	}
+}
+
+func (b *backend) pathBatchDeleteWrite(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	names := d.Get("keys").([]string)
+	if len(names) == 0 {
+		return logical.ErrorResponse("at least one key name must be given"), logical.ErrInvalidRequest
+	}
+
+	config, err := b.readKeysConfig(req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config.RequireDeleteConfirmation {
+		return logical.ErrorResponse("deletion of each key must be confirmed, so keys cannot be deleted in bulk; delete them individually instead"), logical.ErrInvalidRequest
+	}
+
+	// Each key is deleted as by a delete of keys/<name>; failures are
+	// reported per key so that one protected key does not prevent the others
+	// from being deleted
+	batchResults := make([]map[string]interface{}, len(names))
+	for i, name := range names {
+		batchResults[i] = map[string]interface{}{
+			"name":    name,
+			"deleted": false,
+		}
+
+		// Delete does its own locking
+		err := b.lm.DeletePolicy(req.Storage, name, config.RequireDecommissionBeforeDelete)
+		if err != nil {
+			batchResults[i]["error"] = err.Error()
+			continue
+		}
+		b.idempotency.forget(name)
+		b.rateLimiter.forget(name)
+		batchResults[i]["deleted"] = true
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"batch_results": batchResults,
+		},
+	}, nil
+}
+
+const pathBatchDeleteHelpSyn = `Delete several named keys`
+
+const pathBatchDeleteHelpDesc = `
+This path deletes each of the given keys, as a delete of the key's own path
+would. Keys that do not have deletion_allowed set, or that cannot be deleted
+for another reason, are left in place and the reason is returned in the result
+for the key; the other keys are still deleted.
+`
@@ -0,0 +1,69 @@
+package transit
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestTransit_BatchDeleteKeys(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	for _, name := range []string{"first", "protected", "second"} {
+		mustHandle(t, b, storage, logical.UpdateOperation, "keys/"+name, nil)
+	}
+	for _, name := range []string{"first", "second"} {
+		mustHandle(t, b, storage, logical.UpdateOperation, "keys/"+name+"/config", map[string]interface{}{
+			"deletion_allowed": true,
+		})
+	}
+
+	resp := mustHandle(t, b, storage, logical.UpdateOperation, "delete", map[string]interface{}{
+		"keys": "first,protected,missing,second",
+	})
+	results := resp.Data["batch_results"].([]map[string]interface{})
+	if len(results) != 4 {
+		t.Fatalf("expected 4 results, got %#v", results)
+	}
+	for i, expected := range []struct {
+		name    string
+		deleted bool
+		err     string
+	}{
+		{"first", true, ""},
+		{"protected", false, "deletion is not allowed"},
+		{"missing", false, "not found"},
+		{"second", true, ""},
+	} {
+		result := results[i]
+		errMsg, _ := result["error"].(string)
+		if result["name"] != expected.name || result["deleted"] != expected.deleted {
+			t.Fatalf("bad result %d: %#v", i, result)
+		}
+		if (expected.err == "") != (errMsg == "") || !strings.Contains(errMsg, expected.err) {
+			t.Fatalf("bad error for %s: %#v", expected.name, result)
+		}
+	}
+
+	resp = mustHandle(t, b, storage, logical.ListOperation, "keys", nil)
+	if keys := resp.Data["keys"].([]string); len(keys) != 1 || keys[0] != "protected" {
+		t.Fatalf("expected only the protected key to remain, got %v", keys)
+	}
+
+	// Bulk deletion would bypass the confirmation of each key
+	mustHandle(t, b, storage, logical.UpdateOperation, "config/keys", map[string]interface{}{
+		"require_delete_confirmation": true,
+	})
+	resp, err := b.HandleRequest(&logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "delete",
+		Data: map[string]interface{}{
+			"keys": "protected",
+		},
+	})
+	if err != logical.ErrInvalidRequest || resp == nil || !resp.IsError() {
+		t.Fatalf("expected bulk deletion to be rejected, got %#v (err: %v)", resp, err)
+	}
+}
@@ -0,0 +1,254 @@
+package transit
+
+import (
+	"sync/atomic"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+const cacheConfigStorageKey = "config/cache"
+
+// cacheConfig holds the settings of the policy cache
+type cacheConfig struct {
+	// The maximum number of cached keys; zero means that the cache is not
+	// limited
+	Size int `json:"size"`
+}
+
+func (b *backend) pathCacheConfig() *framework.Path {
+	return &framework.Path{
+		Pattern: "cache/config",
+		Fields: map[string]*framework.FieldSchema{
+			"size": &framework.FieldSchema{
+				Type: framework.TypeInt,
+				Description: `The maximum number of keys to keep in the cache.
+If 0, the cache is not limited.`,
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation:   b.pathCacheConfigRead,
+			logical.UpdateOperation: b.pathCacheConfigWrite,
+		},
+
+		HelpSynopsis:    pathCacheConfigHelpSyn,
+		HelpDescription: pathCacheConfigHelpDesc,
+	}
+}
+
+func (b *backend) pathCacheConfigRead(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	config, err := readCacheConfig(req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"size": config.Size,
+		},
+	}, nil
+}
+
+func (b *backend) pathCacheConfigWrite(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	if !b.lm.CacheActive() {
+		return logical.ErrorResponse("caching is disabled; the cache size cannot be configured"), logical.ErrInvalidRequest
+	}
+
+	size := d.Get("size").(int)
+	if size < 0 {
+		return logical.ErrorResponse("size must not be negative"), logical.ErrInvalidRequest
+	}
+
+	entry, err := logical.StorageEntryJSON(cacheConfigStorageKey, &cacheConfig{
+		Size: size,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Put(entry); err != nil {
+		return nil, err
+	}
+
+	// Shrinking the cache evicts keys right away
+	b.lm.SetCacheMaxSize(size)
+
+	return nil, nil
+}
+
+// applyCacheConfig sets the cache size of the lock manager to the stored one
+func (b *backend) applyCacheConfig(storage logical.Storage) error {
+	if !b.lm.CacheActive() {
+		return nil
+	}
+
+	config, err := readCacheConfig(storage)
+	if err != nil {
+		return err
+	}
+	b.lm.SetCacheMaxSize(config.Size)
+	return nil
+}
+
+// applyChangedCacheConfig applies the cache config if it has changed on
+// another node since it was last applied
+func (b *backend) applyChangedCacheConfig(storage logical.Storage) error {
+	if !atomic.CompareAndSwapUint32(&b.cacheConfigChanged, 1, 0) {
+		return nil
+	}
+	if err := b.applyCacheConfig(storage); err != nil {
+		atomic.StoreUint32(&b.cacheConfigChanged, 1)
+		return err
+	}
+	return nil
+}
+
+// readCacheConfig returns the stored cache settings, or the defaults if none
+// have been stored
+func readCacheConfig(storage logical.Storage) (*cacheConfig, error) {
+	config := &cacheConfig{}
+
+	entry, err := storage.Get(cacheConfigStorageKey)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return config, nil
+	}
+
+	if err := entry.DecodeJSON(config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func (b *backend) pathCachePreload() *framework.Path {
+	return &framework.Path{
+		Pattern: "cache/preload",
+		Fields: map[string]*framework.FieldSchema{
+			"keys": &framework.FieldSchema{
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Names of the keys to load into the cache",
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.pathCachePreloadWrite,
+		},
+
+		HelpSynopsis:    pathCachePreloadHelpSyn,
+		HelpDescription: pathCachePreloadHelpDesc,
+	}
+}
+
+func (b *backend) pathCachePreloadWrite(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	names := d.Get("keys").([]string)
+	if len(names) == 0 {
+		return logical.ErrorResponse("at least one key name must be given"), logical.ErrInvalidRequest
+	}
+	if !b.lm.CacheActive() {
+		return logical.ErrorResponse("caching is disabled; keys cannot be preloaded"), logical.ErrInvalidRequest
+	}
+
+	// Loading a key with the lock manager caches it; failures are reported
+	// per key so that one bad key does not prevent the others from loading
+	results := make(map[string]interface{}, len(names))
+	for _, name := range names {
+		p, lock, err := b.lm.GetPolicyShared(req.Storage, name)
+		if lock != nil {
+			lock.RUnlock()
+		}
+		switch {
+		case err != nil:
+			results[name] = map[string]interface{}{
+				"loaded": false,
+				"error":  err.Error(),
+			}
+		case p == nil:
+			results[name] = map[string]interface{}{
+				"loaded": false,
+				"error":  "key not found",
+			}
+		default:
+			results[name] = map[string]interface{}{
+				"loaded": true,
+			}
+		}
+	}
+
+	// Keys loaded earlier are evicted by the later ones if the cache is too
+	// small to hold them all, or by concurrent requests
+	for name, result := range results {
+		if result.(map[string]interface{})["loaded"] == true && !b.lm.PolicyCached(name) {
+			results[name] = map[string]interface{}{
+				"loaded": false,
+				"error":  "key was evicted from the cache before preloading finished",
+			}
+		}
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"keys": results,
+		},
+	}, nil
+}
+
+func (b *backend) pathCacheStats() *framework.Path {
+	return &framework.Path{
+		Pattern: "cache/stats",
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.pathCacheStatsRead,
+		},
+
+		HelpSynopsis:    pathCacheStatsHelpSyn,
+		HelpDescription: pathCacheStatsHelpDesc,
+	}
+}
+
+func (b *backend) pathCacheStatsRead(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	stats := b.lm.CacheStats()
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"enabled":  b.lm.CacheActive(),
+			"hits":     stats.Hits,
+			"misses":   stats.Misses,
+			"entries":  stats.Entries,
+			"max_size": stats.MaxSize,
+		},
+	}, nil
+}
+
+const pathCachePreloadHelpSyn = `Load keys into the policy cache`
+
+const pathCachePreloadHelpDesc = `
+This path loads the named keys into the in-memory policy cache, so that the
+first requests using them after a restart or failover do not have to read
+them from storage. The result of loading each key is returned; keys that were
+evicted again before the preload finished, for instance because the cache is
+too small to hold all of them, are reported as not loaded.
+`
+
+const pathCacheConfigHelpSyn = `Configure the size of the policy cache`
+
+const pathCacheConfigHelpDesc = `
+This path configures the maximum number of keys kept in the in-memory policy
+cache. When the cache is full, the least recently used keys are evicted to
+make room. Reducing the size evicts keys immediately on the node handling the
+request, and on other nodes once the change reaches them; evicted keys are
+loaded from storage again when next used.
+`
+
+const pathCacheStatsHelpSyn = `Return statistics about the policy cache`
+
+const pathCacheStatsHelpDesc = `
+This path returns the number of key lookups served from the in-memory policy
+cache and loaded from storage since the backend started, the number of cached
+keys, and the maximum size of the cache, where 0 means that it is unlimited.
+`
@@ -0,0 +1,248 @@
+package transit
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestTransit_CachePreload(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	for _, name := range []string{"foo", "bar"} {
+		mustHandle(t, b, storage, logical.UpdateOperation, "keys/"+name, nil)
+	}
+
+	// Start cold, as after a restart
+	b.lm.InvalidateAllPolicies()
+
+	resp := mustHandle(t, b, storage, logical.UpdateOperation, "cache/preload", map[string]interface{}{
+		"keys": "foo,missing",
+	})
+	results := resp.Data["keys"].(map[string]interface{})
+	if len(results) != 2 ||
+		results["foo"].(map[string]interface{})["loaded"] != true ||
+		results["missing"].(map[string]interface{})["loaded"] != false {
+		t.Fatalf("bad preload results: %#v", results)
+	}
+
+	// With the stored keys removed, only the preloaded key can still be
+	// served, from the cache
+	for _, name := range []string{"foo", "bar"} {
+		if err := storage.Delete("policy/" + name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if resp := mustHandle(t, b, storage, logical.ReadOperation, "keys/foo", nil); resp == nil || resp.Data["name"] != "foo" {
+		t.Fatalf("expected preloaded key to be served from the cache, got %#v", resp)
+	}
+	if resp := mustHandle(t, b, storage, logical.ReadOperation, "keys/bar", nil); resp != nil {
+		t.Fatalf("expected key that was not preloaded to be read from storage, got %#v", resp)
+	}
+
+	resp, err := b.HandleRequest(&logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "cache/preload",
+	})
+	if err == nil && (resp == nil || !resp.IsError()) {
+		t.Fatal("expected error without key names")
+	}
+}
+
+func TestTransit_CacheStats(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	stats := func() (uint64, uint64, int) {
+		resp := mustHandle(t, b, storage, logical.ReadOperation, "cache/stats", nil)
+		if resp.Data["enabled"] != true || resp.Data["max_size"] != 0 {
+			t.Fatalf("bad cache stats: %#v", resp.Data)
+		}
+		return resp.Data["hits"].(uint64), resp.Data["misses"].(uint64), resp.Data["entries"].(int)
+	}
+
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo", nil)
+	hits, misses, entries := stats()
+	if entries != 1 {
+		t.Fatalf("expected 1 cached key, got %d", entries)
+	}
+
+	// Reads of a cached key are hits
+	for i := 0; i < 3; i++ {
+		mustHandle(t, b, storage, logical.ReadOperation, "keys/foo", nil)
+	}
+	newHits, newMisses, _ := stats()
+	if newHits != hits+3 || newMisses != misses {
+		t.Fatalf("expected 3 more hits and no more misses, got hits %d -> %d, misses %d -> %d", hits, newHits, misses, newMisses)
+	}
+
+	// Reads of a key that is not cached are misses
+	mustHandle(t, b, storage, logical.ReadOperation, "keys/missing", nil)
+	b.lm.InvalidateAllPolicies()
+	mustHandle(t, b, storage, logical.ReadOperation, "keys/foo", nil)
+	finalHits, finalMisses, entries := stats()
+	if finalHits != newHits || finalMisses != newMisses+2 || entries != 1 {
+		t.Fatalf("expected 2 more misses, got hits %d -> %d, misses %d -> %d, %d entries", newHits, finalHits, newMisses, finalMisses, entries)
+	}
+}
+
+func TestTransit_CacheConfig(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	doReq := func(op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(&logical.Request{
+			Storage:   storage,
+			Operation: op,
+			Path:      path,
+			Data:      data,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Errorf("%s: got err:\n%#v\nresp:\n%#v\n", path, err, resp)
+		}
+		return resp
+	}
+
+	names := []string{"a", "b", "c", "d", "e"}
+	for _, name := range names {
+		doReq(logical.UpdateOperation, "keys/"+name, nil)
+	}
+	if stats := b.lm.CacheStats(); stats.Entries != 5 || stats.MaxSize != 0 {
+		t.Fatalf("bad stats before resize: %#v", stats)
+	}
+
+	// Shrinking below the number of cached keys evicts keys, while
+	// operations with them keep working
+	var wg sync.WaitGroup
+	for _, name := range names {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				resp := doReq(logical.UpdateOperation, "encrypt/"+name, map[string]interface{}{
+					"plaintext": "dGhlIHF1aWNrIGJyb3duIGZveA==",
+				})
+				if resp == nil || resp.Data["ciphertext"] == nil {
+					t.Errorf("bad encryption with %s: %#v", name, resp)
+					return
+				}
+			}
+		}(name)
+	}
+	for _, size := range []int{4, 1, 3, 2} {
+		doReq(logical.UpdateOperation, "cache/config", map[string]interface{}{
+			"size": size,
+		})
+	}
+	wg.Wait()
+	if t.Failed() {
+		t.FailNow()
+	}
+
+	if stats := b.lm.CacheStats(); stats.Entries > 2 || stats.MaxSize != 2 {
+		t.Fatalf("bad stats after resize: %#v", stats)
+	}
+	for _, name := range names {
+		if resp := doReq(logical.ReadOperation, "keys/"+name, nil); resp == nil || resp.Data["name"] != name {
+			t.Fatalf("failed to read evicted key %s: %#v", name, resp)
+		}
+	}
+	if stats := b.lm.CacheStats(); stats.Entries != 2 {
+		t.Fatalf("expected the cache to stay full, got %#v", stats)
+	}
+	if resp := doReq(logical.ReadOperation, "cache/config", nil); resp.Data["size"] != 2 {
+		t.Fatalf("bad cache config: %#v", resp.Data)
+	}
+
+	resp, err := b.HandleRequest(&logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "cache/config",
+		Data: map[string]interface{}{
+			"size": -1,
+		},
+	})
+	if err == nil && (resp == nil || !resp.IsError()) {
+		t.Fatal("expected error for a negative size")
+	}
+
+	// The size is applied when the backend is set up again
+	config := logical.TestBackendConfig()
+	config.StorageView = storage
+	restarted, err := Factory(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats := restarted.(*backend).lm.CacheStats(); stats.MaxSize != 2 {
+		t.Fatalf("expected the configured size to be applied on setup, got %#v", stats)
+	}
+}
+
+func TestTransit_CacheEvictsLeastRecentlyUsed(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	mustHandle(t, b, storage, logical.UpdateOperation, "cache/config", map[string]interface{}{
+		"size": 2,
+	})
+	cached := func(expected ...string) {
+		for _, name := range []string{"a", "b", "c", "d"} {
+			want := false
+			for _, e := range expected {
+				want = want || e == name
+			}
+			if b.lm.PolicyCached(name) != want {
+				t.Fatalf("expected %s cached: %t, stats %#v", name, want, b.lm.CacheStats())
+			}
+		}
+	}
+
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/a", nil)
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/b", nil)
+	mustHandle(t, b, storage, logical.ReadOperation, "keys/a", nil)
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/c", nil)
+	cached("a", "c")
+
+	mustHandle(t, b, storage, logical.UpdateOperation, "encrypt/a", map[string]interface{}{
+		"plaintext": "dGhlIHF1aWNrIGJyb3duIGZveA==",
+	})
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/d", nil)
+	cached("a", "d")
+
+	// Preloading more keys than the cache holds reports the keys evicted
+	// by the later ones as not loaded
+	resp := mustHandle(t, b, storage, logical.UpdateOperation, "cache/preload", map[string]interface{}{
+		"keys": "b,c,d",
+	})
+	results := resp.Data["keys"].(map[string]interface{})
+	for name, loaded := range map[string]bool{"b": false, "c": true, "d": true} {
+		if result := results[name].(map[string]interface{}); result["loaded"] != loaded {
+			t.Fatalf("expected %s loaded: %t, got %#v", name, loaded, result)
+		}
+	}
+	cached("c", "d")
+}
+
+func TestTransit_CacheConfigInvalidation(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	for _, name := range []string{"a", "b", "c"} {
+		mustHandle(t, b, storage, logical.UpdateOperation, "keys/"+name, nil)
+	}
+
+	// A size written by another node is applied on the next request once
+	// it has been invalidated
+	entry, err := logical.StorageEntryJSON(cacheConfigStorageKey, &cacheConfig{
+		Size: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Put(entry); err != nil {
+		t.Fatal(err)
+	}
+	b.invalidate(cacheConfigStorageKey)
+	mustHandle(t, b, storage, logical.ReadOperation, "keys/a", nil)
+	if stats := b.lm.CacheStats(); stats.MaxSize != 1 || stats.Entries != 1 {
+		t.Fatalf("expected the invalidated size to be applied, got %#v", stats)
+	}
+}
@@ -0,0 +1,47 @@
+package transit
+
+import (
+	"github.com/hashicorp/vault/helper/keysutil"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+// maxRSAKeyBits is the size of the largest supported RSA key type
+const maxRSAKeyBits = 4096
+
+func (b *backend) pathCapabilities() *framework.Path {
+	return &framework.Path{
+		Pattern: "capabilities",
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.pathCapabilitiesRead,
+		},
+
+		HelpSynopsis:    pathCapabilitiesHelpSyn,
+		HelpDescription: pathCapabilitiesHelpDesc,
+	}
+}
+
+func (b *backend) pathCapabilitiesRead(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"key_types":                 keyTypeNames(),
+			"signature_hash_algorithms": append([]string(nil), keysutil.SignatureHashAlgorithms...),
+			"max_rsa_key_bits":          maxRSAKeyBits,
+			"features": map[string]bool{
+				"import": true,
+				"backup": true,
+			},
+		},
+	}, nil
+}
+
+const pathCapabilitiesHelpSyn = `Return the key types and features supported by this backend`
+
+const pathCapabilitiesHelpDesc = `
+This path returns the key types that can be created, the hash algorithms that
+can be used for signing, the size of the largest RSA keys, and whether keys
+can be imported and backed up. The values depend only on the version of Vault,
+so clients can use them to discover what is supported instead of hardcoding it.
+`
@@ -0,0 +1,58 @@
+package transit
+
+import (
+	"encoding/base64"
+	"strconv"
+	"testing"
+
+	"github.com/hashicorp/vault/helper/keysutil"
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestTransit_Capabilities(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	resp := mustHandle(t, b, storage, logical.ReadOperation, "capabilities", nil)
+
+	// The advertised types are exactly those that can be created
+	advertised := map[string]bool{}
+	for _, name := range resp.Data["key_types"].([]string) {
+		keyType, ok := keysutil.ParseKeyType(name)
+		if !ok || keyType.String() != name {
+			t.Fatalf("advertised key type %s cannot be created", name)
+		}
+		advertised[name] = true
+	}
+	count := 0
+	for keyType := keysutil.KeyType(0); keyType < 32; keyType++ {
+		if !keyType.Known() {
+			continue
+		}
+		count++
+		if !advertised[keyType.String()] {
+			t.Fatalf("key type %s is not advertised", keyType)
+		}
+	}
+	if len(advertised) != count {
+		t.Fatalf("expected %d key types, got %v", count, resp.Data["key_types"])
+	}
+
+	if resp.Data["max_rsa_key_bits"] != 4096 || !advertised["rsa-"+strconv.Itoa(maxRSAKeyBits)] {
+		t.Fatalf("bad max rsa key bits: %#v", resp.Data)
+	}
+	features := resp.Data["features"].(map[string]bool)
+	if !features["import"] || !features["backup"] {
+		t.Fatalf("bad features: %#v", features)
+	}
+
+	// Every advertised hash algorithm can be used for signing
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/signing", map[string]interface{}{
+		"type": "ecdsa-p256",
+	})
+	input := base64.StdEncoding.EncodeToString([]byte("the quick brown fox"))
+	for _, algorithm := range resp.Data["signature_hash_algorithms"].([]string) {
+		mustHandle(t, b, storage, logical.UpdateOperation, "sign/signing/"+algorithm, map[string]interface{}{
+			"input": input,
+		})
+	}
+}
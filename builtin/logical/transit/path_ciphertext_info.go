@@ -0,0 +1,91 @@
+package transit
+
+import (
+	"encoding/base64"
+
+	"github.com/hashicorp/vault/helper/errutil"
+	"github.com/hashicorp/vault/helper/keysutil"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+func (b *backend) pathCiphertextInfo() *framework.Path {
+	return &framework.Path{
+		Pattern: "ciphertext_info/" + framework.GenericNameRegex("name"),
+		Fields: map[string]*framework.FieldSchema{
+			"name": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "Name of the key the ciphertext is expected to belong to",
+			},
+
+			"ciphertext": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "The ciphertext to inspect",
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.pathCiphertextInfoRead,
+		},
+
+		HelpSynopsis:    pathCiphertextInfoHelpSyn,
+		HelpDescription: pathCiphertextInfoHelpDesc,
+	}
+}
+
+func (b *backend) pathCiphertextInfoRead(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+	ciphertext := d.Get("ciphertext").(string)
+	if ciphertext == "" {
+		return logical.ErrorResponse("missing ciphertext"), logical.ErrInvalidRequest
+	}
+
+	// Only the envelope is parsed; the ciphertext is never decrypted
+	ver, encoded, err := keysutil.ParseCiphertext(ciphertext)
+	if err != nil {
+		switch err.(type) {
+		case errutil.UserError:
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		default:
+			return nil, err
+		}
+	}
+	if ver < 0 {
+		return logical.ErrorResponse("invalid ciphertext: version number is negative"), logical.ErrInvalidRequest
+	}
+	if _, err := base64.StdEncoding.DecodeString(encoded); err != nil {
+		return logical.ErrorResponse("invalid ciphertext: could not decode base64"), logical.ErrInvalidRequest
+	}
+
+	p, lock, err := b.lm.GetPolicyShared(req.Storage, name)
+	if lock != nil {
+		defer lock.RUnlock()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return logical.ErrorResponse("encryption key not found"), logical.ErrInvalidRequest
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"name":           p.Name,
+			"version":        ver,
+			"latest_version": p.LatestVersion,
+			"version_exists": ver <= p.LatestVersion && ver >= p.MinAvailableVersion,
+			"decryptable":    ver <= p.LatestVersion && ver >= p.MinDecryptionVersion,
+		},
+	}, nil
+}
+
+const pathCiphertextInfoHelpSyn = `Return the key version that produced a ciphertext`
+
+const pathCiphertextInfoHelpDesc = `
+This path parses a ciphertext produced with the named key and returns the
+version of the key embedded in it, along with whether that version still
+exists and is allowed to be decrypted. The ciphertext is not decrypted, so
+access to this path can be granted without access to the decrypt path.
+Malformed ciphertexts are rejected.
+`
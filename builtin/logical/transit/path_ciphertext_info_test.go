@@ -0,0 +1,81 @@
+package transit
+
+import (
+	"testing"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestTransit_CiphertextInfo(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	info := func(ciphertext string) (*logical.Response, error) {
+		return b.HandleRequest(&logical.Request{
+			Storage:   storage,
+			Operation: logical.ReadOperation,
+			Path:      "ciphertext_info/foo",
+			Data: map[string]interface{}{
+				"ciphertext": ciphertext,
+			},
+		})
+	}
+	plaintext := map[string]interface{}{
+		"plaintext": "dGhlIHF1aWNrIGJyb3duIGZveA==",
+	}
+
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo", nil)
+	v1Ciphertext := mustHandle(t, b, storage, logical.UpdateOperation, "encrypt/foo", plaintext).Data["ciphertext"].(string)
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo/rotate", nil)
+	v2Ciphertext := mustHandle(t, b, storage, logical.UpdateOperation, "encrypt/foo", plaintext).Data["ciphertext"].(string)
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo/config", map[string]interface{}{
+		"min_decryption_version": 2,
+	})
+
+	for _, tc := range []struct {
+		ciphertext    string
+		version       int
+		versionExists bool
+		decryptable   bool
+	}{
+		{v1Ciphertext, 1, true, false},
+		{v2Ciphertext, 2, true, true},
+		{"vault:v3:" + v2Ciphertext[len("vault:v2:"):], 3, false, false},
+		// Ciphertext from the initial implementation with version 0
+		{"vault:v0:" + v2Ciphertext[len("vault:v2:"):], 1, true, false},
+	} {
+		resp, err := info(tc.ciphertext)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("%s: got err:\n%#v\nresp:\n%#v\n", tc.ciphertext, err, resp)
+		}
+		if resp.Data["name"] != "foo" || resp.Data["version"] != tc.version || resp.Data["latest_version"] != 2 ||
+			resp.Data["version_exists"] != tc.versionExists || resp.Data["decryptable"] != tc.decryptable {
+			t.Fatalf("%s: bad response: %#v", tc.ciphertext, resp.Data)
+		}
+	}
+
+	for _, ciphertext := range []string{
+		"",
+		"dGhlIHF1aWNrIGJyb3duIGZveA==",
+		"vault:v1",
+		"vault:vx:dGhlIHF1aWNr",
+		"vault:v-1:dGhlIHF1aWNr",
+		"vault:v1:not base64!",
+	} {
+		resp, err := info(ciphertext)
+		if err != logical.ErrInvalidRequest || resp == nil || !resp.IsError() {
+			t.Fatalf("%q: expected malformed ciphertext error, got %#v (err: %v)", ciphertext, resp, err)
+		}
+	}
+
+	resp, _ := b.HandleRequest(&logical.Request{
+		Storage:   storage,
+		Operation: logical.ReadOperation,
+		Path:      "ciphertext_info/missing",
+		Data: map[string]interface{}{
+			"ciphertext": v1Ciphertext,
+		},
+	})
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected error for a missing key, got %#v", resp)
+	}
+}
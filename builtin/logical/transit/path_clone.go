@@ -0,0 +1,123 @@
+package transit
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/vault/helper/errutil"
+	"github.com/hashicorp/vault/helper/keysutil"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+func (b *backend) pathClone() *framework.Path {
+	return &framework.Path{
+		Pattern: "keys/" + framework.GenericNameRegex("name") + "/clone",
+		Fields: map[string]*framework.FieldSchema{
+			"name": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "Name of the key to copy the settings of",
+			},
+
+			"target": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "Name of the key to create. Must not already be in use.",
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.pathCloneUpdate,
+		},
+
+		HelpSynopsis:    pathCloneHelpSyn,
+		HelpDescription: pathCloneHelpDesc,
+	}
+}
+
+func (b *backend) pathCloneUpdate(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+	target := d.Get("target").(string)
+
+	if target == "" {
+		return logical.ErrorResponse("missing target"), logical.ErrInvalidRequest
+	}
+	if !keyNameRegex.MatchString(target) {
+		return logical.ErrorResponse("invalid target"), logical.ErrInvalidRequest
+	}
+
+	p, lock, err := b.lm.GetPolicyShared(req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return logical.ErrorResponse("key not found"), logical.ErrInvalidRequest
+	}
+	if p.ManagedKeyName != "" {
+		lock.RUnlock()
+		return logical.ErrorResponse(fmt.Sprintf("key %s is a managed key; its material cannot be generated by Vault", name)), logical.ErrInvalidRequest
+	}
+
+	// Only settings are copied; exportability and plaintext backups have to
+	// be enabled explicitly for the new key
+	polReq := keysutil.PolicyRequest{
+		Storage:           req.Storage,
+		Name:              target,
+		KeyType:           p.Type,
+		Derived:           p.Derived,
+		Convergent:        p.ConvergentEncryption,
+		AutoRotatePeriod:  p.AutoRotatePeriod,
+		MaxVersions:       p.MaxVersions,
+		EntropySource:     p.EntropySource,
+		AllowedOperations: append([]string(nil), p.AllowedOperations...),
+		CreatedBy:         requestIdentity(req),
+		Upsert:            true,
+	}
+	if len(p.Tags) != 0 {
+		polReq.Tags = make(map[string]string, len(p.Tags))
+		for k, v := range p.Tags {
+			polReq.Tags[k] = v
+		}
+	}
+
+	// The source is released before the target is locked, so that clones
+	// running in opposite directions cannot deadlock
+	lock.RUnlock()
+
+	cloned, lock, upserted, err := b.lm.GetPolicyUpsert(polReq)
+	if lock != nil {
+		defer lock.RUnlock()
+	}
+	if err != nil {
+		switch err.(type) {
+		case errutil.UserError:
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		default:
+			return nil, err
+		}
+	}
+	if cloned == nil {
+		return nil, fmt.Errorf("error generating key: returned policy was nil")
+	}
+	if !upserted {
+		return logical.ErrorResponse(fmt.Sprintf("key %s already exists", target)), logical.ErrInvalidRequest
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"name":           cloned.Name,
+			"source":         name,
+			"type":           cloned.Type.String(),
+			"latest_version": cloned.LatestVersion,
+		},
+	}, nil
+}
+
+const pathCloneHelpSyn = `Create a new key with the settings of a named key`
+
+const pathCloneHelpDesc = `
+This path creates a new key named by the target parameter with the same type,
+derivation, convergent encryption, tags, automatic rotation period, version
+cap and allowed operations as the named key, but with newly generated key
+material. Exportability and plaintext backups are not copied. The clone is
+rejected if a key already exists under the target name.
+`
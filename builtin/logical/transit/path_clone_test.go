@@ -0,0 +1,85 @@
+package transit
+
+import (
+	"bytes"
+	"encoding/base64"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestTransit_Clone(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/source", map[string]interface{}{
+		"derived":               true,
+		"convergent_encryption": true,
+		"exportable":            true,
+		"tags":                  "team=payments",
+		"auto_rotate_period":    7200,
+		"allowed_operations":    "encrypt,decrypt",
+	})
+
+	resp := mustHandle(t, b, storage, logical.UpdateOperation, "keys/source/clone", map[string]interface{}{
+		"target": "copy",
+	})
+	if resp.Data["name"] != "copy" || resp.Data["source"] != "source" || resp.Data["latest_version"] != 1 {
+		t.Fatalf("bad clone response: %#v", resp.Data)
+	}
+
+	source := mustHandle(t, b, storage, logical.ReadOperation, "keys/source", nil).Data
+	clone := mustHandle(t, b, storage, logical.ReadOperation, "keys/copy", nil).Data
+	for _, field := range []string{"type", "derived", "convergent_encryption", "tags", "auto_rotate_period", "allowed_operations", "kdf"} {
+		if !reflect.DeepEqual(source[field], clone[field]) {
+			t.Fatalf("%s was not copied: %#v != %#v", field, source[field], clone[field])
+		}
+	}
+	if clone["exportable"] != false {
+		t.Fatalf("exportability should not be copied: %#v", clone)
+	}
+	if clone["fingerprint"] == source["fingerprint"] {
+		t.Fatal("expected the clone to have its own fingerprint")
+	}
+
+	// The clone has new key material, so it cannot decrypt the source's
+	// ciphertext
+	sourcePolicy, lock, err := b.lm.GetPolicyShared(storage, "source")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sourceKey := sourcePolicy.Keys[1].Key
+	lock.RUnlock()
+	clonePolicy, lock, err := b.lm.GetPolicyShared(storage, "copy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cloneKey := clonePolicy.Keys[1].Key
+	lock.RUnlock()
+	if len(cloneKey) != 32 || bytes.Equal(sourceKey, cloneKey) {
+		t.Fatal("expected the clone to have new key material")
+	}
+
+	context := base64.StdEncoding.EncodeToString([]byte("context"))
+	resp = mustHandle(t, b, storage, logical.UpdateOperation, "encrypt/source", map[string]interface{}{
+		"plaintext": base64.StdEncoding.EncodeToString([]byte(testPlaintext)),
+		"context":   context,
+	})
+	mustFail(t, b, storage, logical.UpdateOperation, "decrypt/copy", map[string]interface{}{
+		"ciphertext": resp.Data["ciphertext"],
+		"context":    context,
+	})
+
+	// Existing targets are never overwritten
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/existing", map[string]interface{}{
+		"type": "ed25519",
+	})
+	mustFail(t, b, storage, logical.UpdateOperation, "keys/source/clone", map[string]interface{}{"target": "existing"})
+	if resp := mustHandle(t, b, storage, logical.ReadOperation, "keys/existing", nil); resp.Data["type"] != "ed25519" {
+		t.Fatalf("existing key was changed: %#v", resp.Data)
+	}
+	mustFail(t, b, storage, logical.UpdateOperation, "keys/source/clone", map[string]interface{}{"target": "source"})
+	mustFail(t, b, storage, logical.UpdateOperation, "keys/source/clone", nil)
+	mustFail(t, b, storage, logical.UpdateOperation, "keys/source/clone", map[string]interface{}{"target": "bad/name"})
+	mustFail(t, b, storage, logical.UpdateOperation, "keys/missing/clone", map[string]interface{}{"target": "other"})
+}
@@ -2,6 +2,9 @@ package transit
 
 import (
 	"fmt"
+	"reflect"
+	"sort"
+	"time"
 
 	"github.com/hashicorp/vault/logical"
 	"github.com/hashicorp/vault/logical/framework"
@@ -23,6 +26,14 @@ to be decrypted. For signing keys, the minimum
 version allowed to be used for verification.`,
 			},
 
+			"reason": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `The reason for changing min_decryption_version,
+of at most 1024 bytes, such as an incident. It is
+recorded for auditing and only used when the min
+decryption version changes.`,
+			},
+
 			"min_encryption_version": &framework.FieldSchema{
 				Type: framework.TypeInt,
 				Description: `If set, the minimum version of the key allowed
@@ -31,10 +42,93 @@ to be used for signing. If set to zero, only
 the latest version of the key is allowed.`,
 			},
 
+			"disabled_encryption_versions": &framework.FieldSchema{
+				Type: framework.TypeCommaStringSlice,
+				Description: `A list of key versions that may not be used for
+encryption, even if they are at or above the min
+encryption version. They can still be used for
+decryption. The given list replaces the versions
+currently disabled; set it to an empty list to
+allow encryption with every version again.`,
+			},
+
+			"disabled_decryption_versions": &framework.FieldSchema{
+				Type: framework.TypeCommaStringSlice,
+				Description: `A list of key versions that may not be used for
+decryption, even if they are at or above the min
+decryption version, such as versions known to be
+compromised. They cannot be used for encryption
+either. The latest version cannot be disabled.
+The given list replaces the versions currently
+disabled; set it to an empty list to allow
+decryption with every version again.`,
+			},
+
 			"deletion_allowed": &framework.FieldSchema{
 				Type:        framework.TypeBool,
 				Description: "Whether to allow deletion of the key",
 			},
+
+			"exportable": &framework.FieldSchema{
+				Type: framework.TypeBool,
+				Description: `Whether all versions of the key may be
+exported. Once exportability has been turned off
+again, it cannot be re-enabled.`,
+			},
+
+			"allow_plaintext_backup": &framework.FieldSchema{
+				Type: framework.TypeBool,
+				Description: `Whether the key may be backed up in plaintext
+format. The key must also be exportable.`,
+			},
+
+			"allow_export_versions": &framework.FieldSchema{
+				Type: framework.TypeCommaStringSlice,
+				Description: `A list of key versions that may be exported
+even if the key is not exportable. Versions are
+added to those already allowed; once allowed, a
+version cannot be made unexportable again.`,
+			},
+
+			"auto_rotate_period": &framework.FieldSchema{
+				Type: framework.TypeDurationSecond,
+				Description: `Amount of time the key should live before
+being automatically rotated, counted from the
+creation of the latest version. A value of 0
+disables automatic rotation. Must be at least one
+hour if set.`,
+			},
+
+			"description": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `A free-text description of the key's purpose,
+of at most 1024 bytes. Set to an empty string to
+remove the description.`,
+			},
+
+			"tags": &framework.FieldSchema{
+				Type: framework.TypeKVPairs,
+				Description: `Key/value tags for the key. The given tags
+replace all existing tags; to remove every tag,
+set this to an empty map.`,
+			},
+
+			"enabled": &framework.FieldSchema{
+				Type: framework.TypeBool,
+				Description: `Whether the key may be used for cryptographic
+operations. A disabled key is kept and can still
+be read, and can be enabled again later.`,
+			},
+
+			"operation_rate_limit": &framework.FieldSchema{
+				Type: framework.TypeInt,
+				Description: `The maximum number of cryptographic operations
+per second allowed with the key, enforced
+separately on each node. Operations beyond the
+limit fail with a 429 status code, and batches
+with more items than the limit are rejected. A
+value of 0 removes the limit.`,
+			},
 		},
 
 		Callbacks: map[logical.Operation]framework.OperationFunc{
@@ -50,7 +144,8 @@ func (b *backend) pathConfigWrite(
 	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
 	name := d.Get("name").(string)
 
-	// Check if the policy already exists before we lock everything
+	// Configuration only ever tunes an existing key; keys are created via
+	// the keys/<name> path
 	p, lock, err := b.lm.GetPolicyExclusive(req.Storage, name)
 	if lock != nil {
 		defer lock.Unlock()
@@ -61,13 +156,21 @@ func (b *backend) pathConfigWrite(
 	if p == nil {
 		return logical.ErrorResponse(
 				fmt.Sprintf("no existing key named %s could be found", name)),
-			logical.ErrInvalidRequest
+			logical.ErrUnsupportedPath
 	}
 
 	resp := &logical.Response{}
 
-	persistNeeded := false
+	// Every field is parsed and validated before any of them is applied to
+	// the policy, which is the cached one, so that a rejected request leaves
+	// the key as it was
 
+	reason := d.Get("reason").(string)
+	if len(reason) > maxDescriptionLength {
+		return logical.ErrorResponse(fmt.Sprintf("reason must not be longer than %d bytes", maxDescriptionLength)), logical.ErrInvalidRequest
+	}
+
+	newMinDecryptionVersion := p.MinDecryptionVersion
 	minDecryptionVersionRaw, ok := d.GetOk("min_decryption_version")
 	if ok {
 		minDecryptionVersion := minDecryptionVersionRaw.(int)
@@ -86,11 +189,38 @@ func (b *backend) pathConfigWrite(
 				return logical.ErrorResponse(
 					fmt.Sprintf("cannot set min decryption version of %d, latest key version is %d", minDecryptionVersion, p.LatestVersion)), nil
 			}
-			p.MinDecryptionVersion = minDecryptionVersion
-			persistNeeded = true
+			if minDecryptionVersion < p.MinAvailableVersion {
+				return logical.ErrorResponse(
+					fmt.Sprintf("cannot set min decryption version of %d, versions below %d have been trimmed", minDecryptionVersion, p.MinAvailableVersion)), nil
+			}
+			for _, deleted := range p.DeletedVersions {
+				if deleted >= minDecryptionVersion {
+					return logical.ErrorResponse(
+						fmt.Sprintf("cannot set min decryption version of %d, version %d has been deleted", minDecryptionVersion, deleted)), nil
+				}
+			}
+			// Ciphertext of the versions below the new minimum can no longer be
+			// decrypted, which is the point when responding to a compromise but
+			// easy to do by accident otherwise
+			invalidated := 0
+			for ver := range p.Keys {
+				if ver >= p.MinDecryptionVersion && ver < minDecryptionVersion {
+					invalidated++
+				}
+			}
+			if invalidated != 0 {
+				resp.AddWarning(fmt.Sprintf("%d key version(s) below version %d can no longer be used for decryption", invalidated, minDecryptionVersion))
+			}
+			newMinDecryptionVersion = minDecryptionVersion
 		}
 	}
+	minDecryptionChanged := newMinDecryptionVersion != p.MinDecryptionVersion
+
+	if reason != "" && !minDecryptionChanged {
+		resp.AddWarning("the reason is only recorded when the min decryption version changes and was ignored")
+	}
 
+	newMinEncryptionVersion := p.MinEncryptionVersion
 	minEncryptionVersionRaw, ok := d.GetOk("min_encryption_version")
 	if ok {
 		minEncryptionVersion := minEncryptionVersionRaw.(int)
@@ -104,24 +234,211 @@ func (b *backend) pathConfigWrite(
 				return logical.ErrorResponse(
 					fmt.Sprintf("cannot set min encryption version of %d, latest key version is %d", minEncryptionVersion, p.LatestVersion)), nil
 			}
-			p.MinEncryptionVersion = minEncryptionVersion
-			persistNeeded = true
+			if minEncryptionVersion > 0 && minEncryptionVersion < p.MinAvailableVersion {
+				return logical.ErrorResponse(
+					fmt.Sprintf("cannot set min encryption version of %d, versions below %d have been trimmed", minEncryptionVersion, p.MinAvailableVersion)), nil
+			}
+			newMinEncryptionVersion = minEncryptionVersion
 		}
 	}
 
 	// Check here to get the final picture after the logic on each
 	// individually. MinDecryptionVersion will always be 1 or above.
-	if p.MinEncryptionVersion > 0 &&
-		p.MinEncryptionVersion < p.MinDecryptionVersion {
+	if newMinEncryptionVersion > 0 &&
+		newMinEncryptionVersion < newMinDecryptionVersion {
 		return logical.ErrorResponse(
-			fmt.Sprintf("cannot set min encryption/decryption values; min encryption version of %d must be greater than or equal to min decryption version of %d", p.MinEncryptionVersion, p.MinDecryptionVersion)), nil
+			fmt.Sprintf("cannot set min encryption/decryption values; min encryption version of %d must be greater than or equal to min decryption version of %d", newMinEncryptionVersion, newMinDecryptionVersion)), nil
+	}
+
+	var disabledEncryptionVersions []int
+	disabledEncryptionVersionsRaw, disabledEncryptionVersionsSet := d.GetOk("disabled_encryption_versions")
+	if disabledEncryptionVersionsSet {
+		disabledEncryptionVersions, err = parseKeyVersions(disabledEncryptionVersionsRaw.([]string))
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		for _, ver := range disabledEncryptionVersions {
+			if _, ok := p.Keys[ver]; !ok {
+				return logical.ErrorResponse(
+					fmt.Sprintf("cannot disable encryption with version %d; it does not exist or is below the min decryption version", ver)), nil
+			}
+		}
+		sort.Ints(disabledEncryptionVersions)
 	}
 
-	allowDeletionInt, ok := d.GetOk("deletion_allowed")
+	var disabledDecryptionVersions []int
+	disabledDecryptionVersionsRaw, disabledDecryptionVersionsSet := d.GetOk("disabled_decryption_versions")
+	if disabledDecryptionVersionsSet {
+		disabledDecryptionVersions, err = parseKeyVersions(disabledDecryptionVersionsRaw.([]string))
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		for _, ver := range disabledDecryptionVersions {
+			if _, ok := p.Keys[ver]; !ok {
+				return logical.ErrorResponse(
+					fmt.Sprintf("cannot disable decryption with version %d; it does not exist or is below the min decryption version", ver)), nil
+			}
+			if ver == p.LatestVersion {
+				return logical.ErrorResponse(
+					fmt.Sprintf("cannot disable decryption with version %d; it is the latest version, so the key must be rotated first", ver)), nil
+			}
+		}
+		sort.Ints(disabledDecryptionVersions)
+	}
+
+	allowDeletionRaw, allowDeletionSet := d.GetOk("deletion_allowed")
+
+	exportableRaw, exportableSet := d.GetOk("exportable")
+	if exportableSet {
+		exportable := exportableRaw.(bool)
+		switch {
+		case exportable == p.Exportable:
+			exportableSet = false
+		case exportable && p.ManagedKeyName != "":
+			return logical.ErrorResponse(fmt.Sprintf("key %s is a managed key without key material in Vault and cannot be made exportable", name)), logical.ErrInvalidRequest
+		case exportable && p.ExportRevoked:
+			return logical.ErrorResponse(fmt.Sprintf("exportability of key %s was turned off and cannot be enabled again", name)), logical.ErrInvalidRequest
+		}
+	}
+
+	allowPlaintextBackupRaw, allowPlaintextBackupSet := d.GetOk("allow_plaintext_backup")
+	if allowPlaintextBackupSet && allowPlaintextBackupRaw.(bool) && p.ManagedKeyName != "" {
+		return logical.ErrorResponse(fmt.Sprintf("key %s is a managed key without key material in Vault and cannot be backed up in plaintext", name)), logical.ErrInvalidRequest
+	}
+
+	enabledRaw, enabledSet := d.GetOk("enabled")
+
+	operationRateLimitRaw, operationRateLimitSet := d.GetOk("operation_rate_limit")
+	if operationRateLimitSet && operationRateLimitRaw.(int) < 0 {
+		return logical.ErrorResponse("operation rate limit cannot be negative"), logical.ErrInvalidRequest
+	}
+
+	var autoRotatePeriod time.Duration
+	autoRotatePeriodRaw, autoRotatePeriodSet := d.GetOk("auto_rotate_period")
+	if autoRotatePeriodSet {
+		autoRotatePeriod = time.Second * time.Duration(autoRotatePeriodRaw.(int))
+		if autoRotatePeriod != 0 && autoRotatePeriod < time.Hour {
+			return logical.ErrorResponse("auto rotate period must be 0 to disable or at least an hour"), logical.ErrInvalidRequest
+		}
+		if autoRotatePeriod != 0 && p.Imported && !p.AllowImportedKeyRotation {
+			return logical.ErrorResponse("auto rotate period requires rotation to be allowed for imported keys"), logical.ErrInvalidRequest
+		}
+		if autoRotatePeriod != 0 && p.ManagedKeyName != "" {
+			return logical.ErrorResponse("auto rotate period cannot be set for managed keys, which cannot be rotated"), logical.ErrInvalidRequest
+		}
+	}
+
+	var tags map[string]string
+	tagsRaw, tagsSet := d.GetOk("tags")
+	if tagsSet {
+		tags = tagsRaw.(map[string]string)
+		if err := validateTags(tags); err != nil {
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		}
+		if len(tags) == 0 {
+			tags = nil
+		}
+	}
+
+	descriptionRaw, descriptionSet := d.GetOk("description")
+	if descriptionSet {
+		if err := validateDescription(descriptionRaw.(string)); err != nil {
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		}
+	}
+
+	var allowExportVersions []int
+	allowExportVersionsRaw, ok := d.GetOk("allow_export_versions")
 	if ok {
-		allowDeletion := allowDeletionInt.(bool)
-		if allowDeletion != p.DeletionAllowed {
-			p.DeletionAllowed = allowDeletion
+		allowExportVersions, err = parseKeyVersions(allowExportVersionsRaw.([]string))
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		if len(allowExportVersions) != 0 && p.ManagedKeyName != "" {
+			return logical.ErrorResponse(fmt.Sprintf("key %s is a managed key without key material in Vault and cannot be exported", name)), logical.ErrInvalidRequest
+		}
+		for _, ver := range allowExportVersions {
+			if _, ok := p.Keys[ver]; !ok {
+				return logical.ErrorResponse(
+					fmt.Sprintf("cannot allow export of version %d; it does not exist or is below the min decryption version", ver)), nil
+			}
+		}
+	}
+
+	// Every field is valid, so they can all be applied
+	persistNeeded := false
+
+	if minDecryptionChanged {
+		p.MinDecryptionVersion = newMinDecryptionVersion
+		// The reason belongs to this change, so a change without one
+		// clears the reason given for an earlier change
+		p.MinDecryptionChangeReason = reason
+		persistNeeded = true
+	}
+
+	if newMinEncryptionVersion != p.MinEncryptionVersion {
+		p.MinEncryptionVersion = newMinEncryptionVersion
+		persistNeeded = true
+	}
+
+	if disabledEncryptionVersionsSet && !reflect.DeepEqual(disabledEncryptionVersions, p.DisabledEncryptionVersions) {
+		p.DisabledEncryptionVersions = disabledEncryptionVersions
+		persistNeeded = true
+	}
+
+	if disabledDecryptionVersionsSet && !reflect.DeepEqual(disabledDecryptionVersions, p.DisabledDecryptionVersions) {
+		p.DisabledDecryptionVersions = disabledDecryptionVersions
+		persistNeeded = true
+	}
+
+	if allowDeletionSet && allowDeletionRaw.(bool) != p.DeletionAllowed {
+		p.DeletionAllowed = allowDeletionRaw.(bool)
+		persistNeeded = true
+	}
+
+	if exportableSet {
+		// Turning exportability off is final
+		exportable := exportableRaw.(bool)
+		p.Exportable = exportable
+		p.ExportRevoked = !exportable
+		persistNeeded = true
+	}
+
+	if allowPlaintextBackupSet && allowPlaintextBackupRaw.(bool) != p.AllowPlaintextBackup {
+		p.AllowPlaintextBackup = allowPlaintextBackupRaw.(bool)
+		persistNeeded = true
+	}
+
+	if enabledSet && !enabledRaw.(bool) != p.Disabled {
+		p.Disabled = !enabledRaw.(bool)
+		persistNeeded = true
+	}
+
+	if operationRateLimitSet && operationRateLimitRaw.(int) != p.OperationRateLimit {
+		p.OperationRateLimit = operationRateLimitRaw.(int)
+		persistNeeded = true
+	}
+
+	if autoRotatePeriodSet && autoRotatePeriod != p.AutoRotatePeriod {
+		p.AutoRotatePeriod = autoRotatePeriod
+		persistNeeded = true
+	}
+
+	if tagsSet && !reflect.DeepEqual(tags, p.Tags) {
+		p.Tags = tags
+		persistNeeded = true
+	}
+
+	if descriptionSet && descriptionRaw.(string) != p.Description {
+		p.Description = descriptionRaw.(string)
+		persistNeeded = true
+	}
+
+	// Versions are only added once the exportability of the whole key has
+	// been applied, since there is nothing to add if it is exportable
+	for _, ver := range allowExportVersions {
+		if !p.VersionExportable(ver) {
+			p.AllowedExportVersions = append(p.AllowedExportVersions, ver)
 			persistNeeded = true
 		}
 	}
@@ -134,11 +451,25 @@ func (b *backend) pathConfigWrite(
 		persistNeeded = true
 	}
 
+	// Report the resulting schedule, which is based on the creation time of
+	// the latest version rather than on when the period was changed
+	if autoRotatePeriodSet {
+		resp.Data = map[string]interface{}{
+			"auto_rotate_period": int64(p.AutoRotatePeriod.Seconds()),
+		}
+		if nextRotation := p.NextRotationTime(); !nextRotation.IsZero() {
+			resp.Data["next_rotation"] = nextRotation.UTC().Format(time.RFC3339)
+		}
+	}
+
 	if !persistNeeded {
-		return nil, nil
+		if len(resp.Warnings) == 0 && resp.Data == nil {
+			return nil, nil
+		}
+		return resp, nil
 	}
 
-	if len(resp.Warnings) == 0 {
+	if len(resp.Warnings) == 0 && resp.Data == nil {
 		return nil, p.Persist(req.Storage)
 	}
 
@@ -148,7 +479,17 @@ func (b *backend) pathConfigWrite(
 const pathConfigHelpSyn = `Configure a named encryption key`
 
 const pathConfigHelpDesc = `
-This path is used to configure the named key. Currently, this
-supports adjusting the minimum version of the key allowed to
-be used for decryption via the min_decryption_version paramter.
+This path is used to configure the named key, which must already exist; it
+never creates a key.
+
+The versions the key may be used with are set by min_decryption_version,
+with an optional reason for raising it, min_encryption_version,
+disabled_encryption_versions and disabled_decryption_versions. Whether the
+key may be deleted, exported or backed up in plaintext is set by
+deletion_allowed, exportable and allow_plaintext_backup, and
+allow_export_versions lists versions that may be exported even if the key is
+not exportable. auto_rotate_period sets how often the key is rotated
+automatically, description and tags describe the key, enabled sets whether
+it may be used at all and operation_rate_limit limits the operations per
+second performed with it.
 `
@@ -0,0 +1,322 @@
+package transit
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/hashicorp/vault/helper/keysutil"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+const keysConfigStorageKey = "config/keys"
+
+// defaultMinContextLength is the context length, in bytes, below which
+// requests using derived keys are warned about unless configured otherwise.
+// It matches the 128-bit security level of the weakest supported key type.
+const defaultMinContextLength = 16
+
+// namespacePrefixRegex matches valid namespace prefixes, which follow the
+// same rules as key names
+var namespacePrefixRegex = regexp.MustCompile(`^\w(([\w-.]+)?\w)?$`)
+
+// keysConfig holds settings that apply to every key in the backend
+type keysConfig struct {
+	// Whether deleting a key requires its name to be given in the confirm
+	// field
+	RequireDeleteConfirmation bool `json:"require_delete_confirmation"`
+
+	// Whether deleting a key requires it to have been decommissioned first by
+	// raising its minimum decryption version to its latest version
+	RequireDecommissionBeforeDelete bool `json:"require_decommission_before_delete"`
+
+	// If set, keys are stored under this namespace and only keys within it
+	// can be used
+	NamespacePrefix string `json:"namespace_prefix"`
+
+	// The type of keys created without an explicit type; if empty, keys
+	// default to aes256-gcm96
+	DefaultKeyType string `json:"default_key_type"`
+
+	// Whether the derivation test vector endpoint is available; it is meant
+	// for client test suites and is off by default
+	EnableDerivationTestVectors bool `json:"enable_derivation_test_vectors"`
+
+	// The length, in bytes, below which a derivation context is warned about;
+	// if zero, defaultMinContextLength is used
+	MinContextLength int `json:"min_context_length"`
+
+	// Key types whose use is discouraged, mapped to the type recommended
+	// instead, which may be empty. Keys of these types can still be created,
+	// but with a warning.
+	DeprecatedKeyTypes map[string]string `json:"deprecated_key_types"`
+}
+
+func (b *backend) pathConfigKeys() *framework.Path {
+	return &framework.Path{
+		Pattern: "config/keys",
+		Fields: map[string]*framework.FieldSchema{
+			"require_delete_confirmation": &framework.FieldSchema{
+				Type: framework.TypeBool,
+				Description: `If set, deleting a key requires the confirm
+field to be set to the name of the key.`,
+			},
+
+			"require_decommission_before_delete": &framework.FieldSchema{
+				Type: framework.TypeBool,
+				Description: `If set, a key can only be deleted once its
+min_decryption_version has been raised to its
+latest version, so that deletion takes two
+deliberate steps.`,
+			},
+
+			"namespace_prefix": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `If set, keys are stored under this namespace.
+Key names are used as before, but only keys
+within the active namespace can be listed or
+used. Set to an empty string to use keys outside
+of any namespace.`,
+			},
+
+			"default_key_type": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `The type of keys created without an explicit
+type. Must be one of the supported key types. Set
+to an empty string to default to "aes256-gcm96".`,
+			},
+
+			"enable_derivation_test_vectors": &framework.FieldSchema{
+				Type: framework.TypeBool,
+				Description: `If set, the keys/<name>/derivation/vector
+endpoint returns the parameters and fingerprints of
+derived keys, for validating client derivation in
+test suites. Should not be enabled in production.`,
+			},
+
+			"min_context_length": &framework.FieldSchema{
+				Type: framework.TypeInt,
+				Description: `The length in bytes of the decoded derivation
+context below which reads and encryptions with
+derived keys return a warning. The request is
+not rejected. Set to 0 to use the default of 16.`,
+			},
+
+			"deprecated_key_types": &framework.FieldSchema{
+				Type: framework.TypeKVPairs,
+				Description: `Key types that are deprecated, each mapped to
+the type recommended instead or to an empty
+string. Creating a key of a deprecated type
+returns a warning but is not rejected. The given
+types replace those currently deprecated; set to
+an empty map to deprecate none.`,
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation:   b.pathConfigKeysRead,
+			logical.UpdateOperation: b.pathConfigKeysWrite,
+		},
+
+		HelpSynopsis:    pathConfigKeysHelpSyn,
+		HelpDescription: pathConfigKeysHelpDesc,
+	}
+}
+
+func (b *backend) pathConfigKeysRead(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	config, err := b.readKeysConfig(req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"require_delete_confirmation":        config.RequireDeleteConfirmation,
+			"require_decommission_before_delete": config.RequireDecommissionBeforeDelete,
+			"namespace_prefix":                   config.NamespacePrefix,
+			"default_key_type":                   config.defaultKeyType(),
+			"enable_derivation_test_vectors":     config.EnableDerivationTestVectors,
+			"min_context_length":                 config.minContextLength(),
+			"deprecated_key_types":               config.deprecatedKeyTypes(),
+		},
+	}, nil
+}
+
+func (b *backend) pathConfigKeysWrite(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	config, err := b.readKeysConfig(req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if requireConfirmRaw, ok := d.GetOk("require_delete_confirmation"); ok {
+		config.RequireDeleteConfirmation = requireConfirmRaw.(bool)
+	}
+
+	if requireDecommissionRaw, ok := d.GetOk("require_decommission_before_delete"); ok {
+		config.RequireDecommissionBeforeDelete = requireDecommissionRaw.(bool)
+	}
+
+	namespaceChanged := false
+	if namespacePrefixRaw, ok := d.GetOk("namespace_prefix"); ok {
+		namespacePrefix := namespacePrefixRaw.(string)
+		if namespacePrefix != "" && !namespacePrefixRegex.MatchString(namespacePrefix) {
+			return logical.ErrorResponse("namespace prefix may only contain letters, digits, underscores, dashes and dots"), logical.ErrInvalidRequest
+		}
+		namespaceChanged = namespacePrefix != config.NamespacePrefix
+		config.NamespacePrefix = namespacePrefix
+	}
+
+	if defaultKeyTypeRaw, ok := d.GetOk("default_key_type"); ok {
+		defaultKeyType := defaultKeyTypeRaw.(string)
+		if defaultKeyType != "" {
+			if _, ok := keysutil.ParseKeyType(defaultKeyType); !ok {
+				return logical.ErrorResponse(fmt.Sprintf("unknown key type %v", defaultKeyType)), logical.ErrInvalidRequest
+			}
+		}
+		config.DefaultKeyType = defaultKeyType
+	}
+
+	if enableTestVectorsRaw, ok := d.GetOk("enable_derivation_test_vectors"); ok {
+		config.EnableDerivationTestVectors = enableTestVectorsRaw.(bool)
+	}
+
+	if minContextLengthRaw, ok := d.GetOk("min_context_length"); ok {
+		minContextLength := minContextLengthRaw.(int)
+		if minContextLength < 0 {
+			return logical.ErrorResponse("min_context_length cannot be negative"), logical.ErrInvalidRequest
+		}
+		config.MinContextLength = minContextLength
+	}
+
+	if deprecatedKeyTypesRaw, ok := d.GetOk("deprecated_key_types"); ok {
+		deprecatedKeyTypes := deprecatedKeyTypesRaw.(map[string]string)
+		for keyType, successor := range deprecatedKeyTypes {
+			if _, ok := keysutil.ParseKeyType(keyType); !ok {
+				return logical.ErrorResponse(fmt.Sprintf("unknown key type %v", keyType)), logical.ErrInvalidRequest
+			}
+			if successor == "" {
+				continue
+			}
+			if _, ok := keysutil.ParseKeyType(successor); !ok {
+				return logical.ErrorResponse(fmt.Sprintf("unknown key type %v recommended instead of %v", successor, keyType)), logical.ErrInvalidRequest
+			}
+			if _, ok := deprecatedKeyTypes[successor]; ok {
+				return logical.ErrorResponse(fmt.Sprintf("key type %v recommended instead of %v is deprecated itself", successor, keyType)), logical.ErrInvalidRequest
+			}
+		}
+		if len(deprecatedKeyTypes) == 0 {
+			deprecatedKeyTypes = nil
+		}
+		config.DeprecatedKeyTypes = deprecatedKeyTypes
+	}
+
+	entry, err := logical.StorageEntryJSON(keysConfigStorageKey, config)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Put(entry); err != nil {
+		return nil, err
+	}
+
+	// The namespace lock is already held by HandleRequest for this request
+	if namespaceChanged {
+		b.resetNamespacePrefixLocked()
+	} else {
+		b.resetKeysConfig()
+	}
+
+	return nil, nil
+}
+
+// defaultKeyType returns the type of keys created without an explicit type
+func (c *keysConfig) defaultKeyType() string {
+	if c.DefaultKeyType == "" {
+		return "aes256-gcm96"
+	}
+	return c.DefaultKeyType
+}
+
+// minContextLength returns the context length, in bytes, below which a
+// derivation context is warned about
+func (c *keysConfig) minContextLength() int {
+	if c.MinContextLength == 0 {
+		return defaultMinContextLength
+	}
+	return c.MinContextLength
+}
+
+// deprecatedKeyTypes returns the deprecated key types, never nil so that
+// configurations without any are reported consistently
+func (c *keysConfig) deprecatedKeyTypes() map[string]string {
+	if c.DeprecatedKeyTypes == nil {
+		return map[string]string{}
+	}
+	return c.DeprecatedKeyTypes
+}
+
+// deprecationWarning returns a warning recommending the successor of the key
+// type if it is deprecated, or an empty string if it is not
+func (c *keysConfig) deprecationWarning(keyType string) string {
+	successor, ok := c.DeprecatedKeyTypes[keyType]
+	switch {
+	case !ok:
+		return ""
+	case successor == "":
+		return fmt.Sprintf("key type %s is deprecated", keyType)
+	default:
+		return fmt.Sprintf("key type %s is deprecated; consider using %s instead", keyType, successor)
+	}
+}
+
+// shortContextWarning returns a warning if any of the given derivation
+// contexts is shorter than the configured minimum, or an empty string if none
+// is. Short contexts do not fail requests, but they reduce the number of
+// distinct keys that can be derived.
+func (b *backend) shortContextWarning(storage logical.Storage, contexts ...[]byte) (string, error) {
+	minLength, err := b.getMinContextLength(storage)
+	if err != nil {
+		return "", err
+	}
+
+	short := 0
+	for _, context := range contexts {
+		if len(context) != 0 && len(context) < minLength {
+			short++
+		}
+	}
+	if short == 0 {
+		return "", nil
+	}
+	if len(contexts) == 1 {
+		return fmt.Sprintf("the context is shorter than the recommended minimum of %d bytes, which reduces the number of distinct keys that can be derived", minLength), nil
+	}
+	return fmt.Sprintf("%d context(s) are shorter than the recommended minimum of %d bytes, which reduces the number of distinct keys that can be derived", short, minLength), nil
+}
+
+// readKeysConfig returns the backend-wide key configuration, or the defaults
+// if it has never been written
+func (b *backend) readKeysConfig(storage logical.Storage) (*keysConfig, error) {
+	config := &keysConfig{}
+
+	entry, err := storage.Get(keysConfigStorageKey)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return config, nil
+	}
+
+	if err := entry.DecodeJSON(config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+const pathConfigKeysHelpSyn = `Configure settings that apply to all keys`
+
+const pathConfigKeysHelpDesc = `
+This path is used to configure settings that apply to every key in the
+backend, rather than to a single named key.
+`
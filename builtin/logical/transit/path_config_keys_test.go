@@ -0,0 +1,584 @@
+package transit
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestTransit_ConfigKeysDeleteConfirmation(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	createDeletableKey := func(name string) {
+		mustHandleRequest(t, b, &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "keys/" + name,
+		})
+		mustHandleRequest(t, b, &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "keys/" + name + "/config",
+			Data: map[string]interface{}{
+				"deletion_allowed": true,
+			},
+		})
+	}
+
+	req := &logical.Request{
+		Storage:   storage,
+		Operation: logical.ReadOperation,
+		Path:      "config/keys",
+	}
+	if resp := mustHandleRequest(t, b, req); resp.Data["require_delete_confirmation"] != false {
+		t.Fatalf("expected confirmation not to be required by default, got %#v", resp.Data)
+	}
+
+	// Without the setting, keys are deleted without confirmation
+	createDeletableKey("foo")
+	mustHandleRequest(t, b, &logical.Request{
+		Storage:   storage,
+		Operation: logical.DeleteOperation,
+		Path:      "keys/foo",
+	})
+
+	req.Operation = logical.UpdateOperation
+	req.Data = map[string]interface{}{
+		"require_delete_confirmation": true,
+	}
+	mustHandleRequest(t, b, req)
+	req.Operation = logical.ReadOperation
+	req.Data = nil
+	if resp := mustHandleRequest(t, b, req); resp.Data["require_delete_confirmation"] != true {
+		t.Fatalf("expected confirmation to be required, got %#v", resp.Data)
+	}
+
+	createDeletableKey("bar")
+	if _, err := b.HandleRequest(&logical.Request{
+		Storage:   storage,
+		Operation: logical.DeleteOperation,
+		Path:      "keys/bar",
+	}); err != logical.ErrInvalidRequest {
+		t.Fatalf("expected deletion without confirmation to fail, got err: %v", err)
+	}
+	for _, confirm := range []string{"", "baz"} {
+		resp, err := b.HandleRequest(&logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "keys/bar/delete",
+			Data: map[string]interface{}{
+				"confirm": confirm,
+			},
+		})
+		if err != logical.ErrInvalidRequest || resp == nil || !strings.Contains(resp.Data["error"].(string), "confirm") {
+			t.Fatalf("confirm %q: expected confirmation error, got %#v (err: %v)", confirm, resp, err)
+		}
+	}
+
+	mustHandleRequest(t, b, &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "keys/bar/delete",
+		Data: map[string]interface{}{
+			"confirm": "bar",
+		},
+	})
+	if resp := mustHandleRequest(t, b, &logical.Request{
+		Storage:   storage,
+		Operation: logical.ReadOperation,
+		Path:      "keys/bar",
+	}); resp != nil {
+		t.Fatalf("expected key to be deleted, got %#v", resp)
+	}
+}
+
+func TestTransit_ConfigKeysDecommissionBeforeDelete(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	if resp := mustHandle(t, b, storage, logical.ReadOperation, "config/keys", nil); resp.Data["require_decommission_before_delete"] != false {
+		t.Fatalf("expected decommissioning not to be required by default, got %#v", resp.Data)
+	}
+	mustHandle(t, b, storage, logical.UpdateOperation, "config/keys", map[string]interface{}{
+		"require_decommission_before_delete": true,
+	})
+	if resp := mustHandle(t, b, storage, logical.ReadOperation, "config/keys", nil); resp.Data["require_decommission_before_delete"] != true {
+		t.Fatalf("expected decommissioning to be required, got %#v", resp.Data)
+	}
+
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo", nil)
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo/rotate", nil)
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo/rotate", nil)
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo/config", map[string]interface{}{
+		"deletion_allowed": true,
+	})
+
+	// Deletion is blocked while older versions can still be decrypted, with
+	// an error naming the step needed
+	for _, minDecryptionVersion := range []int{1, 2} {
+		mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo/config", map[string]interface{}{
+			"min_decryption_version": minDecryptionVersion,
+		})
+		resp, err := b.HandleRequest(&logical.Request{
+			Storage:   storage,
+			Operation: logical.DeleteOperation,
+			Path:      "keys/foo",
+		})
+		if err != logical.ErrInvalidRequest || resp == nil || !strings.Contains(resp.Data["error"].(string), "set min_decryption_version to the latest version 3") {
+			t.Fatalf("min decryption version %d: expected decommission error, got %#v (err: %v)", minDecryptionVersion, resp, err)
+		}
+		if resp := mustHandle(t, b, storage, logical.ReadOperation, "keys/foo", nil); resp == nil {
+			t.Fatal("expected key not to be deleted")
+		}
+	}
+
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo/config", map[string]interface{}{
+		"min_decryption_version": 3,
+	})
+	mustHandle(t, b, storage, logical.DeleteOperation, "keys/foo", nil)
+	if resp := mustHandle(t, b, storage, logical.ReadOperation, "keys/foo", nil); resp != nil {
+		t.Fatalf("expected key to be deleted, got %#v", resp)
+	}
+
+	// Missing keys are still reported as such
+	resp, _ := b.HandleRequest(&logical.Request{
+		Storage:   storage,
+		Operation: logical.DeleteOperation,
+		Path:      "keys/missing",
+	})
+	if resp == nil || !strings.Contains(resp.Data["error"].(string), "not found") {
+		t.Fatalf("expected not found error, got %#v", resp)
+	}
+}
+
+func TestTransit_ConfigKeysNamespacePrefix(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	setNamespace := func(prefix string) {
+		mustHandle(t, b, storage, logical.UpdateOperation, "config/keys", map[string]interface{}{
+			"namespace_prefix": prefix,
+		})
+	}
+	listKeys := func() []string {
+		resp := mustHandle(t, b, storage, logical.ListOperation, "keys/", nil)
+		keys, _ := resp.Data["keys"].([]string)
+		sort.Strings(keys)
+		return keys
+	}
+	plaintext := "dGhlIHF1aWNrIGJyb3duIGZveA=="
+
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/shared", nil)
+
+	setNamespace("tenant-a")
+	if resp := mustHandle(t, b, storage, logical.ReadOperation, "config/keys", nil); resp.Data["namespace_prefix"] != "tenant-a" {
+		t.Fatalf("bad namespace prefix: %#v", resp.Data)
+	}
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo", nil)
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/a-only", nil)
+	ciphertextA := mustHandle(t, b, storage, logical.UpdateOperation, "encrypt/foo", map[string]interface{}{
+		"plaintext": plaintext,
+	}).Data["ciphertext"].(string)
+	if keys := listKeys(); !reflect.DeepEqual(keys, []string{"a-only", "foo"}) {
+		t.Fatalf("bad keys in tenant-a: %v", keys)
+	}
+	if resp := mustHandle(t, b, storage, logical.ReadOperation, "keys/shared", nil); resp != nil {
+		t.Fatalf("expected key outside the namespace not to be visible: %#v", resp)
+	}
+
+	// A key with the same name in another namespace is a different key, even
+	// though the first one was cached
+	setNamespace("tenant-b")
+	if resp := mustHandle(t, b, storage, logical.ReadOperation, "keys/foo", nil); resp != nil {
+		t.Fatalf("expected tenant-a key not to be visible in tenant-b: %#v", resp)
+	}
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo", nil)
+	if keys := listKeys(); !reflect.DeepEqual(keys, []string{"foo"}) {
+		t.Fatalf("bad keys in tenant-b: %v", keys)
+	}
+	resp, err := b.HandleRequest(&logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "decrypt/foo",
+		Data: map[string]interface{}{
+			"ciphertext": ciphertextA,
+		},
+	})
+	if err == nil && (resp == nil || !resp.IsError()) {
+		t.Fatal("expected tenant-a ciphertext not to decrypt with the tenant-b key")
+	}
+
+	setNamespace("tenant-a")
+	resp = mustHandle(t, b, storage, logical.UpdateOperation, "decrypt/foo", map[string]interface{}{
+		"ciphertext": ciphertextA,
+	})
+	if resp.Data["plaintext"] != plaintext {
+		t.Fatalf("bad plaintext: %#v", resp.Data)
+	}
+
+	// Without a namespace, only keys outside of any namespace are listed
+	setNamespace("")
+	if keys := listKeys(); !reflect.DeepEqual(keys, []string{"shared"}) {
+		t.Fatalf("bad keys without a namespace: %v", keys)
+	}
+
+	// The namespace is persisted for new backends on the same storage
+	setNamespace("tenant-b")
+	config := logical.TestBackendConfig()
+	config.StorageView = storage
+	b2 := Backend(config)
+	if err := b2.Backend.Setup(config); err != nil {
+		t.Fatal(err)
+	}
+	resp, err = b2.HandleRequest(&logical.Request{
+		Storage:   storage,
+		Operation: logical.ListOperation,
+		Path:      "keys/",
+	})
+	if err != nil || resp == nil || !reflect.DeepEqual(resp.Data["keys"], []string{"foo"}) {
+		t.Fatalf("bad keys from new backend: %#v (err: %v)", resp, err)
+	}
+
+	resp, err = b.HandleRequest(&logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "config/keys",
+		Data: map[string]interface{}{
+			"namespace_prefix": "tenant/a",
+		},
+	})
+	if err == nil && (resp == nil || !resp.IsError()) {
+		t.Fatal("expected error for a namespace prefix containing a slash")
+	}
+}
+
+func TestTransit_ConfigKeysNamespacePrefixConcurrent(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	setNamespace := func(prefix string) {
+		mustHandle(t, b, storage, logical.UpdateOperation, "config/keys", map[string]interface{}{
+			"namespace_prefix": prefix,
+		})
+	}
+	keyType := func() interface{} {
+		return mustHandle(t, b, storage, logical.ReadOperation, "keys/foo", nil).Data["type"]
+	}
+
+	// A key of the same name but a different type in each namespace shows
+	// which namespace a cached policy belongs to
+	setNamespace("tenant-a")
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo", nil)
+	setNamespace("tenant-b")
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo", map[string]interface{}{
+		"type": "ecdsa-p256",
+	})
+
+	expected := map[string]string{
+		"tenant-a": "aes256-gcm96",
+		"tenant-b": "ecdsa-p256",
+	}
+	for i := 0; i < 20; i++ {
+		namespace := "tenant-a"
+		if i%2 == 1 {
+			namespace = "tenant-b"
+		}
+
+		// Reads in flight while the namespace changes must not leave a
+		// policy of the previous namespace in the cache
+		var wg sync.WaitGroup
+		for j := 0; j < 4; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				b.HandleRequest(&logical.Request{
+					Storage:   storage,
+					Operation: logical.ReadOperation,
+					Path:      "keys/foo",
+				})
+			}()
+		}
+		setNamespace(namespace)
+		wg.Wait()
+
+		if got := keyType(); got != expected[namespace] {
+			t.Fatalf("iteration %d: expected the %s key in %s, got %v", i, expected[namespace], namespace, got)
+		}
+	}
+
+	// Invalidations of keys in other namespaces leave cached policies alone
+	setNamespace("tenant-a")
+	keyType()
+	before := b.lm.CacheStats().Entries
+	b.invalidate("policy/tenant-b/foo")
+	if after := b.lm.CacheStats().Entries; after != before {
+		t.Fatalf("expected invalidation in another namespace to keep the cache, had %d entries, now %d", before, after)
+	}
+	b.invalidate("policy/tenant-a/foo")
+	if after := b.lm.CacheStats().Entries; after != before-1 {
+		t.Fatalf("expected invalidation to drop the key, had %d entries, now %d", before, after)
+	}
+}
+
+func TestTransit_ConfigKeysDefaultKeyType(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	keyType := func(name string) interface{} {
+		return mustHandle(t, b, storage, logical.ReadOperation, "keys/"+name, nil).Data["type"]
+	}
+
+	if resp := mustHandle(t, b, storage, logical.ReadOperation, "config/keys", nil); resp.Data["default_key_type"] != "aes256-gcm96" {
+		t.Fatalf("bad default key type: %#v", resp.Data)
+	}
+
+	mustHandle(t, b, storage, logical.UpdateOperation, "config/keys", map[string]interface{}{
+		"default_key_type": "ecdsa-p256",
+	})
+	if resp := mustHandle(t, b, storage, logical.ReadOperation, "config/keys", nil); resp.Data["default_key_type"] != "ecdsa-p256" {
+		t.Fatalf("bad default key type: %#v", resp.Data)
+	}
+
+	// Keys created without a type, or with an empty one, use the default
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/default", nil)
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/empty", map[string]interface{}{
+		"type": "",
+	})
+	for _, name := range []string{"default", "empty"} {
+		if kt := keyType(name); kt != "ecdsa-p256" {
+			t.Fatalf("%s: expected the default key type, got %v", name, kt)
+		}
+	}
+
+	// An explicit type overrides the default
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/explicit", map[string]interface{}{
+		"type": "aes256-gcm96",
+	})
+	if kt := keyType("explicit"); kt != "aes256-gcm96" {
+		t.Fatalf("expected the explicit key type, got %v", kt)
+	}
+
+	// Rewriting an existing key without a type only warns, even if its type
+	// differs from the default
+	if resp := mustHandle(t, b, storage, logical.UpdateOperation, "keys/explicit", nil); resp == nil || len(resp.Warnings) != 1 {
+		t.Fatalf("expected already existed warning, got %#v", resp)
+	}
+
+	resp, err := b.HandleRequest(&logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "config/keys",
+		Data: map[string]interface{}{
+			"default_key_type": "aes512-gcm96",
+		},
+	})
+	if err != logical.ErrInvalidRequest || resp == nil || !strings.Contains(resp.Data["error"].(string), "unknown key type") {
+		t.Fatalf("expected unknown key type to be rejected, got %#v (err: %v)", resp, err)
+	}
+
+	// Clearing the default goes back to aes256-gcm96
+	mustHandle(t, b, storage, logical.UpdateOperation, "config/keys", map[string]interface{}{
+		"default_key_type": "",
+	})
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/cleared", nil)
+	if kt := keyType("cleared"); kt != "aes256-gcm96" {
+		t.Fatalf("expected aes256-gcm96 after clearing the default, got %v", kt)
+	}
+}
+
+func TestTransit_ConfigKeysMinContextLength(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	shortContextWarnings := func(resp *logical.Response) int {
+		var count int
+		for _, warning := range resp.Warnings {
+			if strings.Contains(warning, "shorter than the recommended minimum") {
+				count++
+			}
+		}
+		return count
+	}
+
+	if resp := mustHandle(t, b, storage, logical.ReadOperation, "config/keys", nil); resp.Data["min_context_length"] != 16 {
+		t.Fatalf("bad default min context length: %#v", resp.Data)
+	}
+
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/derived", map[string]interface{}{
+		"derived": true,
+	})
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/plain", nil)
+
+	// "short" and "a long enough context"
+	shortContext := "c2hvcnQ="
+	longContext := "YSBsb25nIGVub3VnaCBjb250ZXh0"
+
+	resp := mustHandle(t, b, storage, logical.ReadOperation, "keys/derived", map[string]interface{}{
+		"context": shortContext,
+	})
+	if shortContextWarnings(resp) != 1 || !strings.Contains(resp.Warnings[0], "minimum of 16 bytes") {
+		t.Fatalf("expected short context warning, got %#v", resp.Warnings)
+	}
+	resp = mustHandle(t, b, storage, logical.ReadOperation, "keys/derived", map[string]interface{}{
+		"context": longContext,
+	})
+	if shortContextWarnings(resp) != 0 {
+		t.Fatalf("unexpected warnings: %#v", resp.Warnings)
+	}
+
+	// Encryption with a short context succeeds but warns
+	resp = mustHandle(t, b, storage, logical.UpdateOperation, "encrypt/derived", map[string]interface{}{
+		"plaintext": "dGhlIHF1aWNrIGJyb3duIGZveA==",
+		"context":   shortContext,
+	})
+	if resp.Data["ciphertext"] == nil || shortContextWarnings(resp) != 1 {
+		t.Fatalf("expected ciphertext and short context warning, got %#v", resp)
+	}
+	resp = mustHandle(t, b, storage, logical.UpdateOperation, "encrypt/derived", map[string]interface{}{
+		"batch_input": []interface{}{
+			map[string]interface{}{"plaintext": "dGhlIHF1aWNrIGJyb3duIGZveA==", "context": shortContext},
+			map[string]interface{}{"plaintext": "dGhlIHF1aWNrIGJyb3duIGZveA==", "context": longContext},
+			map[string]interface{}{"plaintext": "dGhlIHF1aWNrIGJyb3duIGZveA==", "context": shortContext},
+		},
+	})
+	if shortContextWarnings(resp) != 1 || !strings.Contains(resp.Warnings[0], "2 context(s)") {
+		t.Fatalf("expected batch short context warning, got %#v", resp.Warnings)
+	}
+
+	// Keys that are not derived ignore the context
+	resp = mustHandle(t, b, storage, logical.UpdateOperation, "encrypt/plain", map[string]interface{}{
+		"plaintext": "dGhlIHF1aWNrIGJyb3duIGZveA==",
+		"context":   shortContext,
+	})
+	if resp != nil && shortContextWarnings(resp) != 0 {
+		t.Fatalf("unexpected warnings: %#v", resp.Warnings)
+	}
+
+	// Lowering the minimum silences the warning
+	mustHandle(t, b, storage, logical.UpdateOperation, "config/keys", map[string]interface{}{
+		"min_context_length": 4,
+	})
+	resp = mustHandle(t, b, storage, logical.UpdateOperation, "encrypt/derived", map[string]interface{}{
+		"plaintext": "dGhlIHF1aWNrIGJyb3duIGZveA==",
+		"context":   shortContext,
+	})
+	if shortContextWarnings(resp) != 0 {
+		t.Fatalf("unexpected warnings: %#v", resp.Warnings)
+	}
+
+	// The minimum is cached, so a change written by another node only takes
+	// effect once the config is invalidated
+	entry, err := logical.StorageEntryJSON(keysConfigStorageKey, &keysConfig{
+		MinContextLength: 32,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Put(entry); err != nil {
+		t.Fatal(err)
+	}
+	b.invalidate(keysConfigStorageKey)
+	resp = mustHandle(t, b, storage, logical.UpdateOperation, "encrypt/derived", map[string]interface{}{
+		"plaintext": "dGhlIHF1aWNrIGJyb3duIGZveA==",
+		"context":   longContext,
+	})
+	if shortContextWarnings(resp) != 1 || !strings.Contains(resp.Warnings[0], "minimum of 32 bytes") {
+		t.Fatalf("expected short context warning after invalidation, got %#v", resp.Warnings)
+	}
+
+	resp, err = b.HandleRequest(&logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "config/keys",
+		Data: map[string]interface{}{
+			"min_context_length": -1,
+		},
+	})
+	if err != logical.ErrInvalidRequest || resp == nil || !resp.IsError() {
+		t.Fatalf("expected negative length to be rejected, got %#v (err: %v)", resp, err)
+	}
+}
+
+func TestTransit_ConfigKeysDeprecatedKeyTypes(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	doErrReq := func(data map[string]interface{}) {
+		resp, err := b.HandleRequest(&logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "config/keys",
+			Data:      data,
+		})
+		if err != logical.ErrInvalidRequest || resp == nil || !resp.IsError() {
+			t.Fatalf("expected invalid request, got %#v (err: %v)", resp, err)
+		}
+	}
+
+	resp := mustHandle(t, b, storage, logical.ReadOperation, "config/keys", nil)
+	if !reflect.DeepEqual(resp.Data["deprecated_key_types"], map[string]string{}) {
+		t.Fatalf("expected no deprecated key types: %#v", resp.Data)
+	}
+
+	mustHandle(t, b, storage, logical.UpdateOperation, "config/keys", map[string]interface{}{
+		"deprecated_key_types": map[string]interface{}{
+			"rsa-2048":     "rsa-4096",
+			"aes128-gcm96": "",
+		},
+	})
+	resp = mustHandle(t, b, storage, logical.ReadOperation, "config/keys", nil)
+	expected := map[string]string{
+		"rsa-2048":     "rsa-4096",
+		"aes128-gcm96": "",
+	}
+	if !reflect.DeepEqual(resp.Data["deprecated_key_types"], expected) {
+		t.Fatalf("bad deprecated key types: %#v", resp.Data)
+	}
+
+	// Keys of deprecated types are still created, with a warning that
+	// recommends the successor if there is one
+	resp = mustHandle(t, b, storage, logical.UpdateOperation, "keys/rsa", map[string]interface{}{
+		"type": "rsa-2048",
+	})
+	if resp == nil || !reflect.DeepEqual(resp.Warnings, []string{"key type rsa-2048 is deprecated; consider using rsa-4096 instead"}) {
+		t.Fatalf("expected deprecation warning, got %#v", resp)
+	}
+	if resp := mustHandle(t, b, storage, logical.ReadOperation, "keys/rsa", nil); resp.Data["type"] != "rsa-2048" {
+		t.Fatalf("bad key: %#v", resp.Data)
+	}
+	resp = mustHandle(t, b, storage, logical.UpdateOperation, "keys/aes", map[string]interface{}{
+		"type": "aes128-gcm96",
+	})
+	if resp == nil || !reflect.DeepEqual(resp.Warnings, []string{"key type aes128-gcm96 is deprecated"}) {
+		t.Fatalf("expected deprecation warning, got %#v", resp)
+	}
+
+	// Existing keys and other types are not warned about
+	resp = mustHandle(t, b, storage, logical.UpdateOperation, "keys/rsa", map[string]interface{}{
+		"type": "rsa-2048",
+	})
+	if resp == nil || len(resp.Warnings) != 1 || !strings.Contains(resp.Warnings[0], "already existed") {
+		t.Fatalf("expected only the existing key warning, got %#v", resp)
+	}
+	if resp := mustHandle(t, b, storage, logical.UpdateOperation, "keys/default", nil); resp != nil {
+		t.Fatalf("expected no warning, got %#v", resp)
+	}
+
+	for _, deprecated := range []map[string]interface{}{
+		{"rsa-1024": "rsa-4096"},
+		{"rsa-2048": "rsa-8192"},
+		{"rsa-2048": "rsa-3072", "rsa-3072": "rsa-4096"},
+	} {
+		doErrReq(map[string]interface{}{
+			"deprecated_key_types": deprecated,
+		})
+	}
+
+	// An empty map deprecates no types
+	mustHandle(t, b, storage, logical.UpdateOperation, "config/keys", map[string]interface{}{
+		"deprecated_key_types": map[string]interface{}{},
+	})
+	if resp := mustHandle(t, b, storage, logical.UpdateOperation, "keys/rsa2", map[string]interface{}{
+		"type": "rsa-2048",
+	}); resp != nil {
+		t.Fatalf("expected no warning, got %#v", resp)
+	}
+}
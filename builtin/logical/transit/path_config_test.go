@@ -1,10 +1,15 @@
 package transit
 
 import (
+	"encoding/base64"
+	"net/http"
+	"reflect"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/hashicorp/vault/helper/keysutil"
 	"github.com/hashicorp/vault/logical"
 )
 
@@ -221,3 +226,728 @@ func TestTransit_ConfigSettings(t *testing.T) {
 	testHMAC(3, true)
 	testHMAC(2, false)
 }
+
+func TestTransit_ConfigMissingKey(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	req := &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "keys/missing/config",
+		Data: map[string]interface{}{
+			"deletion_allowed": true,
+		},
+	}
+	resp, err := b.HandleRequest(req)
+	if err != logical.ErrUnsupportedPath || resp == nil || !resp.IsError() {
+		t.Fatalf("expected not found error; resp: %#v, err: %v", resp, err)
+	}
+	if status, _ := logical.RespondErrorCommon(req, resp, err); status != http.StatusNotFound {
+		t.Fatalf("expected status %d, got %d", http.StatusNotFound, status)
+	}
+
+	// The key must not have been created
+	req.Operation = logical.ReadOperation
+	req.Path = "keys/missing"
+	req.Data = nil
+	resp, err = b.HandleRequest(req)
+	if err != nil || resp != nil {
+		t.Fatalf("expected no key; resp: %#v, err: %v", resp, err)
+	}
+}
+
+func TestTransit_ConfigDisable(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	doDisabledReq := func(op logical.Operation, path string, data map[string]interface{}) {
+		resp, err := b.HandleRequest(&logical.Request{
+			Storage:   storage,
+			Operation: op,
+			Path:      path,
+			Data:      data,
+		})
+		if err != logical.ErrInvalidRequest || resp == nil || !resp.IsError() {
+			t.Fatalf("%s: expected error, got %#v (err: %v)", path, resp, err)
+		}
+		if errStr := resp.Data["error"].(string); !strings.Contains(errStr, "disabled") {
+			t.Fatalf("%s: expected disabled error, got %q", path, errStr)
+		}
+	}
+
+	plaintext := "dGhlIHF1aWNrIGJyb3duIGZveA=="
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/aes", nil)
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/ecdsa", map[string]interface{}{
+		"type":       "ecdsa-p256",
+		"exportable": true,
+	})
+	if resp := mustHandle(t, b, storage, logical.ReadOperation, "keys/aes", nil); resp.Data["enabled"] != true {
+		t.Fatalf("expected new key to be enabled: %#v", resp.Data)
+	}
+
+	ciphertext := mustHandle(t, b, storage, logical.UpdateOperation, "encrypt/aes", map[string]interface{}{
+		"plaintext": plaintext,
+	}).Data["ciphertext"].(string)
+	signature := mustHandle(t, b, storage, logical.UpdateOperation, "sign/ecdsa", map[string]interface{}{
+		"input": plaintext,
+	}).Data["signature"].(string)
+
+	for _, name := range []string{"aes", "ecdsa"} {
+		mustHandle(t, b, storage, logical.UpdateOperation, "keys/"+name+"/config", map[string]interface{}{
+			"enabled": false,
+		})
+		if resp := mustHandle(t, b, storage, logical.ReadOperation, "keys/"+name, nil); resp.Data["enabled"] != false {
+			t.Fatalf("expected %s to be disabled: %#v", name, resp.Data)
+		}
+	}
+
+	doDisabledReq(logical.UpdateOperation, "encrypt/aes", map[string]interface{}{"plaintext": plaintext})
+	doDisabledReq(logical.CreateOperation, "encrypt/aes", map[string]interface{}{"plaintext": plaintext})
+	doDisabledReq(logical.UpdateOperation, "decrypt/aes", map[string]interface{}{"ciphertext": ciphertext})
+	doDisabledReq(logical.UpdateOperation, "rewrap/aes", map[string]interface{}{"ciphertext": ciphertext})
+	doDisabledReq(logical.UpdateOperation, "datakey/plaintext/aes", nil)
+	doDisabledReq(logical.UpdateOperation, "hmac/aes", map[string]interface{}{"input": plaintext})
+	doDisabledReq(logical.UpdateOperation, "sign/ecdsa", map[string]interface{}{"input": plaintext})
+	doDisabledReq(logical.UpdateOperation, "verify/ecdsa", map[string]interface{}{
+		"input":     plaintext,
+		"signature": signature,
+	})
+	doDisabledReq(logical.ReadOperation, "export/signing-key/ecdsa", nil)
+	doDisabledReq(logical.ReadOperation, "keys/aes/test", nil)
+	doDisabledReq(logical.ReadOperation, "keys/ecdsa/public", nil)
+	doDisabledReq(logical.ReadOperation, "keys/ecdsa/version/1", nil)
+	doDisabledReq(logical.ReadOperation, "keys/aes/derivation/vector", map[string]interface{}{"context": plaintext})
+
+	// Re-enabling the key makes it usable again
+	for _, name := range []string{"aes", "ecdsa"} {
+		mustHandle(t, b, storage, logical.UpdateOperation, "keys/"+name+"/config", map[string]interface{}{
+			"enabled": true,
+		})
+	}
+	resp := mustHandle(t, b, storage, logical.UpdateOperation, "decrypt/aes", map[string]interface{}{
+		"ciphertext": ciphertext,
+	})
+	if resp.Data["plaintext"] != plaintext {
+		t.Fatalf("bad plaintext: %#v", resp.Data)
+	}
+	resp = mustHandle(t, b, storage, logical.UpdateOperation, "verify/ecdsa", map[string]interface{}{
+		"input":     plaintext,
+		"signature": signature,
+	})
+	if resp.Data["valid"] != true {
+		t.Fatalf("expected signature to verify: %#v", resp.Data)
+	}
+	mustHandle(t, b, storage, logical.ReadOperation, "export/signing-key/ecdsa", nil)
+	mustHandle(t, b, storage, logical.ReadOperation, "keys/ecdsa/public", nil)
+}
+
+func TestTransit_ConfigAutoRotatePeriod(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	// expectedNextRotation returns the latest version's creation time plus
+	// the given period, formatted as in responses
+	expectedNextRotation := func(period time.Duration) string {
+		resp := mustHandle(t, b, storage, logical.ReadOperation, "keys/foo", nil)
+		latest := strconv.Itoa(resp.Data["latest_version"].(int))
+		created, err := time.Parse(time.RFC3339, resp.Data["creation_times"].(map[string]string)[latest])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return created.Add(period).UTC().Format(time.RFC3339)
+	}
+
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo", nil)
+
+	resp := mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo/config", map[string]interface{}{
+		"auto_rotate_period": "24h",
+	})
+	if resp.Data["auto_rotate_period"] != int64(86400) || resp.Data["next_rotation"] != expectedNextRotation(24*time.Hour) {
+		t.Fatalf("bad schedule: %#v", resp.Data)
+	}
+	resp = mustHandle(t, b, storage, logical.ReadOperation, "keys/foo", nil)
+	if resp.Data["auto_rotate_period"] != int64(86400) || resp.Data["next_rotation"] != expectedNextRotation(24*time.Hour) {
+		t.Fatalf("bad schedule on read: %#v", resp.Data)
+	}
+
+	// Changing the period recomputes the schedule from the latest version
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo/rotate", nil)
+	resp = mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo/config", map[string]interface{}{
+		"auto_rotate_period": 48 * 3600,
+	})
+	if resp.Data["next_rotation"] != expectedNextRotation(48*time.Hour) {
+		t.Fatalf("bad recomputed schedule: %#v", resp.Data)
+	}
+
+	// Setting the period to 0 cancels the scheduled rotation
+	resp = mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo/config", map[string]interface{}{
+		"auto_rotate_period": 0,
+	})
+	if _, ok := resp.Data["next_rotation"]; ok || resp.Data["auto_rotate_period"] != int64(0) {
+		t.Fatalf("expected rotation to be cancelled: %#v", resp.Data)
+	}
+	resp = mustHandle(t, b, storage, logical.ReadOperation, "keys/foo", nil)
+	if _, ok := resp.Data["next_rotation"]; ok {
+		t.Fatalf("expected no next rotation on read: %#v", resp.Data)
+	}
+
+	resp, err := b.HandleRequest(&logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "keys/foo/config",
+		Data: map[string]interface{}{
+			"auto_rotate_period": "30m",
+		},
+	})
+	if err == nil && (resp == nil || !resp.IsError()) {
+		t.Fatal("expected error for a period shorter than an hour")
+	}
+}
+
+func TestTransit_ConfigDisabledEncryptionVersions(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	encrypt := func(ver int) map[string]interface{} {
+		return map[string]interface{}{
+			"plaintext":   "dGhlIHF1aWNrIGJyb3duIGZveA==",
+			"key_version": ver,
+		}
+	}
+
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/aes", nil)
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/aes/rotate", nil)
+	ciphertext := mustHandle(t, b, storage, logical.UpdateOperation, "encrypt/aes", encrypt(2)).Data["ciphertext"].(string)
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/aes/rotate", nil)
+
+	resp := mustHandle(t, b, storage, logical.ReadOperation, "keys/aes", nil)
+	if !reflect.DeepEqual(resp.Data["disabled_encryption_versions"], []int{}) {
+		t.Fatalf("expected no disabled encryption versions: %#v", resp.Data)
+	}
+
+	// An intermediate version above the min encryption version is refused
+	// for encryption, but its ciphertext can still be decrypted
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/aes/config", map[string]interface{}{
+		"min_encryption_version":       1,
+		"disabled_encryption_versions": "2",
+	})
+	resp = mustHandle(t, b, storage, logical.ReadOperation, "keys/aes", nil)
+	if !reflect.DeepEqual(resp.Data["disabled_encryption_versions"], []int{2}) {
+		t.Fatalf("bad disabled encryption versions: %#v", resp.Data)
+	}
+
+	resp = mustFail(t, b, storage, logical.UpdateOperation, "encrypt/aes", encrypt(2))
+	if errStr := resp.Data["error"].(string); !strings.Contains(errStr, "disabled for encryption") {
+		t.Fatalf("expected disabled version error, got %q", errStr)
+	}
+	mustHandle(t, b, storage, logical.UpdateOperation, "encrypt/aes", encrypt(1))
+	mustHandle(t, b, storage, logical.UpdateOperation, "encrypt/aes", encrypt(3))
+	mustHandle(t, b, storage, logical.UpdateOperation, "decrypt/aes", map[string]interface{}{
+		"ciphertext": ciphertext,
+	})
+
+	// Disabling the latest version also refuses encryption without an
+	// explicit version
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/aes/config", map[string]interface{}{
+		"disabled_encryption_versions": []string{"3", "2"},
+	})
+	resp = mustHandle(t, b, storage, logical.ReadOperation, "keys/aes", nil)
+	if !reflect.DeepEqual(resp.Data["disabled_encryption_versions"], []int{2, 3}) {
+		t.Fatalf("bad disabled encryption versions: %#v", resp.Data)
+	}
+	mustFail(t, b, storage, logical.UpdateOperation, "encrypt/aes", encrypt(0))
+
+	// Versions that do not exist cannot be disabled
+	mustFail(t, b, storage, logical.UpdateOperation, "keys/aes/config", map[string]interface{}{
+		"disabled_encryption_versions": "4",
+	})
+
+	// An empty list allows encryption with every version again
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/aes/config", map[string]interface{}{
+		"disabled_encryption_versions": []string{},
+	})
+	resp = mustHandle(t, b, storage, logical.ReadOperation, "keys/aes", nil)
+	if !reflect.DeepEqual(resp.Data["disabled_encryption_versions"], []int{}) {
+		t.Fatalf("expected no disabled encryption versions: %#v", resp.Data)
+	}
+	mustHandle(t, b, storage, logical.UpdateOperation, "encrypt/aes", encrypt(2))
+}
+
+func TestTransit_ConfigDisabledDecryptionVersions(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	decrypt := func(ciphertext string) map[string]interface{} {
+		return map[string]interface{}{
+			"ciphertext": ciphertext,
+		}
+	}
+
+	plaintext := "dGhlIHF1aWNrIGJyb3duIGZveA=="
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/aes", map[string]interface{}{
+		"num_versions": 3,
+	})
+	ciphertexts := map[int]string{}
+	for ver := 1; ver <= 3; ver++ {
+		ciphertexts[ver] = mustHandle(t, b, storage, logical.UpdateOperation, "encrypt/aes", map[string]interface{}{
+			"plaintext":   plaintext,
+			"key_version": ver,
+		}).Data["ciphertext"].(string)
+	}
+
+	resp := mustHandle(t, b, storage, logical.ReadOperation, "keys/aes", nil)
+	if !reflect.DeepEqual(resp.Data["disabled_decryption_versions"], []int{}) {
+		t.Fatalf("expected no disabled decryption versions: %#v", resp.Data)
+	}
+
+	// An intermediate version is refused for decryption, while the versions
+	// on either side of it can still be decrypted
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/aes/config", map[string]interface{}{
+		"disabled_decryption_versions": "2",
+	})
+	resp = mustHandle(t, b, storage, logical.ReadOperation, "keys/aes", nil)
+	if !reflect.DeepEqual(resp.Data["disabled_decryption_versions"], []int{2}) || resp.Data["min_decryption_version"] != 1 {
+		t.Fatalf("bad disabled decryption versions: %#v", resp.Data)
+	}
+
+	resp = mustFail(t, b, storage, logical.UpdateOperation, "decrypt/aes", decrypt(ciphertexts[2]))
+	if errStr := resp.Data["error"].(string); errStr != "version 2 of the key is disabled for decryption" {
+		t.Fatalf("expected disabled version error, got %q", errStr)
+	}
+	mustFail(t, b, storage, logical.UpdateOperation, "rewrap/aes", decrypt(ciphertexts[2]))
+	for _, ver := range []int{1, 3} {
+		if resp := mustHandle(t, b, storage, logical.UpdateOperation, "decrypt/aes", decrypt(ciphertexts[ver])); resp.Data["plaintext"] != plaintext {
+			t.Fatalf("version %d: bad plaintext: %#v", ver, resp.Data)
+		}
+	}
+
+	// Ciphertext of a version that cannot be decrypted is not produced
+	mustFail(t, b, storage, logical.UpdateOperation, "encrypt/aes", map[string]interface{}{
+		"plaintext":   plaintext,
+		"key_version": 2,
+	})
+
+	// Neither the latest version nor versions that do not exist can be
+	// disabled
+	mustFail(t, b, storage, logical.UpdateOperation, "keys/aes/config", map[string]interface{}{
+		"disabled_decryption_versions": "3",
+	})
+	mustFail(t, b, storage, logical.UpdateOperation, "keys/aes/config", map[string]interface{}{
+		"disabled_decryption_versions": "4",
+	})
+
+	// An empty list allows decryption with every version again
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/aes/config", map[string]interface{}{
+		"disabled_decryption_versions": []string{},
+	})
+	resp = mustHandle(t, b, storage, logical.ReadOperation, "keys/aes", nil)
+	if !reflect.DeepEqual(resp.Data["disabled_decryption_versions"], []int{}) {
+		t.Fatalf("expected no disabled decryption versions: %#v", resp.Data)
+	}
+	mustHandle(t, b, storage, logical.UpdateOperation, "decrypt/aes", decrypt(ciphertexts[2]))
+}
+
+func TestTransit_ConfigOperationRateLimit(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	now := time.Now()
+	b.rateLimiter.now = func() time.Time {
+		return now
+	}
+
+	doLimitedReq := func(path string, data map[string]interface{}) {
+		req := &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Data:      data,
+		}
+		resp, err := b.HandleRequest(req)
+		if err != logical.ErrRateLimited || resp == nil || !resp.IsError() {
+			t.Fatalf("%s: expected rate limit error, got %#v (err: %v)", path, resp, err)
+		}
+		if status, _ := logical.RespondErrorCommon(req, resp, err); status != http.StatusTooManyRequests {
+			t.Fatalf("%s: expected status %d, got %d", path, http.StatusTooManyRequests, status)
+		}
+	}
+
+	plaintext := map[string]interface{}{
+		"plaintext": "dGhlIHF1aWNrIGJyb3duIGZveA==",
+	}
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/limited", nil)
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/unlimited", nil)
+	if resp := mustHandle(t, b, storage, logical.ReadOperation, "keys/limited", nil); resp.Data["operation_rate_limit"] != 0 {
+		t.Fatalf("expected no rate limit by default: %#v", resp.Data)
+	}
+
+	resp, _ := b.HandleRequest(&logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "keys/limited/config",
+		Data:      map[string]interface{}{"operation_rate_limit": -1},
+	})
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected error for negative rate limit, got %#v", resp)
+	}
+
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/limited/config", map[string]interface{}{
+		"operation_rate_limit": 3,
+	})
+	if resp := mustHandle(t, b, storage, logical.ReadOperation, "keys/limited", nil); resp.Data["operation_rate_limit"] != 3 {
+		t.Fatalf("bad rate limit: %#v", resp.Data)
+	}
+
+	// A burst above the limit is rejected, and every operation counts
+	// towards the same limit
+	ciphertext := mustHandle(t, b, storage, logical.UpdateOperation, "encrypt/limited", plaintext).Data["ciphertext"].(string)
+	mustHandle(t, b, storage, logical.UpdateOperation, "decrypt/limited", map[string]interface{}{
+		"ciphertext": ciphertext,
+	})
+	mustHandle(t, b, storage, logical.UpdateOperation, "hmac/limited", map[string]interface{}{
+		"input": plaintext["plaintext"],
+	})
+	doLimitedReq("encrypt/limited", plaintext)
+	doLimitedReq("datakey/plaintext/limited", nil)
+
+	// Other keys are not affected
+	for i := 0; i < 10; i++ {
+		mustHandle(t, b, storage, logical.UpdateOperation, "encrypt/unlimited", plaintext)
+	}
+
+	// Tokens are refilled over time
+	now = now.Add(400 * time.Millisecond)
+	mustHandle(t, b, storage, logical.UpdateOperation, "encrypt/limited", plaintext)
+	doLimitedReq("encrypt/limited", plaintext)
+
+	// Each item of a batch counts as an operation
+	now = now.Add(time.Second)
+	batch := func(items int) map[string]interface{} {
+		batchInput := make([]interface{}, items)
+		for i := range batchInput {
+			batchInput[i] = plaintext
+		}
+		return map[string]interface{}{
+			"batch_input": batchInput,
+		}
+	}
+	mustHandle(t, b, storage, logical.UpdateOperation, "encrypt/limited", batch(3))
+	doLimitedReq("encrypt/limited", plaintext)
+
+	// A batch larger than the limit could never be allowed, so it is
+	// rejected as invalid rather than rate limited, and takes no tokens
+	now = now.Add(time.Second)
+	for _, path := range []string{"encrypt/limited", "decrypt/limited"} {
+		req := &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Data:      batch(4),
+		}
+		resp, err := b.HandleRequest(req)
+		if err != logical.ErrInvalidRequest || resp == nil || !resp.IsError() {
+			t.Fatalf("%s: expected invalid request error, got %#v (err: %v)", path, resp, err)
+		}
+		if !strings.Contains(resp.Data["error"].(string), "rate limit of 3") {
+			t.Fatalf("%s: expected error to name the limit, got %#v", path, resp.Data)
+		}
+		if status, _ := logical.RespondErrorCommon(req, resp, err); status != http.StatusBadRequest {
+			t.Fatalf("%s: expected status %d, got %d", path, http.StatusBadRequest, status)
+		}
+	}
+	mustHandle(t, b, storage, logical.UpdateOperation, "encrypt/limited", batch(3))
+
+	// Requests refused for other reasons do not count towards the limit
+	now = now.Add(time.Second)
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/limited/config", map[string]interface{}{
+		"enabled": false,
+	})
+	for i := 0; i < 5; i++ {
+		mustFail(t, b, storage, logical.UpdateOperation, "encrypt/limited", plaintext)
+	}
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/limited/config", map[string]interface{}{
+		"enabled": true,
+	})
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/encrypt-only", map[string]interface{}{
+		"allowed_operations": []string{"encrypt"},
+	})
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/encrypt-only/config", map[string]interface{}{
+		"operation_rate_limit": 1,
+	})
+	ciphertext = mustHandle(t, b, storage, logical.UpdateOperation, "encrypt/unlimited", plaintext).Data["ciphertext"].(string)
+	for i := 0; i < 5; i++ {
+		resp, err := b.HandleRequest(&logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "decrypt/encrypt-only",
+			Data: map[string]interface{}{
+				"ciphertext": ciphertext,
+			},
+		})
+		if err != logical.ErrInvalidRequest || resp == nil || !resp.IsError() {
+			t.Fatalf("expected disallowed operation error, got %#v (err: %v)", resp, err)
+		}
+	}
+	mustHandle(t, b, storage, logical.UpdateOperation, "encrypt/encrypt-only", plaintext)
+	mustHandle(t, b, storage, logical.UpdateOperation, "encrypt/limited", batch(3))
+
+	// Removing the limit allows any number of operations
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/limited/config", map[string]interface{}{
+		"operation_rate_limit": 0,
+	})
+	for i := 0; i < 10; i++ {
+		mustHandle(t, b, storage, logical.UpdateOperation, "encrypt/limited", plaintext)
+	}
+
+	// The bucket of a key is dropped when the key is renamed or deleted
+	hasBucket := func(name string) bool {
+		b.rateLimiter.lock.Lock()
+		defer b.rateLimiter.lock.Unlock()
+		_, ok := b.rateLimiter.buckets[name]
+		return ok
+	}
+	if !hasBucket("encrypt-only") {
+		t.Fatal("expected a bucket for the rate limited key")
+	}
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/encrypt-only/rename", map[string]interface{}{
+		"new_name": "renamed",
+	})
+	if hasBucket("encrypt-only") {
+		t.Fatal("expected the bucket to be dropped on rename")
+	}
+	mustHandle(t, b, storage, logical.UpdateOperation, "encrypt/renamed", plaintext)
+	if !hasBucket("renamed") {
+		t.Fatal("expected a bucket for the renamed key")
+	}
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/renamed/config", map[string]interface{}{
+		"deletion_allowed": true,
+	})
+	mustHandle(t, b, storage, logical.DeleteOperation, "keys/renamed", nil)
+	if hasBucket("renamed") {
+		t.Fatal("expected the bucket to be dropped on delete")
+	}
+}
+
+func TestTransit_ConfigExportable(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	checkExportable := func(exportable, revoked bool) {
+		resp := mustHandle(t, b, storage, logical.ReadOperation, "keys/foo", nil)
+		if resp.Data["exportable"] != exportable || resp.Data["export_revoked"] != revoked {
+			t.Fatalf("expected exportable %t and export_revoked %t, got %#v", exportable, revoked, resp.Data)
+		}
+	}
+	setExportable := func(exportable bool) map[string]interface{} {
+		return map[string]interface{}{
+			"exportable": exportable,
+		}
+	}
+
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo", nil)
+	checkExportable(false, false)
+	mustFail(t, b, storage, logical.ReadOperation, "export/encryption-key/foo", nil)
+
+	// Exportability can be enabled on an existing key
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo/config", setExportable(true))
+	checkExportable(true, false)
+	mustHandle(t, b, storage, logical.ReadOperation, "export/encryption-key/foo", nil)
+
+	// Setting the current value again is allowed
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo/config", setExportable(true))
+	checkExportable(true, false)
+
+	// Once turned off, it cannot be enabled again
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo/config", setExportable(false))
+	checkExportable(false, true)
+	mustFail(t, b, storage, logical.ReadOperation, "export/encryption-key/foo", nil)
+
+	resp := mustFail(t, b, storage, logical.UpdateOperation, "keys/foo/config", setExportable(true))
+	if errStr := resp.Data["error"].(string); !strings.Contains(errStr, "cannot be enabled again") {
+		t.Fatalf("expected revoked exportability error, got %q", errStr)
+	}
+	checkExportable(false, true)
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo/config", setExportable(false))
+	checkExportable(false, true)
+}
+
+func TestTransit_ConfigMinDecryptionVersion(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo", map[string]interface{}{
+		"num_versions": 4,
+	})
+	plaintext := base64.StdEncoding.EncodeToString([]byte("the quick brown fox"))
+	ciphertexts := map[int]string{}
+	for ver := 1; ver <= 4; ver++ {
+		resp := mustHandle(t, b, storage, logical.UpdateOperation, "encrypt/foo", map[string]interface{}{
+			"plaintext":   plaintext,
+			"key_version": ver,
+		})
+		ciphertexts[ver] = resp.Data["ciphertext"].(string)
+	}
+
+	// Versions outside of 1 to the latest version are rejected
+	for _, ver := range []int{-1, 5} {
+		mustFail(t, b, storage, logical.UpdateOperation, "keys/foo/config", map[string]interface{}{
+			"min_decryption_version": ver,
+		})
+	}
+
+	// The number of versions that are no longer decryptable is reported
+	resp := mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo/config", map[string]interface{}{
+		"min_decryption_version": 3,
+	})
+	if resp == nil || len(resp.Warnings) != 1 || !strings.Contains(resp.Warnings[0], "2 key version(s) below version 3") {
+		t.Fatalf("expected warning about invalidated versions, got %#v", resp)
+	}
+	if resp := mustHandle(t, b, storage, logical.ReadOperation, "keys/foo", nil); resp.Data["min_decryption_version"] != 3 {
+		t.Fatalf("bad min decryption version: %#v", resp.Data)
+	}
+
+	for ver, ciphertext := range ciphertexts {
+		data := map[string]interface{}{
+			"ciphertext": ciphertext,
+		}
+		if ver >= 3 {
+			if resp := mustHandle(t, b, storage, logical.UpdateOperation, "decrypt/foo", data); resp.Data["plaintext"] != plaintext {
+				t.Fatalf("version %d: bad plaintext: %#v", ver, resp.Data)
+			}
+			continue
+		}
+		resp := mustFail(t, b, storage, logical.UpdateOperation, "decrypt/foo", data)
+		if resp == nil || resp.Data["error"] != keysutil.ErrTooOld {
+			t.Fatalf("version %d: expected too old error, got %#v", ver, resp)
+		}
+	}
+
+	// Lowering the minimum again invalidates nothing
+	if resp := mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo/config", map[string]interface{}{
+		"min_decryption_version": 2,
+	}); resp != nil && len(resp.Warnings) != 0 {
+		t.Fatalf("unexpected warnings: %#v", resp.Warnings)
+	}
+}
+
+func TestTransit_ConfigMinDecryptionChangeReason(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	readReason := func() interface{} {
+		return mustHandle(t, b, storage, logical.ReadOperation, "keys/foo", nil).Data["last_min_decryption_change_reason"]
+	}
+
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo", map[string]interface{}{
+		"num_versions": 3,
+	})
+	if reason := readReason(); reason != "" {
+		t.Fatalf("expected no reason, got %#v", reason)
+	}
+
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo/config", map[string]interface{}{
+		"min_decryption_version": 2,
+		"reason":                 "incident 1234",
+	})
+	if reason := readReason(); reason != "incident 1234" {
+		t.Fatalf("bad reason: %#v", reason)
+	}
+
+	// The reason persists across a reload of the key from storage
+	b.invalidate("policy/foo")
+	if reason := readReason(); reason != "incident 1234" {
+		t.Fatalf("bad reason after reload: %#v", reason)
+	}
+
+	// A reason without a change of the min decryption version is ignored
+	resp := mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo/config", map[string]interface{}{
+		"min_decryption_version": 2,
+		"reason":                 "unrelated",
+	})
+	if resp == nil || len(resp.Warnings) != 1 || !strings.Contains(resp.Warnings[0], "reason") {
+		t.Fatalf("expected warning about the ignored reason, got %#v", resp)
+	}
+	if reason := readReason(); reason != "incident 1234" {
+		t.Fatalf("bad reason after unchanged min decryption version: %#v", reason)
+	}
+
+	// A later change without a reason clears it
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo/config", map[string]interface{}{
+		"min_decryption_version": 3,
+	})
+	if reason := readReason(); reason != "" {
+		t.Fatalf("expected reason to be cleared, got %#v", reason)
+	}
+
+	resp, err := b.HandleRequest(&logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "keys/foo/config",
+		Data: map[string]interface{}{
+			"min_decryption_version": 2,
+			"reason":                 strings.Repeat("a", 1025),
+		},
+	})
+	if err == nil && (resp == nil || !resp.IsError()) {
+		t.Fatalf("expected error for a long reason, got %#v", resp)
+	}
+}
+
+func TestTransit_ConfigRejectedRequestLeavesKeyUnchanged(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo", nil)
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo/rotate", nil)
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo/rotate", nil)
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo/config", map[string]interface{}{
+		"enabled":              false,
+		"operation_rate_limit": 5,
+		"description":          "original",
+		"tags":                 "owner=a",
+	})
+
+	p, lock, err := b.lm.GetPolicyShared(storage, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock.RUnlock()
+	before := *p
+
+	// Every field that can be changed, followed by each of the fields that
+	// is checked last being invalid
+	changes := map[string]interface{}{
+		"min_decryption_version":       2,
+		"min_encryption_version":       3,
+		"disabled_encryption_versions": "2",
+		"disabled_decryption_versions": "2",
+		"deletion_allowed":             true,
+		"exportable":                   true,
+		"allow_plaintext_backup":       true,
+		"enabled":                      true,
+		"operation_rate_limit":         0,
+		"tags":                         "owner=b",
+		"description":                  "changed",
+		"reason":                       "testing",
+	}
+	for field, invalid := range map[string]interface{}{
+		"auto_rotate_period":    "60s",
+		"tags":                  "=b",
+		"description":           strings.Repeat("a", maxDescriptionLength+1),
+		"allow_export_versions": "9",
+	} {
+		data := map[string]interface{}{}
+		for k, v := range changes {
+			data[k] = v
+		}
+		data[field] = invalid
+		mustFail(t, b, storage, logical.UpdateOperation, "keys/foo/config", data)
+
+		if !reflect.DeepEqual(*p, before) {
+			t.Fatalf("invalid %s: expected the key to be unchanged, got %#v", field, *p)
+		}
+	}
+
+	// The rejected requests did not allow the key to be deleted
+	mustFail(t, b, storage, logical.DeleteOperation, "keys/foo", nil)
+	if resp := mustHandle(t, b, storage, logical.ReadOperation, "keys/foo", nil); resp == nil {
+		t.Fatal("expected the key to still exist")
+	}
+
+	// Without an invalid field, the same changes are all applied
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo/config", changes)
+	if p.Disabled || !p.DeletionAllowed || !p.Exportable || p.OperationRateLimit != 0 || p.Description != "changed" || p.MinDecryptionVersion != 2 {
+		t.Fatalf("expected the changes to be applied, got %#v", *p)
+	}
+}
@@ -0,0 +1,72 @@
+package transit
+
+import (
+	"github.com/hashicorp/vault/helper/errutil"
+	"github.com/hashicorp/vault/helper/keysutil"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+func (b *backend) pathConvergentUpgrade() *framework.Path {
+	return &framework.Path{
+		Pattern: "keys/" + framework.GenericNameRegex("name") + "/convergent/upgrade",
+		Fields: map[string]*framework.FieldSchema{
+			"name": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "Name of the key",
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.pathConvergentUpgradeWrite,
+		},
+
+		HelpSynopsis:    pathConvergentUpgradeHelpSyn,
+		HelpDescription: pathConvergentUpgradeHelpDesc,
+	}
+}
+
+func (b *backend) pathConvergentUpgradeWrite(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+
+	p, lock, err := b.lm.GetPolicyExclusive(req.Storage, name)
+	if lock != nil {
+		defer lock.Unlock()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return logical.ErrorResponse("key not found"), logical.ErrInvalidRequest
+	}
+
+	upgraded, err := p.UpgradeConvergentVersion(req.Storage)
+	if err != nil {
+		switch err.(type) {
+		case errutil.UserError:
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		default:
+			return nil, err
+		}
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"upgraded":                  upgraded,
+			"convergent_version":        p.ConvergentVersion,
+			"target_convergent_version": keysutil.LatestConvergentVersion,
+			"latest_version":            p.LatestVersion,
+		},
+	}, nil
+}
+
+const pathConvergentUpgradeHelpSyn = `Upgrade a key to the latest convergent encryption scheme`
+
+const pathConvergentUpgradeHelpDesc = `
+This path moves a key using convergent encryption to the latest convergent
+encryption scheme. The key is rotated so that new encryptions use a version
+with the latest scheme, while existing versions keep their scheme and their
+ciphertext remains decryptable. Keys already using the latest scheme are left
+unchanged.
+`
@@ -0,0 +1,114 @@
+package transit
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/helper/keysutil"
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestTransit_ConvergentUpgrade(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	plaintext := "emlwIHphcA==" // "zip zap"
+	context := "pWZ6t/im3AORd0lVYE0zBdKpX6Bl3/SvFtoVTPWbdkzjG788XmMAnOlxandSdd7S"
+	nonce := "b25ldHdvdGhyZWVl" // "onetwothreee"
+
+	// Keys can no longer be created with the first convergent scheme, so
+	// store one directly
+	p := &keysutil.Policy{
+		Name:                 "convergent",
+		Type:                 keysutil.KeyType_AES256_GCM96,
+		Derived:              true,
+		KDF:                  keysutil.Kdf_hkdf_sha256,
+		ConvergentEncryption: true,
+		ConvergentVersion:    1,
+	}
+	if err := p.Rotate(storage); err != nil {
+		t.Fatal(err)
+	}
+
+	v1Ciphertext := mustHandle(t, b, storage, logical.UpdateOperation, "encrypt/convergent", map[string]interface{}{
+		"plaintext": plaintext,
+		"context":   context,
+		"nonce":     nonce,
+	}).Data["ciphertext"].(string)
+
+	resp := mustHandle(t, b, storage, logical.ReadOperation, "keys/convergent", nil)
+	if resp.Data["convergent_version"] != 1 || resp.Data["target_convergent_version"] != keysutil.LatestConvergentVersion {
+		t.Fatalf("bad convergent versions before upgrade: %#v", resp.Data)
+	}
+	if resp.Data["requires_nonce"] != true || resp.Data["nonce_size"] != 12 {
+		t.Fatalf("expected a 12 byte nonce to be required before upgrade: %#v", resp.Data)
+	}
+
+	resp = mustHandle(t, b, storage, logical.UpdateOperation, "keys/convergent/convergent/upgrade", nil)
+	if resp.Data["upgraded"] != true || resp.Data["convergent_version"] != 2 || resp.Data["latest_version"] != 2 {
+		t.Fatalf("bad upgrade response: %#v", resp.Data)
+	}
+	resp = mustHandle(t, b, storage, logical.ReadOperation, "keys/convergent", nil)
+	if resp.Data["convergent_version"] != 2 || resp.Data["target_convergent_version"] != 2 {
+		t.Fatalf("bad convergent versions after upgrade: %#v", resp.Data)
+	}
+	if resp.Data["requires_nonce"] != false {
+		t.Fatalf("expected no nonce to be required after upgrade: %#v", resp.Data)
+	}
+
+	// Upgrading again changes nothing
+	resp = mustHandle(t, b, storage, logical.UpdateOperation, "keys/convergent/convergent/upgrade", nil)
+	if resp.Data["upgraded"] != false || resp.Data["latest_version"] != 2 {
+		t.Fatalf("expected upgrade to be idempotent: %#v", resp.Data)
+	}
+
+	// Ciphertext of the first scheme still decrypts with its nonce
+	resp = mustHandle(t, b, storage, logical.UpdateOperation, "decrypt/convergent", map[string]interface{}{
+		"ciphertext": v1Ciphertext,
+		"context":    context,
+		"nonce":      nonce,
+	})
+	if resp.Data["plaintext"] != plaintext {
+		t.Fatalf("bad plaintext of old ciphertext: %#v", resp.Data)
+	}
+
+	// New ciphertext uses the latest scheme, which needs no nonce, stores the
+	// derived nonce in the ciphertext and is still deterministic
+	encryptReq := map[string]interface{}{
+		"plaintext": plaintext,
+		"context":   context,
+	}
+	v2Ciphertext := mustHandle(t, b, storage, logical.UpdateOperation, "encrypt/convergent", encryptReq).Data["ciphertext"].(string)
+	if !strings.HasPrefix(v2Ciphertext, "vault:v2:") {
+		t.Fatalf("expected ciphertext of the new version, got %q", v2Ciphertext)
+	}
+	if again := mustHandle(t, b, storage, logical.UpdateOperation, "encrypt/convergent", encryptReq).Data["ciphertext"]; again != v2Ciphertext {
+		t.Fatalf("expected deterministic ciphertext, got %q and %q", v2Ciphertext, again)
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(v2Ciphertext, "vault:v2:"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plainBytes, _ := base64.StdEncoding.DecodeString(plaintext)
+	if len(decoded) != 12+len(plainBytes)+16 {
+		t.Fatalf("expected the nonce to be stored in the ciphertext, got %d bytes", len(decoded))
+	}
+	resp = mustHandle(t, b, storage, logical.UpdateOperation, "decrypt/convergent", map[string]interface{}{
+		"ciphertext": v2Ciphertext,
+		"context":    context,
+	})
+	if resp.Data["plaintext"] != plaintext {
+		t.Fatalf("bad plaintext of new ciphertext: %#v", resp.Data)
+	}
+
+	// Keys without convergent encryption cannot be upgraded
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/plain", nil)
+	resp, _ = b.HandleRequest(&logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "keys/plain/convergent/upgrade",
+	})
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected error upgrading a key without convergent encryption, got %#v", resp)
+	}
+}
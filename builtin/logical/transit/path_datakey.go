@@ -52,7 +52,7 @@ min_encryption_version configured on the key.`,
 		},
 
 		Callbacks: map[logical.Operation]framework.OperationFunc{
-			logical.UpdateOperation: b.pathDatakeyWrite,
+			logical.UpdateOperation: b.requireEnabledKey(b.pathDatakeyWrite),
 		},
 
 		HelpSynopsis:    pathDatakeyHelpSyn,
@@ -108,6 +108,12 @@ func (b *backend) pathDatakeyWrite(
 	if p == nil {
 		return logical.ErrorResponse("encryption key not found"), logical.ErrInvalidRequest
 	}
+	if !p.OperationAllowed("encrypt") {
+		return logical.ErrorResponse(fmt.Sprintf("key %s does not allow the encrypt operation", p.Name)), logical.ErrInvalidRequest
+	}
+	if resp, err := b.checkRateLimit(p, 1); err != nil {
+		return resp, err
+	}
 
 	newKey := make([]byte, 32)
 	bits := d.Get("bits").(int)
@@ -42,7 +42,7 @@ Vault 0.6.1. Not required for keys created in 0.6.2+.`,
 		},
 
 		Callbacks: map[logical.Operation]framework.OperationFunc{
-			logical.UpdateOperation: b.pathDecryptWrite,
+			logical.UpdateOperation: b.requireEnabledKey(b.pathDecryptWrite),
 		},
 
 		HelpSynopsis:    pathDecryptHelpSyn,
@@ -121,6 +121,12 @@ func (b *backend) pathDecryptWrite(
 	if p == nil {
 		return logical.ErrorResponse("encryption key not found"), logical.ErrInvalidRequest
 	}
+	if !p.OperationAllowed("decrypt") {
+		return logical.ErrorResponse(fmt.Sprintf("key %s does not allow the decrypt operation", p.Name)), logical.ErrInvalidRequest
+	}
+	if resp, err := b.checkRateLimit(p, len(batchInputItems)); err != nil {
+		return resp, err
+	}
 
 	for i, item := range batchInputItems {
 		if batchResponseItems[i].Error != "" {
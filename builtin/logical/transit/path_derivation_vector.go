@@ -0,0 +1,157 @@
+package transit
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"github.com/hashicorp/vault/helper/errutil"
+	"github.com/hashicorp/vault/helper/keysutil"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+func (b *backend) pathDerivationVector() *framework.Path {
+	return &framework.Path{
+		Pattern: "keys/" + framework.GenericNameRegex("name") + "/derivation/vector",
+		Fields: map[string]*framework.FieldSchema{
+			"name": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "Name of the key",
+			},
+
+			"context": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "Base64 encoded context to derive the key for",
+			},
+
+			"version": &framework.FieldSchema{
+				Type: framework.TypeInt,
+				Description: `The version of the key to derive from. Defaults
+to the latest version.`,
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.requireEnabledKey(b.pathDerivationVectorRead),
+		},
+
+		HelpSynopsis:    pathDerivationVectorHelpSyn,
+		HelpDescription: pathDerivationVectorHelpDesc,
+	}
+}
+
+func (b *backend) pathDerivationVectorRead(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+	ver := d.Get("version").(int)
+
+	config, err := b.readKeysConfig(req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if !config.EnableDerivationTestVectors {
+		return logical.ErrorResponse("derivation test vectors are disabled; set enable_derivation_test_vectors in config/keys to enable them"), logical.ErrUnsupportedPath
+	}
+
+	contextRaw := d.Get("context").(string)
+	if len(contextRaw) == 0 {
+		return logical.ErrorResponse("missing context"), logical.ErrInvalidRequest
+	}
+	context, err := decodeContext(contextRaw)
+	if err != nil {
+		return logical.ErrorResponse("failed to base64-decode context"), logical.ErrInvalidRequest
+	}
+
+	p, lock, err := b.lm.GetPolicyShared(req.Storage, name)
+	if lock != nil {
+		defer lock.RUnlock()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return logical.ErrorResponse("key not found"), logical.ErrInvalidRequest
+	}
+	if !p.Derived {
+		return logical.ErrorResponse("key derivation is not enabled for this key"), logical.ErrInvalidRequest
+	}
+
+	if ver == 0 {
+		ver = p.LatestVersion
+	}
+	if _, ok := p.Keys[ver]; !ok || ver < p.MinDecryptionVersion {
+		return logical.ErrorResponse(fmt.Sprintf("version %d of the key does not exist or is below the min decryption version", ver)), logical.ErrInvalidRequest
+	}
+
+	// Only the parameters of the derivation and values that reveal nothing
+	// about the derived key are returned, never the derived key itself
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"name":    p.Name,
+			"type":    p.Type.String(),
+			"version": ver,
+			"context": base64.StdEncoding.EncodeToString(context),
+		},
+	}
+
+	outputLength := 32
+	if p.Type == keysutil.KeyType_AES128_GCM96 {
+		outputLength = 16
+	}
+	switch p.KDF {
+	case keysutil.Kdf_hmac_sha256_counter:
+		resp.Data["kdf"] = "hmac-sha256-counter"
+		resp.Data["kdf_params"] = map[string]interface{}{
+			"prf":           "hmac-sha256",
+			"output_length": 32,
+		}
+	case keysutil.Kdf_hkdf_sha256:
+		resp.Data["kdf"] = "hkdf_sha256"
+		resp.Data["kdf_params"] = map[string]interface{}{
+			"hash":          "sha256",
+			"salt":          "",
+			"info":          base64.StdEncoding.EncodeToString(context),
+			"output_length": outputLength,
+		}
+	}
+
+	switch p.Type {
+	case keysutil.KeyType_ED25519, keysutil.KeyType_ED448:
+		derived, err := p.DeriveKey(context, ver)
+		if err != nil {
+			switch err.(type) {
+			case errutil.UserError:
+				return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+			default:
+				return nil, err
+			}
+		}
+		resp.Data["public_key"] = encodeEdDSAPublicKey(p.Type, derived)
+
+	default:
+		fingerprint, err := p.DerivedKeyFingerprint(context, ver)
+		if err != nil {
+			switch err.(type) {
+			case errutil.UserError:
+				return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+			default:
+				return nil, err
+			}
+		}
+		resp.Data["derived_key_fingerprint"] = fingerprint
+	}
+
+	return resp, nil
+}
+
+const pathDerivationVectorHelpSyn = `Return a test vector for the key derivation of a named key`
+
+const pathDerivationVectorHelpDesc = `
+This path returns the parameters used to derive a key version of the named key
+for the given context, along with the public key (for ed25519 and ed448 keys)
+or the fingerprint (for symmetric keys) of the derived key, so that clients can
+check that their own derivation matches. The derived key itself is never
+returned.
+The path is disabled unless enable_derivation_test_vectors is set in
+config/keys, and should not be enabled in production.
+`
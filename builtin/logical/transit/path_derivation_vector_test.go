@@ -0,0 +1,100 @@
+package transit
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestTransit_DerivationVector(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	doErrReq := func(path string, data map[string]interface{}, expected error) {
+		resp, err := b.HandleRequest(&logical.Request{
+			Storage:   storage,
+			Operation: logical.ReadOperation,
+			Path:      path,
+			Data:      data,
+		})
+		if err != expected || resp == nil || !resp.IsError() {
+			t.Fatalf("%s: expected error %v, got %#v (err: %v)", path, expected, resp, err)
+		}
+	}
+	// createKnownKey creates a derived key whose first version is the bytes
+	// 0x00 to 0x1f, so that the derived values are known in advance
+	createKnownKey := func(name, keyType string) {
+		mustHandle(t, b, storage, logical.UpdateOperation, "keys/"+name, map[string]interface{}{
+			"type":    keyType,
+			"derived": true,
+		})
+		p, lock, err := b.lm.GetPolicyExclusive(storage, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer lock.Unlock()
+		entry := p.Keys[1]
+		entry.Key = make([]byte, 32)
+		for i := range entry.Key {
+			entry.Key[i] = byte(i)
+		}
+		p.Keys[1] = entry
+		if err := p.Persist(storage); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	context := base64.StdEncoding.EncodeToString([]byte("test vector context"))
+
+	// The endpoint is disabled by default
+	createKnownKey("aes256", "aes256-gcm96")
+	doErrReq("keys/aes256/derivation/vector", map[string]interface{}{"context": context}, logical.ErrUnsupportedPath)
+
+	mustHandle(t, b, storage, logical.UpdateOperation, "config/keys", map[string]interface{}{
+		"enable_derivation_test_vectors": true,
+	})
+	createKnownKey("aes128", "aes128-gcm96")
+	createKnownKey("ed25519", "ed25519")
+
+	for name, expected := range map[string]struct {
+		field  string
+		value  string
+		length int
+	}{
+		"aes256":  {"derived_key_fingerprint", "217131790da6ca4db36bf811c78d98d11fa5c84ff4e1e90f0a612ebcb07049e5", 32},
+		"aes128":  {"derived_key_fingerprint", "8b1a60d034f7411c929114969221d987177a8d377d87995138edb509608ec356", 16},
+		"ed25519": {"public_key", "2ssAlrTjfEnLbnWEsn8jpd4ini3cEhnifsu1Vq9FLZk=", 32},
+	} {
+		resp := mustHandle(t, b, storage, logical.ReadOperation, "keys/"+name+"/derivation/vector", map[string]interface{}{
+			"context": context,
+		})
+		if resp.Data[expected.field] != expected.value {
+			t.Fatalf("%s: expected %s %s, got %#v", name, expected.field, expected.value, resp.Data)
+		}
+		params := resp.Data["kdf_params"].(map[string]interface{})
+		if resp.Data["kdf"] != "hkdf_sha256" || resp.Data["version"] != 1 ||
+			params["hash"] != "sha256" || params["salt"] != "" || params["info"] != context ||
+			params["output_length"] != expected.length {
+			t.Fatalf("%s: bad derivation parameters: %#v", name, resp.Data)
+		}
+		for _, field := range []string{"key", "derived_key"} {
+			if _, ok := resp.Data[field]; ok {
+				t.Fatalf("%s: key material returned in %s: %#v", name, field, resp.Data)
+			}
+		}
+	}
+
+	// Different contexts derive different keys
+	resp := mustHandle(t, b, storage, logical.ReadOperation, "keys/aes256/derivation/vector", map[string]interface{}{
+		"context": base64.StdEncoding.EncodeToString([]byte("other context")),
+	})
+	if resp.Data["derived_key_fingerprint"] == "217131790da6ca4db36bf811c78d98d11fa5c84ff4e1e90f0a612ebcb07049e5" {
+		t.Fatal("expected a different fingerprint for a different context")
+	}
+
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/plain", nil)
+	doErrReq("keys/plain/derivation/vector", map[string]interface{}{"context": context}, logical.ErrInvalidRequest)
+	doErrReq("keys/aes256/derivation/vector", nil, logical.ErrInvalidRequest)
+	doErrReq("keys/aes256/derivation/vector", map[string]interface{}{"context": context, "version": 2}, logical.ErrInvalidRequest)
+	doErrReq("keys/missing/derivation/vector", map[string]interface{}{"context": context}, logical.ErrInvalidRequest)
+}
@@ -87,7 +87,8 @@ encryption key) this nonce value is **never reused**.
 				Description: `
 This parameter is required when encryption key is expected to be created.
 When performing an upsert operation, the type of key to create. Currently,
-"aes256-gcm96" (symmetric) is the only type supported. Defaults to
+"aes128-gcm96" (symmetric), "aes256-gcm96" (symmetric), and
+"chacha20-poly1305" (symmetric) are the only types supported. Defaults to
 "aes256-gcm96".`,
 			},
 
@@ -113,8 +114,8 @@ to the min_encryption_version configured on the key.`,
 		},
 
 		Callbacks: map[logical.Operation]framework.OperationFunc{
-			logical.CreateOperation: b.pathEncryptWrite,
-			logical.UpdateOperation: b.pathEncryptWrite,
+			logical.CreateOperation: b.requireEnabledKey(b.pathEncryptWrite),
+			logical.UpdateOperation: b.requireEnabledKey(b.pathEncryptWrite),
 		},
 
 		ExistenceCheck: b.pathEncryptExistenceCheck,
@@ -220,12 +221,17 @@ func (b *backend) pathEncryptWrite(
 			Name:       name,
 			Derived:    contextSet,
 			Convergent: convergent,
+			CreatedBy:  requestIdentity(req),
 		}
 
 		keyType := d.Get("type").(string)
 		switch keyType {
+		case "aes128-gcm96":
+			polReq.KeyType = keysutil.KeyType_AES128_GCM96
 		case "aes256-gcm96":
 			polReq.KeyType = keysutil.KeyType_AES256_GCM96
+		case "chacha20-poly1305":
+			polReq.KeyType = keysutil.KeyType_ChaCha20_Poly1305
 		case "ecdsa-p256":
 			return logical.ErrorResponse(fmt.Sprintf("key type %v not supported for this operation", keyType)), logical.ErrInvalidRequest
 		default:
@@ -241,11 +247,22 @@ func (b *backend) pathEncryptWrite(
 		defer lock.RUnlock()
 	}
 	if err != nil {
-		return nil, err
+		switch err.(type) {
+		case errutil.UserError:
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		default:
+			return nil, err
+		}
 	}
 	if p == nil {
 		return logical.ErrorResponse("encryption key not found"), logical.ErrInvalidRequest
 	}
+	if !p.OperationAllowed("encrypt") {
+		return logical.ErrorResponse(fmt.Sprintf("key %s does not allow the encrypt operation", p.Name)), logical.ErrInvalidRequest
+	}
+	if resp, err := b.checkRateLimit(p, len(batchInputItems)); err != nil {
+		return resp, err
+	}
 
 	// Process batch request items. If encryption of any request
 	// item fails, respectively mark the error in the response
@@ -290,6 +307,19 @@ func (b *backend) pathEncryptWrite(
 	if req.Operation == logical.CreateOperation && !upserted {
 		resp.AddWarning("Attempted creation of the key during the encrypt operation, but it was created beforehand")
 	}
+	if p.Derived {
+		contexts := make([][]byte, 0, len(batchInputItems))
+		for _, item := range batchInputItems {
+			contexts = append(contexts, item.DecodedContext)
+		}
+		warning, err := b.shortContextWarning(req.Storage, contexts...)
+		if err != nil {
+			return nil, err
+		}
+		if warning != "" {
+			resp.AddWarning(warning)
+		}
+	}
 	return resp, nil
 }
 
@@ -23,9 +23,14 @@ const (
 	exportTypeHMACKey       = "hmac-key"
 )
 
+const (
+	rsaEncodingPKCS1 = "pkcs1"
+	rsaEncodingPKCS8 = "pkcs8"
+)
+
 func (b *backend) pathExportKeys() *framework.Path {
 	return &framework.Path{
-		Pattern: "export/" + framework.GenericNameRegex("type") + "/" + framework.GenericNameRegex("name") + framework.OptionalParamRegex("version"),
+		Pattern: "export/" + framework.GenericNameRegex("type") + "/" + framework.GenericNameRegex("name") + framework.OptionalParamRegex("urlversion"),
 		Fields: map[string]*framework.FieldSchema{
 			"type": &framework.FieldSchema{
 				Type:        framework.TypeString,
@@ -35,14 +40,26 @@ func (b *backend) pathExportKeys() *framework.Path {
 				Type:        framework.TypeString,
 				Description: "Name of the key",
 			},
-			"version": &framework.FieldSchema{
+			"urlversion": &framework.FieldSchema{
 				Type:        framework.TypeString,
-				Description: "Version of the key",
+				Description: "Version of the key (URL parameter)",
+			},
+			"version": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `Version of the key to export, as a number or
+"latest", if not given in the URL. If neither is
+set, every exportable version is exported.`,
+			},
+			"encoding": &framework.FieldSchema{
+				Type:    framework.TypeString,
+				Default: rsaEncodingPKCS8,
+				Description: `Encoding of exported RSA private keys: "pkcs8"
+or "pkcs1". Defaults to "pkcs8".`,
 			},
 		},
 
 		Callbacks: map[logical.Operation]framework.OperationFunc{
-			logical.ReadOperation: b.pathPolicyExportRead,
+			logical.ReadOperation: b.requireEnabledKey(b.pathPolicyExportRead),
 		},
 
 		HelpSynopsis:    pathExportHelpSyn,
@@ -54,7 +71,17 @@ func (b *backend) pathPolicyExportRead(
 	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
 	exportType := d.Get("type").(string)
 	name := d.Get("name").(string)
-	version := d.Get("version").(string)
+	version := d.Get("urlversion").(string)
+	encoding := d.Get("encoding").(string)
+
+	// The version can also be given as a parameter, for clients that cannot
+	// build the URL themselves
+	if paramVersion := d.Get("version").(string); paramVersion != "" {
+		if version != "" && version != paramVersion {
+			return logical.ErrorResponse(fmt.Sprintf("version %s in the URL conflicts with version parameter %s", version, paramVersion)), logical.ErrInvalidRequest
+		}
+		version = paramVersion
+	}
 
 	switch exportType {
 	case exportTypeEncryptionKey:
@@ -64,6 +91,12 @@ func (b *backend) pathPolicyExportRead(
 		return logical.ErrorResponse(fmt.Sprintf("invalid export type: %s", exportType)), logical.ErrInvalidRequest
 	}
 
+	switch encoding {
+	case rsaEncodingPKCS1, rsaEncodingPKCS8:
+	default:
+		return logical.ErrorResponse(fmt.Sprintf("invalid encoding: %s", encoding)), logical.ErrInvalidRequest
+	}
+
 	p, lock, err := b.lm.GetPolicyShared(req.Storage, name)
 	if lock != nil {
 		defer lock.RUnlock()
@@ -75,7 +108,7 @@ func (b *backend) pathPolicyExportRead(
 		return nil, nil
 	}
 
-	if !p.Exportable {
+	if !p.Exportable && len(p.AllowedExportVersions) == 0 {
 		return logical.ErrorResponse("key is not exportable"), nil
 	}
 
@@ -90,11 +123,20 @@ func (b *backend) pathPolicyExportRead(
 		}
 	}
 
+	// The encoding only applies to RSA private keys
+	isRSA := p.Type == keysutil.KeyType_RSA2048 || p.Type == keysutil.KeyType_RSA3072 || p.Type == keysutil.KeyType_RSA4096
+	if _, ok := d.GetOk("encoding"); ok && (!isRSA || exportType == exportTypeHMACKey) {
+		return logical.ErrorResponse("encoding is only supported when exporting RSA private keys"), logical.ErrInvalidRequest
+	}
+
 	retKeys := map[string]string{}
 	switch version {
 	case "":
 		for k, v := range p.Keys {
-			exportKey, err := getExportKey(p, &v, exportType)
+			if !p.VersionExportable(k) {
+				continue
+			}
+			exportKey, err := getExportKey(p, &v, exportType, encoding)
 			if err != nil {
 				return nil, err
 			}
@@ -120,8 +162,11 @@ func (b *backend) pathPolicyExportRead(
 		if !ok {
 			return logical.ErrorResponse("version does not exist or cannot be found"), logical.ErrInvalidRequest
 		}
+		if !p.VersionExportable(versionValue) {
+			return logical.ErrorResponse("version is not exportable"), logical.ErrInvalidRequest
+		}
 
-		exportKey, err := getExportKey(p, &key, exportType)
+		exportKey, err := getExportKey(p, &key, exportType, encoding)
 		if err != nil {
 			return nil, err
 		}
@@ -140,7 +185,7 @@ func (b *backend) pathPolicyExportRead(
 	return resp, nil
 }
 
-func getExportKey(policy *keysutil.Policy, key *keysutil.KeyEntry, exportType string) (string, error) {
+func getExportKey(policy *keysutil.Policy, key *keysutil.KeyEntry, exportType, encoding string) (string, error) {
 	if policy == nil {
 		return "", errors.New("nil policy provided")
 	}
@@ -151,43 +196,57 @@ func getExportKey(policy *keysutil.Policy, key *keysutil.KeyEntry, exportType st
 
 	case exportTypeEncryptionKey:
 		switch policy.Type {
-		case keysutil.KeyType_AES256_GCM96:
+		case keysutil.KeyType_AES128_GCM96, keysutil.KeyType_AES256_GCM96, keysutil.KeyType_ChaCha20_Poly1305:
 			return strings.TrimSpace(base64.StdEncoding.EncodeToString(key.Key)), nil
 
-		case keysutil.KeyType_RSA2048, keysutil.KeyType_RSA4096:
-			return encodeRSAPrivateKey(key.RSAKey), nil
+		case keysutil.KeyType_RSA2048, keysutil.KeyType_RSA3072, keysutil.KeyType_RSA4096:
+			return encodeRSAPrivateKey(key.RSAKey, encoding)
 		}
 
 	case exportTypeSigningKey:
 		switch policy.Type {
-		case keysutil.KeyType_ECDSA_P256:
-			ecKey, err := keyEntryToECPrivateKey(key, elliptic.P256())
+		case keysutil.KeyType_ECDSA_P256, keysutil.KeyType_ECDSA_P384, keysutil.KeyType_ECDSA_P521:
+			ecKey, err := keyEntryToECPrivateKey(key, policy.Type.ECDSACurve())
 			if err != nil {
 				return "", err
 			}
 			return ecKey, nil
 
-		case keysutil.KeyType_ED25519:
+		case keysutil.KeyType_ED25519, keysutil.KeyType_ED448:
 			return strings.TrimSpace(base64.StdEncoding.EncodeToString(key.Key)), nil
 
-		case keysutil.KeyType_RSA2048, keysutil.KeyType_RSA4096:
-			return encodeRSAPrivateKey(key.RSAKey), nil
+		case keysutil.KeyType_RSA2048, keysutil.KeyType_RSA3072, keysutil.KeyType_RSA4096:
+			return encodeRSAPrivateKey(key.RSAKey, encoding)
 		}
 	}
 
 	return "", fmt.Errorf("unknown key type %v", policy.Type)
 }
 
-func encodeRSAPrivateKey(key *rsa.PrivateKey) string {
-	// When encoding PKCS1, the PEM header should be `RSA PRIVATE KEY`. When Go
-	// has PKCS8 encoding support, we may want to change this.
-	derBytes := x509.MarshalPKCS1PrivateKey(key)
-	pemBlock := &pem.Block{
-		Type:  "RSA PRIVATE KEY",
-		Bytes: derBytes,
+// encodeRSAPrivateKey returns the PEM encoding of an RSA private key in the
+// given encoding. The PEM header is `PRIVATE KEY` for PKCS8 and
+// `RSA PRIVATE KEY` for PKCS1.
+func encodeRSAPrivateKey(key *rsa.PrivateKey, encoding string) (string, error) {
+	pemBlock := &pem.Block{}
+	switch encoding {
+	case rsaEncodingPKCS1:
+		pemBlock.Type = "RSA PRIVATE KEY"
+		pemBlock.Bytes = x509.MarshalPKCS1PrivateKey(key)
+
+	case rsaEncodingPKCS8:
+		derBytes, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return "", fmt.Errorf("error marshaling RSA private key: %v", err)
+		}
+		pemBlock.Type = "PRIVATE KEY"
+		pemBlock.Bytes = derBytes
+
+	default:
+		return "", fmt.Errorf("unknown RSA private key encoding %s", encoding)
 	}
+
 	pemBytes := pem.EncodeToMemory(pemBlock)
-	return string(pemBytes)
+	return string(pemBytes), nil
 }
 
 func keyEntryToECPrivateKey(k *keysutil.KeyEntry, curve elliptic.Curve) (string, error) {
@@ -1,6 +1,9 @@
 package transit
 
 import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
 	"fmt"
 	"reflect"
 	"strconv"
@@ -412,3 +415,199 @@ func TestTransit_Export_EncryptionKey_DoesNotExportHMACKey(t *testing.T) {
 		t.Fatal("Encryption key data matched hmac key data")
 	}
 }
+
+func TestTransit_Export_AllowedVersions(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	readExportableVersions := func() []int {
+		resp := mustHandleRequest(t, b, &logical.Request{
+			Storage:   storage,
+			Operation: logical.ReadOperation,
+			Path:      "keys/foo",
+		})
+		return resp.Data["exportable_versions"].([]int)
+	}
+
+	// Only version 1 exists at creation
+	req := &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "keys/foo",
+		Data: map[string]interface{}{
+			"allow_export_versions": "2",
+		},
+	}
+	mustFailRequest(t, b, req)
+
+	req.Data["allow_export_versions"] = "1"
+	mustHandleRequest(t, b, req)
+	if versions := readExportableVersions(); !reflect.DeepEqual(versions, []int{1}) {
+		t.Fatalf("bad exportable versions: %#v", versions)
+	}
+
+	req.Path = "keys/foo/rotate"
+	req.Data = nil
+	mustHandleRequest(t, b, req)
+	mustHandleRequest(t, b, req)
+
+	// Versions that do not exist cannot be allowed
+	req.Path = "keys/foo/config"
+	req.Data = map[string]interface{}{
+		"allow_export_versions": "4",
+	}
+	mustFailRequest(t, b, req)
+
+	req.Data["allow_export_versions"] = "3"
+	mustHandleRequest(t, b, req)
+	if versions := readExportableVersions(); !reflect.DeepEqual(versions, []int{1, 3}) {
+		t.Fatalf("bad exportable versions: %#v", versions)
+	}
+
+	// Exportability cannot be taken away once granted
+	req.Data["allow_export_versions"] = []string{}
+	mustHandleRequest(t, b, req)
+	if versions := readExportableVersions(); !reflect.DeepEqual(versions, []int{1, 3}) {
+		t.Fatalf("bad exportable versions: %#v", versions)
+	}
+
+	req.Operation = logical.ReadOperation
+	req.Data = nil
+	req.Path = "export/encryption-key/foo"
+	keys := mustHandleRequest(t, b, req).Data["keys"].(map[string]string)
+	if len(keys) != 2 || keys["1"] == "" || keys["3"] == "" {
+		t.Fatalf("bad exported keys: %#v", keys)
+	}
+
+	req.Path = "export/encryption-key/foo/3"
+	mustHandleRequest(t, b, req)
+	req.Path = "export/encryption-key/foo/2"
+	mustFailRequest(t, b, req)
+}
+
+func TestTransit_Export_RSAEncoding(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	createKey := func(name, keyType string, exportable bool) {
+		resp, err := b.HandleRequest(&logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "keys/" + name,
+			Data: map[string]interface{}{
+				"type":       keyType,
+				"exportable": exportable,
+			},
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("failed to create key %s: %v, %#v", name, err, resp)
+		}
+	}
+	// export returns the exported private key in the given encoding, and
+	// the PEM type it was encoded with
+	export := func(encoding string) (*rsa.PrivateKey, string) {
+		var data map[string]interface{}
+		if encoding != "" {
+			data = map[string]interface{}{"encoding": encoding}
+		}
+		resp := mustHandle(t, b, storage, logical.ReadOperation, "export/signing-key/rsa/1", data)
+		block, _ := pem.Decode([]byte(resp.Data["keys"].(map[string]string)["1"]))
+		if block == nil {
+			t.Fatalf("failed to decode %s PEM", encoding)
+		}
+
+		var key *rsa.PrivateKey
+		var err error
+		switch block.Type {
+		case "PRIVATE KEY":
+			var parsed interface{}
+			parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+			key, _ = parsed.(*rsa.PrivateKey)
+		case "RSA PRIVATE KEY":
+			key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		default:
+			t.Fatalf("bad PEM type %s", block.Type)
+		}
+		if err != nil || key == nil {
+			t.Fatalf("failed to parse %s key: %v", encoding, err)
+		}
+		return key, block.Type
+	}
+
+	createKey("rsa", "rsa-2048", true)
+
+	pkcs8Key, pemType := export("pkcs8")
+	if pemType != "PRIVATE KEY" {
+		t.Fatalf("bad PKCS8 PEM type %s", pemType)
+	}
+	pkcs1Key, pemType := export("pkcs1")
+	if pemType != "RSA PRIVATE KEY" {
+		t.Fatalf("bad PKCS1 PEM type %s", pemType)
+	}
+	if pkcs8Key.N.Cmp(pkcs1Key.N) != 0 || pkcs8Key.D.Cmp(pkcs1Key.D) != 0 {
+		t.Fatal("PKCS8 and PKCS1 encodings hold different keys")
+	}
+	if _, pemType := export(""); pemType != "PRIVATE KEY" {
+		t.Fatalf("expected PKCS8 by default, got %s", pemType)
+	}
+
+	mustFail(t, b, storage, logical.ReadOperation, "export/signing-key/rsa/1", map[string]interface{}{"encoding": "der"})
+	mustFail(t, b, storage, logical.ReadOperation, "export/hmac-key/rsa/1", map[string]interface{}{"encoding": "pkcs1"})
+
+	createKey("ecdsa", "ecdsa-p256", true)
+	mustFail(t, b, storage, logical.ReadOperation, "export/signing-key/ecdsa/1", map[string]interface{}{"encoding": "pkcs1"})
+
+	createKey("unexportable", "rsa-2048", false)
+	mustFail(t, b, storage, logical.ReadOperation, "export/signing-key/unexportable/1", map[string]interface{}{"encoding": "pkcs1"})
+}
+
+func TestTransit_Export_VersionParameter(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	exportVersions := func(path string, data map[string]interface{}) []string {
+		var versions []string
+		for ver := range mustHandle(t, b, storage, logical.ReadOperation, path, data).Data["keys"].(map[string]string) {
+			versions = append(versions, ver)
+		}
+		return versions
+	}
+
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo", map[string]interface{}{
+		"exportable":   true,
+		"num_versions": 4,
+	})
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo/config", map[string]interface{}{
+		"min_decryption_version": 2,
+	})
+
+	cases := map[string][]string{
+		"latest": {"4"},
+		"3":      {"3"},
+		"2":      {"2"},
+		"v2":     {"2"},
+	}
+	for version, expected := range cases {
+		versions := exportVersions("export/encryption-key/foo", map[string]interface{}{
+			"version": version,
+		})
+		if !reflect.DeepEqual(versions, expected) {
+			t.Fatalf("version %s: expected versions %v, got %v", version, expected, versions)
+		}
+	}
+
+	// The version in the URL still works, and agrees with the parameter
+	if versions := exportVersions("export/encryption-key/foo/latest", nil); !reflect.DeepEqual(versions, []string{"4"}) {
+		t.Fatalf("bad versions for the URL version: %v", versions)
+	}
+	if versions := exportVersions("export/encryption-key/foo/3", map[string]interface{}{"version": "3"}); !reflect.DeepEqual(versions, []string{"3"}) {
+		t.Fatalf("bad versions for matching versions: %v", versions)
+	}
+	mustFail(t, b, storage, logical.ReadOperation, "export/encryption-key/foo/3", map[string]interface{}{"version": "latest"})
+
+	// Only live versions can be exported
+	for _, version := range []string{"1", "5", "0", "foo"} {
+		mustFail(t, b, storage, logical.ReadOperation, "export/encryption-key/foo", map[string]interface{}{"version": version})
+	}
+
+	// Exportability still applies
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/bar", nil)
+	mustFail(t, b, storage, logical.ReadOperation, "export/encryption-key/bar", map[string]interface{}{"version": "latest"})
+}
@@ -55,7 +55,7 @@ to the min_encryption_version configured on the key.`,
 		},
 
 		Callbacks: map[logical.Operation]framework.OperationFunc{
-			logical.UpdateOperation: b.pathHMACWrite,
+			logical.UpdateOperation: b.requireEnabledKey(b.pathHMACWrite),
 		},
 
 		HelpSynopsis:    pathHMACHelpSyn,
@@ -89,6 +89,9 @@ func (b *backend) pathHMACWrite(
 	if p == nil {
 		return logical.ErrorResponse("encryption key not found"), logical.ErrInvalidRequest
 	}
+	if resp, err := b.checkRateLimit(p, 1); err != nil {
+		return resp, err
+	}
 
 	switch {
 	case ver == 0:
@@ -183,6 +186,9 @@ func (b *backend) pathHMACVerify(
 	if p == nil {
 		return logical.ErrorResponse("encryption key not found"), logical.ErrInvalidRequest
 	}
+	if resp, err := b.checkRateLimit(p, 1); err != nil {
+		return resp, err
+	}
 
 	if ver > p.LatestVersion {
 		return logical.ErrorResponse("invalid HMAC: version is too new"), logical.ErrInvalidRequest
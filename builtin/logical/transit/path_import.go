@@ -0,0 +1,270 @@
+package transit
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/helper/errutil"
+	"github.com/hashicorp/vault/helper/keysutil"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+func (b *backend) pathImport() *framework.Path {
+	return &framework.Path{
+		Pattern: "keys/" + framework.GenericNameRegex("name") + "/import",
+		Fields: map[string]*framework.FieldSchema{
+			"name": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "Name of the key",
+			},
+
+			"ciphertext": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `The base64-encoded key material, wrapped using
+the public key returned by the wrapping_key
+endpoint.`,
+			},
+
+			"type": &framework.FieldSchema{
+				Type:    framework.TypeString,
+				Default: "aes256-gcm96",
+				Description: `The type of key being imported. Any key type
+that can be created can be imported. Defaults
+to "aes256-gcm96".`,
+			},
+
+			"derived": &framework.FieldSchema{
+				Type: framework.TypeBool,
+				Description: `Enables key derivation mode. This
+allows for per-transaction unique
+keys for encryption operations.`,
+			},
+
+			"exportable": &framework.FieldSchema{
+				Type: framework.TypeBool,
+				Description: `Enables keys to be exportable.
+This allows for all the valid keys
+in the key ring to be exported.`,
+			},
+
+			"allow_plaintext_backup": &framework.FieldSchema{
+				Type: framework.TypeBool,
+				Description: `Enables taking a backup of the named key in
+plaintext format. The key must also be
+exportable.`,
+			},
+
+			"allow_rotation": &framework.FieldSchema{
+				Type: framework.TypeBool,
+				Description: `Whether the key may be rotated to new key
+material generated by Vault. If not set, new
+versions can only be added by importing them.`,
+			},
+
+			"auto_rotate_period": &framework.FieldSchema{
+				Type:    framework.TypeDurationSecond,
+				Default: 0,
+				Description: `Amount of time the key should live before
+being automatically rotated. Requires
+allow_rotation. A value of 0 (default) disables
+automatic rotation for the key.`,
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.pathImportWrite,
+		},
+
+		HelpSynopsis:    pathImportHelpSyn,
+		HelpDescription: pathImportHelpDesc,
+	}
+}
+
+func (b *backend) pathImportVersion() *framework.Path {
+	return &framework.Path{
+		Pattern: "keys/" + framework.GenericNameRegex("name") + "/import_version",
+		Fields: map[string]*framework.FieldSchema{
+			"name": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "Name of the key",
+			},
+
+			"ciphertext": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `The base64-encoded key material, wrapped using
+the public key returned by the wrapping_key
+endpoint.`,
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.pathImportVersionWrite,
+		},
+
+		HelpSynopsis:    pathImportVersionHelpSyn,
+		HelpDescription: pathImportVersionHelpDesc,
+	}
+}
+
+func (b *backend) pathImportWrite(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+	keyType := d.Get("type").(string)
+	allowRotation := d.Get("allow_rotation").(bool)
+	autoRotatePeriod := time.Second * time.Duration(d.Get("auto_rotate_period").(int))
+
+	if autoRotatePeriod != 0 {
+		if !allowRotation {
+			return logical.ErrorResponse("auto rotate period requires allow_rotation to be set for imported keys"), logical.ErrInvalidRequest
+		}
+		if autoRotatePeriod < time.Hour {
+			return logical.ErrorResponse("auto rotate period must be 0 to disable or at least an hour"), logical.ErrInvalidRequest
+		}
+	}
+
+	polReq := keysutil.PolicyRequest{
+		Storage:                  req.Storage,
+		Name:                     name,
+		Derived:                  d.Get("derived").(bool),
+		Exportable:               d.Get("exportable").(bool),
+		AllowPlaintextBackup:     d.Get("allow_plaintext_backup").(bool),
+		AllowImportedKeyRotation: allowRotation,
+		AutoRotatePeriod:         autoRotatePeriod,
+		CreatedBy:                requestIdentity(req),
+	}
+	var ok bool
+	polReq.KeyType, ok = keysutil.ParseKeyType(keyType)
+	if !ok {
+		return logical.ErrorResponse(fmt.Sprintf("unknown key type %v", keyType)), logical.ErrInvalidRequest
+	}
+
+	key, err := b.unwrapKeyMaterial(req.Storage, d.Get("ciphertext").(string))
+	if err == nil {
+		err = b.lm.ImportPolicy(polReq, key)
+	}
+	if err != nil {
+		switch err.(type) {
+		case errutil.UserError:
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		default:
+			return nil, err
+		}
+	}
+
+	return nil, nil
+}
+
+func (b *backend) pathImportVersionWrite(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+
+	p, lock, err := b.lm.GetPolicyExclusive(req.Storage, name)
+	if lock != nil {
+		defer lock.Unlock()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return logical.ErrorResponse("key not found"), logical.ErrInvalidRequest
+	}
+	if !p.Imported {
+		return logical.ErrorResponse("new versions can only be imported into keys that were imported"), logical.ErrInvalidRequest
+	}
+
+	key, err := b.unwrapKeyMaterial(req.Storage, d.Get("ciphertext").(string))
+	if err == nil {
+		err = p.ImportKeyVersion(req.Storage, key)
+	}
+	if err != nil {
+		switch err.(type) {
+		case errutil.UserError:
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		default:
+			return nil, err
+		}
+	}
+
+	if warning := maxVersionsWarning(p); warning != "" {
+		resp := &logical.Response{}
+		resp.AddWarning(warning)
+		return resp, nil
+	}
+
+	return nil, nil
+}
+
+// unwrapKeyMaterial decrypts wrapped key material. The expected format is the
+// RSA-OAEP (SHA-256) encryption of an ephemeral AES-256 key under the backend's
+// wrapping key, followed by the 12-byte nonce and AES-GCM encryption of the key
+// material under the ephemeral key.
+func (b *backend) unwrapKeyMaterial(storage logical.Storage, ciphertext string) ([]byte, error) {
+	if ciphertext == "" {
+		return nil, errutil.UserError{Err: "missing ciphertext"}
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return nil, errutil.UserError{Err: "ciphertext could not be decoded from base64"}
+	}
+
+	wrappingKey, err := b.getWrappingKey(storage)
+	if err != nil {
+		return nil, err
+	}
+
+	wrappedKeySize := wrappingKey.Size()
+	if len(decoded) <= wrappedKeySize+12 {
+		return nil, errutil.UserError{Err: "invalid ciphertext length"}
+	}
+
+	ephemeralKey, err := rsa.DecryptOAEP(sha256.New(), nil, wrappingKey, decoded[:wrappedKeySize], nil)
+	if err != nil {
+		return nil, errutil.UserError{Err: "unable to unwrap the ephemeral key"}
+	}
+	if len(ephemeralKey) != 32 {
+		return nil, errutil.UserError{Err: "ephemeral key must be 32 bytes long"}
+	}
+
+	aesCipher, err := aes.NewCipher(ephemeralKey)
+	if err != nil {
+		return nil, errutil.InternalError{Err: err.Error()}
+	}
+	gcm, err := cipher.NewGCM(aesCipher)
+	if err != nil {
+		return nil, errutil.InternalError{Err: err.Error()}
+	}
+
+	rest := decoded[wrappedKeySize:]
+	key, err := gcm.Open(nil, rest[:gcm.NonceSize()], rest[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, errutil.UserError{Err: "unable to unwrap the key material"}
+	}
+
+	return key, nil
+}
+
+const pathImportHelpSyn = `Import externally generated key material as a new named key`
+
+const pathImportHelpDesc = `
+This path is used to create a new named key from key material generated
+outside of Vault. The key material must be wrapped using the public key
+returned by the wrapping_key path. Symmetric keys are given as raw bytes
+and asymmetric keys as PKCS#8 DER-encoded private keys. Imported keys
+cannot be rotated to Vault-generated key material unless allow_rotation
+is set; new versions can instead be added via keys/<name>/import_version.
+`
+
+const pathImportVersionHelpSyn = `Import externally generated key material as a new key version`
+
+const pathImportVersionHelpDesc = `
+This path is used to add a new version to a previously imported named
+key using externally generated key material, wrapped in the same way as
+for the keys/<name>/import path.
+`
@@ -0,0 +1,364 @@
+package transit
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"strings"
+	"testing"
+
+	uuid "github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestTransit_Import(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	req := &logical.Request{
+		Storage:   storage,
+		Operation: logical.ReadOperation,
+		Path:      "wrapping_key",
+	}
+	resp := mustHandleRequest(t, b, req)
+	block, _ := pem.Decode([]byte(resp.Data["public_key"].(string)))
+	if block == nil {
+		t.Fatal("failed to decode wrapping key PEM")
+	}
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrappingKey := parsed.(*rsa.PublicKey)
+
+	// The wrapping key must be stable across reads
+	if mustHandleRequest(t, b, req).Data["public_key"] != resp.Data["public_key"] {
+		t.Fatal("wrapping key changed between reads")
+	}
+
+	wrap := func(key []byte) string {
+		ephemeralKey, err := uuid.GenerateRandomBytes(32)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wrappedEphemeralKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, wrappingKey, ephemeralKey, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		aesCipher, err := aes.NewCipher(ephemeralKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gcm, err := cipher.NewGCM(aesCipher)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nonce, err := uuid.GenerateRandomBytes(gcm.NonceSize())
+		if err != nil {
+			t.Fatal(err)
+		}
+		wrapped := append(wrappedEphemeralKey, nonce...)
+		wrapped = append(wrapped, gcm.Seal(nil, nonce, key, nil)...)
+		return base64.StdEncoding.EncodeToString(wrapped)
+	}
+
+	plaintext := "dGhlIHF1aWNrIGJyb3duIGZveA=="
+
+	// Import a symmetric key and make sure Vault encrypts with it
+	aesKey, err := uuid.GenerateRandomBytes(32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Operation = logical.UpdateOperation
+	req.Path = "keys/aes/import"
+	req.Data = map[string]interface{}{
+		"ciphertext": wrap(aesKey),
+	}
+	mustHandleRequest(t, b, req)
+
+	// Importing over an existing key fails
+	mustFailRequest(t, b, req)
+
+	req.Path = "encrypt/aes"
+	req.Data = map[string]interface{}{
+		"plaintext": plaintext,
+	}
+	ciphertext := mustHandleRequest(t, b, req).Data["ciphertext"].(string)
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(ciphertext, "vault:v1:"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	aesCipher, err := aes.NewCipher(aesKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCM(aesCipher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := gcm.Open(nil, decoded[:gcm.NonceSize()], decoded[gcm.NonceSize():], nil)
+	if err != nil {
+		t.Fatalf("ciphertext was not produced with the imported key: %v", err)
+	}
+	if base64.StdEncoding.EncodeToString(plain) != plaintext {
+		t.Fatalf("bad plaintext: %q", plain)
+	}
+
+	req.Operation = logical.ReadOperation
+	req.Path = "keys/aes"
+	req.Data = nil
+	resp = mustHandleRequest(t, b, req)
+	if resp.Data["imported"] != true || resp.Data["imported_key_allow_rotation"] != false {
+		t.Fatalf("bad imported key flags: %#v", resp.Data)
+	}
+
+	// Rotation to generated key material is refused, but new versions can be
+	// imported
+	req.Operation = logical.UpdateOperation
+	req.Path = "keys/aes/rotate"
+	mustFailRequest(t, b, req)
+
+	newAESKey, err := uuid.GenerateRandomBytes(32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Path = "keys/aes/import_version"
+	req.Data = map[string]interface{}{
+		"ciphertext": wrap(newAESKey),
+	}
+	mustHandleRequest(t, b, req)
+
+	req.Operation = logical.ReadOperation
+	req.Path = "keys/aes"
+	req.Data = nil
+	if resp = mustHandleRequest(t, b, req); resp.Data["latest_version"] != 2 {
+		t.Fatalf("expected latest version 2, got %#v", resp.Data["latest_version"])
+	}
+
+	req.Operation = logical.UpdateOperation
+	req.Path = "decrypt/aes"
+	req.Data = map[string]interface{}{
+		"ciphertext": ciphertext,
+	}
+	if resp = mustHandleRequest(t, b, req); resp.Data["plaintext"] != plaintext {
+		t.Fatalf("bad plaintext after importing a new version: %#v", resp.Data)
+	}
+
+	// Re-keying replaces the material of the latest version in place, so
+	// ciphertext of that version can no longer be decrypted, while older
+	// versions are unaffected
+	req.Path = "encrypt/aes"
+	req.Data = map[string]interface{}{
+		"plaintext": plaintext,
+	}
+	ciphertextV2 := mustHandleRequest(t, b, req).Data["ciphertext"].(string)
+
+	rekeyedAESKey, err := uuid.GenerateRandomBytes(32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Path = "keys/aes/rekey"
+	req.Data = map[string]interface{}{
+		"ciphertext": wrap(rekeyedAESKey),
+	}
+	mustFailRequest(t, b, req)
+	req.Data["i_understand_this_invalidates_ciphertext"] = true
+	if resp = mustHandleRequest(t, b, req); resp.Data["latest_version"] != 2 {
+		t.Fatalf("expected re-keying to keep latest version 2, got %#v", resp.Data)
+	}
+
+	req.Path = "decrypt/aes"
+	req.Data = map[string]interface{}{
+		"ciphertext": ciphertextV2,
+	}
+	mustFailRequest(t, b, req)
+	req.Data["ciphertext"] = ciphertext
+	if resp = mustHandleRequest(t, b, req); resp.Data["plaintext"] != plaintext {
+		t.Fatalf("bad plaintext of version 1 after re-keying: %#v", resp.Data)
+	}
+
+	staleCiphertextV2 := ciphertextV2
+	req.Path = "encrypt/aes"
+	req.Data = map[string]interface{}{
+		"plaintext": plaintext,
+	}
+	ciphertextV2 = mustHandleRequest(t, b, req).Data["ciphertext"].(string)
+	if !strings.HasPrefix(ciphertextV2, "vault:v2:") {
+		t.Fatalf("expected version 2 ciphertext after re-keying, got %q", ciphertextV2)
+	}
+	decoded, err = base64.StdEncoding.DecodeString(strings.TrimPrefix(ciphertextV2, "vault:v2:"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	aesCipher, err = aes.NewCipher(rekeyedAESKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err = cipher.NewGCM(aesCipher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gcm.Open(nil, decoded[:gcm.NonceSize()], decoded[gcm.NonceSize():], nil); err != nil {
+		t.Fatalf("ciphertext was not produced with the re-keyed material: %v", err)
+	}
+
+	// The archived copy of the version is re-keyed as well, so the previous
+	// material does not come back when versions are loaded from the archive,
+	// nor when the key is backed up and restored
+	checkRekeyed := func(name string) {
+		req.Path = "decrypt/" + name
+		req.Data = map[string]interface{}{
+			"ciphertext": staleCiphertextV2,
+		}
+		mustFailRequest(t, b, req)
+		req.Data["ciphertext"] = ciphertextV2
+		if resp := mustHandleRequest(t, b, req); resp.Data["plaintext"] != plaintext {
+			t.Fatalf("%s: bad plaintext of the re-keyed version: %#v", name, resp.Data)
+		}
+	}
+	for _, minDecryptionVersion := range []int{2, 1} {
+		req.Path = "keys/aes/config"
+		req.Data = map[string]interface{}{
+			"min_decryption_version": minDecryptionVersion,
+		}
+		mustHandleRequest(t, b, req)
+		checkRekeyed("aes")
+	}
+
+	req.Path = "keys/aes/config"
+	req.Data = map[string]interface{}{
+		"exportable":             true,
+		"allow_plaintext_backup": true,
+	}
+	mustHandleRequest(t, b, req)
+	req.Operation = logical.ReadOperation
+	req.Path = "keys/aes/backup"
+	req.Data = nil
+	backup := mustHandleRequest(t, b, req).Data["backup"].(string)
+	req.Operation = logical.UpdateOperation
+	req.Path = "restore/aes-restored"
+	req.Data = map[string]interface{}{
+		"backup": backup,
+	}
+	mustHandleRequest(t, b, req)
+	checkRekeyed("aes-restored")
+
+	// Key material of the wrong size or wrapped with another key is rejected
+	req.Path = "keys/aes/import_version"
+	req.Data = map[string]interface{}{
+		"ciphertext": wrap(newAESKey[:16]),
+	}
+	mustFailRequest(t, b, req)
+	req.Data = map[string]interface{}{
+		"ciphertext": base64.StdEncoding.EncodeToString(make([]byte, 1024)),
+	}
+	mustFailRequest(t, b, req)
+
+	// New versions cannot be imported into keys that were generated by Vault
+	req.Path = "keys/generated"
+	req.Data = nil
+	mustHandleRequest(t, b, req)
+	req.Path = "keys/generated/import_version"
+	req.Data = map[string]interface{}{
+		"ciphertext": wrap(newAESKey),
+	}
+	mustFailRequest(t, b, req)
+
+	// Nor can they be re-keyed
+	req.Path = "keys/generated/rekey"
+	req.Data = map[string]interface{}{
+		"ciphertext": wrap(newAESKey),
+		"i_understand_this_invalidates_ciphertext": true,
+	}
+	mustFailRequest(t, b, req)
+
+	// Import an asymmetric key that may be rotated
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(rsaKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Path = "keys/rsa/import"
+	req.Data = map[string]interface{}{
+		"ciphertext": wrap(pkcs8),
+		"type":       "rsa-4096",
+	}
+	mustFailRequest(t, b, req)
+	req.Data["type"] = "rsa-2048"
+	req.Data["allow_rotation"] = true
+	mustHandleRequest(t, b, req)
+
+	req.Path = "sign/rsa"
+	req.Data = map[string]interface{}{
+		"input": plaintext,
+	}
+	signature := mustHandleRequest(t, b, req).Data["signature"].(string)
+	sigBytes, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(signature, "vault:v1:"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	input, _ := base64.StdEncoding.DecodeString(plaintext)
+	digest := sha256.Sum256(input)
+	if err := rsa.VerifyPSS(&rsaKey.PublicKey, crypto.SHA256, digest[:], sigBytes, nil); err != nil {
+		t.Fatalf("signature was not produced with the imported key: %v", err)
+	}
+
+	req.Path = "keys/rsa/rotate"
+	req.Data = nil
+	mustHandleRequest(t, b, req)
+
+	// The imported flag survives rotation
+	req.Operation = logical.ReadOperation
+	req.Path = "keys/rsa"
+	resp = mustHandleRequest(t, b, req)
+	if resp.Data["imported"] != true || resp.Data["imported_key_allow_rotation"] != true || resp.Data["latest_version"] != 2 {
+		t.Fatalf("bad imported key flags after rotation: %#v", resp.Data)
+	}
+
+	// Generated keys are not flagged as imported
+	req.Path = "keys/generated"
+	resp = mustHandleRequest(t, b, req)
+	if resp.Data["imported"] != false {
+		t.Fatalf("expected generated key not to be flagged as imported: %#v", resp.Data)
+	}
+	if _, ok := resp.Data["imported_key_allow_rotation"]; ok {
+		t.Fatalf("unexpected imported key rotation flag on generated key: %#v", resp.Data)
+	}
+	req.Operation = logical.UpdateOperation
+
+	// ECDSA keys must match the requested curve
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8, err = x509.MarshalPKCS8PrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Path = "keys/ecdsa/import"
+	req.Data = map[string]interface{}{
+		"ciphertext": wrap(pkcs8),
+		"type":       "ecdsa-p256",
+	}
+	mustFailRequest(t, b, req)
+	req.Data["type"] = "ecdsa-p384"
+	mustHandleRequest(t, b, req)
+
+	// Automatic rotation requires rotation to be allowed
+	req.Path = "keys/auto/import"
+	req.Data = map[string]interface{}{
+		"ciphertext":         wrap(aesKey),
+		"auto_rotate_period": "24h",
+	}
+	mustFailRequest(t, b, req)
+}
@@ -0,0 +1,95 @@
+package transit
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/hashicorp/vault/helper/keysutil"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+func (b *backend) pathJWKS() *framework.Path {
+	return &framework.Path{
+		Pattern: "jwks",
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.pathJWKSRead,
+		},
+
+		HelpSynopsis:    pathJWKSHelpSyn,
+		HelpDescription: pathJWKSHelpDesc,
+	}
+}
+
+func (b *backend) pathJWKSRead(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	entries, err := req.Storage.List("policy/")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(entries)
+
+	// Keys that cannot be loaded are reported as warnings rather than
+	// failing the request, so that one bad key does not prevent the others
+	// from being published
+	jwks := []interface{}{}
+	var warnings []string
+	for _, name := range entries {
+		p, lock, err := b.lm.GetPolicyShared(req.Storage, name)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("key %s could not be loaded: %v", name, err))
+			continue
+		}
+		if p == nil {
+			continue
+		}
+		jwks = append(jwks, policyJWKs(p)...)
+		lock.RUnlock()
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"keys": jwks,
+		},
+	}
+	for _, warning := range warnings {
+		resp.AddWarning(warning)
+	}
+	return resp, nil
+}
+
+// policyJWKs returns a JSON Web Key for each exportable version of the key
+// that can still be used, in version order. Only asymmetric keys have public
+// keys that can be published; derived ed25519 and ed448 keys are left out as
+// their public keys depend on a context, and disabled keys and versions as
+// they can no longer be used.
+func policyJWKs(p *keysutil.Policy) []interface{} {
+	if !p.Type.SigningSupported() || (p.Type.EdDSA() && p.Derived) || p.Disabled {
+		return nil
+	}
+
+	var versions []int
+	for ver := range p.Keys {
+		if ver >= p.MinDecryptionVersion && p.VersionExportable(ver) && !p.DecryptionVersionDisabled(ver) {
+			versions = append(versions, ver)
+		}
+	}
+	sort.Ints(versions)
+
+	jwks := make([]interface{}, 0, len(versions))
+	for _, ver := range versions {
+		jwks = append(jwks, publicKeyJWK(fmt.Sprintf("%s:%d", p.Name, ver), entryPublicKey(p, p.Keys[ver])))
+	}
+	return jwks
+}
+
+const pathJWKSHelpSyn = `Return the public keys of all exportable asymmetric keys as a JWK Set`
+
+const pathJWKSHelpDesc = `
+This path returns a JSON Web Key Set (RFC 7517) holding the public key of
+every exportable version of every asymmetric key, for use by verifiers such as
+OpenID Connect clients. The ID of each key is the name of the key and the
+version, separated by a colon. Disabled keys, versions below the minimum
+decryption version and versions disabled for decryption are not published.
+`
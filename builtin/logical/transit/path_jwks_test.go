@@ -0,0 +1,154 @@
+package transit
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestTransit_JWKS(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	readJWKS := func() map[string]map[string]interface{} {
+		resp := mustHandle(t, b, storage, logical.ReadOperation, "jwks", nil)
+		if len(resp.Warnings) != 0 {
+			t.Fatalf("unexpected warnings: %#v", resp.Warnings)
+		}
+		jwks := map[string]map[string]interface{}{}
+		for _, raw := range resp.Data["keys"].([]interface{}) {
+			jwk := raw.(map[string]interface{})
+			if jwk["use"] != "sig" {
+				t.Fatalf("bad use: %#v", jwk)
+			}
+			jwks[jwk["kid"].(string)] = jwk
+		}
+		return jwks
+	}
+	kids := func(jwks map[string]map[string]interface{}) map[string]bool {
+		set := map[string]bool{}
+		for kid := range jwks {
+			set[kid] = true
+		}
+		return set
+	}
+	decodeJWK := func(jwk map[string]interface{}, field string) *big.Int {
+		raw, err := base64.RawURLEncoding.DecodeString(jwk[field].(string))
+		if err != nil {
+			t.Fatalf("bad JWK field %s: %v", field, err)
+		}
+		return new(big.Int).SetBytes(raw)
+	}
+	publicKey := func(name string, ver string) interface{} {
+		resp := mustHandle(t, b, storage, logical.ReadOperation, "keys/"+name, nil)
+		keys := resp.Data["keys"].(map[string]map[string]interface{})
+		block, _ := pem.Decode([]byte(keys[ver]["public_key"].(string)))
+		if block == nil {
+			t.Fatalf("failed to decode PEM of %s", name)
+		}
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pub
+	}
+
+	if jwks := readJWKS(); len(jwks) != 0 {
+		t.Fatalf("expected an empty key set, got %#v", jwks)
+	}
+
+	for name, keyType := range map[string]string{
+		"ecdsa":   "ecdsa-p256",
+		"rsa":     "rsa-2048",
+		"ed25519": "ed25519",
+		"aes":     "aes256-gcm96",
+	} {
+		mustHandle(t, b, storage, logical.UpdateOperation, "keys/"+name, map[string]interface{}{
+			"type":       keyType,
+			"exportable": true,
+		})
+	}
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/ecdsa/rotate", nil)
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/ecdsa/rotate", nil)
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/private", map[string]interface{}{
+		"type": "ecdsa-p256",
+	})
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/derived", map[string]interface{}{
+		"type":       "ed25519",
+		"derived":    true,
+		"exportable": true,
+	})
+
+	jwks := readJWKS()
+	expected := map[string]bool{
+		"ecdsa:1":   true,
+		"ecdsa:2":   true,
+		"ecdsa:3":   true,
+		"rsa:1":     true,
+		"ed25519:1": true,
+	}
+	if !reflect.DeepEqual(kids(jwks), expected) {
+		t.Fatalf("bad key IDs: %#v", kids(jwks))
+	}
+
+	ecKey := publicKey("ecdsa", "2").(*ecdsa.PublicKey)
+	if jwk := jwks["ecdsa:2"]; jwk["kty"] != "EC" || jwk["crv"] != "P-256" ||
+		decodeJWK(jwk, "x").Cmp(ecKey.X) != 0 || decodeJWK(jwk, "y").Cmp(ecKey.Y) != 0 {
+		t.Fatalf("JWK does not match the public key: %#v", jwk)
+	}
+	rsaKey := publicKey("rsa", "1").(*rsa.PublicKey)
+	if jwk := jwks["rsa:1"]; jwk["kty"] != "RSA" || decodeJWK(jwk, "n").Cmp(rsaKey.N) != 0 {
+		t.Fatalf("JWK does not match the public key: %#v", jwk)
+	}
+	edKey := mustHandle(t, b, storage, logical.ReadOperation, "keys/ed25519", nil).Data["keys"].(map[string]map[string]interface{})["1"]["public_key"].(string)
+	raw, err := base64.StdEncoding.DecodeString(edKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jwk := jwks["ed25519:1"]; jwk["kty"] != "OKP" || jwk["x"] != base64.RawURLEncoding.EncodeToString(raw) {
+		t.Fatalf("JWK does not match the public key: %#v", jwk)
+	}
+
+	// Retired versions are no longer published
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/ecdsa/config", map[string]interface{}{
+		"min_decryption_version": 3,
+	})
+	delete(expected, "ecdsa:1")
+	delete(expected, "ecdsa:2")
+	if jwks := readJWKS(); !reflect.DeepEqual(kids(jwks), expected) {
+		t.Fatalf("bad key IDs after raising the min decryption version: %#v", kids(jwks))
+	}
+
+	// As are versions disabled for decryption
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/ecdsa/rotate", nil)
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/ecdsa/config", map[string]interface{}{
+		"disabled_decryption_versions": []int{3},
+	})
+	delete(expected, "ecdsa:3")
+	expected["ecdsa:4"] = true
+	if jwks := readJWKS(); !reflect.DeepEqual(kids(jwks), expected) {
+		t.Fatalf("bad key IDs after disabling a version: %#v", kids(jwks))
+	}
+
+	// And disabled keys, until they are enabled again
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/rsa/config", map[string]interface{}{
+		"enabled": false,
+	})
+	delete(expected, "rsa:1")
+	if jwks := readJWKS(); !reflect.DeepEqual(kids(jwks), expected) {
+		t.Fatalf("bad key IDs after disabling a key: %#v", kids(jwks))
+	}
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/rsa/config", map[string]interface{}{
+		"enabled": true,
+	})
+	expected["rsa:1"] = true
+	if jwks := readJWKS(); !reflect.DeepEqual(kids(jwks), expected) {
+		t.Fatalf("bad key IDs after enabling a key: %#v", kids(jwks))
+	}
+}
@@ -0,0 +1,89 @@
+package transit
+
+import (
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+func (b *backend) pathKeyDelete() *framework.Path {
+	return &framework.Path{
+		Pattern: "keys/" + framework.GenericNameRegex("name") + "/delete",
+		Fields: map[string]*framework.FieldSchema{
+			"name": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "Name of the key",
+			},
+
+			"confirm": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `The name of the key. Required if
+require_delete_confirmation is set in the
+config/keys path.`,
+			},
+
+			"dry_run": &framework.FieldSchema{
+				Type: framework.TypeBool,
+				Description: `If set, report whether the key exists,
+whether deletion is allowed, and how many key
+versions would be destroyed, without deleting
+anything.`,
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.pathKeyDeleteUpdate,
+		},
+
+		HelpSynopsis:    pathKeyDeleteHelpSyn,
+		HelpDescription: pathKeyDeleteHelpDesc,
+	}
+}
+
+func (b *backend) pathKeyDeleteUpdate(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+
+	if d.Get("dry_run").(bool) {
+		return b.pathKeyDeleteDryRun(req.Storage, name)
+	}
+
+	return b.deletePolicy(req.Storage, name, d.Get("confirm").(string))
+}
+
+// pathKeyDeleteDryRun reports what deleting the named key would do
+func (b *backend) pathKeyDeleteDryRun(storage logical.Storage, name string) (*logical.Response, error) {
+	p, lock, err := b.lm.GetPolicyShared(storage, name)
+	if lock != nil {
+		defer lock.RUnlock()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"exists":           p != nil,
+			"deletion_allowed": false,
+			"versions":         0,
+		},
+	}
+	if p == nil {
+		return resp, nil
+	}
+
+	resp.Data["deletion_allowed"] = p.DeletionAllowed
+	resp.Data["versions"] = p.AvailableVersions()
+
+	return resp, nil
+}
+
+const pathKeyDeleteHelpSyn = `Delete a named key, with confirmation or as a dry run`
+
+const pathKeyDeleteHelpDesc = `
+This path deletes the named key as a DELETE request to keys/<name> does, but
+takes parameters: the confirm parameter must be set to the name of the key if
+require_delete_confirmation is set in the config/keys path, and if dry_run is
+set nothing is deleted. Instead, the response reports whether the key exists,
+whether deletion_allowed is set, and the number of key versions deletion would
+destroy.
+`
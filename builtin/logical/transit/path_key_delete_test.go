@@ -0,0 +1,49 @@
+package transit
+
+import (
+	"testing"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestTransit_KeyDelete(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	dryRun := func(name string) map[string]interface{} {
+		return mustHandle(t, b, storage, logical.UpdateOperation, "keys/"+name+"/delete", map[string]interface{}{
+			"dry_run": true,
+		}).Data
+	}
+
+	if data := dryRun("missing"); data["exists"] != false || data["versions"] != 0 {
+		t.Fatalf("bad dry run for missing key: %#v", data)
+	}
+
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo", nil)
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo/rotate", nil)
+
+	data := dryRun("foo")
+	if data["exists"] != true || data["deletion_allowed"] != false || data["versions"] != 2 {
+		t.Fatalf("bad dry run: %#v", data)
+	}
+
+	// Without deletion_allowed the real deletion fails
+	mustFail(t, b, storage, logical.UpdateOperation, "keys/foo/delete", nil)
+
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo/config", map[string]interface{}{
+		"deletion_allowed": true,
+	})
+	if data := dryRun("foo"); data["deletion_allowed"] != true {
+		t.Fatalf("bad dry run: %#v", data)
+	}
+
+	// The key must still exist after the dry runs
+	if resp := mustHandle(t, b, storage, logical.ReadOperation, "keys/foo", nil); resp == nil {
+		t.Fatal("expected key to still exist")
+	}
+
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo/delete", nil)
+	if resp := mustHandle(t, b, storage, logical.ReadOperation, "keys/foo", nil); resp != nil {
+		t.Fatalf("expected key to be deleted, got %#v", resp)
+	}
+}
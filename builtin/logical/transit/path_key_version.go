@@ -0,0 +1,260 @@
+package transit
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hashicorp/vault/helper/errutil"
+	"github.com/hashicorp/vault/helper/keysutil"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+func (b *backend) pathKeyVersion() *framework.Path {
+	return &framework.Path{
+		Pattern: "keys/" + framework.GenericNameRegex("name") + "/version/(?P<version>\\d+)",
+		Fields: map[string]*framework.FieldSchema{
+			"name": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "Name of the key",
+			},
+
+			"version": &framework.FieldSchema{
+				Type:        framework.TypeInt,
+				Description: "Version of the key",
+			},
+
+			"context": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `Base64 encoded context for key derivation.
+When set for a derived ed25519 or ed448 key, the
+public key for the given context is returned.`,
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation:   b.requireEnabledKey(b.pathKeyVersionRead),
+			logical.DeleteOperation: b.pathKeyVersionDelete,
+		},
+
+		HelpSynopsis:    pathKeyVersionHelpSyn,
+		HelpDescription: pathKeyVersionHelpDesc,
+	}
+}
+
+func (b *backend) pathKeyVersionRead(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+	ver := d.Get("version").(int)
+
+	p, lock, err := b.lm.GetPolicyShared(req.Storage, name)
+	if lock != nil {
+		defer lock.RUnlock()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return logical.ErrorResponse("key not found"), logical.ErrUnsupportedPath
+	}
+
+	// Versions below the minimum decryption version are archived and can no
+	// longer be used, so they are reported in the same way as missing ones
+	entry, ok := p.Keys[ver]
+	if !ok || ver < p.MinDecryptionVersion {
+		return logical.ErrorResponse(fmt.Sprintf("version %d of the key does not exist or is below the min decryption version", ver)), logical.ErrUnsupportedPath
+	}
+
+	if p.ManagedKeyName != "" && p.Type.SigningSupported() {
+		return logical.ErrorResponse(managedPublicKeyError(p)), logical.ErrInvalidRequest
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"name":          p.Name,
+			"version":       ver,
+			"algorithm":     p.Type.String(),
+			"creation_time": entryCreationTime(entry),
+		},
+	}
+
+	switch p.Type {
+	case keysutil.KeyType_ECDSA_P256, keysutil.KeyType_ECDSA_P384, keysutil.KeyType_ECDSA_P521:
+		resp.Data["public_key"] = entry.FormattedPublicKey
+
+	case keysutil.KeyType_RSA2048, keysutil.KeyType_RSA3072, keysutil.KeyType_RSA4096:
+		pubKey, err := rsaPublicKeyPEM(entry.RSAKey)
+		if err != nil {
+			return nil, err
+		}
+		resp.Data["public_key"] = pubKey
+
+	case keysutil.KeyType_ED25519, keysutil.KeyType_ED448:
+		if !p.Derived {
+			resp.Data["public_key"] = entry.FormattedPublicKey
+			break
+		}
+
+		contextRaw := d.Get("context").(string)
+		if len(contextRaw) == 0 {
+			return logical.ErrorResponse("this key requires a derivation context to return its public key"), logical.ErrInvalidRequest
+		}
+		context, err := decodeContext(contextRaw)
+		if err != nil {
+			return logical.ErrorResponse("failed to base64-decode context"), logical.ErrInvalidRequest
+		}
+		derived, err := p.DeriveKey(context, ver)
+		if err != nil {
+			switch err.(type) {
+			case errutil.UserError:
+				return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+			default:
+				return nil, fmt.Errorf("failed to derive key to return public component: %v", err)
+			}
+		}
+		resp.Data["public_key"] = encodeEdDSAPublicKey(p.Type, derived)
+	}
+
+	return resp, nil
+}
+
+func (b *backend) pathKeyVersionDelete(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+	ver := d.Get("version").(int)
+
+	p, lock, err := b.lm.GetPolicyExclusive(req.Storage, name)
+	if lock != nil {
+		defer lock.Unlock()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return logical.ErrorResponse("key not found"), logical.ErrUnsupportedPath
+	}
+
+	err = p.DeleteVersion(req.Storage, ver)
+	if err != nil {
+		switch err.(type) {
+		case errutil.UserError:
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		default:
+			return nil, err
+		}
+	}
+
+	return nil, nil
+}
+
+func (b *backend) pathKeyVersions() *framework.Path {
+	return &framework.Path{
+		Pattern: "keys/" + framework.GenericNameRegex("name") + "/versions",
+		Fields: map[string]*framework.FieldSchema{
+			"name": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "Name of the key",
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.pathKeyVersionsRead,
+		},
+
+		HelpSynopsis:    pathKeyVersionsHelpSyn,
+		HelpDescription: pathKeyVersionsHelpDesc,
+	}
+}
+
+func (b *backend) pathKeyVersionsRead(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+
+	p, lock, err := b.lm.GetPolicyShared(req.Storage, name)
+	if lock != nil {
+		defer lock.RUnlock()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return logical.ErrorResponse("key not found"), logical.ErrUnsupportedPath
+	}
+
+	// Trimmed versions are no longer in the key, and archived versions below
+	// the minimum decryption version can no longer be used, so only the
+	// versions that are still live are listed
+	live := keyVersions(p, p.MinDecryptionVersion)
+	versions := make([]map[string]interface{}, 0, len(live))
+	for _, version := range live {
+		versions = append(versions, map[string]interface{}{
+			"version":       version.version,
+			"creation_time": version.creationTime,
+			"enabled":       !p.EncryptionVersionDisabled(version.version),
+		})
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"name":     p.Name,
+			"versions": versions,
+		},
+	}, nil
+}
+
+// keyVersion is a version of a key along with its creation time in RFC3339
+// format
+type keyVersion struct {
+	version      int
+	creationTime string
+}
+
+// keyVersions returns the versions of the key at or above the given version,
+// in version order
+func keyVersions(p *keysutil.Policy, minVersion int) []keyVersion {
+	versions := make([]keyVersion, 0, len(p.Keys))
+	for ver, entry := range p.Keys {
+		if ver >= minVersion {
+			versions = append(versions, keyVersion{
+				version:      ver,
+				creationTime: entryCreationTime(entry),
+			})
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].version < versions[j].version
+	})
+	return versions
+}
+
+// entryCreationTime returns the creation time of a key version in RFC3339
+// format, falling back to the deprecated field used by older keys
+func entryCreationTime(entry keysutil.KeyEntry) string {
+	creationTime := entry.CreationTime
+	if creationTime.IsZero() {
+		creationTime = time.Unix(entry.DeprecatedCreationTime, 0)
+	}
+	return creationTime.UTC().Format(time.RFC3339)
+}
+
+const pathKeyVersionHelpSyn = `Read a single version of a named key`
+
+const pathKeyVersionHelpDesc = `
+This path returns the creation time and algorithm of one version of the named
+key and, for asymmetric keys, its public key. Versions that do not exist or
+are below the minimum decryption version are not found.
+
+Deleting a version removes its key material for good. Only archived versions
+below the minimum decryption version can be deleted, and never the latest
+version; once deleted, the minimum decryption version cannot be lowered to
+include the version again.
+`
+
+const pathKeyVersionsHelpSyn = `List the live versions of a named key`
+
+const pathKeyVersionsHelpDesc = `
+This path returns the versions of the named key that are at or above its
+minimum decryption version, in order, with their creation times and whether
+they are enabled for encryption. It is lighter than reading the whole key.
+`
@@ -0,0 +1,188 @@
+package transit
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestTransit_KeyVersion(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	doNotFoundReq := func(path string) {
+		resp, err := b.HandleRequest(&logical.Request{
+			Storage:   storage,
+			Operation: logical.ReadOperation,
+			Path:      path,
+		})
+		if err != logical.ErrUnsupportedPath || resp == nil || !resp.IsError() {
+			t.Fatalf("%s: expected not found, got %#v (err: %v)", path, resp, err)
+		}
+	}
+
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/rsa", map[string]interface{}{
+		"type": "rsa-2048",
+	})
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/rsa/rotate", nil)
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/rsa/rotate", nil)
+
+	keyRing := mustHandle(t, b, storage, logical.ReadOperation, "keys/rsa", nil).Data["keys"].(map[string]map[string]interface{})
+	resp := mustHandle(t, b, storage, logical.ReadOperation, "keys/rsa/version/2", nil)
+	if resp.Data["version"] != 2 || resp.Data["algorithm"] != "rsa-2048" {
+		t.Fatalf("bad version data: %#v", resp.Data)
+	}
+	if resp.Data["public_key"] != keyRing["2"]["public_key"] || resp.Data["public_key"] == keyRing["1"]["public_key"] {
+		t.Fatalf("public key does not match version 2: %#v", resp.Data)
+	}
+	block, _ := pem.Decode([]byte(resp.Data["public_key"].(string)))
+	if block == nil {
+		t.Fatalf("bad PEM: %#v", resp.Data["public_key"])
+	}
+	if _, err := x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Data["creation_time"] == "" {
+		t.Fatalf("missing creation time: %#v", resp.Data)
+	}
+
+	// Symmetric keys have no public key
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/aes", nil)
+	resp = mustHandle(t, b, storage, logical.ReadOperation, "keys/aes/version/1", nil)
+	if _, ok := resp.Data["public_key"]; ok || resp.Data["algorithm"] != "aes256-gcm96" {
+		t.Fatalf("bad symmetric version data: %#v", resp.Data)
+	}
+
+	// Derived ed25519 keys have a public key per context, so one is required
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/derived", map[string]interface{}{
+		"type":    "ed25519",
+		"derived": true,
+	})
+	resp, err := b.HandleRequest(&logical.Request{
+		Storage:   storage,
+		Operation: logical.ReadOperation,
+		Path:      "keys/derived/version/1",
+	})
+	if err != logical.ErrInvalidRequest || resp == nil || !strings.Contains(resp.Data["error"].(string), "requires a derivation context") {
+		t.Fatalf("expected derivation context error, got %#v (err: %v)", resp, err)
+	}
+	resp = mustHandle(t, b, storage, logical.ReadOperation, "keys/derived/version/1", map[string]interface{}{
+		"context": "dGVzdGNvbnRleHQ=",
+	})
+	if resp.Data["public_key"] == "" || resp.Data["public_key"] == nil {
+		t.Fatalf("expected derived public key: %#v", resp.Data)
+	}
+
+	// Archived versions are not found
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/rsa/config", map[string]interface{}{
+		"min_decryption_version": 2,
+	})
+	doNotFoundReq("keys/rsa/version/1")
+	mustHandle(t, b, storage, logical.ReadOperation, "keys/rsa/version/2", nil)
+
+	doNotFoundReq("keys/rsa/version/4")
+	doNotFoundReq("keys/missing/version/1")
+}
+
+func TestTransit_KeyVersions(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	listVersions := func() ([]int, []bool) {
+		resp := mustHandle(t, b, storage, logical.ReadOperation, "keys/foo/versions", nil)
+		var versions []int
+		var enabled []bool
+		for _, entry := range resp.Data["versions"].([]map[string]interface{}) {
+			if _, err := time.Parse(time.RFC3339, entry["creation_time"].(string)); err != nil {
+				t.Fatalf("bad creation time: %#v", entry)
+			}
+			versions = append(versions, entry["version"].(int))
+			enabled = append(enabled, entry["enabled"].(bool))
+		}
+		return versions, enabled
+	}
+
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo", nil)
+	for i := 0; i < 4; i++ {
+		mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo/rotate", nil)
+	}
+	if versions, _ := listVersions(); !reflect.DeepEqual(versions, []int{1, 2, 3, 4, 5}) {
+		t.Fatalf("bad versions: %v", versions)
+	}
+
+	// Versions below the min decryption version and trimmed versions are
+	// left out
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo/config", map[string]interface{}{
+		"min_decryption_version":       3,
+		"disabled_encryption_versions": "4",
+	})
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo/trim", map[string]interface{}{
+		"min_available_version": 2,
+	})
+	versions, enabled := listVersions()
+	if !reflect.DeepEqual(versions, []int{3, 4, 5}) || !reflect.DeepEqual(enabled, []bool{true, false, true}) {
+		t.Fatalf("bad versions: %v, enabled: %v", versions, enabled)
+	}
+
+	resp, err := b.HandleRequest(&logical.Request{
+		Storage:   storage,
+		Operation: logical.ReadOperation,
+		Path:      "keys/missing/versions",
+	})
+	if err != logical.ErrUnsupportedPath || resp == nil || !resp.IsError() {
+		t.Fatalf("expected not found, got %#v (err: %v)", resp, err)
+	}
+}
+
+func TestTransit_DeleteKeyVersion(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo", nil)
+	for i := 0; i < 3; i++ {
+		mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo/rotate", nil)
+	}
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo/config", map[string]interface{}{
+		"min_decryption_version": 3,
+	})
+
+	// Versions that can still be used, the latest version and missing
+	// versions cannot be deleted
+	mustFail(t, b, storage, logical.DeleteOperation, "keys/foo/version/3", nil)
+	mustFail(t, b, storage, logical.DeleteOperation, "keys/foo/version/4", nil)
+	mustFail(t, b, storage, logical.DeleteOperation, "keys/foo/version/5", nil)
+
+	mustHandle(t, b, storage, logical.DeleteOperation, "keys/foo/version/2", nil)
+	mustFail(t, b, storage, logical.DeleteOperation, "keys/foo/version/2", nil)
+
+	p, lock, err := b.lm.GetPolicyShared(storage, "foo")
+	if err != nil || p == nil {
+		t.Fatalf("failed to load key: %v", err)
+	}
+	archive, err := p.LoadArchive(storage)
+	lock.RUnlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if archive.Keys[2].Key != nil || archive.Keys[2].HMACKey != nil {
+		t.Fatalf("key material of version 2 was not deleted: %#v", archive.Keys[2])
+	}
+	for _, ver := range []int{1, 3, 4} {
+		if archive.Keys[ver].Key == nil {
+			t.Fatalf("key material of version %d was deleted", ver)
+		}
+	}
+	if !reflect.DeepEqual(p.DeletedVersions, []int{2}) {
+		t.Fatalf("bad deleted versions: %v", p.DeletedVersions)
+	}
+
+	// The deleted version cannot be made usable again
+	mustFail(t, b, storage, logical.UpdateOperation, "keys/foo/config", map[string]interface{}{
+		"min_decryption_version": 2,
+	})
+	mustFail(t, b, storage, logical.UpdateOperation, "keys/foo/config", map[string]interface{}{
+		"min_decryption_version": 1,
+	})
+}
@@ -1,17 +1,22 @@
 package transit
 
 import (
-	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
 	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"encoding/pem"
 	"fmt"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
-
-	"golang.org/x/crypto/ed25519"
+	"unicode"
 
 	"github.com/fatih/structs"
+	"github.com/hashicorp/vault/helper/errutil"
 	"github.com/hashicorp/vault/helper/keysutil"
 	"github.com/hashicorp/vault/logical"
 	"github.com/hashicorp/vault/logical/framework"
@@ -21,12 +26,56 @@ func (b *backend) pathListKeys() *framework.Path {
 	return &framework.Path{
 		Pattern: "keys/?$",
 
+		Fields: map[string]*framework.FieldSchema{
+			"detailed": &framework.FieldSchema{
+				Type: framework.TypeBool,
+				Description: `If set, the response includes the type, latest
+version, minimum decryption and encryption
+versions, and derived and exportable settings of
+each key.`,
+			},
+
+			"limit": &framework.FieldSchema{
+				Type: framework.TypeInt,
+				Description: `The maximum number of keys to return. If not
+set, all keys are returned.`,
+			},
+
+			"after": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `If set, only keys whose names sort after this
+value are returned. Use the next value of a
+previous response to fetch the following page.`,
+			},
+
+			"tags": &framework.FieldSchema{
+				Type: framework.TypeKVPairs,
+				Description: `If set, only keys having all of the given tags
+with the given values are returned.`,
+			},
+
+			"exportable": &framework.FieldSchema{
+				Type: framework.TypeBool,
+				Description: `If set, only keys whose exportable setting
+matches this value are returned. Requires detailed
+to be set.`,
+			},
+
+			"derived": &framework.FieldSchema{
+				Type: framework.TypeBool,
+				Description: `If set, only keys whose derived setting matches
+this value are returned. Requires detailed to be
+set.`,
+			},
+		},
+
 		Callbacks: map[logical.Operation]framework.OperationFunc{
-			logical.ListOperation: b.pathKeysList,
+			logical.ListOperation:   b.pathKeysList,
+			logical.UpdateOperation: b.pathKeysBatchWrite,
 		},
 
-		HelpSynopsis:    pathPolicyHelpSyn,
-		HelpDescription: pathPolicyHelpDesc,
+		HelpSynopsis:    pathListKeysHelpSyn,
+		HelpDescription: pathListKeysHelpDesc,
 	}
 }
 
@@ -43,9 +92,12 @@ func (b *backend) pathKeys() *framework.Path {
 				Type:    framework.TypeString,
 				Default: "aes256-gcm96",
 				Description: `
-The type of key to create. Currently, "aes256-gcm96" (symmetric), "ecdsa-p256"
-(asymmetric), 'ed25519' (asymmetric), 'rsa-2048' (asymmetric), 'rsa-4096'
-(asymmetric) are supported.  Defaults to "aes256-gcm96".
+The type of key to create. Currently, "aes128-gcm96" (symmetric),
+"aes256-gcm96" (symmetric), "chacha20-poly1305" (symmetric), "ecdsa-p256"
+(asymmetric), "ecdsa-p384" (asymmetric), "ecdsa-p521" (asymmetric), 'ed25519'
+(asymmetric), 'ed448' (asymmetric), 'rsa-2048' (asymmetric), 'rsa-3072'
+(asymmetric), 'rsa-4096' (asymmetric) are supported. Defaults to the default_key_type set in
+config/keys, or "aes256-gcm96" if none is set.
 `,
 			},
 
@@ -72,6 +124,16 @@ given context. Failing to do so will severely
 impact the ciphertext's security.`,
 			},
 
+			"convergent_version": &framework.FieldSchema{
+				Type: framework.TypeInt,
+				Description: `The convergent encryption scheme to create the
+key with, for compatibility with older versions
+of Vault. Version 1 requires a nonce to be
+supplied with every encryption request. Only
+valid with convergent encryption. Defaults to 0,
+which selects the latest version.`,
+			},
+
 			"exportable": &framework.FieldSchema{
 				Type: framework.TypeBool,
 				Description: `Enables keys to be exportable.
@@ -79,12 +141,152 @@ This allows for all the valid keys
 in the key ring to be exported.`,
 			},
 
+			"allow_export_versions": &framework.FieldSchema{
+				Type: framework.TypeCommaStringSlice,
+				Description: `A list of key versions that may be
+exported even if the key is not exportable.
+At creation only version 1 exists; further
+versions can be allowed via the config
+endpoint.`,
+			},
+
+			"allow_plaintext_backup": &framework.FieldSchema{
+				Type: framework.TypeBool,
+				Description: `Enables taking a backup of the named key in
+plaintext format. The key must also be
+exportable. Can be disabled again via the
+config endpoint.`,
+			},
+
+			"auto_rotate_period": &framework.FieldSchema{
+				Type:    framework.TypeDurationSecond,
+				Default: 0,
+				Description: `Amount of time the key should live before
+being automatically rotated. A value of 0
+(default) disables automatic rotation for the
+key. Must be at least one hour if set.`,
+			},
+
+			"max_versions": &framework.FieldSchema{
+				Type: framework.TypeInt,
+				Description: `The maximum number of key versions to keep. On
+rotation, the oldest versions are trimmed once
+they are below min_decryption_version. A value
+of 0 (default) keeps all versions.`,
+			},
+
+			"entropy_source": &framework.FieldSchema{
+				Type:    framework.TypeString,
+				Default: keysutil.EntropySourcePlatform,
+				Description: `The source of randomness to generate the key
+material from, both on creation and on rotation:
+"platform" for the operating system's random
+number generator or "seal" for entropy
+augmentation from the seal, if supported by this
+build. Defaults to "platform".`,
+			},
+
+			"idempotency_token": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `An arbitrary token identifying the request. If a
+creation of the same key with the same token
+succeeded in the last 10 minutes, its result is
+returned again instead of processing the request.
+Reusing a token with different parameters is
+rejected.`,
+			},
+
+			"num_versions": &framework.FieldSchema{
+				Type:    framework.TypeInt,
+				Default: 1,
+				Description: `The number of versions to create the key with, so
+that its latest version matches that of a key
+elsewhere. Only
+used when the key is created. Defaults to 1, and
+cannot be more than 100.`,
+			},
+
+			"show_public_key": &framework.FieldSchema{
+				Type:    framework.TypeBool,
+				Default: true,
+				Description: `When reading an asymmetric key, whether to
+include the public key of each version. Defaults
+to true.`,
+			},
+
+			"show_archive_bytes": &framework.FieldSchema{
+				Type: framework.TypeBool,
+				Description: `When reading a key, whether to include the
+approximate size in bytes of its serialized key
+versions. Defaults to false, as computing it
+requires serializing the keys.`,
+			},
+
+			"rotation_history": &framework.FieldSchema{
+				Type: framework.TypeBool,
+				Description: `When reading a key, if set, the response also
+includes the version and creation time of each
+live version of the key, in order.`,
+			},
+
+			"api_version": &framework.FieldSchema{
+				Type:    framework.TypeInt,
+				Default: keyReadAPIVersionLegacy,
+				Description: `When reading a key, the format of the response:
+1 for the flat format, or 2 to group the
+information about each version of the key under
+versions. Defaults to 1.`,
+			},
+
+			"attest": &framework.FieldSchema{
+				Type: framework.TypeBool,
+				Description: `When reading a key, if set, the response also
+includes an attestation of the key's information
+signed by the backend's attestation key, whose
+public key can be read from attestation_key.`,
+			},
+
+			"etag": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `When reading a key, the etag returned by a
+previous read. If the key's versions have not
+changed since, only a compact response with
+not_modified set is returned.`,
+			},
+
+			"allowed_operations": &framework.FieldSchema{
+				Type: framework.TypeCommaStringSlice,
+				Description: `A list of the operations the key may be used
+for, out of "encrypt", "decrypt", "sign" and
+"verify". If not set, every operation supported
+by the key type is allowed.`,
+			},
+
+			"tags": &framework.FieldSchema{
+				Type: framework.TypeKVPairs,
+				Description: `Key/value tags to attach to the key, such as
+team=payments. Tags are informational only and
+can be changed later via the config path.`,
+			},
+
+			"description": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `A free-text description of the key's purpose,
+of at most 1024 bytes. It is informational only
+and can be changed later via the config path.`,
+			},
+
 			"context": &framework.FieldSchema{
 				Type: framework.TypeString,
 				Description: `Base64 encoded context for key derivation.
 When reading a key with key derivation enabled,
 if the key type supports public keys, this will
-return the public key for the given context.`,
+return the public key for the given context. For
+symmetric keys, a fingerprint of each derived
+key version is returned instead, which can be
+used to validate the context without exposing
+the derived key. Standard and URL-safe base64 are
+both accepted, with or without padding.`,
 			},
 		},
 
@@ -106,41 +308,294 @@ func (b *backend) pathKeysList(
 		return nil, err
 	}
 
-	return logical.ListResponse(entries), nil
+	// The order of listed entries depends on the storage backend, so names
+	// are always returned sorted
+	sort.Strings(entries)
+
+	limit := d.Get("limit").(int)
+	if limit < 0 {
+		return logical.ErrorResponse("limit must not be negative"), logical.ErrInvalidRequest
+	}
+
+	detailed := d.Get("detailed").(bool)
+
+	// Filtering on the settings of keys loads every key, so it is only
+	// available along with the detailed information that does the same
+	var filters []func(*keysutil.Policy) bool
+	if tags := d.Get("tags").(map[string]string); len(tags) != 0 {
+		filters = append(filters, func(p *keysutil.Policy) bool {
+			for k, v := range tags {
+				if tag, ok := p.Tags[k]; !ok || tag != v {
+					return false
+				}
+			}
+			return true
+		})
+	}
+	if exportableRaw, ok := d.GetOk("exportable"); ok {
+		if !detailed {
+			return logical.ErrorResponse("the exportable filter requires detailed to be set"), logical.ErrInvalidRequest
+		}
+		exportable := exportableRaw.(bool)
+		filters = append(filters, func(p *keysutil.Policy) bool {
+			return p.KeyExportable() == exportable
+		})
+	}
+	if derivedRaw, ok := d.GetOk("derived"); ok {
+		if !detailed {
+			return logical.ErrorResponse("the derived filter requires detailed to be set"), logical.ErrInvalidRequest
+		}
+		derived := derivedRaw.(bool)
+		filters = append(filters, func(p *keysutil.Policy) bool {
+			return p.Derived == derived
+		})
+	}
+
+	// Keys that cannot be loaded are left out and reported separately, so
+	// that a single corrupt entry does not hide all of the other keys
+	var loadErrors []map[string]interface{}
+
+	// Filter before paging so that pages are filled with matching keys
+	if len(filters) != 0 {
+		entries, loadErrors = b.keysMatching(req.Storage, entries, filters)
+	}
+
+	// Page through the sorted names if requested
+	var next string
+	if after := d.Get("after").(string); after != "" || limit > 0 {
+		start := sort.SearchStrings(entries, after)
+		if start < len(entries) && entries[start] == after {
+			start++
+		}
+		entries = entries[start:]
+		if limit > 0 && len(entries) > limit {
+			entries = entries[:limit]
+			next = entries[limit-1]
+		}
+	}
+
+	var resp *logical.Response
+	if !detailed {
+		resp = logical.ListResponse(entries)
+	} else {
+		var detailedErrors []map[string]interface{}
+		resp, detailedErrors = b.keysListDetailed(req.Storage, entries)
+		loadErrors = append(loadErrors, detailedErrors...)
+	}
+	if len(loadErrors) != 0 {
+		resp.Data["errors"] = loadErrors
+	}
+	if next != "" {
+		resp.Data["next"] = next
+	}
+	return resp, nil
+}
+
+// keyLoadError describes a key that could not be loaded while listing keys
+func keyLoadError(name string, err error) map[string]interface{} {
+	return map[string]interface{}{
+		"name":  name,
+		"error": err.Error(),
+	}
+}
+
+// keysMatching returns the names of the keys matching all of the given
+// filters, along with the keys that could not be loaded
+func (b *backend) keysMatching(storage logical.Storage, entries []string, filters []func(*keysutil.Policy) bool) ([]string, []map[string]interface{}) {
+	var matching []string
+	var loadErrors []map[string]interface{}
+	for _, name := range entries {
+		p, lock, err := b.lm.GetPolicyShared(storage, name)
+		if err != nil {
+			loadErrors = append(loadErrors, keyLoadError(name, err))
+			continue
+		}
+		if p == nil {
+			continue
+		}
+		matches := true
+		for _, filter := range filters {
+			if !filter(p) {
+				matches = false
+				break
+			}
+		}
+		lock.RUnlock()
+		if matches {
+			matching = append(matching, name)
+		}
+	}
+	return matching, loadErrors
+}
+
+// keysListDetailed returns a list response including information about
+// each of the named keys that can be loaded, along with the keys that could
+// not be loaded
+func (b *backend) keysListDetailed(storage logical.Storage, entries []string) (*logical.Response, []map[string]interface{}) {
+	var loaded []string
+	var loadErrors []map[string]interface{}
+	keyInfo := make(map[string]interface{}, len(entries))
+	for _, name := range entries {
+		p, lock, err := b.lm.GetPolicyShared(storage, name)
+		if err != nil {
+			loadErrors = append(loadErrors, keyLoadError(name, err))
+			continue
+		}
+		if p == nil {
+			// The key was deleted after the list was taken
+			continue
+		}
+
+		keyInfo[name] = map[string]interface{}{
+			"type":                   p.Type.String(),
+			"latest_version":         p.LatestVersion,
+			"min_decryption_version": p.MinDecryptionVersion,
+			"min_encryption_version": p.MinEncryptionVersion,
+			"derived":                p.Derived,
+			"exportable":             p.KeyExportable(),
+			"tags":                   keyTags(p),
+			"fips_compliant":         p.FIPSCompliant(),
+		}
+		lock.RUnlock()
+		loaded = append(loaded, name)
+	}
+
+	return logical.ListResponseWithInfo(loaded, keyInfo), loadErrors
 }
 
 func (b *backend) pathPolicyWrite(
 	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	// A retried creation returns the result of the original one, rather
+	// than warning that the key it created already exists
+	name := d.Get("name").(string)
+	token := d.Get("idempotency_token").(string)
+	var paramsHash string
+	if token != "" {
+		var err error
+		paramsHash, err = idempotencyParamsHash(d)
+		if err != nil {
+			return nil, err
+		}
+		result, err := b.idempotency.get(name, token, paramsHash)
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), logical.ErrConflict
+		}
+		if result != nil {
+			return result.response(), nil
+		}
+	}
+
+	upserted, resp, err := b.upsertPolicy(req, d)
+	if token != "" && err == nil && (resp == nil || !resp.IsError()) {
+		b.idempotency.put(name, token, paramsHash, upserted, resp)
+	}
+	return resp, err
+}
+
+// upsertPolicy creates the key described by the fields of a key write unless
+// it already exists, returning whether it was created along with the response
+// for the write
+func (b *backend) upsertPolicy(req *logical.Request, d *framework.FieldData) (bool, *logical.Response, error) {
+	storage := req.Storage
 	name := d.Get("name").(string)
+	if err := validateKeyName(name); err != nil {
+		return false, logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
 	derived := d.Get("derived").(bool)
 	convergent := d.Get("convergent_encryption").(bool)
 	keyType := d.Get("type").(string)
 	exportable := d.Get("exportable").(bool)
+	allowPlaintextBackup := d.Get("allow_plaintext_backup").(bool)
+	autoRotatePeriod := time.Second * time.Duration(d.Get("auto_rotate_period").(int))
+	maxVersions := d.Get("max_versions").(int)
+	numVersions := d.Get("num_versions").(int)
+
+	config, err := b.readKeysConfig(storage)
+	if err != nil {
+		return false, nil, err
+	}
+
+	// Keys created without an explicit type use the backend's default type,
+	// if one is configured
+	_, typeRequested := d.GetOk("type")
+	if !typeRequested || keyType == "" {
+		typeRequested = false
+		keyType = config.defaultKeyType()
+	}
 
 	if !derived && convergent {
-		return logical.ErrorResponse("convergent encryption requires derivation to be enabled"), nil
+		return false, logical.ErrorResponse("convergent encryption requires derivation to be enabled, so a context must be supplied with every encryption and decryption request"), logical.ErrInvalidRequest
+	}
+
+	convergentVersion := d.Get("convergent_version").(int)
+	if convergentVersion != 0 && !convergent {
+		return false, logical.ErrorResponse("convergent version is only valid with convergent encryption enabled"), logical.ErrInvalidRequest
+	}
+	if convergentVersion < 0 || convergentVersion > keysutil.LatestConvergentVersion {
+		return false, logical.ErrorResponse(fmt.Sprintf("unsupported convergent version %d; supported versions are 1 to %d", convergentVersion, keysutil.LatestConvergentVersion)), logical.ErrInvalidRequest
+	}
+
+	if autoRotatePeriod != 0 && autoRotatePeriod < time.Hour {
+		return false, logical.ErrorResponse("auto rotate period must be 0 to disable or at least an hour"), logical.ErrInvalidRequest
+	}
+
+	if maxVersions < 0 {
+		return false, logical.ErrorResponse("max versions must be 0 to disable or positive"), logical.ErrInvalidRequest
+	}
+
+	if numVersions < 1 || numVersions > keysutil.MaxInitialVersions {
+		return false, logical.ErrorResponse(fmt.Sprintf("num versions must be between 1 and %d", keysutil.MaxInitialVersions)), logical.ErrInvalidRequest
+	}
+
+	allowExportVersions, err := parseKeyVersions(d.Get("allow_export_versions").([]string))
+	if err != nil {
+		return false, logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+	for _, ver := range allowExportVersions {
+		if ver > numVersions {
+			return false, logical.ErrorResponse(fmt.Sprintf("cannot allow export of version %d; a new key only has versions up to %d", ver, numVersions)), logical.ErrInvalidRequest
+		}
 	}
 
 	polReq := keysutil.PolicyRequest{
-		Storage:    req.Storage,
-		Name:       name,
-		Derived:    derived,
-		Convergent: convergent,
-		Exportable: exportable,
-	}
-	switch keyType {
-	case "aes256-gcm96":
-		polReq.KeyType = keysutil.KeyType_AES256_GCM96
-	case "ecdsa-p256":
-		polReq.KeyType = keysutil.KeyType_ECDSA_P256
-	case "ed25519":
-		polReq.KeyType = keysutil.KeyType_ED25519
-	case "rsa-2048":
-		polReq.KeyType = keysutil.KeyType_RSA2048
-	case "rsa-4096":
-		polReq.KeyType = keysutil.KeyType_RSA4096
-	default:
-		return logical.ErrorResponse(fmt.Sprintf("unknown key type %v", keyType)), logical.ErrInvalidRequest
+		Storage:               storage,
+		Name:                  name,
+		Derived:               derived,
+		Convergent:            convergent,
+		ConvergentVersion:     convergentVersion,
+		Exportable:            exportable,
+		AllowedExportVersions: allowExportVersions,
+		AllowPlaintextBackup:  allowPlaintextBackup,
+		AutoRotatePeriod:      autoRotatePeriod,
+		MaxVersions:           maxVersions,
+		NumVersions:           numVersions,
+		EntropySource:         d.Get("entropy_source").(string),
+		Tags:                  d.Get("tags").(map[string]string),
+		Description:           d.Get("description").(string),
+		CreatedBy:             requestIdentity(req),
+	}
+	if err := validateTags(polReq.Tags); err != nil {
+		return false, logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+	if err := validateDescription(polReq.Description); err != nil {
+		return false, logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+	var ok bool
+	polReq.KeyType, ok = keysutil.ParseKeyType(keyType)
+	if !ok {
+		return false, logical.ErrorResponse(fmt.Sprintf("unknown key type %v", keyType)), logical.ErrInvalidRequest
+	}
+
+	polReq.AllowedOperations, err = parseAllowedOperations(polReq.KeyType, d.Get("allowed_operations").([]string))
+	if err != nil {
+		return false, logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+
+	if derived && !polReq.KeyType.DerivationSupported() {
+		return false, logical.ErrorResponse(fmt.Sprintf("key derivation is not supported for keys of type %v; only keys of type %s can be derived", keyType, strings.Join(derivableKeyTypes(), ", "))), logical.ErrInvalidRequest
+	}
+	if convergent && !polReq.KeyType.EncryptionSupported() {
+		return false, logical.ErrorResponse(fmt.Sprintf("convergent encryption is not supported for keys of type %v", keyType)), logical.ErrInvalidRequest
 	}
 
 	p, lock, upserted, err := b.lm.GetPolicyUpsert(polReq)
@@ -148,20 +603,60 @@ func (b *backend) pathPolicyWrite(
 		defer lock.RUnlock()
 	}
 	if err != nil {
-		return nil, err
+		switch err.(type) {
+		case errutil.UserError:
+			return false, logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		default:
+			return false, nil, err
+		}
 	}
 	if p == nil {
-		return nil, fmt.Errorf("error generating key: returned policy was nil")
+		return false, nil, fmt.Errorf("error generating key: returned policy was nil")
+	}
+
+	// The type of an existing key cannot be changed; only report an error if
+	// a type was explicitly requested, since it otherwise defaults
+	if typeRequested && !upserted && p.Type != polReq.KeyType {
+		return false, logical.ErrorResponse(fmt.Sprintf("key %s already exists with type %v; the type of a key cannot be changed", name, p.Type)), logical.ErrInvalidRequest
+	}
+	if convergentVersion != 0 && !upserted && p.ConvergentEncryption && p.ConvergentVersion != convergentVersion {
+		return false, logical.ErrorResponse(fmt.Sprintf("key %s already exists with convergent version %d; use the convergent upgrade endpoint to change it", name, p.ConvergentVersion)), logical.ErrInvalidRequest
 	}
 
 	resp := &logical.Response{}
 	if !upserted {
 		resp.AddWarning(fmt.Sprintf("key %s already existed", name))
+	} else if p.ConvergentEncryption {
+		resp.AddWarning("convergent encryption is enabled: a context must be supplied with every request, and all nonce values used with a given context value must be unique or the security of the key will be compromised")
+		if !p.AllowPlaintextBackup {
+			resp.AddWarning("plaintext backups are not allowed for this key: if it is lost, the ciphertexts it produced can never be reproduced; consider setting allow_plaintext_backup to keep a backup of the key")
+		}
 	}
 
-	return nil, nil
+	// Deprecated types are only discouraged, never rejected
+	if upserted {
+		if warning := config.deprecationWarning(keyType); warning != "" {
+			resp.AddWarning(warning)
+		}
+	}
+
+	if len(resp.Warnings) == 0 {
+		return upserted, nil, nil
+	}
+
+	return upserted, resp, nil
 }
 
+const (
+	// keyReadAPIVersionLegacy selects the flat format of key reads, with
+	// separate maps of version information
+	keyReadAPIVersionLegacy = 1
+
+	// keyReadAPIVersionNested selects the format of key reads grouping the
+	// information about each version under versions
+	keyReadAPIVersionNested = 2
+)
+
 // Built-in helper type for returning asymmetric keys
 type asymKey struct {
 	Name         string    `json:"name" structs:"name" mapstructure:"name"`
@@ -172,6 +667,10 @@ type asymKey struct {
 func (b *backend) pathPolicyRead(
 	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
 	name := d.Get("name").(string)
+	apiVersion := d.Get("api_version").(int)
+	if apiVersion != keyReadAPIVersionLegacy && apiVersion != keyReadAPIVersionNested {
+		return logical.ErrorResponse(fmt.Sprintf("unsupported api version %d; supported versions are %d and %d", apiVersion, keyReadAPIVersionLegacy, keyReadAPIVersionNested)), logical.ErrInvalidRequest
+	}
 
 	p, lock, err := b.lm.GetPolicyShared(req.Storage, name)
 	if lock != nil {
@@ -180,28 +679,110 @@ func (b *backend) pathPolicyRead(
 	if err != nil {
 		return nil, err
 	}
+	// Missing keys are not found, while keys that exist but cannot be read
+	// are reported as invalid requests, so that clients provisioning keys can
+	// tell whether a key still needs to be created
 	if p == nil {
 		return nil, nil
 	}
+	if !p.Type.Known() {
+		return logical.ErrorResponse(fmt.Sprintf("key %s has type %d, which is not supported by this version of Vault; the key may have been created by a newer version", p.Name, int(p.Type))), logical.ErrInvalidRequest
+	}
+
+	// Clients polling for new versions can skip the full response when the
+	// versions have not changed since their last read. Attestations always
+	// cover the full response.
+	etag := policyETag(p)
+	attest := d.Get("attest").(bool)
+	if !attest && d.Get("etag").(string) == etag {
+		return &logical.Response{
+			Data: map[string]interface{}{
+				"name":                   p.Name,
+				"etag":                   etag,
+				"not_modified":           true,
+				"latest_version":         p.LatestVersion,
+				"min_decryption_version": p.MinDecryptionVersion,
+				"min_encryption_version": p.MinEncryptionVersion,
+				"min_available_version":  p.MinAvailableVersion,
+			},
+		}, nil
+	}
 
 	// Return the response
 	resp := &logical.Response{
 		Data: map[string]interface{}{
-			"name":                   p.Name,
-			"type":                   p.Type.String(),
-			"derived":                p.Derived,
-			"deletion_allowed":       p.DeletionAllowed,
-			"min_decryption_version": p.MinDecryptionVersion,
-			"min_encryption_version": p.MinEncryptionVersion,
-			"latest_version":         p.LatestVersion,
-			"exportable":             p.Exportable,
-			"supports_encryption":    p.Type.EncryptionSupported(),
-			"supports_decryption":    p.Type.DecryptionSupported(),
-			"supports_signing":       p.Type.SigningSupported(),
-			"supports_derivation":    p.Type.DerivationSupported(),
+			"name":                         p.Name,
+			"fingerprint":                  p.Fingerprint,
+			"type":                         p.Type.String(),
+			"derived":                      p.Derived,
+			"deletion_allowed":             p.DeletionAllowed,
+			"min_decryption_version":       p.MinDecryptionVersion,
+			"min_encryption_version":       p.MinEncryptionVersion,
+			"min_available_version":        p.MinAvailableVersion,
+			"latest_version":               p.LatestVersion,
+			"disabled_encryption_versions": disabledEncryptionVersions(p),
+			"disabled_decryption_versions": disabledDecryptionVersions(p),
+			"exportable":                   p.KeyExportable(),
+			"export_revoked":               p.ExportRevoked,
+			"allow_plaintext_backup":       p.AllowPlaintextBackup,
+			"imported":                     p.Imported,
+			"supports_encryption":          p.Type.EncryptionSupported(),
+			"supports_decryption":          p.Type.DecryptionSupported(),
+			"supports_signing":             p.Type.SigningSupported(),
+			"supports_derivation":          p.Type.DerivationSupported(),
+			"auto_rotate_period":           int64(p.AutoRotatePeriod.Seconds()),
+			"allowed_operations":           allowedOperations(p),
+			"max_versions":                 p.MaxVersions,
+			"enabled":                      !p.Disabled,
+			"operation_rate_limit":         p.OperationRateLimit,
+			"entropy_source":               entropySource(p),
+			"managed":                      p.ManagedKeyName != "",
+			"tags":                         keyTags(p),
+			"description":                  p.Description,
+			"fips_compliant":               p.FIPSCompliant(),
+			"version_count":                len(p.Keys),
+			"etag":                         etag,
+			"not_modified":                 false,
 		},
 	}
 
+	if allowed := p.Type.AllowedSignatureHashes(); allowed != nil {
+		resp.Data["allowed_signature_hashes"] = allowed
+		resp.Data["recommended_signature_hash"] = p.Type.DefaultHashAlgorithm()
+	}
+
+	resp.Data["last_min_decryption_change_reason"] = p.MinDecryptionChangeReason
+	resp.Data["created_by"] = p.CreatedBy
+
+	// Whether rotation is allowed is only configurable for imported keys
+	if p.Imported {
+		resp.Data["imported_key_allow_rotation"] = p.AllowImportedKeyRotation
+	}
+	if p.ManagedKeyName != "" {
+		resp.Data["managed_key_name"] = p.ManagedKeyName
+	}
+
+	exportableVersions := []int{}
+	for ver := range p.Keys {
+		if p.VersionExportable(ver) {
+			exportableVersions = append(exportableVersions, ver)
+		}
+	}
+	sort.Ints(exportableVersions)
+	resp.Data["exportable_versions"] = exportableVersions
+
+	if nextRotation := p.NextRotationTime(); !nextRotation.IsZero() {
+		resp.Data["next_rotation"] = nextRotation.UTC().Format(time.RFC3339)
+	}
+
+	if d.Get("show_archive_bytes").(bool) {
+		serialized, err := json.Marshal(p.Keys)
+		if err != nil {
+			return nil, fmt.Errorf("failed to serialize key versions: %v", err)
+		}
+		resp.Data["archive_bytes"] = len(serialized)
+	}
+
 	if p.Derived {
 		switch p.KDF {
 		case keysutil.Kdf_hmac_sha256_counter:
@@ -213,27 +794,87 @@ func (b *backend) pathPolicyRead(
 		resp.Data["convergent_encryption"] = p.ConvergentEncryption
 		if p.ConvergentEncryption {
 			resp.Data["convergent_encryption_version"] = p.ConvergentVersion
+			resp.Data["convergent_version"] = p.ConvergentVersion
+			resp.Data["target_convergent_version"] = keysutil.LatestConvergentVersion
+			resp.Data["requires_nonce"] = p.RequiresNonce()
+			resp.Data["nonce_size"] = p.Type.NonceSize()
 		}
 	}
 
+	if p.Disabled {
+		resp.AddWarning(fmt.Sprintf("key %s is disabled and cannot be used for operations until it is enabled again", p.Name))
+	}
+
 	contextRaw := d.Get("context").(string)
 	var context []byte
 	if len(contextRaw) != 0 {
-		context, err = base64.StdEncoding.DecodeString(contextRaw)
+		context, err = decodeContext(contextRaw)
 		if err != nil {
 			return logical.ErrorResponse("failed to base64-decode context"), logical.ErrInvalidRequest
 		}
 	}
 
+	// Values of a derived key depend on the context, so make it clear that
+	// they are left out rather than returning values that do not match any
+	// key actually used for operations
+	derivedWithoutContext := p.Derived && len(context) == 0
+	_, showPublicKeySet := d.GetOk("show_public_key")
+	if showPublicKeySet && d.Get("show_public_key").(bool) && derivedWithoutContext && p.Type.EdDSA() {
+		// The public key was asked for explicitly, and there is none to
+		// return without a context. The default of show_public_key is not
+		// an explicit request, so it only results in the warning below.
+		return logical.ErrorResponse("this key requires a derivation context to return its public key"), logical.ErrInvalidRequest
+	}
+	if derivedWithoutContext {
+		resp.AddWarning("this key requires a derivation context; provide one to include values of the derived key in the response")
+	}
+	if p.Derived {
+		warning, err := b.shortContextWarning(req.Storage, context)
+		if err != nil {
+			return nil, err
+		}
+		if warning != "" {
+			resp.AddWarning(warning)
+		}
+	}
+
+	// All versions of a key currently share its type, but the algorithm is
+	// reported per version so that clients do not have to assume this
+	creationTimes := map[string]string{}
+	versionAlgorithms := map[string]string{}
+	for _, version := range keyVersions(p, 0) {
+		creationTimes[strconv.Itoa(version.version)] = version.creationTime
+		versionAlgorithms[strconv.Itoa(version.version)] = p.Type.String()
+	}
+	resp.Data["creation_times"] = creationTimes
+	resp.Data["version_algorithms"] = versionAlgorithms
+	if d.Get("rotation_history").(bool) {
+		resp.Data["rotation_history"] = rotationHistory(p)
+	}
+
 	switch p.Type {
-	case keysutil.KeyType_AES256_GCM96:
+	case keysutil.KeyType_AES128_GCM96, keysutil.KeyType_AES256_GCM96, keysutil.KeyType_ChaCha20_Poly1305:
 		retKeys := map[string]int64{}
 		for k, v := range p.Keys {
 			retKeys[strconv.Itoa(k)] = v.DeprecatedCreationTime
 		}
 		resp.Data["keys"] = retKeys
 
-	case keysutil.KeyType_ECDSA_P256, keysutil.KeyType_ED25519, keysutil.KeyType_RSA2048, keysutil.KeyType_RSA4096:
+		if p.Derived && len(context) != 0 {
+			fingerprints := map[string]string{}
+			for k := range p.Keys {
+				fingerprint, err := p.DerivedKeyFingerprint(context, k)
+				if err != nil {
+					return nil, fmt.Errorf("failed to compute derived key fingerprint: %v", err)
+				}
+				fingerprints[strconv.Itoa(k)] = fingerprint
+			}
+			resp.Data["derived_key_fingerprints"] = fingerprints
+		}
+
+	case keysutil.KeyType_ECDSA_P256, keysutil.KeyType_ECDSA_P384, keysutil.KeyType_ECDSA_P521, keysutil.KeyType_ED25519, keysutil.KeyType_ED448, keysutil.KeyType_RSA2048, keysutil.KeyType_RSA3072, keysutil.KeyType_RSA4096:
+		// Managed keys hold no key material in Vault to take public keys from
+		showPublicKey := d.Get("show_public_key").(bool) && p.ManagedKeyName == ""
 		retKeys := map[string]map[string]interface{}{}
 		for k, v := range p.Keys {
 			key := asymKey{
@@ -245,66 +886,368 @@ func (b *backend) pathPolicyRead(
 			}
 
 			switch p.Type {
-			case keysutil.KeyType_ECDSA_P256:
-				key.Name = elliptic.P256().Params().Name
-			case keysutil.KeyType_ED25519:
-				if p.Derived {
-					if len(context) == 0 {
-						key.PublicKey = ""
-					} else {
-						derived, err := p.DeriveKey(context, k)
-						if err != nil {
-							return nil, fmt.Errorf("failed to derive key to return public component")
+			case keysutil.KeyType_ECDSA_P256, keysutil.KeyType_ECDSA_P384, keysutil.KeyType_ECDSA_P521:
+				key.Name = p.Type.ECDSACurve().Params().Name
+			case keysutil.KeyType_ED25519, keysutil.KeyType_ED448:
+				if p.Derived && showPublicKey && !derivedWithoutContext {
+					derived, err := p.DeriveKey(context, k)
+					if err != nil {
+						switch err.(type) {
+						case errutil.UserError:
+							return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+						default:
+							return nil, fmt.Errorf("failed to derive key to return public component: %v", err)
 						}
-						pubKey := ed25519.PrivateKey(derived).Public().(ed25519.PublicKey)
-						key.PublicKey = base64.StdEncoding.EncodeToString(pubKey)
 					}
+					key.PublicKey = encodeEdDSAPublicKey(p.Type, derived)
 				}
-				key.Name = "ed25519"
-			case keysutil.KeyType_RSA2048, keysutil.KeyType_RSA4096:
-				key.Name = "rsa-2048"
-				if p.Type == keysutil.KeyType_RSA4096 {
-					key.Name = "rsa-4096"
+				key.Name = p.Type.String()
+			case keysutil.KeyType_RSA2048, keysutil.KeyType_RSA3072, keysutil.KeyType_RSA4096:
+				key.Name = p.Type.String()
+				if !showPublicKey {
+					break
 				}
 
-				// Encode the RSA public key in PEM format to return over the
-				// API
-				derBytes, err := x509.MarshalPKIXPublicKey(v.RSAKey.Public())
+				key.PublicKey, err = rsaPublicKeyPEM(v.RSAKey)
 				if err != nil {
-					return nil, fmt.Errorf("error marshaling RSA public key: %v", err)
-				}
-				pemBlock := &pem.Block{
-					Type:  "PUBLIC KEY",
-					Bytes: derBytes,
-				}
-				pemBytes := pem.EncodeToMemory(pemBlock)
-				if pemBytes == nil || len(pemBytes) == 0 {
-					return nil, fmt.Errorf("failed to PEM-encode RSA public key")
+					return nil, err
 				}
-				key.PublicKey = string(pemBytes)
 			}
 
 			retKeys[strconv.Itoa(k)] = structs.New(key).Map()
+			if !showPublicKey || (p.Type.EdDSA() && derivedWithoutContext) {
+				delete(retKeys[strconv.Itoa(k)], "public_key")
+			}
 		}
 		resp.Data["keys"] = retKeys
 	}
 
+	if apiVersion == keyReadAPIVersionNested {
+		nestKeyVersions(p, resp.Data)
+	}
+
+	if attest {
+		attestation, err := b.attestKeyRead(req.Storage, resp.Data)
+		if err != nil {
+			switch err.(type) {
+			case errutil.UserError:
+				return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+			default:
+				return nil, err
+			}
+		}
+		resp.Data["attestation"] = attestation
+	}
+
 	return resp, nil
 }
 
+// nestKeyVersions converts the data of a key read to the nested format, which
+// replaces the maps of version information by a single map holding the
+// information about each version
+func nestKeyVersions(p *keysutil.Policy, data map[string]interface{}) {
+	asymKeys, _ := data["keys"].(map[string]map[string]interface{})
+	fingerprints, _ := data["derived_key_fingerprints"].(map[string]string)
+
+	versions := make(map[string]map[string]interface{}, len(p.Keys))
+	for ver, entry := range p.Keys {
+		v := strconv.Itoa(ver)
+		version := map[string]interface{}{
+			"creation_time":       entryCreationTime(entry),
+			"algorithm":           p.Type.String(),
+			"exportable":          p.VersionExportable(ver),
+			"encryption_disabled": p.EncryptionVersionDisabled(ver),
+			"decryption_disabled": p.DecryptionVersionDisabled(ver),
+		}
+		if pubKey, ok := asymKeys[v]["public_key"]; ok {
+			version["public_key"] = pubKey
+		}
+		if fingerprint, ok := fingerprints[v]; ok {
+			version["derived_key_fingerprint"] = fingerprint
+		}
+		versions[v] = version
+	}
+
+	for _, field := range []string{"keys", "creation_times", "version_algorithms", "exportable_versions", "disabled_encryption_versions", "disabled_decryption_versions", "derived_key_fingerprints"} {
+		delete(data, field)
+	}
+	data["versions"] = versions
+	data["api_version"] = keyReadAPIVersionNested
+}
+
+// rotationHistory returns the version and creation time of every version of
+// the key at or above its minimum decryption version, in version order. Vault
+// does not record who rotated a key; that is found in the audit log.
+func rotationHistory(p *keysutil.Policy) []map[string]interface{} {
+	versions := keyVersions(p, p.MinDecryptionVersion)
+	history := make([]map[string]interface{}, 0, len(versions))
+	for _, version := range versions {
+		history = append(history, map[string]interface{}{
+			"version":       version.version,
+			"creation_time": version.creationTime,
+		})
+	}
+	return history
+}
+
 func (b *backend) pathPolicyDelete(
 	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
-	name := d.Get("name").(string)
+	return b.deletePolicy(req.Storage, d.Get("name").(string), "")
+}
 
+// deletePolicy deletes the named key if the key config allows it. Confirm is
+// the name of the key if the deletion has been confirmed.
+func (b *backend) deletePolicy(storage logical.Storage, name, confirm string) (*logical.Response, error) {
+	config, err := b.readKeysConfig(storage)
+	if err != nil {
+		return nil, err
+	}
+	if config.RequireDeleteConfirmation && confirm != name {
+		return logical.ErrorResponse(fmt.Sprintf("deletion of key %s must be confirmed by setting confirm to the name of the key via keys/%s/delete", name, name)), logical.ErrInvalidRequest
+	}
 	// Delete does its own locking
-	err := b.lm.DeletePolicy(req.Storage, name)
+	err = b.lm.DeletePolicy(storage, name, config.RequireDecommissionBeforeDelete)
 	if err != nil {
-		return logical.ErrorResponse(fmt.Sprintf("error deleting policy %s: %s", name, err)), err
+		switch err.(type) {
+		case errutil.UserError:
+			return logical.ErrorResponse(fmt.Sprintf("error deleting policy %s: %s", name, err)), logical.ErrInvalidRequest
+		default:
+			return logical.ErrorResponse(fmt.Sprintf("error deleting policy %s: %s", name, err)), err
+		}
 	}
+	b.idempotency.forget(name)
+	b.rateLimiter.forget(name)
 
 	return nil, nil
 }
 
+// keyTypeNames returns the names of the key types that can be created
+func keyTypeNames() []string {
+	names := make([]string, 0, len(keysutil.KeyTypes))
+	for _, keyType := range keysutil.KeyTypes {
+		names = append(names, keyType.String())
+	}
+	return names
+}
+
+// derivableKeyTypes returns the names of the key types that support key
+// derivation
+func derivableKeyTypes() []string {
+	var derivable []string
+	for _, keyType := range keysutil.KeyTypes {
+		if keyType.DerivationSupported() {
+			derivable = append(derivable, keyType.String())
+		}
+	}
+	return derivable
+}
+
+// rsaPublicKeyPEM encodes the public part of an RSA key in PEM format to
+// return over the API
+func rsaPublicKeyPEM(key *rsa.PrivateKey) (string, error) {
+	derBytes, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return "", fmt.Errorf("error marshaling RSA public key: %v", err)
+	}
+	pemBlock := &pem.Block{
+		Type:  "PUBLIC KEY",
+		Bytes: derBytes,
+	}
+	pemBytes := pem.EncodeToMemory(pemBlock)
+	if pemBytes == nil || len(pemBytes) == 0 {
+		return "", fmt.Errorf("failed to PEM-encode RSA public key")
+	}
+	return string(pemBytes), nil
+}
+
+// contextEncodings are the encodings tried, in order, when decoding a
+// context given on a read. The standard encoding is used everywhere else,
+// but URL-safe contexts are common enough that reads accept them too.
+var contextEncodings = []*base64.Encoding{
+	base64.StdEncoding,
+	base64.RawStdEncoding,
+	base64.URLEncoding,
+	base64.RawURLEncoding,
+}
+
+// decodeContext decodes a base64-encoded context given in either the standard
+// or the URL-safe alphabet, with or without padding
+func decodeContext(raw string) ([]byte, error) {
+	var err error
+	for _, encoding := range contextEncodings {
+		var context []byte
+		if context, err = encoding.DecodeString(raw); err == nil {
+			return context, nil
+		}
+	}
+	return nil, err
+}
+
+// parseKeyVersions converts a list of key version strings into ints, ignoring
+// duplicates
+func parseKeyVersions(raw []string) ([]int, error) {
+	var versions []int
+	seen := map[int]bool{}
+	for _, verStr := range raw {
+		ver, err := strconv.Atoi(strings.TrimPrefix(verStr, "v"))
+		if err != nil || ver < 1 {
+			return nil, fmt.Errorf("invalid key version %q", verStr)
+		}
+		if seen[ver] {
+			continue
+		}
+		seen[ver] = true
+		versions = append(versions, ver)
+	}
+	return versions, nil
+}
+
+// entropySource returns the source of randomness the key material of the key
+// is generated from
+func entropySource(p *keysutil.Policy) string {
+	if p.EntropySource == "" {
+		return keysutil.EntropySourcePlatform
+	}
+	return p.EntropySource
+}
+
+// policyETag returns an opaque tag identifying the current versions of the
+// key, which changes whenever the key is rotated or its minimum versions are
+// changed
+func policyETag(p *keysutil.Policy) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d:%d:%d:%d", p.LatestVersion, p.MinDecryptionVersion, p.MinEncryptionVersion, p.MinAvailableVersion)))
+	return hex.EncodeToString(sum[:16])
+}
+
+// disabledEncryptionVersions returns the sorted versions of the key disabled
+// for encryption, never nil so that keys without any are reported consistently
+func disabledEncryptionVersions(p *keysutil.Policy) []int {
+	versions := append([]int{}, p.DisabledEncryptionVersions...)
+	sort.Ints(versions)
+	return versions
+}
+
+// disabledDecryptionVersions returns the sorted versions of the key disabled
+// for decryption, never nil so that keys without any are reported consistently
+func disabledDecryptionVersions(p *keysutil.Policy) []int {
+	versions := append([]int{}, p.DisabledDecryptionVersions...)
+	sort.Ints(versions)
+	return versions
+}
+
+// validateTags checks that every tag has a name
+func validateTags(tags map[string]string) error {
+	for k := range tags {
+		if k == "" {
+			return fmt.Errorf("tag names must not be empty")
+		}
+	}
+	return nil
+}
+
+// maxDescriptionLength is the maximum length, in bytes, of a key description
+const maxDescriptionLength = 1024
+
+// validateDescription checks that a key description is within the length
+// limit
+func validateDescription(description string) error {
+	if len(description) > maxDescriptionLength {
+		return fmt.Errorf("description must not be longer than %d bytes", maxDescriptionLength)
+	}
+	return nil
+}
+
+// requestIdentity returns the identity of the caller to record as the creator
+// of a key: the entity ID if the request has one, otherwise its display name
+func requestIdentity(req *logical.Request) string {
+	if req.EntityID != "" {
+		return req.EntityID
+	}
+	return req.DisplayName
+}
+
+// keyTags returns the tags of the key, never nil so that keys without tags
+// are reported consistently
+func keyTags(p *keysutil.Policy) map[string]string {
+	if p.Tags == nil {
+		return map[string]string{}
+	}
+	return p.Tags
+}
+
+// keyOperations lists the operations that can be restricted with
+// allowed_operations, along with whether a key type supports each of them
+var keyOperations = []struct {
+	name      string
+	supported func(keysutil.KeyType) bool
+}{
+	{"encrypt", keysutil.KeyType.EncryptionSupported},
+	{"decrypt", keysutil.KeyType.DecryptionSupported},
+	{"sign", keysutil.KeyType.SigningSupported},
+	{"verify", keysutil.KeyType.SigningSupported},
+}
+
+// parseAllowedOperations validates a list of operation names against the
+// operations supported by the key type, ignoring duplicates
+func parseAllowedOperations(keyType keysutil.KeyType, raw []string) ([]string, error) {
+	var ops []string
+	seen := map[string]bool{}
+	for _, op := range raw {
+		op = strings.ToLower(strings.TrimSpace(op))
+		if seen[op] {
+			continue
+		}
+
+		known := false
+		for _, keyOp := range keyOperations {
+			if keyOp.name != op {
+				continue
+			}
+			if !keyOp.supported(keyType) {
+				return nil, fmt.Errorf("operation %q is not supported for keys of type %v", op, keyType)
+			}
+			known = true
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown operation %q", op)
+		}
+
+		seen[op] = true
+		ops = append(ops, op)
+	}
+	return ops, nil
+}
+
+// allowedOperations returns the operations the key may be used for
+func allowedOperations(p *keysutil.Policy) []string {
+	ops := []string{}
+	for _, keyOp := range keyOperations {
+		if keyOp.supported(p.Type) && p.OperationAllowed(keyOp.name) {
+			ops = append(ops, keyOp.name)
+		}
+	}
+	return ops
+}
+
+// validateKeyName rejects key names containing path separators or control
+// characters. The path pattern does not match such names, but they are also
+// checked here as keys with them would be stored below other keys and could
+// not be deleted cleanly.
+func validateKeyName(name string) error {
+	if name == "" {
+		return fmt.Errorf("missing key name")
+	}
+	if strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("key name %q cannot contain path separators", name)
+	}
+	for _, r := range name {
+		if unicode.IsControl(r) {
+			return fmt.Errorf("key name %q cannot contain control characters", name)
+		}
+	}
+	return nil
+}
+
 const pathPolicyHelpSyn = `Managed named encryption keys`
 
 const pathPolicyHelpDesc = `
@@ -312,3 +1255,13 @@ This path is used to manage the named keys that are available.
 Doing a write with no value against a new named key will create
 it using a randomly generated key.
 `
+
+const pathListKeysHelpSyn = `List named encryption keys, or create several at once`
+
+const pathListKeysHelpDesc = `
+A list of this path returns the names of the keys, optionally filtered and
+with details of each key. A write of a batch_input list of key parameters,
+each with a name, creates each key as a write to keys/<name> would; keys that
+cannot be created are reported in the result for the key, while the others
+are still created.
+`
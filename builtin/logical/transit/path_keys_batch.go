@@ -0,0 +1,103 @@
+package transit
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+	"github.com/mitchellh/mapstructure"
+)
+
+func (b *backend) pathKeysBatchWrite(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	batchInputRaw := d.Raw["batch_input"]
+	if batchInputRaw == nil {
+		return logical.ErrorResponse("missing batch input to process"), logical.ErrInvalidRequest
+	}
+	var batchInputItems []map[string]interface{}
+	if err := mapstructure.Decode(batchInputRaw, &batchInputItems); err != nil {
+		return nil, fmt.Errorf("failed to parse batch input: %v", err)
+	}
+	if len(batchInputItems) == 0 {
+		return logical.ErrorResponse("missing batch input to process"), logical.ErrInvalidRequest
+	}
+
+	// Each item is handled as a write to keys/<name> with the item as its
+	// data; failures are reported per key so that one bad item does not
+	// prevent the others from being created
+	keyFields := b.pathKeys().Fields
+	batchResults := make([]map[string]interface{}, len(batchInputItems))
+	for i, item := range batchInputItems {
+		name, _ := item["name"].(string)
+		batchResults[i] = map[string]interface{}{
+			"name": name,
+		}
+
+		if !keyNameRegex.MatchString(name) {
+			batchResults[i]["error"] = fmt.Sprintf("invalid key name %q", name)
+			continue
+		}
+		itemData := &framework.FieldData{
+			Raw:    item,
+			Schema: keyFields,
+		}
+		if err := itemData.Validate(); err != nil {
+			batchResults[i]["error"] = err.Error()
+			continue
+		}
+
+		// Items with an idempotency token that was already used report the
+		// original result for the key, as a single creation would
+		token := itemData.Get("idempotency_token").(string)
+		var paramsHash string
+		if token != "" {
+			var err error
+			paramsHash, err = idempotencyParamsHash(itemData)
+			if err != nil {
+				batchResults[i]["error"] = err.Error()
+				continue
+			}
+			result, err := b.idempotency.get(name, token, paramsHash)
+			if err != nil {
+				batchResults[i]["error"] = err.Error()
+				continue
+			}
+			if result != nil {
+				setBatchCreateResult(batchResults[i], result.created, result.response())
+				continue
+			}
+		}
+
+		upserted, resp, err := b.upsertPolicy(req, itemData)
+		if token != "" && err == nil && (resp == nil || !resp.IsError()) {
+			b.idempotency.put(name, token, paramsHash, upserted, resp)
+		}
+		switch {
+		case resp != nil && resp.IsError():
+			batchResults[i]["error"] = resp.Data["error"]
+		case err != nil:
+			batchResults[i]["error"] = err.Error()
+		default:
+			setBatchCreateResult(batchResults[i], upserted, resp)
+		}
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"batch_results": batchResults,
+		},
+	}, nil
+}
+
+// setBatchCreateResult records in the result of a batch item whether its key
+// was created, along with the warnings of the creation
+func setBatchCreateResult(result map[string]interface{}, created bool, resp *logical.Response) {
+	if !created {
+		result["existed"] = true
+		return
+	}
+	result["created"] = true
+	if resp != nil && len(resp.Warnings) != 0 {
+		result["warnings"] = resp.Warnings
+	}
+}
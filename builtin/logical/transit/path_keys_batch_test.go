@@ -0,0 +1,163 @@
+package transit
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestTransit_BatchCreateKeys(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/existing", map[string]interface{}{
+		"type": "ecdsa-p256",
+	})
+
+	resp := mustHandle(t, b, storage, logical.UpdateOperation, "keys", map[string]interface{}{
+		"batch_input": []interface{}{
+			map[string]interface{}{
+				"name": "new",
+			},
+			map[string]interface{}{
+				"name":    "derived",
+				"type":    "aes256-gcm96",
+				"derived": true,
+			},
+			map[string]interface{}{
+				"name": "existing",
+			},
+			map[string]interface{}{
+				"name": "badtype",
+				"type": "aes512-gcm96",
+			},
+			map[string]interface{}{
+				"name": "new",
+			},
+			map[string]interface{}{
+				"name": "bad/name",
+			},
+			map[string]interface{}{
+				"name":    "badfield",
+				"derived": "sometimes",
+			},
+			map[string]interface{}{
+				"name":                  "convergent",
+				"derived":               true,
+				"convergent_encryption": true,
+			},
+		},
+	})
+
+	results := resp.Data["batch_results"].([]map[string]interface{})
+	if len(results) != 8 {
+		t.Fatalf("expected a result per item: %#v", results)
+	}
+	for i, expected := range []string{"created", "created", "existed", "unknown key type", "existed", "invalid key name", "Error converting input", "created"} {
+		result := results[i]
+		switch expected {
+		case "created", "existed":
+			if result[expected] != true || result["error"] != nil {
+				t.Fatalf("item %d: expected %s, got %#v", i, expected, result)
+			}
+		default:
+			if result["error"] == nil || !strings.Contains(result["error"].(string), expected) {
+				t.Fatalf("item %d: expected error containing %q, got %#v", i, expected, result)
+			}
+		}
+	}
+	if _, ok := results[7]["warnings"]; !ok {
+		t.Fatalf("expected a warning for the convergent key: %#v", results[7])
+	}
+
+	// The existing key is left untouched and failed items are not created
+	if resp := mustHandle(t, b, storage, logical.ReadOperation, "keys/existing", nil); resp.Data["type"] != "ecdsa-p256" {
+		t.Fatalf("existing key was changed: %#v", resp.Data)
+	}
+	if resp := mustHandle(t, b, storage, logical.ReadOperation, "keys/derived", nil); resp.Data["derived"] != true {
+		t.Fatalf("bad derived key: %#v", resp.Data)
+	}
+	for _, name := range []string{"badtype", "badfield"} {
+		if resp := mustHandle(t, b, storage, logical.ReadOperation, "keys/"+name, nil); resp != nil {
+			t.Fatalf("expected key %s not to be created, got %#v", name, resp)
+		}
+	}
+	resp = mustHandle(t, b, storage, logical.ListOperation, "keys", nil)
+	if keys := resp.Data["keys"].([]string); len(keys) != 4 {
+		t.Fatalf("bad keys: %#v", keys)
+	}
+
+	resp, err := b.HandleRequest(&logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "keys",
+		Data: map[string]interface{}{
+			"batch_input": []interface{}{},
+		},
+	})
+	if err != logical.ErrInvalidRequest || resp == nil || !resp.IsError() {
+		t.Fatalf("expected error for empty batch input, got %#v (err: %v)", resp, err)
+	}
+}
+
+func TestTransit_BatchCreateKeysIdempotencyToken(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	batch := func(items ...map[string]interface{}) []map[string]interface{} {
+		input := make([]interface{}, len(items))
+		for i, item := range items {
+			input[i] = item
+		}
+		resp := mustHandle(t, b, storage, logical.UpdateOperation, "keys", map[string]interface{}{
+			"batch_input": input,
+		})
+		return resp.Data["batch_results"].([]map[string]interface{})
+	}
+
+	results := batch(map[string]interface{}{
+		"name":              "foo",
+		"idempotency_token": "abc",
+	}, map[string]interface{}{
+		"name":                  "bar",
+		"derived":               true,
+		"convergent_encryption": true,
+		"idempotency_token":     "abc",
+	})
+	if results[0]["created"] != true || results[1]["created"] != true || results[1]["warnings"] == nil {
+		t.Fatalf("bad results: %#v", results)
+	}
+
+	// Retried items report the original result, tokens are shared with
+	// single creations, and reusing a token with other parameters fails
+	results = batch(map[string]interface{}{
+		"name":              "foo",
+		"idempotency_token": "abc",
+	}, map[string]interface{}{
+		"name":                  "bar",
+		"derived":               true,
+		"convergent_encryption": true,
+		"idempotency_token":     "abc",
+	}, map[string]interface{}{
+		"name":              "foo",
+		"idempotency_token": "def",
+	}, map[string]interface{}{
+		"name":              "foo",
+		"type":              "ed25519",
+		"idempotency_token": "abc",
+	})
+	if results[0]["created"] != true || results[1]["created"] != true || results[1]["warnings"] == nil {
+		t.Fatalf("expected the original results on retry: %#v", results)
+	}
+	if results[2]["existed"] != true {
+		t.Fatalf("expected an existing key with a new token: %#v", results[2])
+	}
+	if err, _ := results[3]["error"].(string); !strings.Contains(err, "different parameters") {
+		t.Fatalf("expected a token mismatch: %#v", results[3])
+	}
+
+	if resp := mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo", map[string]interface{}{
+		"idempotency_token": "def",
+	}); resp == nil || len(resp.Warnings) != 1 || !strings.Contains(resp.Warnings[0], "already existed") {
+		t.Fatalf("expected the original result of the batch item, got %#v", resp)
+	}
+}
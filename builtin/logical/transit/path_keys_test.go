@@ -1,7 +1,19 @@
 package transit_test
 
 import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/hashicorp/vault/api"
 	"github.com/hashicorp/vault/audit"
@@ -10,6 +22,7 @@ import (
 	vaulthttp "github.com/hashicorp/vault/http"
 	"github.com/hashicorp/vault/logical"
 	"github.com/hashicorp/vault/vault"
+	"golang.org/x/crypto/hkdf"
 )
 
 func TestTransit_Issue_2958(t *testing.T) {
@@ -75,3 +88,2114 @@ func TestTransit_Issue_2958(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func createTestBackend(t *testing.T) (logical.Backend, logical.Storage) {
+	config := logical.TestBackendConfig()
+	config.StorageView = &logical.InmemStorage{}
+	b, err := transit.Factory(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b, config.StorageView
+}
+
+// mustHandle handles a request against the backend and fails the test if it
+// returns an error
+func mustHandle(t *testing.T, b logical.Backend, storage logical.Storage, op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+	return mustHandleRequest(t, b, &logical.Request{
+		Storage:   storage,
+		Operation: op,
+		Path:      path,
+		Data:      data,
+	})
+}
+
+// mustFail handles a request against the backend and fails the test unless
+// it returns an error
+func mustFail(t *testing.T, b logical.Backend, storage logical.Storage, op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+	return mustFailRequest(t, b, &logical.Request{
+		Storage:   storage,
+		Operation: op,
+		Path:      path,
+		Data:      data,
+	})
+}
+
+func mustHandleRequest(t *testing.T, b logical.Backend, req *logical.Request) *logical.Response {
+	resp, err := b.HandleRequest(req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("%s: got err:\n%#v\nresp:\n%#v\n", req.Path, err, resp)
+	}
+	return resp
+}
+
+func mustFailRequest(t *testing.T, b logical.Backend, req *logical.Request) *logical.Response {
+	resp, err := b.HandleRequest(req)
+	if err == nil && (resp == nil || !resp.IsError()) {
+		t.Fatalf("%s: expected error, got %#v", req.Path, resp)
+	}
+	return resp
+}
+
+func TestTransit_CreateExistingKey(t *testing.T) {
+	b, storage := createTestBackend(t)
+
+	req := &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "keys/foo",
+	}
+
+	resp, err := b.HandleRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp != nil {
+		t.Fatalf("expected no response on creation, got %#v", *resp)
+	}
+
+	resp, err = b.HandleRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil {
+		t.Fatal("expected a response when writing an existing key")
+	}
+	if len(resp.Warnings) != 1 || resp.Warnings[0] != "key foo already existed" {
+		t.Fatalf("bad warnings: %#v", resp.Warnings)
+	}
+}
+
+func TestTransit_CreateConvergentKeyWarning(t *testing.T) {
+	b, storage := createTestBackend(t)
+
+	create := func(name string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(&logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "keys/" + name,
+			Data:      data,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("%s: got err:\n%#v\nresp:\n%#v\n", name, err, resp)
+		}
+		return resp
+	}
+
+	convergent := map[string]interface{}{
+		"derived":               true,
+		"convergent_encryption": true,
+	}
+	resp := create("convergent", convergent)
+	if resp == nil || len(resp.Warnings) != 2 || !strings.Contains(resp.Warnings[0], "nonce values used with a given context value must be unique") {
+		t.Fatalf("expected nonce warning, got %#v", resp)
+	}
+
+	// Without plaintext backups, losing the key means the ciphertexts can
+	// never be reproduced
+	if !strings.Contains(resp.Warnings[1], "allow_plaintext_backup") {
+		t.Fatalf("expected backup warning, got %#v", resp.Warnings)
+	}
+	resp = create("convergent-backup", map[string]interface{}{
+		"derived":                true,
+		"convergent_encryption":  true,
+		"allow_plaintext_backup": true,
+	})
+	if resp == nil || len(resp.Warnings) != 1 || !strings.Contains(resp.Warnings[0], "nonce") {
+		t.Fatalf("expected only the nonce warning, got %#v", resp)
+	}
+
+	// The warning is only given when the key is created
+	resp = create("convergent", convergent)
+	if resp == nil || len(resp.Warnings) != 1 || resp.Warnings[0] != "key convergent already existed" {
+		t.Fatalf("bad warnings: %#v", resp)
+	}
+
+	if resp := create("derived", map[string]interface{}{"derived": true}); resp != nil {
+		t.Fatalf("expected no response for a derived key, got %#v", resp)
+	}
+}
+
+func TestTransit_CreateExistingKeyTypeMismatch(t *testing.T) {
+	b, storage := createTestBackend(t)
+
+	req := &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "keys/foo",
+		Data: map[string]interface{}{
+			"type": "aes256-gcm96",
+		},
+	}
+	if _, err := b.HandleRequest(req); err != nil {
+		t.Fatal(err)
+	}
+
+	req.Data["type"] = "ecdsa-p256"
+	resp, err := b.HandleRequest(req)
+	if err != logical.ErrInvalidRequest || resp == nil || !strings.Contains(resp.Data["error"].(string), "cannot be changed") {
+		t.Fatalf("expected type change to be rejected, got %#v (err: %v)", resp, err)
+	}
+
+	// Rewriting with the same type, or without a type, only warns
+	for _, data := range []map[string]interface{}{{"type": "aes256-gcm96"}, nil} {
+		req.Data = data
+		resp, err = b.HandleRequest(req)
+		if err != nil || resp == nil || resp.IsError() || len(resp.Warnings) != 1 {
+			t.Fatalf("expected already existed warning, got %#v (err: %v)", resp, err)
+		}
+	}
+
+	req.Operation = logical.ReadOperation
+	req.Data = nil
+	resp, err = b.HandleRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Data["type"] != "aes256-gcm96" {
+		t.Fatalf("expected key type to be unchanged, got %v", resp.Data["type"])
+	}
+}
+
+func TestTransit_ReadKeyCreationTimes(t *testing.T) {
+	b, storage := createTestBackend(t)
+
+	req := &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "keys/foo",
+	}
+	if _, err := b.HandleRequest(req); err != nil {
+		t.Fatal(err)
+	}
+	req.Path = "keys/foo/rotate"
+	if _, err := b.HandleRequest(req); err != nil {
+		t.Fatal(err)
+	}
+
+	req.Operation = logical.ReadOperation
+	req.Path = "keys/foo"
+	resp, err := b.HandleRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil {
+		t.Fatal("expected a response")
+	}
+
+	// The existing keys map must be left untouched
+	if _, ok := resp.Data["keys"].(map[string]int64); !ok {
+		t.Fatalf("bad keys: %#v", resp.Data["keys"])
+	}
+
+	creationTimes := resp.Data["creation_times"].(map[string]string)
+	if len(creationTimes) != 2 {
+		t.Fatalf("expected 2 creation times, got %#v", creationTimes)
+	}
+	for i := 1; i <= 2; i++ {
+		ts, ok := creationTimes[strconv.Itoa(i)]
+		if !ok {
+			t.Fatalf("missing creation time for version %d", i)
+		}
+		if _, err := time.Parse(time.RFC3339, ts); err != nil {
+			t.Fatalf("creation time %q for version %d is not RFC3339: %v", ts, i, err)
+		}
+	}
+}
+
+func TestTransit_ReadKeyVersionAlgorithms(t *testing.T) {
+	b, storage := createTestBackend(t)
+
+	for _, keyType := range []string{"aes256-gcm96", "ecdsa-p384", "rsa-2048"} {
+		req := &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "keys/" + keyType,
+			Data: map[string]interface{}{
+				"type": keyType,
+			},
+		}
+		if _, err := b.HandleRequest(req); err != nil {
+			t.Fatal(err)
+		}
+		req.Path = "keys/" + keyType + "/rotate"
+		req.Data = nil
+		if _, err := b.HandleRequest(req); err != nil {
+			t.Fatal(err)
+		}
+
+		req.Operation = logical.ReadOperation
+		req.Path = "keys/" + keyType
+		resp, err := b.HandleRequest(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := map[string]string{"1": keyType, "2": keyType}
+		if !reflect.DeepEqual(resp.Data["version_algorithms"], expected) {
+			t.Fatalf("%s: bad version algorithms: %#v", keyType, resp.Data["version_algorithms"])
+		}
+		if resp.Data["type"] != keyType {
+			t.Fatalf("%s: bad type: %v", keyType, resp.Data["type"])
+		}
+	}
+}
+
+func TestTransit_CreateKeyAutoRotatePeriod(t *testing.T) {
+	b, storage := createTestBackend(t)
+
+	req := &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "keys/foo",
+		Data: map[string]interface{}{
+			"auto_rotate_period": "30m",
+		},
+	}
+	resp, err := b.HandleRequest(req)
+	if err == nil && (resp == nil || !resp.IsError()) {
+		t.Fatal("expected error for an auto rotate period under an hour")
+	}
+
+	req.Data["auto_rotate_period"] = "720h"
+	if _, err := b.HandleRequest(req); err != nil {
+		t.Fatal(err)
+	}
+
+	req.Operation = logical.ReadOperation
+	req.Data = nil
+	resp, err = b.HandleRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Data["auto_rotate_period"].(int64) != 720*3600 {
+		t.Fatalf("bad auto rotate period: %#v", resp.Data["auto_rotate_period"])
+	}
+	nextRotation, err := time.Parse(time.RFC3339, resp.Data["next_rotation"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	creationTime, err := time.Parse(time.RFC3339, resp.Data["creation_times"].(map[string]string)["1"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !nextRotation.Equal(creationTime.Add(720 * time.Hour)) {
+		t.Fatalf("bad next rotation %v for creation time %v", nextRotation, creationTime)
+	}
+
+	// Keys without a period should not report a next rotation
+	req.Operation = logical.UpdateOperation
+	req.Path = "keys/bar"
+	if _, err := b.HandleRequest(req); err != nil {
+		t.Fatal(err)
+	}
+	req.Operation = logical.ReadOperation
+	resp, err = b.HandleRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Data["auto_rotate_period"].(int64) != 0 {
+		t.Fatalf("bad auto rotate period: %#v", resp.Data["auto_rotate_period"])
+	}
+	if _, ok := resp.Data["next_rotation"]; ok {
+		t.Fatalf("unexpected next rotation: %#v", resp.Data["next_rotation"])
+	}
+}
+
+func TestTransit_DeletionAllowed(t *testing.T) {
+	b, storage := createTestBackend(t)
+
+	req := &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "keys/foo",
+	}
+	if _, err := b.HandleRequest(req); err != nil {
+		t.Fatal(err)
+	}
+
+	readDeletionAllowed := func() bool {
+		resp, err := b.HandleRequest(&logical.Request{
+			Storage:   storage,
+			Operation: logical.ReadOperation,
+			Path:      "keys/foo",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.Data["deletion_allowed"].(bool)
+	}
+	if readDeletionAllowed() {
+		t.Fatal("expected deletion to be disallowed by default")
+	}
+
+	// Deletion is refused with a user-facing error
+	req.Operation = logical.DeleteOperation
+	resp, err := b.HandleRequest(req)
+	if err != logical.ErrInvalidRequest {
+		t.Fatalf("expected invalid request error, got %v", err)
+	}
+	if resp == nil || !resp.IsError() || !strings.Contains(resp.Data["error"].(string), "deletion_allowed") {
+		t.Fatalf("bad response: %#v", resp)
+	}
+
+	req.Operation = logical.UpdateOperation
+	req.Path = "keys/foo/config"
+	req.Data = map[string]interface{}{
+		"deletion_allowed": true,
+	}
+	if _, err := b.HandleRequest(req); err != nil {
+		t.Fatal(err)
+	}
+	if !readDeletionAllowed() {
+		t.Fatal("expected deletion to be allowed")
+	}
+
+	req.Operation = logical.DeleteOperation
+	req.Path = "keys/foo"
+	req.Data = nil
+	if _, err := b.HandleRequest(req); err != nil {
+		t.Fatal(err)
+	}
+
+	req.Operation = logical.ReadOperation
+	resp, err = b.HandleRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp != nil {
+		t.Fatalf("expected key to be deleted, got %#v", resp)
+	}
+}
+
+func TestTransit_ReadConvergentVersion(t *testing.T) {
+	b, storage := createTestBackend(t)
+
+	req := &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "keys/convergent",
+		Data: map[string]interface{}{
+			"derived":               true,
+			"convergent_encryption": true,
+		},
+	}
+	if _, err := b.HandleRequest(req); err != nil {
+		t.Fatal(err)
+	}
+	req.Path = "keys/derived"
+	req.Data = map[string]interface{}{
+		"derived": true,
+	}
+	if _, err := b.HandleRequest(req); err != nil {
+		t.Fatal(err)
+	}
+
+	req.Operation = logical.ReadOperation
+	req.Path = "keys/convergent"
+	req.Data = nil
+	resp, err := b.HandleRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Data["convergent_version"] != 2 || resp.Data["convergent_encryption_version"] != 2 {
+		t.Fatalf("bad convergent version: %#v", resp.Data)
+	}
+	if resp.Data["requires_nonce"] != false || resp.Data["nonce_size"] != 12 {
+		t.Fatalf("bad nonce requirement: %#v", resp.Data)
+	}
+
+	req.Path = "keys/derived"
+	resp, err = b.HandleRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := resp.Data["convergent_version"]; ok {
+		t.Fatalf("unexpected convergent version for non-convergent key: %#v", resp.Data)
+	}
+	for _, field := range []string{"requires_nonce", "nonce_size"} {
+		if _, ok := resp.Data[field]; ok {
+			t.Fatalf("unexpected %s for non-convergent key: %#v", field, resp.Data)
+		}
+	}
+}
+
+func TestTransit_CreateKeyConvergentVersion(t *testing.T) {
+	b, storage := createTestBackend(t)
+
+	doErrReq := func(op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(&logical.Request{
+			Storage:   storage,
+			Operation: op,
+			Path:      path,
+			Data:      data,
+		})
+		if err != logical.ErrInvalidRequest || resp == nil || !resp.IsError() {
+			t.Fatalf("%s: expected invalid request, got %#v (err: %v)", path, resp, err)
+		}
+		return resp
+	}
+
+	plaintext := base64.StdEncoding.EncodeToString([]byte("the quick brown fox"))
+	context := base64.StdEncoding.EncodeToString([]byte("context"))
+	nonce := base64.StdEncoding.EncodeToString([]byte("twelve bytes"))
+	for _, version := range []int{1, 2} {
+		path := "keys/convergent" + strconv.Itoa(version)
+		mustHandle(t, b, storage, logical.UpdateOperation, path, map[string]interface{}{
+			"derived":               true,
+			"convergent_encryption": true,
+			"convergent_version":    version,
+		})
+
+		resp := mustHandle(t, b, storage, logical.ReadOperation, path, nil)
+		if resp.Data["convergent_version"] != version || resp.Data["requires_nonce"] != (version == 1) {
+			t.Fatalf("version %d: bad convergent version: %#v", version, resp.Data)
+		}
+
+		// Version 1 uses the supplied nonce for both encryption and
+		// decryption, while later versions derive it
+		data := map[string]interface{}{
+			"plaintext": plaintext,
+			"context":   context,
+		}
+		if version == 1 {
+			data["nonce"] = nonce
+		}
+		name := "convergent" + strconv.Itoa(version)
+		ciphertext := mustHandle(t, b, storage, logical.UpdateOperation, "encrypt/"+name, data).Data["ciphertext"]
+		if again := mustHandle(t, b, storage, logical.UpdateOperation, "encrypt/"+name, data).Data["ciphertext"]; again != ciphertext {
+			t.Fatalf("version %d: expected convergent ciphertexts, got %v and %v", version, ciphertext, again)
+		}
+		delete(data, "plaintext")
+		data["ciphertext"] = ciphertext
+		resp = mustHandle(t, b, storage, logical.UpdateOperation, "decrypt/"+name, data)
+		if resp.Data["plaintext"] != plaintext {
+			t.Fatalf("version %d: bad plaintext: %#v", version, resp.Data)
+		}
+
+		// Writing the key again with the same version is fine, but the
+		// version of an existing key cannot be changed this way
+		mustHandle(t, b, storage, logical.UpdateOperation, path, map[string]interface{}{
+			"derived":               true,
+			"convergent_encryption": true,
+			"convergent_version":    version,
+		})
+		doErrReq(logical.UpdateOperation, path, map[string]interface{}{
+			"derived":               true,
+			"convergent_encryption": true,
+			"convergent_version":    3 - version,
+		})
+	}
+
+	for i, data := range []map[string]interface{}{
+		{"derived": true, "convergent_encryption": true, "convergent_version": 3},
+		{"derived": true, "convergent_encryption": true, "convergent_version": -1},
+		{"derived": true, "convergent_version": 1},
+	} {
+		path := "keys/invalid" + strconv.Itoa(i)
+		doErrReq(logical.UpdateOperation, path, data)
+		if resp := mustHandle(t, b, storage, logical.ReadOperation, path, nil); resp != nil {
+			t.Fatalf("case %d: expected key not to be created, got %#v", i, resp)
+		}
+	}
+}
+
+func TestTransit_ListKeysDetailed(t *testing.T) {
+	b, storage := createTestBackend(t)
+
+	req := &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "keys/aes",
+	}
+	if _, err := b.HandleRequest(req); err != nil {
+		t.Fatal(err)
+	}
+	req.Path = "keys/aes/rotate"
+	if _, err := b.HandleRequest(req); err != nil {
+		t.Fatal(err)
+	}
+	req.Path = "keys/ecdsa"
+	req.Data = map[string]interface{}{
+		"type":       "ecdsa-p256",
+		"exportable": true,
+	}
+	if _, err := b.HandleRequest(req); err != nil {
+		t.Fatal(err)
+	}
+
+	req.Operation = logical.ListOperation
+	req.Path = "keys/"
+	req.Data = nil
+	resp, err := b.HandleRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := resp.Data["key_info"]; ok {
+		t.Fatalf("unexpected key info without detailed: %#v", resp.Data)
+	}
+	if len(resp.Data["keys"].([]string)) != 2 {
+		t.Fatalf("bad keys: %#v", resp.Data)
+	}
+
+	req.Data = map[string]interface{}{
+		"detailed": true,
+	}
+	resp, err = b.HandleRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyInfo := resp.Data["key_info"].(map[string]interface{})
+
+	aes := keyInfo["aes"].(map[string]interface{})
+	if aes["type"] != "aes256-gcm96" || aes["latest_version"] != 2 ||
+		aes["min_decryption_version"] != 1 || aes["min_encryption_version"] != 0 ||
+		aes["derived"] != false || aes["exportable"] != false {
+		t.Fatalf("bad info for aes key: %#v", aes)
+	}
+	ecdsa := keyInfo["ecdsa"].(map[string]interface{})
+	if ecdsa["type"] != "ecdsa-p256" || ecdsa["latest_version"] != 1 ||
+		ecdsa["exportable"] != true {
+		t.Fatalf("bad info for ecdsa key: %#v", ecdsa)
+	}
+
+	// A key that fails to load is reported separately without failing the
+	// list or hiding the healthy keys
+	if err := storage.Put(&logical.StorageEntry{
+		Key:   "policy/broken",
+		Value: []byte("{not json"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	resp, err = b.HandleRequest(req)
+	if err != nil || resp.IsError() {
+		t.Fatalf("expected list to succeed, got %#v (err: %v)", resp, err)
+	}
+	keys := resp.Data["keys"].([]string)
+	sort.Strings(keys)
+	if !reflect.DeepEqual(keys, []string{"aes", "ecdsa"}) {
+		t.Fatalf("bad keys: %v", keys)
+	}
+	keyInfo = resp.Data["key_info"].(map[string]interface{})
+	if len(keyInfo) != 2 {
+		t.Fatalf("bad key info: %#v", keyInfo)
+	}
+	loadErrors := resp.Data["errors"].([]map[string]interface{})
+	if len(loadErrors) != 1 || loadErrors[0]["name"] != "broken" || loadErrors[0]["error"] == "" {
+		t.Fatalf("expected error for broken key, got %#v", loadErrors)
+	}
+
+	// Filtering by tag also skips the broken key
+	req.Data = map[string]interface{}{
+		"tags": "team=none",
+	}
+	resp, err = b.HandleRequest(req)
+	if err != nil || resp.IsError() {
+		t.Fatalf("expected filtered list to succeed, got %#v (err: %v)", resp, err)
+	}
+	if loadErrors := resp.Data["errors"].([]map[string]interface{}); len(loadErrors) != 1 || loadErrors[0]["name"] != "broken" {
+		t.Fatalf("expected error for broken key, got %#v", loadErrors)
+	}
+}
+
+func TestTransit_ListKeysFiltered(t *testing.T) {
+	b, storage := createTestBackend(t)
+
+	listKeys := func(data map[string]interface{}) []string {
+		resp := mustHandle(t, b, storage, logical.ListOperation, "keys/", data)
+		keys := resp.Data["keys"].([]string)
+		sort.Strings(keys)
+		if len(keys) != len(resp.Data["key_info"].(map[string]interface{})) {
+			t.Fatalf("key info does not match keys: %#v", resp.Data)
+		}
+		return keys
+	}
+
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/plain", nil)
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/exportable", map[string]interface{}{
+		"exportable": true,
+	})
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/derived", map[string]interface{}{
+		"derived": true,
+		"tags":    "team=payments",
+	})
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/both", map[string]interface{}{
+		"derived":    true,
+		"exportable": true,
+	})
+
+	for _, tc := range []struct {
+		data     map[string]interface{}
+		expected []string
+	}{
+		{map[string]interface{}{"exportable": true}, []string{"both", "exportable"}},
+		{map[string]interface{}{"exportable": false}, []string{"derived", "plain"}},
+		{map[string]interface{}{"derived": true}, []string{"both", "derived"}},
+		{map[string]interface{}{"derived": false}, []string{"exportable", "plain"}},
+		{map[string]interface{}{"derived": true, "exportable": false}, []string{"derived"}},
+		{map[string]interface{}{"derived": true, "tags": "team=payments"}, []string{"derived"}},
+	} {
+		tc.data["detailed"] = true
+		if keys := listKeys(tc.data); !reflect.DeepEqual(keys, tc.expected) {
+			t.Fatalf("filter %v: expected %v, got %v", tc.data, tc.expected, keys)
+		}
+	}
+
+	// The filters load every key, so they require the detailed mode
+	for _, filter := range []string{"exportable", "derived"} {
+		resp, err := b.HandleRequest(&logical.Request{
+			Storage:   storage,
+			Operation: logical.ListOperation,
+			Path:      "keys/",
+			Data: map[string]interface{}{
+				filter: true,
+			},
+		})
+		if err != logical.ErrInvalidRequest || resp == nil || !strings.Contains(resp.Data["error"].(string), "requires detailed") {
+			t.Fatalf("%s: expected error without detailed, got %#v (err: %v)", filter, resp, err)
+		}
+	}
+
+	// Keys that cannot be loaded are reported and the locks of the others
+	// are released, so that they can still be rotated
+	if err := storage.Put(&logical.StorageEntry{
+		Key:   "policy/broken",
+		Value: []byte("{not json"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	resp := mustHandle(t, b, storage, logical.ListOperation, "keys/", map[string]interface{}{
+		"detailed":   true,
+		"exportable": true,
+	})
+	if loadErrors := resp.Data["errors"].([]map[string]interface{}); len(loadErrors) != 1 || loadErrors[0]["name"] != "broken" {
+		t.Fatalf("expected error for broken key, got %#v", resp.Data)
+	}
+	for _, name := range []string{"plain", "exportable", "derived", "both"} {
+		mustHandle(t, b, storage, logical.UpdateOperation, "keys/"+name+"/rotate", nil)
+	}
+}
+
+func TestTransit_CreateKeyInvalidDerivation(t *testing.T) {
+	b, storage := createTestBackend(t)
+
+	cases := []map[string]interface{}{
+		{"convergent_encryption": true},
+		{"type": "rsa-2048", "derived": true},
+		{"type": "ecdsa-p256", "derived": true},
+		{"type": "ed25519", "derived": true, "convergent_encryption": true},
+		{"type": "rsa-4096", "derived": true, "convergent_encryption": true},
+	}
+	for i, data := range cases {
+		req := &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "keys/invalid" + strconv.Itoa(i),
+			Data:      data,
+		}
+		resp, err := b.HandleRequest(req)
+		if resp == nil || !resp.IsError() {
+			t.Fatalf("case %d: expected error response, got %#v (err: %v)", i, resp, err)
+		}
+
+		req.Operation = logical.ReadOperation
+		req.Data = nil
+		resp, err = b.HandleRequest(req)
+		if err != nil || resp != nil {
+			t.Fatalf("case %d: expected key not to be created, got %#v (err: %v)", i, resp, err)
+		}
+	}
+
+	req := &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "keys/convergent",
+		Data: map[string]interface{}{
+			"derived":               true,
+			"convergent_encryption": true,
+		},
+	}
+	resp, err := b.HandleRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || len(resp.Warnings) != 2 || !strings.Contains(resp.Warnings[0], "nonce") {
+		t.Fatalf("expected convergent encryption warnings, got %#v", resp)
+	}
+
+	req.Path = "keys/derived-ed25519"
+	req.Data = map[string]interface{}{
+		"type":    "ed25519",
+		"derived": true,
+	}
+	resp, err = b.HandleRequest(req)
+	if err != nil || resp != nil {
+		t.Fatalf("expected clean creation of derived ed25519 key, got %#v (err: %v)", resp, err)
+	}
+}
+
+func TestTransit_CreateKeyDerivedUnsupportedType(t *testing.T) {
+	b, storage := createTestBackend(t)
+
+	for _, keyType := range []string{"ecdsa-p256", "ecdsa-p384", "ecdsa-p521", "rsa-2048", "rsa-3072", "rsa-4096"} {
+		req := &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "keys/" + keyType,
+			Data: map[string]interface{}{
+				"type":    keyType,
+				"derived": true,
+			},
+		}
+		resp, err := b.HandleRequest(req)
+		if err != logical.ErrInvalidRequest || resp == nil || !resp.IsError() {
+			t.Fatalf("%s: expected invalid request, got %#v (err: %v)", keyType, resp, err)
+		}
+		expected := "key derivation is not supported for keys of type " + keyType + "; only keys of type aes128-gcm96, aes256-gcm96, chacha20-poly1305, ed25519, ed448 can be derived"
+		if resp.Data["error"] != expected {
+			t.Fatalf("%s: bad error: %#v", keyType, resp.Data["error"])
+		}
+
+		req.Operation = logical.ReadOperation
+		req.Data = nil
+		resp, err = b.HandleRequest(req)
+		if err != nil || resp != nil {
+			t.Fatalf("%s: expected key not to be created, got %#v (err: %v)", keyType, resp, err)
+		}
+	}
+}
+
+func TestTransit_ReadKeySupportedOperations(t *testing.T) {
+	b, storage := createTestBackend(t)
+
+	cases := map[string][4]bool{
+		// encryption, decryption, signing, derivation
+		"aes256-gcm96": {true, true, false, true},
+		"ecdsa-p256":   {false, false, true, false},
+		"ecdsa-p384":   {false, false, true, false},
+		"ecdsa-p521":   {false, false, true, false},
+		"ed25519":      {false, false, true, true},
+		"rsa-2048":     {true, true, true, false},
+		"rsa-3072":     {true, true, true, false},
+		"rsa-4096":     {true, true, true, false},
+	}
+	for keyType, expected := range cases {
+		req := &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "keys/" + keyType,
+			Data: map[string]interface{}{
+				"type": keyType,
+			},
+		}
+		if _, err := b.HandleRequest(req); err != nil {
+			t.Fatal(err)
+		}
+
+		req.Operation = logical.ReadOperation
+		req.Data = nil
+		resp, err := b.HandleRequest(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual := [4]bool{
+			resp.Data["supports_encryption"].(bool),
+			resp.Data["supports_decryption"].(bool),
+			resp.Data["supports_signing"].(bool),
+			resp.Data["supports_derivation"].(bool),
+		}
+		if actual != expected {
+			t.Fatalf("%s: expected supported operations %v, got %v", keyType, expected, actual)
+		}
+	}
+}
+
+func TestTransit_AllowedOperations(t *testing.T) {
+	b, storage := createTestBackend(t)
+
+	// Operations must be known and supported by the key type
+	mustFail(t, b, storage, logical.UpdateOperation, "keys/bad", map[string]interface{}{
+		"allowed_operations": "encrypt,frobnicate",
+	})
+	mustFail(t, b, storage, logical.UpdateOperation, "keys/bad", map[string]interface{}{
+		"allowed_operations": "sign",
+	})
+
+	plaintext := "dGhlIHF1aWNrIGJyb3duIGZveA=="
+
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/sign-only", map[string]interface{}{
+		"type":               "rsa-2048",
+		"allowed_operations": "sign,verify",
+	})
+	resp := mustHandle(t, b, storage, logical.ReadOperation, "keys/sign-only", nil)
+	if ops := resp.Data["allowed_operations"].([]string); strings.Join(ops, ",") != "sign,verify" {
+		t.Fatalf("bad allowed operations: %v", ops)
+	}
+
+	mustFail(t, b, storage, logical.UpdateOperation, "encrypt/sign-only", map[string]interface{}{
+		"plaintext": plaintext,
+	})
+	mustFail(t, b, storage, logical.UpdateOperation, "datakey/plaintext/sign-only", nil)
+	signature := mustHandle(t, b, storage, logical.UpdateOperation, "sign/sign-only", map[string]interface{}{
+		"input": plaintext,
+	}).Data["signature"].(string)
+	resp = mustHandle(t, b, storage, logical.UpdateOperation, "verify/sign-only", map[string]interface{}{
+		"input":     plaintext,
+		"signature": signature,
+	})
+	if !resp.Data["valid"].(bool) {
+		t.Fatal("expected signature to verify")
+	}
+
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/encrypt-only", map[string]interface{}{
+		"type":               "rsa-2048",
+		"allowed_operations": "encrypt",
+	})
+	mustFail(t, b, storage, logical.UpdateOperation, "sign/encrypt-only", map[string]interface{}{
+		"input": plaintext,
+	})
+	ciphertext := mustHandle(t, b, storage, logical.UpdateOperation, "encrypt/encrypt-only", map[string]interface{}{
+		"plaintext": plaintext,
+	}).Data["ciphertext"].(string)
+	mustFail(t, b, storage, logical.UpdateOperation, "decrypt/encrypt-only", map[string]interface{}{
+		"ciphertext": ciphertext,
+	})
+	mustFail(t, b, storage, logical.UpdateOperation, "rewrap/encrypt-only", map[string]interface{}{
+		"ciphertext": ciphertext,
+	})
+
+	// Unrestricted keys report every operation their type supports
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/unrestricted", nil)
+	resp = mustHandle(t, b, storage, logical.ReadOperation, "keys/unrestricted", nil)
+	if ops := resp.Data["allowed_operations"].([]string); strings.Join(ops, ",") != "encrypt,decrypt" {
+		t.Fatalf("bad allowed operations: %v", ops)
+	}
+}
+
+func TestTransit_ReadDerivedKeyFingerprints(t *testing.T) {
+	b, storage := createTestBackend(t)
+
+	for _, keyType := range []string{"aes128-gcm96", "aes256-gcm96", "chacha20-poly1305"} {
+		req := &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "keys/" + keyType,
+			Data: map[string]interface{}{
+				"type":    keyType,
+				"derived": true,
+			},
+		}
+		if _, err := b.HandleRequest(req); err != nil {
+			t.Fatal(err)
+		}
+		req.Path = "keys/" + keyType + "/rotate"
+		req.Data = nil
+		if _, err := b.HandleRequest(req); err != nil {
+			t.Fatal(err)
+		}
+
+		read := func(context string) map[string]string {
+			resp, err := b.HandleRequest(&logical.Request{
+				Storage:   storage,
+				Operation: logical.ReadOperation,
+				Path:      "keys/" + keyType,
+				Data: map[string]interface{}{
+					"context": context,
+				},
+			})
+			if err != nil || resp == nil || resp.IsError() {
+				t.Fatalf("%s: bad read: %#v (err: %v)", keyType, resp, err)
+			}
+			fingerprints, _ := resp.Data["derived_key_fingerprints"].(map[string]string)
+			return fingerprints
+		}
+
+		if fingerprints := read(""); fingerprints != nil {
+			t.Fatalf("%s: expected no fingerprints without a context, got %v", keyType, fingerprints)
+		}
+
+		first := read("dGVzdGNvbnRleHQ=")
+		if len(first) != 2 || first["1"] == "" || first["1"] == first["2"] {
+			t.Fatalf("%s: bad fingerprints: %v", keyType, first)
+		}
+		if second := read("dGVzdGNvbnRleHQ="); second["1"] != first["1"] || second["2"] != first["2"] {
+			t.Fatalf("%s: fingerprints are not deterministic: %v vs %v", keyType, first, second)
+		}
+		if other := read("b3RoZXJjb250ZXh0"); other["1"] == first["1"] {
+			t.Fatalf("%s: different contexts produced the same fingerprint", keyType)
+		}
+	}
+}
+
+func TestTransit_ReadKeyFingerprint(t *testing.T) {
+	b, storage := createTestBackend(t)
+
+	readFingerprint := func(name string) string {
+		resp, err := b.HandleRequest(&logical.Request{
+			Storage:   storage,
+			Operation: logical.ReadOperation,
+			Path:      "keys/" + name,
+		})
+		if err != nil || resp == nil {
+			t.Fatalf("bad read: %#v (err: %v)", resp, err)
+		}
+		return resp.Data["fingerprint"].(string)
+	}
+
+	for _, keyType := range []string{"aes256-gcm96", "ecdsa-p256"} {
+		req := &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "keys/" + keyType,
+			Data: map[string]interface{}{
+				"type": keyType,
+			},
+		}
+		if _, err := b.HandleRequest(req); err != nil {
+			t.Fatal(err)
+		}
+
+		fingerprint := readFingerprint(keyType)
+		if fingerprint == "" {
+			t.Fatalf("%s: missing fingerprint", keyType)
+		}
+		if readFingerprint(keyType) != fingerprint {
+			t.Fatalf("%s: fingerprint changed between reads", keyType)
+		}
+
+		req.Path = "keys/" + keyType + "/rotate"
+		req.Data = nil
+		if _, err := b.HandleRequest(req); err != nil {
+			t.Fatal(err)
+		}
+		if readFingerprint(keyType) != fingerprint {
+			t.Fatalf("%s: fingerprint changed on rotation", keyType)
+		}
+
+		req.Path = "keys/" + keyType + "/rename"
+		req.Data = map[string]interface{}{
+			"new_name": keyType + "-renamed",
+		}
+		if _, err := b.HandleRequest(req); err != nil {
+			t.Fatal(err)
+		}
+		if readFingerprint(keyType+"-renamed") != fingerprint {
+			t.Fatalf("%s: fingerprint changed on rename", keyType)
+		}
+	}
+
+	if readFingerprint("aes256-gcm96-renamed") == readFingerprint("ecdsa-p256-renamed") {
+		t.Fatal("expected distinct fingerprints for distinct keys")
+	}
+}
+
+func TestTransit_ReadDerivedEd25519PublicKey(t *testing.T) {
+	b, storage := createTestBackend(t)
+
+	req := &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "keys/ed",
+		Data: map[string]interface{}{
+			"type":       "ed25519",
+			"derived":    true,
+			"exportable": true,
+		},
+	}
+	if _, err := b.HandleRequest(req); err != nil {
+		t.Fatal(err)
+	}
+
+	readPublicKey := func(context string) string {
+		resp, err := b.HandleRequest(&logical.Request{
+			Storage:   storage,
+			Operation: logical.ReadOperation,
+			Path:      "keys/ed",
+			Data: map[string]interface{}{
+				"context": context,
+			},
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("bad read: %#v (err: %v)", resp, err)
+		}
+		keys := resp.Data["keys"].(map[string]map[string]interface{})
+		pubKey, _ := keys["1"]["public_key"].(string)
+		return pubKey
+	}
+
+	// Without a context there is no single public key to return
+	if pubKey := readPublicKey(""); pubKey != "" {
+		t.Fatalf("expected no public key without a context, got %q", pubKey)
+	}
+
+	context := "dGVzdGNvbnRleHQ="
+	pubKeyB64 := readPublicKey(context)
+	if pubKeyB64 == readPublicKey("b3RoZXJjb250ZXh0") {
+		t.Fatal("expected different contexts to yield different public keys")
+	}
+
+	// Independently derive the public key from the exported base key
+	req.Operation = logical.ReadOperation
+	req.Path = "export/signing-key/ed/1"
+	req.Data = nil
+	resp, err := b.HandleRequest(req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("bad export: %#v (err: %v)", resp, err)
+	}
+	baseKey, err := base64.StdEncoding.DecodeString(resp.Data["keys"].(map[string]string)["1"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	contextBytes, _ := base64.StdEncoding.DecodeString(context)
+	seed := make([]byte, ed25519.SeedSize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, baseKey, nil, contextBytes), seed); err != nil {
+		t.Fatal(err)
+	}
+	expected := ed25519.NewKeyFromSeed(seed).Public().(ed25519.PublicKey)
+	pubKey, err := base64.StdEncoding.DecodeString(pubKeyB64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(pubKey, expected) {
+		t.Fatalf("expected derived public key %x, got %x", expected, pubKey)
+	}
+
+	// Signatures made with the context verify offline against the key
+	input := "dGhlIHF1aWNrIGJyb3duIGZveA=="
+	req.Operation = logical.UpdateOperation
+	req.Path = "sign/ed"
+	req.Data = map[string]interface{}{
+		"input":   input,
+		"context": context,
+	}
+	resp, err = b.HandleRequest(req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("bad sign: %#v (err: %v)", resp, err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(resp.Data["signature"].(string), "vault:v1:"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	inputBytes, _ := base64.StdEncoding.DecodeString(input)
+	if !ed25519.Verify(pubKey, inputBytes, sig) {
+		t.Fatal("signature did not verify against the derived public key")
+	}
+}
+
+func TestTransit_ListKeysPaginated(t *testing.T) {
+	b, storage := createTestBackend(t)
+
+	for _, name := range []string{"e", "c", "a", "d", "b"} {
+		req := &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "keys/" + name,
+		}
+		if _, err := b.HandleRequest(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	list := func(data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(&logical.Request{
+			Storage:   storage,
+			Operation: logical.ListOperation,
+			Path:      "keys/",
+			Data:      data,
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("bad list: %#v (err: %v)", resp, err)
+		}
+		return resp
+	}
+
+	if resp := list(nil); len(resp.Data["keys"].([]string)) != 5 || resp.Data["next"] != nil {
+		t.Fatalf("bad unpaginated list: %#v", resp.Data)
+	}
+
+	var pages []string
+	after := ""
+	for {
+		resp := list(map[string]interface{}{
+			"limit": 2,
+			"after": after,
+		})
+		pages = append(pages, strings.Join(resp.Data["keys"].([]string), ","))
+		next, ok := resp.Data["next"].(string)
+		if !ok {
+			break
+		}
+		after = next
+	}
+	if strings.Join(pages, "|") != "a,b|c,d|e" {
+		t.Fatalf("bad pages: %v", pages)
+	}
+
+	resp := list(map[string]interface{}{
+		"after":    "c",
+		"detailed": true,
+	})
+	if strings.Join(resp.Data["keys"].([]string), ",") != "d,e" || len(resp.Data["key_info"].(map[string]interface{})) != 2 {
+		t.Fatalf("bad detailed page: %#v", resp.Data)
+	}
+
+	resp, err := b.HandleRequest(&logical.Request{
+		Storage:   storage,
+		Operation: logical.ListOperation,
+		Path:      "keys/",
+		Data: map[string]interface{}{
+			"limit": -1,
+		},
+	})
+	if err == nil && (resp == nil || !resp.IsError()) {
+		t.Fatal("expected error for a negative limit")
+	}
+}
+
+func TestTransit_ReadKeyWithoutPublicKey(t *testing.T) {
+	b, storage := createTestBackend(t)
+
+	for _, keyType := range []string{"rsa-2048", "ecdsa-p256", "ed25519"} {
+		req := &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "keys/" + keyType,
+			Data: map[string]interface{}{
+				"type": keyType,
+			},
+		}
+		if _, err := b.HandleRequest(req); err != nil {
+			t.Fatal(err)
+		}
+
+		read := func(data map[string]interface{}) map[string]interface{} {
+			resp, err := b.HandleRequest(&logical.Request{
+				Storage:   storage,
+				Operation: logical.ReadOperation,
+				Path:      "keys/" + keyType,
+				Data:      data,
+			})
+			if err != nil || resp == nil || resp.IsError() {
+				t.Fatalf("%s: bad read: %#v (err: %v)", keyType, resp, err)
+			}
+			return resp.Data["keys"].(map[string]map[string]interface{})["1"]
+		}
+
+		withKey := read(nil)
+		if withKey["public_key"] == "" || withKey["public_key"] == nil {
+			t.Fatalf("%s: expected public key by default, got %#v", keyType, withKey)
+		}
+
+		withoutKey := read(map[string]interface{}{
+			"show_public_key": false,
+		})
+		if _, ok := withoutKey["public_key"]; ok {
+			t.Fatalf("%s: expected no public key, got %#v", keyType, withoutKey)
+		}
+		delete(withKey, "public_key")
+		if !reflect.DeepEqual(withKey, withoutKey) {
+			t.Fatalf("%s: expected responses to differ only in the public key:\n%#v\n%#v", keyType, withKey, withoutKey)
+		}
+	}
+}
+
+func TestTransit_ReadDerivedKeyWithoutContext(t *testing.T) {
+	b, storage := createTestBackend(t)
+
+	read := func(name string) *logical.Response {
+		resp, err := b.HandleRequest(&logical.Request{
+			Storage:   storage,
+			Operation: logical.ReadOperation,
+			Path:      "keys/" + name,
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("%s: bad read: %#v (err: %v)", name, resp, err)
+		}
+		return resp
+	}
+
+	for _, keyType := range []string{"aes256-gcm96", "ed25519"} {
+		for _, derived := range []bool{false, true} {
+			name := keyType + "-" + strconv.FormatBool(derived)
+			_, err := b.HandleRequest(&logical.Request{
+				Storage:   storage,
+				Operation: logical.UpdateOperation,
+				Path:      "keys/" + name,
+				Data: map[string]interface{}{
+					"type":    keyType,
+					"derived": derived,
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			resp := read(name)
+			if !derived {
+				if len(resp.Warnings) != 0 {
+					t.Fatalf("%s: unexpected warnings: %v", name, resp.Warnings)
+				}
+				continue
+			}
+			if len(resp.Warnings) != 1 || !strings.Contains(resp.Warnings[0], "requires a derivation context") {
+				t.Fatalf("%s: expected derivation context warning, got %v", name, resp.Warnings)
+			}
+			if keyType == "ed25519" {
+				keys := resp.Data["keys"].(map[string]map[string]interface{})
+				if _, ok := keys["1"]["public_key"]; ok {
+					t.Fatalf("%s: expected no public key without a context: %#v", name, keys)
+				}
+
+				// Asking for the public key explicitly fails instead
+				resp, err := b.HandleRequest(&logical.Request{
+					Storage:   storage,
+					Operation: logical.ReadOperation,
+					Path:      "keys/" + name,
+					Data: map[string]interface{}{
+						"show_public_key": true,
+					},
+				})
+				if err != logical.ErrInvalidRequest || resp == nil || !resp.IsError() {
+					t.Fatalf("%s: expected error, got %#v (err: %v)", name, resp, err)
+				}
+				if errStr := resp.Data["error"].(string); !strings.Contains(errStr, "requires a derivation context") {
+					t.Fatalf("%s: expected derivation context error, got %q", name, errStr)
+				}
+
+				// Leaving the public key out explicitly only warns
+				resp, err = b.HandleRequest(&logical.Request{
+					Storage:   storage,
+					Operation: logical.ReadOperation,
+					Path:      "keys/" + name,
+					Data: map[string]interface{}{
+						"show_public_key": false,
+					},
+				})
+				if err != nil || resp == nil || resp.IsError() {
+					t.Fatalf("%s: bad read: %#v (err: %v)", name, resp, err)
+				}
+				if len(resp.Warnings) != 1 || !strings.Contains(resp.Warnings[0], "requires a derivation context") {
+					t.Fatalf("%s: expected derivation context warning, got %v", name, resp.Warnings)
+				}
+			}
+		}
+	}
+
+	// Operations on a derived key without a context fail with a clear error
+	resp, err := b.HandleRequest(&logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "encrypt/aes256-gcm96-true",
+		Data: map[string]interface{}{
+			"plaintext": "dGhlIHF1aWNrIGJyb3duIGZveA==",
+		},
+	})
+	if err != logical.ErrInvalidRequest || resp == nil || !resp.IsError() {
+		t.Fatalf("expected error, got %#v (err: %v)", resp, err)
+	}
+	if errStr := resp.Data["error"].(string); !strings.Contains(errStr, "missing 'context'") {
+		t.Fatalf("expected missing context error, got %q", errStr)
+	}
+}
+
+func TestTransit_ReadDerivedKeyURLSafeContext(t *testing.T) {
+	b, storage := createTestBackend(t)
+
+	_, err := b.HandleRequest(&logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "keys/derived",
+		Data: map[string]interface{}{
+			"derived": true,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	readFingerprint := func(context string) string {
+		resp, err := b.HandleRequest(&logical.Request{
+			Storage:   storage,
+			Operation: logical.ReadOperation,
+			Path:      "keys/derived",
+			Data: map[string]interface{}{
+				"context": context,
+			},
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("%q: bad read: %#v (err: %v)", context, resp, err)
+		}
+		return resp.Data["derived_key_fingerprints"].(map[string]string)["1"]
+	}
+
+	// These bytes encode differently in the standard and URL-safe alphabets
+	// and need padding
+	context := []byte{0xfb, 0xff, 0xbf, 0xfe}
+	expected := readFingerprint(base64.StdEncoding.EncodeToString(context))
+	for _, encoding := range []*base64.Encoding{base64.URLEncoding, base64.RawURLEncoding, base64.RawStdEncoding} {
+		encoded := encoding.EncodeToString(context)
+		if fingerprint := readFingerprint(encoded); fingerprint != expected {
+			t.Fatalf("%q: expected fingerprint %s, got %s", encoded, expected, fingerprint)
+		}
+	}
+
+	resp, err := b.HandleRequest(&logical.Request{
+		Storage:   storage,
+		Operation: logical.ReadOperation,
+		Path:      "keys/derived",
+		Data: map[string]interface{}{
+			"context": "not base64!",
+		},
+	})
+	if err != logical.ErrInvalidRequest || resp == nil || !resp.IsError() {
+		t.Fatalf("expected error for an invalid context, got %#v (err: %v)", resp, err)
+	}
+}
+
+func TestTransit_KeyTags(t *testing.T) {
+	b, storage := createTestBackend(t)
+
+	readTags := func(name string) map[string]string {
+		return mustHandle(t, b, storage, logical.ReadOperation, "keys/"+name, nil).Data["tags"].(map[string]string)
+	}
+	listTags := func(tags interface{}) []string {
+		resp := mustHandle(t, b, storage, logical.ListOperation, "keys/", map[string]interface{}{
+			"tags": tags,
+		})
+		keys, _ := resp.Data["keys"].([]string)
+		sort.Strings(keys)
+		return keys
+	}
+
+	// Tags may be given as a map or as a list of key=value pairs
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/payments-prod", map[string]interface{}{
+		"tags": map[string]interface{}{
+			"team": "payments",
+			"env":  "prod",
+		},
+	})
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/payments-dev", map[string]interface{}{
+		"tags": []string{"team=payments", "env=dev"},
+	})
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/untagged", nil)
+
+	if tags := readTags("payments-prod"); !reflect.DeepEqual(tags, map[string]string{"team": "payments", "env": "prod"}) {
+		t.Fatalf("bad tags: %#v", tags)
+	}
+	if tags := readTags("payments-dev"); !reflect.DeepEqual(tags, map[string]string{"team": "payments", "env": "dev"}) {
+		t.Fatalf("bad tags: %#v", tags)
+	}
+	if tags := readTags("untagged"); len(tags) != 0 {
+		t.Fatalf("expected no tags, got %#v", tags)
+	}
+
+	if keys := listTags("team=payments"); !reflect.DeepEqual(keys, []string{"payments-dev", "payments-prod"}) {
+		t.Fatalf("bad filtered list: %v", keys)
+	}
+	if keys := listTags([]string{"team=payments", "env=prod"}); !reflect.DeepEqual(keys, []string{"payments-prod"}) {
+		t.Fatalf("bad filtered list: %v", keys)
+	}
+	if keys := listTags("team=other"); len(keys) != 0 {
+		t.Fatalf("expected no keys, got %v", keys)
+	}
+
+	resp := mustHandle(t, b, storage, logical.ListOperation, "keys/", map[string]interface{}{
+		"detailed": true,
+		"tags":     "env=dev",
+	})
+	keyInfo := resp.Data["key_info"].(map[string]interface{})
+	if len(keyInfo) != 1 || keyInfo["payments-dev"].(map[string]interface{})["tags"].(map[string]string)["env"] != "dev" {
+		t.Fatalf("bad detailed filtered list: %#v", keyInfo)
+	}
+
+	// Updating the tags replaces them and does not affect the key material
+	ciphertext := mustHandle(t, b, storage, logical.UpdateOperation, "encrypt/payments-prod", map[string]interface{}{
+		"plaintext": "dGhlIHF1aWNrIGJyb3duIGZveA==",
+	}).Data["ciphertext"].(string)
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/payments-prod/config", map[string]interface{}{
+		"tags": "team=billing",
+	})
+	if tags := readTags("payments-prod"); !reflect.DeepEqual(tags, map[string]string{"team": "billing"}) {
+		t.Fatalf("bad updated tags: %#v", tags)
+	}
+	resp = mustHandle(t, b, storage, logical.UpdateOperation, "decrypt/payments-prod", map[string]interface{}{
+		"ciphertext": ciphertext,
+	})
+	if resp.Data["plaintext"] != "dGhlIHF1aWNrIGJyb3duIGZveA==" {
+		t.Fatalf("bad plaintext after updating tags: %#v", resp.Data)
+	}
+
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/payments-prod/config", map[string]interface{}{
+		"tags": map[string]interface{}{},
+	})
+	if tags := readTags("payments-prod"); len(tags) != 0 {
+		t.Fatalf("expected tags to be removed, got %#v", tags)
+	}
+
+	resp, err := b.HandleRequest(&logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "keys/bad",
+		Data: map[string]interface{}{
+			"tags": map[string]interface{}{"": "value"},
+		},
+	})
+	if err == nil && (resp == nil || !resp.IsError()) {
+		t.Fatal("expected error for an empty tag name")
+	}
+}
+
+func TestTransit_KeyDescription(t *testing.T) {
+	b, storage := createTestBackend(t)
+
+	doErrReq := func(path string, data map[string]interface{}) {
+		resp, err := b.HandleRequest(&logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Data:      data,
+		})
+		if err != logical.ErrInvalidRequest || resp == nil || !strings.Contains(resp.Data["error"].(string), "1024 bytes") {
+			t.Fatalf("%s: expected length error, got %#v (err: %v)", path, resp, err)
+		}
+	}
+	readDescription := func(name string) interface{} {
+		return mustHandle(t, b, storage, logical.ReadOperation, "keys/"+name, nil).Data["description"]
+	}
+
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/described", map[string]interface{}{
+		"description": "Encrypts card numbers for the payments service",
+	})
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/plain", nil)
+	if description := readDescription("described"); description != "Encrypts card numbers for the payments service" {
+		t.Fatalf("bad description: %#v", description)
+	}
+	if description := readDescription("plain"); description != "" {
+		t.Fatalf("expected no description, got %#v", description)
+	}
+
+	// Updating the description does not affect the key material
+	ciphertext := mustHandle(t, b, storage, logical.UpdateOperation, "encrypt/described", map[string]interface{}{
+		"plaintext": "dGhlIHF1aWNrIGJyb3duIGZveA==",
+	}).Data["ciphertext"].(string)
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/described/config", map[string]interface{}{
+		"description": "Encrypts card numbers",
+	})
+	if description := readDescription("described"); description != "Encrypts card numbers" {
+		t.Fatalf("bad updated description: %#v", description)
+	}
+	resp := mustHandle(t, b, storage, logical.UpdateOperation, "decrypt/described", map[string]interface{}{
+		"ciphertext": ciphertext,
+	})
+	if resp.Data["plaintext"] != "dGhlIHF1aWNrIGJyb3duIGZveA==" {
+		t.Fatalf("bad plaintext after updating the description: %#v", resp.Data)
+	}
+
+	// Changing other settings leaves the description alone
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/described/config", map[string]interface{}{
+		"deletion_allowed": true,
+	})
+	if description := readDescription("described"); description != "Encrypts card numbers" {
+		t.Fatalf("description changed: %#v", description)
+	}
+
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/described/config", map[string]interface{}{
+		"description": "",
+	})
+	if description := readDescription("described"); description != "" {
+		t.Fatalf("expected description to be removed, got %#v", description)
+	}
+
+	long := strings.Repeat("a", 1025)
+	doErrReq("keys/long", map[string]interface{}{"description": long})
+	doErrReq("keys/plain/config", map[string]interface{}{"description": long})
+	if resp := mustHandle(t, b, storage, logical.ReadOperation, "keys/long", nil); resp != nil {
+		t.Fatalf("expected key not to be created, got %#v", resp)
+	}
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/plain/config", map[string]interface{}{
+		"description": long[:1024],
+	})
+}
+
+func TestTransit_ReadVersionCount(t *testing.T) {
+	b, storage := createTestBackend(t)
+
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo", nil)
+	resp := mustHandle(t, b, storage, logical.ReadOperation, "keys/foo", nil)
+	if resp.Data["version_count"] != 1 {
+		t.Fatalf("bad version count: %#v", resp.Data["version_count"])
+	}
+	if _, ok := resp.Data["archive_bytes"]; ok {
+		t.Fatalf("expected no archive bytes by default: %#v", resp.Data)
+	}
+
+	showArchiveBytes := map[string]interface{}{
+		"show_archive_bytes": true,
+	}
+	lastSize := mustHandle(t, b, storage, logical.ReadOperation, "keys/foo", showArchiveBytes).Data["archive_bytes"].(int)
+	if lastSize <= 0 {
+		t.Fatalf("bad archive bytes: %d", lastSize)
+	}
+
+	for rotations := 1; rotations <= 3; rotations++ {
+		mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo/rotate", nil)
+		resp = mustHandle(t, b, storage, logical.ReadOperation, "keys/foo", showArchiveBytes)
+		if resp.Data["version_count"] != rotations+1 {
+			t.Fatalf("expected %d versions after %d rotations, got %#v", rotations+1, rotations, resp.Data["version_count"])
+		}
+		size := resp.Data["archive_bytes"].(int)
+		if size <= lastSize {
+			t.Fatalf("expected archive bytes to grow on rotation, got %d after %d", size, lastSize)
+		}
+		lastSize = size
+	}
+}
+
+func TestTransit_ReadETag(t *testing.T) {
+	b, storage := createTestBackend(t)
+
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo", map[string]interface{}{
+		"type": "ecdsa-p256",
+	})
+	resp := mustHandle(t, b, storage, logical.ReadOperation, "keys/foo", nil)
+	etag, _ := resp.Data["etag"].(string)
+	if etag == "" || resp.Data["not_modified"] != false {
+		t.Fatalf("bad etag in full response: %#v", resp.Data)
+	}
+	if _, ok := resp.Data["keys"]; !ok {
+		t.Fatalf("expected keys in full response: %#v", resp.Data)
+	}
+
+	// A matching etag returns only the compact response
+	resp = mustHandle(t, b, storage, logical.ReadOperation, "keys/foo", map[string]interface{}{
+		"etag": etag,
+	})
+	if resp.Data["not_modified"] != true || resp.Data["etag"] != etag || resp.Data["latest_version"] != 1 {
+		t.Fatalf("bad compact response: %#v", resp.Data)
+	}
+	for _, field := range []string{"keys", "creation_times", "exportable_versions", "tags"} {
+		if _, ok := resp.Data[field]; ok {
+			t.Fatalf("expected %s to be omitted from compact response: %#v", field, resp.Data)
+		}
+	}
+
+	// Configuration not affecting the versions keeps the etag
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo/config", map[string]interface{}{
+		"deletion_allowed": true,
+	})
+	if resp = mustHandle(t, b, storage, logical.ReadOperation, "keys/foo", nil); resp.Data["etag"] != etag {
+		t.Fatalf("expected etag to be unchanged, got %#v", resp.Data["etag"])
+	}
+
+	// Rotating or changing the minimum versions changes the etag, and the
+	// stale etag then returns the full response
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo/rotate", nil)
+	resp = mustHandle(t, b, storage, logical.ReadOperation, "keys/foo", map[string]interface{}{
+		"etag": etag,
+	})
+	rotatedETag, _ := resp.Data["etag"].(string)
+	if resp.Data["not_modified"] != false || rotatedETag == etag {
+		t.Fatalf("expected full response with a new etag after rotation: %#v", resp.Data)
+	}
+	if _, ok := resp.Data["keys"]; !ok {
+		t.Fatalf("expected keys in full response: %#v", resp.Data)
+	}
+
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo/config", map[string]interface{}{
+		"min_decryption_version": 2,
+	})
+	resp = mustHandle(t, b, storage, logical.ReadOperation, "keys/foo", map[string]interface{}{
+		"etag": rotatedETag,
+	})
+	if resp.Data["not_modified"] != false || resp.Data["etag"] == rotatedETag || resp.Data["etag"] == etag {
+		t.Fatalf("expected full response with a new etag after changing min decryption version: %#v", resp.Data)
+	}
+}
+
+func TestTransit_CreateWithNumVersions(t *testing.T) {
+	b, storage := createTestBackend(t)
+
+	for _, numVersions := range []int{0, -1, 101, 1000000} {
+		resp, err := b.HandleRequest(&logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "keys/foo",
+			Data: map[string]interface{}{
+				"num_versions": numVersions,
+			},
+		})
+		if err != logical.ErrInvalidRequest || resp == nil || !resp.IsError() {
+			t.Fatalf("num_versions %d: expected error, got %#v (err: %v)", numVersions, resp, err)
+		}
+	}
+	if resp := mustHandle(t, b, storage, logical.ReadOperation, "keys/foo", nil); resp != nil {
+		t.Fatalf("expected no key to be created, got %#v", resp)
+	}
+
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo", map[string]interface{}{
+		"num_versions":          3,
+		"allow_export_versions": "2",
+	})
+	resp := mustHandle(t, b, storage, logical.ReadOperation, "keys/foo", nil)
+	if resp.Data["latest_version"] != 3 || resp.Data["version_count"] != 3 || resp.Data["min_decryption_version"] != 1 {
+		t.Fatalf("bad versions: %#v", resp.Data)
+	}
+	if !reflect.DeepEqual(resp.Data["exportable_versions"], []int{2}) {
+		t.Fatalf("bad exportable versions: %#v", resp.Data["exportable_versions"])
+	}
+
+	ciphertext := mustHandle(t, b, storage, logical.UpdateOperation, "encrypt/foo", map[string]interface{}{
+		"plaintext": "dGhlIHF1aWNrIGJyb3duIGZveA==",
+	}).Data["ciphertext"].(string)
+	if !strings.HasPrefix(ciphertext, "vault:v3:") {
+		t.Fatalf("expected ciphertext of version 3, got %q", ciphertext)
+	}
+
+	// Existing keys are not rotated
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo", map[string]interface{}{
+		"num_versions": 5,
+	})
+	if resp = mustHandle(t, b, storage, logical.ReadOperation, "keys/foo", nil); resp.Data["latest_version"] != 3 {
+		t.Fatalf("expected existing key to keep latest version 3: %#v", resp.Data)
+	}
+
+	// Versions beyond those created cannot be made exportable
+	resp, _ = b.HandleRequest(&logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "keys/bar",
+		Data: map[string]interface{}{
+			"num_versions":          2,
+			"allow_export_versions": "3",
+		},
+	})
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected error, got %#v", resp)
+	}
+}
+
+func TestTransit_CreateKeyInvalidInput(t *testing.T) {
+	b, storage := createTestBackend(t)
+
+	for _, data := range []map[string]interface{}{
+		{"convergent_encryption": true},
+		{"auto_rotate_period": 60},
+		{"allow_export_versions": "x"},
+		{"allow_export_versions": "2"},
+		{"max_versions": -1},
+		{"num_versions": 0},
+		{"convergent_version": 1},
+	} {
+		resp, err := b.HandleRequest(&logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "keys/foo",
+			Data:      data,
+		})
+		if err != logical.ErrInvalidRequest || resp == nil || !resp.IsError() {
+			t.Fatalf("%v: expected an invalid request error, got %#v (err: %v)", data, resp, err)
+		}
+	}
+}
+
+func TestTransit_CreateWithEntropySource(t *testing.T) {
+	b, storage := createTestBackend(t)
+
+	// Keys use the platform's randomness by default
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/default", nil)
+	if resp := mustHandle(t, b, storage, logical.ReadOperation, "keys/default", nil); resp.Data["entropy_source"] != "platform" {
+		t.Fatalf("bad default entropy source: %#v", resp.Data)
+	}
+
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/platform", map[string]interface{}{
+		"type":           "ecdsa-p256",
+		"entropy_source": "platform",
+	})
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/platform/rotate", nil)
+	if resp := mustHandle(t, b, storage, logical.ReadOperation, "keys/platform", nil); resp.Data["entropy_source"] != "platform" || resp.Data["latest_version"] != 2 {
+		t.Fatalf("bad entropy source: %#v", resp.Data)
+	}
+
+	for source, expected := range map[string]string{
+		"seal":   "not supported by this build",
+		"chance": "unknown entropy source",
+	} {
+		resp, err := b.HandleRequest(&logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "keys/" + source,
+			Data: map[string]interface{}{
+				"entropy_source": source,
+			},
+		})
+		if err != logical.ErrInvalidRequest || resp == nil || !strings.Contains(resp.Data["error"].(string), expected) {
+			t.Fatalf("entropy source %s: expected error containing %q, got %#v (err: %v)", source, expected, resp, err)
+		}
+		if resp := mustHandle(t, b, storage, logical.ReadOperation, "keys/"+source, nil); resp != nil {
+			t.Fatalf("entropy source %s: expected key not to be created, got %#v", source, resp)
+		}
+	}
+}
+
+func TestTransit_ReadKeyStatus(t *testing.T) {
+	b, storage := createTestBackend(t)
+
+	// read returns the response of reading the key along with the HTTP status
+	// code it is served with
+	read := func(name string) (*logical.Response, int, error) {
+		req := &logical.Request{
+			Storage:   storage,
+			Operation: logical.ReadOperation,
+			Path:      "keys/" + name,
+		}
+		resp, err := b.HandleRequest(req)
+		status, _ := logical.RespondErrorCommon(req, resp, err)
+		if status == 0 {
+			status = http.StatusOK
+		}
+		return resp, status, err
+	}
+
+	for _, name := range []string{"current", "disabled", "future"} {
+		mustHandle(t, b, storage, logical.UpdateOperation, "keys/"+name, nil)
+	}
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/disabled/config", map[string]interface{}{
+		"enabled": false,
+	})
+
+	// Simulate a key created by a newer version of Vault with a type this
+	// version does not know about
+	entry, err := storage.Get("policy/future")
+	if err != nil || entry == nil {
+		t.Fatalf("failed to read stored policy: %v", err)
+	}
+	var stored map[string]interface{}
+	if err := json.Unmarshal(entry.Value, &stored); err != nil {
+		t.Fatal(err)
+	}
+	stored["type"] = 100
+	entry.Value, err = json.Marshal(stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Put(entry); err != nil {
+		t.Fatal(err)
+	}
+	b.InvalidateKey("policy/future")
+
+	resp, status, err := read("missing")
+	if status != http.StatusNotFound || resp != nil {
+		t.Fatalf("expected missing key not to be found, got status %d\nresp: %#v (err: %v)", status, resp, err)
+	}
+
+	// Keys with an unsupported type exist, so they are reported as unusable
+	// rather than missing, without any partial data
+	resp, status, err = read("future")
+	if status != http.StatusBadRequest || err != logical.ErrInvalidRequest || resp == nil || !resp.IsError() {
+		t.Fatalf("expected unsupported type error, got status %d\nresp: %#v (err: %v)", status, resp, err)
+	}
+	if msg := resp.Data["error"].(string); !strings.Contains(msg, "type 100") || !strings.Contains(msg, "not supported by this version") {
+		t.Fatalf("bad unsupported type error: %q", msg)
+	}
+	if len(resp.Data) != 1 {
+		t.Fatalf("expected no partial data, got %#v", resp.Data)
+	}
+
+	// Disabled keys can still be read so that they can be enabled again, but
+	// the response says that they cannot be used
+	resp, status, err = read("disabled")
+	if status != http.StatusOK || resp == nil || resp.Data["enabled"] != false {
+		t.Fatalf("expected disabled key to be readable, got status %d\nresp: %#v (err: %v)", status, resp, err)
+	}
+	if len(resp.Warnings) != 1 || !strings.Contains(resp.Warnings[0], "key disabled is disabled") {
+		t.Fatalf("expected disabled warning, got %#v", resp.Warnings)
+	}
+
+	resp, status, err = read("current")
+	if status != http.StatusOK || resp == nil || resp.Data["type"] != "aes256-gcm96" || len(resp.Warnings) != 0 {
+		t.Fatalf("bad read of usable key: status %d\nresp: %#v (err: %v)", status, resp, err)
+	}
+}
+
+func TestTransit_FIPSCompliant(t *testing.T) {
+	b, storage := createTestBackend(t)
+
+	expected := map[string]bool{
+		"aes":        true,
+		"ecdsa":      true,
+		"rsa":        true,
+		"derived":    true,
+		"ed25519":    false,
+		"chacha":     false,
+		"convergent": false,
+	}
+	for name, data := range map[string]map[string]interface{}{
+		"aes":     {"type": "aes256-gcm96"},
+		"ecdsa":   {"type": "ecdsa-p384"},
+		"rsa":     {"type": "rsa-2048"},
+		"derived": {"type": "aes256-gcm96", "derived": true},
+		"ed25519": {"type": "ed25519"},
+		"chacha":  {"type": "chacha20-poly1305"},
+		"convergent": {
+			"type":                  "aes256-gcm96",
+			"derived":               true,
+			"convergent_encryption": true,
+		},
+	} {
+		mustHandle(t, b, storage, logical.UpdateOperation, "keys/"+name, data)
+	}
+
+	for name, compliant := range expected {
+		if resp := mustHandle(t, b, storage, logical.ReadOperation, "keys/"+name, nil); resp.Data["fips_compliant"] != compliant {
+			t.Fatalf("%s: expected fips_compliant to be %v, got %#v", name, compliant, resp.Data["fips_compliant"])
+		}
+	}
+
+	// Detailed lists report compliance so that keys can be checked in bulk
+	resp := mustHandle(t, b, storage, logical.ListOperation, "keys/", map[string]interface{}{
+		"detailed": true,
+	})
+	keyInfo := resp.Data["key_info"].(map[string]interface{})
+	for name, compliant := range expected {
+		if info := keyInfo[name].(map[string]interface{}); info["fips_compliant"] != compliant {
+			t.Fatalf("%s: expected fips_compliant to be %v in the list, got %#v", name, compliant, info)
+		}
+	}
+}
+
+func TestTransit_RotationHistory(t *testing.T) {
+	b, storage := createTestBackend(t)
+
+	readHistory := func() []int {
+		resp := mustHandle(t, b, storage, logical.ReadOperation, "keys/foo", map[string]interface{}{
+			"rotation_history": true,
+		})
+		var versions []int
+		var last time.Time
+		for _, entry := range resp.Data["rotation_history"].([]map[string]interface{}) {
+			creationTime, err := time.Parse(time.RFC3339, entry["creation_time"].(string))
+			if err != nil || creationTime.Before(last) {
+				t.Fatalf("bad creation time: %#v", entry)
+			}
+			last = creationTime
+			versions = append(versions, entry["version"].(int))
+		}
+		return versions
+	}
+
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo", nil)
+	if resp := mustHandle(t, b, storage, logical.ReadOperation, "keys/foo", nil); resp.Data["rotation_history"] != nil {
+		t.Fatalf("expected no rotation history by default: %#v", resp.Data)
+	}
+
+	// Each rotation adds an entry after the one for the creation of the key
+	for i := 0; i < 3; i++ {
+		mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo/rotate", nil)
+	}
+	if versions := readHistory(); !reflect.DeepEqual(versions, []int{1, 2, 3, 4}) {
+		t.Fatalf("bad rotation history: %v", versions)
+	}
+
+	// Versions that can no longer be used are not live
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo/config", map[string]interface{}{
+		"min_decryption_version": 2,
+	})
+	if versions := readHistory(); !reflect.DeepEqual(versions, []int{2, 3, 4}) {
+		t.Fatalf("bad rotation history after raising min decryption version: %v", versions)
+	}
+}
+
+func TestTransit_KeyCreatedBy(t *testing.T) {
+	b, storage := createTestBackend(t)
+
+	doReq := func(b logical.Backend, req *logical.Request) *logical.Response {
+		req.Storage = storage
+		resp, err := b.HandleRequest(req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("%s: got err:\n%#v\nresp:\n%#v\n", req.Path, err, resp)
+		}
+		return resp
+	}
+	readCreatedBy := func(b logical.Backend, name string) interface{} {
+		resp := doReq(b, &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "keys/" + name,
+		})
+		return resp.Data["created_by"]
+	}
+
+	doReq(b, &logical.Request{
+		Operation:   logical.UpdateOperation,
+		Path:        "keys/entity",
+		EntityID:    "entity-1",
+		DisplayName: "token-alice",
+	})
+	doReq(b, &logical.Request{
+		Operation:   logical.UpdateOperation,
+		Path:        "keys/token",
+		DisplayName: "token-alice",
+	})
+	doReq(b, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "keys/anonymous",
+	})
+	expected := map[string]string{
+		"entity":    "entity-1",
+		"token":     "token-alice",
+		"anonymous": "",
+	}
+	for name, createdBy := range expected {
+		if actual := readCreatedBy(b, name); actual != createdBy {
+			t.Fatalf("%s: expected created_by %q, got %#v", name, createdBy, actual)
+		}
+	}
+
+	// Neither rotating nor writing the key again under another identity
+	// changes the creator
+	doReq(b, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "keys/entity/rotate",
+		EntityID:  "entity-2",
+	})
+	doReq(b, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "keys/entity",
+		EntityID:  "entity-2",
+	})
+	if actual := readCreatedBy(b, "entity"); actual != "entity-1" {
+		t.Fatalf("expected created_by to be unchanged, got %#v", actual)
+	}
+
+	// The creator is persisted with the key
+	config := logical.TestBackendConfig()
+	config.StorageView = storage
+	reloaded, err := transit.Factory(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := readCreatedBy(reloaded, "entity"); actual != "entity-1" {
+		t.Fatalf("expected created_by to persist, got %#v", actual)
+	}
+}
+
+func TestTransit_ReadKeyAPIVersion(t *testing.T) {
+	b, storage := createTestBackend(t)
+
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo", map[string]interface{}{
+		"type":         "ecdsa-p256",
+		"num_versions": 2,
+	})
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo/config", map[string]interface{}{
+		"disabled_encryption_versions": "1",
+	})
+
+	// The legacy format is the default
+	legacy := mustHandle(t, b, storage, logical.ReadOperation, "keys/foo", nil).Data
+	if explicit := mustHandle(t, b, storage, logical.ReadOperation, "keys/foo", map[string]interface{}{
+		"api_version": 1,
+	}).Data; !reflect.DeepEqual(explicit, legacy) {
+		t.Fatalf("expected the default format to be version 1:\n%#v\n%#v", legacy, explicit)
+	}
+	for _, field := range []string{"keys", "creation_times", "version_algorithms", "exportable_versions", "disabled_encryption_versions", "disabled_decryption_versions"} {
+		if _, ok := legacy[field]; !ok {
+			t.Fatalf("legacy format is missing %s: %#v", field, legacy)
+		}
+	}
+	if _, ok := legacy["versions"]; ok {
+		t.Fatalf("unexpected versions in legacy format: %#v", legacy)
+	}
+
+	nested := mustHandle(t, b, storage, logical.ReadOperation, "keys/foo", map[string]interface{}{
+		"api_version": 2,
+	}).Data
+	if nested["api_version"] != 2 || nested["name"] != "foo" || nested["latest_version"] != 2 {
+		t.Fatalf("bad nested format: %#v", nested)
+	}
+	for _, field := range []string{"keys", "creation_times", "version_algorithms", "exportable_versions", "disabled_encryption_versions", "disabled_decryption_versions"} {
+		if _, ok := nested[field]; ok {
+			t.Fatalf("unexpected %s in nested format: %#v", field, nested)
+		}
+	}
+
+	// The nested format holds the same information per version
+	versions := nested["versions"].(map[string]map[string]interface{})
+	if len(versions) != 2 {
+		t.Fatalf("bad versions: %#v", versions)
+	}
+	legacyKeys := legacy["keys"].(map[string]map[string]interface{})
+	for _, ver := range []string{"1", "2"} {
+		expected := map[string]interface{}{
+			"creation_time":       legacy["creation_times"].(map[string]string)[ver],
+			"algorithm":           legacy["version_algorithms"].(map[string]string)[ver],
+			"exportable":          false,
+			"encryption_disabled": ver == "1",
+			"decryption_disabled": false,
+			"public_key":          legacyKeys[ver]["public_key"],
+		}
+		if !reflect.DeepEqual(versions[ver], expected) {
+			t.Fatalf("version %s: expected %#v, got %#v", ver, expected, versions[ver])
+		}
+	}
+
+	resp, err := b.HandleRequest(&logical.Request{
+		Storage:   storage,
+		Operation: logical.ReadOperation,
+		Path:      "keys/foo",
+		Data: map[string]interface{}{
+			"api_version": 3,
+		},
+	})
+	if err != logical.ErrInvalidRequest || resp == nil || !resp.IsError() {
+		t.Fatalf("expected unsupported api version to be rejected, got %#v (err: %v)", resp, err)
+	}
+}
+
+// reverseListStorage returns listed entries in reverse order, like storage
+// backends that do not sort them
+type reverseListStorage struct {
+	logical.Storage
+}
+
+func (s *reverseListStorage) List(prefix string) ([]string, error) {
+	entries, err := s.Storage.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(entries)))
+	return entries, nil
+}
+
+func TestTransit_ListKeysSorted(t *testing.T) {
+	b, storage := createTestBackend(t)
+	storage = &reverseListStorage{Storage: storage}
+
+	names := []string{"delta", "alpha", "charlie", "bravo"}
+	for _, name := range names {
+		if _, err := b.HandleRequest(&logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "keys/" + name,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := []string{"alpha", "bravo", "charlie", "delta"}
+	for _, data := range []map[string]interface{}{
+		nil,
+		{"detailed": true},
+		{"detailed": true, "derived": false},
+	} {
+		resp, err := b.HandleRequest(&logical.Request{
+			Storage:   storage,
+			Operation: logical.ListOperation,
+			Path:      "keys",
+			Data:      data,
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("got err: %v resp: %#v", err, resp)
+		}
+		if keys := resp.Data["keys"]; !reflect.DeepEqual(keys, expected) {
+			t.Fatalf("%v: expected sorted keys %v, got %v", data, expected, keys)
+		}
+	}
+}
@@ -0,0 +1,313 @@
+package transit
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	stded25519 "crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/cloudflare/circl/sign/ed448"
+	"github.com/hashicorp/vault/helper/errutil"
+	"github.com/hashicorp/vault/helper/keysutil"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+const (
+	publicKeyFormatPEM  = "pem"
+	publicKeyFormatSPKI = "spki"
+	publicKeyFormatJWK  = "jwk"
+	publicKeyFormatSSH  = "ssh"
+)
+
+func (b *backend) pathPublicKey() *framework.Path {
+	return &framework.Path{
+		Pattern: "keys/" + framework.GenericNameRegex("name") + "/public",
+		Fields: map[string]*framework.FieldSchema{
+			"name": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "Name of the key",
+			},
+
+			"format": &framework.FieldSchema{
+				Type:    framework.TypeString,
+				Default: publicKeyFormatPEM,
+				Description: `Encoding of the returned public key: "pem" for a
+PEM-encoded SubjectPublicKeyInfo, "spki" for the
+base64-encoded DER SubjectPublicKeyInfo, "jwk"
+for a JSON Web Key, or "ssh" for the OpenSSH
+authorized_keys format, which is only supported
+for ed25519 and ecdsa keys. Defaults to "pem".`,
+			},
+
+			"version": &framework.FieldSchema{
+				Type: framework.TypeInt,
+				Description: `The version of the key to return the public key
+of. Defaults to the latest version.`,
+			},
+
+			"context": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `Base64 encoded context for key derivation.
+Required for derived ed25519 and ed448 keys.`,
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.requireEnabledKey(b.pathPublicKeyRead),
+		},
+
+		HelpSynopsis:    pathPublicKeyHelpSyn,
+		HelpDescription: pathPublicKeyHelpDesc,
+	}
+}
+
+func (b *backend) pathPublicKeyRead(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+	format := d.Get("format").(string)
+	ver := d.Get("version").(int)
+
+	switch format {
+	case publicKeyFormatPEM, publicKeyFormatSPKI, publicKeyFormatJWK, publicKeyFormatSSH:
+	default:
+		return logical.ErrorResponse(fmt.Sprintf("invalid public key format: %s", format)), logical.ErrInvalidRequest
+	}
+
+	p, lock, err := b.lm.GetPolicyShared(req.Storage, name)
+	if lock != nil {
+		defer lock.RUnlock()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return logical.ErrorResponse("key not found"), logical.ErrInvalidRequest
+	}
+	if !p.Type.SigningSupported() {
+		return logical.ErrorResponse(fmt.Sprintf("key type %s has no public key", p.Type)), logical.ErrInvalidRequest
+	}
+	if p.ManagedKeyName != "" {
+		return logical.ErrorResponse(managedPublicKeyError(p)), logical.ErrInvalidRequest
+	}
+	if format == publicKeyFormatSSH && !sshFormatSupported(p.Type) {
+		return logical.ErrorResponse(fmt.Sprintf("the ssh format is only supported for ed25519 and ecdsa keys, not %s", p.Type)), logical.ErrInvalidRequest
+	}
+
+	if ver == 0 {
+		ver = p.LatestVersion
+	}
+	entry, ok := p.Keys[ver]
+	if !ok || ver < p.MinDecryptionVersion {
+		return logical.ErrorResponse(fmt.Sprintf("version %d of the key does not exist or is below the min decryption version", ver)), logical.ErrInvalidRequest
+	}
+
+	var pubKey crypto.PublicKey
+	if p.Type.EdDSA() && p.Derived {
+		contextRaw := d.Get("context").(string)
+		if len(contextRaw) == 0 {
+			return logical.ErrorResponse("context is required to return the public key of a derived key"), logical.ErrInvalidRequest
+		}
+		context, err := decodeContext(contextRaw)
+		if err != nil {
+			return logical.ErrorResponse("failed to base64-decode context"), logical.ErrInvalidRequest
+		}
+		key, err := p.DeriveKey(context, ver)
+		if err != nil {
+			switch err.(type) {
+			case errutil.UserError:
+				return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+			default:
+				return nil, err
+			}
+		}
+		pubKey = edDSAPublicKey(p.Type, key)
+	} else {
+		pubKey = entryPublicKey(p, entry)
+	}
+
+	var formatted interface{}
+	switch format {
+	case publicKeyFormatJWK:
+		formatted = publicKeyJWK(fmt.Sprintf("%s:%d", p.Fingerprint, ver), pubKey)
+
+	case publicKeyFormatSSH:
+		sshKey, err := ssh.NewPublicKey(pubKey)
+		if err != nil {
+			return nil, fmt.Errorf("error converting public key to ssh format: %v", err)
+		}
+		formatted = strings.TrimSuffix(string(ssh.MarshalAuthorizedKey(sshKey)), "\n")
+
+	default:
+		der, err := marshalPublicKeySPKI(pubKey)
+		if err != nil {
+			return nil, err
+		}
+		if format == publicKeyFormatSPKI {
+			formatted = base64.StdEncoding.EncodeToString(der)
+			break
+		}
+		pemBytes := pem.EncodeToMemory(&pem.Block{
+			Type:  "PUBLIC KEY",
+			Bytes: der,
+		})
+		if len(pemBytes) == 0 {
+			return nil, fmt.Errorf("failed to PEM-encode public key")
+		}
+		formatted = string(pemBytes)
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"name":       p.Name,
+			"type":       p.Type.String(),
+			"version":    ver,
+			"format":     format,
+			"public_key": formatted,
+		},
+	}, nil
+}
+
+// entryPublicKey returns the public key of a version of an asymmetric key.
+// Derived ed25519 and ed448 keys have a public key per context, so they must
+// be derived first instead.
+func entryPublicKey(p *keysutil.Policy, entry keysutil.KeyEntry) crypto.PublicKey {
+	switch p.Type {
+	case keysutil.KeyType_ECDSA_P256, keysutil.KeyType_ECDSA_P384, keysutil.KeyType_ECDSA_P521:
+		return &ecdsa.PublicKey{
+			Curve: p.Type.ECDSACurve(),
+			X:     entry.EC_X,
+			Y:     entry.EC_Y,
+		}
+
+	case keysutil.KeyType_RSA2048, keysutil.KeyType_RSA3072, keysutil.KeyType_RSA4096:
+		return entry.RSAKey.Public()
+
+	case keysutil.KeyType_ED25519, keysutil.KeyType_ED448:
+		return edDSAPublicKey(p.Type, entry.Key)
+	}
+	return nil
+}
+
+// edDSAPublicKey returns the public key of an ed25519 or ed448 private key
+func edDSAPublicKey(keyType keysutil.KeyType, key []byte) crypto.PublicKey {
+	if keyType == keysutil.KeyType_ED448 {
+		return ed448.PrivateKey(key).Public()
+	}
+	return ed25519.PrivateKey(key).Public()
+}
+
+// encodeEdDSAPublicKey returns the base64 encoding of the public key of an
+// ed25519 or ed448 private key, which is how their public keys are returned
+// when read with the key
+func encodeEdDSAPublicKey(keyType keysutil.KeyType, key []byte) string {
+	switch pubKey := edDSAPublicKey(keyType, key).(type) {
+	case ed448.PublicKey:
+		return base64.StdEncoding.EncodeToString(pubKey)
+	case ed25519.PublicKey:
+		return base64.StdEncoding.EncodeToString(pubKey)
+	}
+	return ""
+}
+
+// managedPublicKeyError returns the error for reads of the public key of a
+// managed key, which Vault does not hold
+func managedPublicKeyError(p *keysutil.Policy) string {
+	return fmt.Sprintf("managed key %s holds no key material in Vault, so its public key must be read from the managed key backend", p.ManagedKeyName)
+}
+
+// sshFormatSupported returns whether public keys of the given type can be
+// returned in OpenSSH format
+func sshFormatSupported(keyType keysutil.KeyType) bool {
+	switch keyType {
+	case keysutil.KeyType_ED25519, keysutil.KeyType_ECDSA_P256, keysutil.KeyType_ECDSA_P384, keysutil.KeyType_ECDSA_P521:
+		return true
+	}
+	return false
+}
+
+// marshalPublicKeySPKI returns the DER-encoded SubjectPublicKeyInfo of the
+// given public key. Ed25519 keys are converted from the x/crypto type to the
+// standard library one, which is the type x509 expects, while Ed448 keys,
+// which x509 does not support, are encoded by keysutil.
+func marshalPublicKeySPKI(pubKey crypto.PublicKey) ([]byte, error) {
+	switch edKey := pubKey.(type) {
+	case ed25519.PublicKey:
+		pubKey = stded25519.PublicKey(edKey)
+	case ed448.PublicKey:
+		der, err := keysutil.MarshalEd448PublicKey(edKey)
+		if err != nil {
+			return nil, fmt.Errorf("error marshaling public key: %v", err)
+		}
+		return der, nil
+	}
+	der, err := x509.MarshalPKIXPublicKey(pubKey)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling public key: %v", err)
+	}
+	return der, nil
+}
+
+// publicKeyJWK returns the public key as a JSON Web Key (RFC 7517) with the
+// given key ID. For a single key, the ID combines the key's fingerprint and
+// version so that it is stable across renames and unique per version.
+func publicKeyJWK(kid string, pubKey crypto.PublicKey) map[string]interface{} {
+	jwk := map[string]interface{}{
+		"kid": kid,
+		"use": "sig",
+	}
+
+	switch key := pubKey.(type) {
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		jwk["kty"] = "EC"
+		jwk["crv"] = key.Curve.Params().Name
+		jwk["x"] = jwkEncode(key.X, size)
+		jwk["y"] = jwkEncode(key.Y, size)
+
+	case *rsa.PublicKey:
+		jwk["kty"] = "RSA"
+		jwk["n"] = jwkEncode(key.N, 0)
+		jwk["e"] = jwkEncode(big.NewInt(int64(key.E)), 0)
+
+	case ed25519.PublicKey:
+		jwk["kty"] = "OKP"
+		jwk["crv"] = "Ed25519"
+		jwk["x"] = base64.RawURLEncoding.EncodeToString(key)
+
+	case ed448.PublicKey:
+		jwk["kty"] = "OKP"
+		jwk["crv"] = "Ed448"
+		jwk["x"] = base64.RawURLEncoding.EncodeToString(key)
+	}
+
+	return jwk
+}
+
+// jwkEncode returns the unpadded base64url encoding of the big-endian bytes
+// of n, left-padded with zeros to size bytes
+func jwkEncode(n *big.Int, size int) string {
+	b := n.Bytes()
+	if len(b) < size {
+		b = append(make([]byte, size-len(b)), b...)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+const pathPublicKeyHelpSyn = `Return the public key of a named asymmetric key`
+
+const pathPublicKeyHelpDesc = `
+This path returns only the public key of one version of the named key, in
+PEM, base64-encoded DER SubjectPublicKeyInfo, JSON Web Key or, for ed25519
+and ecdsa keys, OpenSSH authorized_keys format, for use by external verifiers
+such as SSH servers. The private key is never returned.
+`
@@ -0,0 +1,234 @@
+package transit
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestTransit_PublicKey(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	decodeJWK := func(jwk map[string]interface{}, field string) *big.Int {
+		raw, err := base64.RawURLEncoding.DecodeString(jwk[field].(string))
+		if err != nil {
+			t.Fatalf("bad JWK field %s: %v", field, err)
+		}
+		return new(big.Int).SetBytes(raw)
+	}
+	// publicKey parses the PEM public key of the given version as returned by
+	// a read of the key
+	publicKey := func(name string, ver string) interface{} {
+		resp := mustHandle(t, b, storage, logical.ReadOperation, "keys/"+name, nil)
+		keys := resp.Data["keys"].(map[string]map[string]interface{})
+		block, _ := pem.Decode([]byte(keys[ver]["public_key"].(string)))
+		if block == nil {
+			t.Fatalf("failed to decode PEM of %s", name)
+		}
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pub
+	}
+
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/ecdsa", map[string]interface{}{
+		"type": "ecdsa-p384",
+	})
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/ecdsa/rotate", nil)
+	fingerprint := mustHandle(t, b, storage, logical.ReadOperation, "keys/ecdsa", nil).Data["fingerprint"].(string)
+
+	resp := mustHandle(t, b, storage, logical.ReadOperation, "keys/ecdsa/public", map[string]interface{}{
+		"format":  "jwk",
+		"version": 1,
+	})
+	if resp.Data["version"] != 1 {
+		t.Fatalf("bad version: %#v", resp.Data)
+	}
+	jwk := resp.Data["public_key"].(map[string]interface{})
+	if jwk["kty"] != "EC" || jwk["crv"] != "P-384" || jwk["kid"] != fingerprint+":1" {
+		t.Fatalf("bad ECDSA JWK: %#v", jwk)
+	}
+	if len(jwk["x"].(string)) != base64.RawURLEncoding.EncodedLen(48) {
+		t.Fatalf("expected x to be padded to the curve size: %#v", jwk)
+	}
+	ecKey := publicKey("ecdsa", "1").(*ecdsa.PublicKey)
+	if decodeJWK(jwk, "x").Cmp(ecKey.X) != 0 || decodeJWK(jwk, "y").Cmp(ecKey.Y) != 0 {
+		t.Fatalf("JWK does not match the public key: %#v", jwk)
+	}
+
+	// The latest version is returned by default, in PEM format
+	resp = mustHandle(t, b, storage, logical.ReadOperation, "keys/ecdsa/public", nil)
+	pemKey := resp.Data["public_key"].(string)
+	if resp.Data["version"] != 2 || resp.Data["format"] != "pem" {
+		t.Fatalf("bad default response: %#v", resp.Data)
+	}
+	block, _ := pem.Decode([]byte(pemKey))
+	if block == nil {
+		t.Fatalf("bad PEM: %q", pemKey)
+	}
+
+	resp = mustHandle(t, b, storage, logical.ReadOperation, "keys/ecdsa/public", map[string]interface{}{
+		"format": "spki",
+	})
+	if resp.Data["public_key"] != base64.StdEncoding.EncodeToString(block.Bytes) {
+		t.Fatalf("SPKI does not match PEM: %#v", resp.Data)
+	}
+
+	resp = mustHandle(t, b, storage, logical.ReadOperation, "keys/ecdsa/public", map[string]interface{}{
+		"format": "ssh",
+	})
+	sshKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(resp.Data["public_key"].(string)))
+	if err != nil {
+		t.Fatalf("failed to parse ecdsa ssh key: %v", err)
+	}
+	if sshKey.Type() != "ecdsa-sha2-nistp384" {
+		t.Fatalf("bad ecdsa ssh key type: %s", sshKey.Type())
+	}
+	if cryptoKey := sshKey.(ssh.CryptoPublicKey).CryptoPublicKey().(*ecdsa.PublicKey); cryptoKey.X.Cmp(publicKey("ecdsa", "2").(*ecdsa.PublicKey).X) != 0 {
+		t.Fatal("ecdsa ssh key does not match the public key")
+	}
+
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/rsa", map[string]interface{}{
+		"type": "rsa-2048",
+	})
+	resp = mustHandle(t, b, storage, logical.ReadOperation, "keys/rsa/public", map[string]interface{}{
+		"format": "jwk",
+	})
+	jwk = resp.Data["public_key"].(map[string]interface{})
+	if jwk["kty"] != "RSA" || jwk["kid"] == "" {
+		t.Fatalf("bad RSA JWK: %#v", jwk)
+	}
+	rsaKey := publicKey("rsa", "1").(*rsa.PublicKey)
+	if decodeJWK(jwk, "n").Cmp(rsaKey.N) != 0 || decodeJWK(jwk, "e").Int64() != int64(rsaKey.E) {
+		t.Fatalf("JWK does not match the public key: %#v", jwk)
+	}
+
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/ed25519", map[string]interface{}{
+		"type": "ed25519",
+	})
+	edKey, err := base64.StdEncoding.DecodeString(
+		mustHandle(t, b, storage, logical.ReadOperation, "keys/ed25519", nil).Data["keys"].(map[string]map[string]interface{})["1"]["public_key"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp = mustHandle(t, b, storage, logical.ReadOperation, "keys/ed25519/public", map[string]interface{}{
+		"format": "spki",
+	})
+	der, err := base64.StdEncoding.DecodeString(resp.Data["public_key"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		t.Fatalf("failed to parse ed25519 SPKI: %v", err)
+	}
+	if pub, ok := parsed.(ed25519.PublicKey); !ok || string(pub) != string(edKey) {
+		t.Fatal("ed25519 SPKI does not match the public key")
+	}
+	resp = mustHandle(t, b, storage, logical.ReadOperation, "keys/ed25519/public", map[string]interface{}{
+		"format": "jwk",
+	})
+	jwk = resp.Data["public_key"].(map[string]interface{})
+	if jwk["kty"] != "OKP" || jwk["crv"] != "Ed25519" || jwk["x"] != base64.RawURLEncoding.EncodeToString(edKey) {
+		t.Fatalf("bad ed25519 JWK: %#v", jwk)
+	}
+	resp = mustHandle(t, b, storage, logical.ReadOperation, "keys/ed25519/public", map[string]interface{}{
+		"format": "ssh",
+	})
+	sshKey, _, _, _, err = ssh.ParseAuthorizedKey([]byte(resp.Data["public_key"].(string)))
+	if err != nil {
+		t.Fatalf("failed to parse ed25519 ssh key: %v", err)
+	}
+	if sshKey.Type() != ssh.KeyAlgoED25519 || string(sshKey.Marshal()[len(sshKey.Marshal())-32:]) != string(edKey) {
+		t.Fatalf("ed25519 ssh key does not match the public key: %s", resp.Data["public_key"])
+	}
+
+	// Derived ed25519 keys require a context
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/derived", map[string]interface{}{
+		"type":    "ed25519",
+		"derived": true,
+	})
+	mustFail(t, b, storage, logical.ReadOperation, "keys/derived/public", nil)
+	resp = mustHandle(t, b, storage, logical.ReadOperation, "keys/derived/public", map[string]interface{}{
+		"format":  "jwk",
+		"context": "dGVzdGNvbnRleHQ=",
+	})
+	if _, ok := resp.Data["public_key"].(map[string]interface{})["x"]; !ok {
+		t.Fatalf("bad derived ed25519 JWK: %#v", resp.Data)
+	}
+
+	// Ed448 keys, which x509 does not support, are encoded per RFC 8410 and
+	// RFC 8037, and have no OpenSSH format
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/ed448", map[string]interface{}{
+		"type": "ed448",
+	})
+	ed448Key, _ := base64.StdEncoding.DecodeString(
+		mustHandle(t, b, storage, logical.ReadOperation, "keys/ed448", nil).Data["keys"].(map[string]map[string]interface{})["1"]["public_key"].(string))
+	resp = mustHandle(t, b, storage, logical.ReadOperation, "keys/ed448/public", nil)
+	block, _ = pem.Decode([]byte(resp.Data["public_key"].(string)))
+	if block == nil {
+		t.Fatalf("bad ed448 PEM: %#v", resp.Data)
+	}
+	var spki struct {
+		Algo      pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if rest, err := asn1.Unmarshal(block.Bytes, &spki); err != nil || len(rest) != 0 {
+		t.Fatalf("failed to parse ed448 SPKI: %v", err)
+	}
+	if !spki.Algo.Algorithm.Equal(asn1.ObjectIdentifier{1, 3, 101, 113}) || string(spki.PublicKey.RightAlign()) != string(ed448Key) {
+		t.Fatalf("ed448 SPKI does not match the public key: %#v", spki)
+	}
+	resp = mustHandle(t, b, storage, logical.ReadOperation, "keys/ed448/public", map[string]interface{}{
+		"format": "jwk",
+	})
+	jwk = resp.Data["public_key"].(map[string]interface{})
+	if jwk["kty"] != "OKP" || jwk["crv"] != "Ed448" || jwk["x"] != base64.RawURLEncoding.EncodeToString(ed448Key) {
+		t.Fatalf("bad ed448 JWK: %#v", jwk)
+	}
+	mustFail(t, b, storage, logical.ReadOperation, "keys/ed448/public", map[string]interface{}{"format": "ssh"})
+
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/derived448", map[string]interface{}{
+		"type":    "ed448",
+		"derived": true,
+	})
+	mustFail(t, b, storage, logical.ReadOperation, "keys/derived448/public", nil)
+	resp = mustHandle(t, b, storage, logical.ReadOperation, "keys/derived448/public", map[string]interface{}{
+		"format":  "jwk",
+		"context": "dGVzdGNvbnRleHQ=",
+	})
+	derivedKey, _ := base64.StdEncoding.DecodeString(
+		mustHandle(t, b, storage, logical.ReadOperation, "keys/derived448", map[string]interface{}{
+			"context": "dGVzdGNvbnRleHQ=",
+		}).Data["keys"].(map[string]map[string]interface{})["1"]["public_key"].(string))
+	if jwk := resp.Data["public_key"].(map[string]interface{}); jwk["x"] != base64.RawURLEncoding.EncodeToString(derivedKey) {
+		t.Fatalf("bad derived ed448 JWK: %#v", jwk)
+	}
+
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/aes", nil)
+	mustFail(t, b, storage, logical.ReadOperation, "keys/aes/public", nil)
+	mustFail(t, b, storage, logical.ReadOperation, "keys/aes/public", map[string]interface{}{"format": "ssh"})
+	mustFail(t, b, storage, logical.ReadOperation, "keys/rsa/public", map[string]interface{}{"format": "ssh"})
+	mustFail(t, b, storage, logical.ReadOperation, "keys/missing/public", nil)
+	mustFail(t, b, storage, logical.ReadOperation, "keys/ecdsa/public", map[string]interface{}{"version": 3})
+	mustFail(t, b, storage, logical.ReadOperation, "keys/ecdsa/public", map[string]interface{}{"format": "der"})
+
+	// Versions below the min decryption version can no longer be read
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/ecdsa/config", map[string]interface{}{
+		"min_decryption_version": 2,
+	})
+	mustFail(t, b, storage, logical.ReadOperation, "keys/ecdsa/public", map[string]interface{}{"version": 1})
+	mustHandle(t, b, storage, logical.ReadOperation, "keys/ecdsa/public", map[string]interface{}{"version": 2})
+}
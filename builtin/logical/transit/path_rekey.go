@@ -0,0 +1,95 @@
+package transit
+
+import (
+	"github.com/hashicorp/vault/helper/errutil"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+func (b *backend) pathRekey() *framework.Path {
+	return &framework.Path{
+		Pattern: "keys/" + framework.GenericNameRegex("name") + "/rekey",
+		Fields: map[string]*framework.FieldSchema{
+			"name": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "Name of the key",
+			},
+
+			"ciphertext": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `The base64-encoded key material replacing that
+of the latest version, wrapped using the public
+key returned by the wrapping_key endpoint.`,
+			},
+
+			"i_understand_this_invalidates_ciphertext": &framework.FieldSchema{
+				Type: framework.TypeBool,
+				Description: `Must be set to confirm that data encrypted or
+signed with the current material of the latest
+version can no longer be decrypted or verified.`,
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.pathRekeyWrite,
+		},
+
+		HelpSynopsis:    pathRekeyHelpSyn,
+		HelpDescription: pathRekeyHelpDesc,
+	}
+}
+
+func (b *backend) pathRekeyWrite(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+
+	if !d.Get("i_understand_this_invalidates_ciphertext").(bool) {
+		return logical.ErrorResponse("re-keying makes existing ciphertext of the latest version undecryptable; set i_understand_this_invalidates_ciphertext to confirm"), logical.ErrInvalidRequest
+	}
+
+	p, lock, err := b.lm.GetPolicyExclusive(req.Storage, name)
+	if lock != nil {
+		defer lock.Unlock()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return logical.ErrorResponse("key not found"), logical.ErrInvalidRequest
+	}
+	if !p.Imported {
+		return logical.ErrorResponse("only imported keys can be re-keyed; use keys/<name>/rotate for keys generated by Vault"), logical.ErrInvalidRequest
+	}
+
+	key, err := b.unwrapKeyMaterial(req.Storage, d.Get("ciphertext").(string))
+	if err == nil {
+		err = p.RekeyLatestVersion(req.Storage, key)
+	}
+	if err != nil {
+		switch err.(type) {
+		case errutil.UserError:
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		default:
+			return nil, err
+		}
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"latest_version": p.LatestVersion,
+		},
+	}, nil
+}
+
+const pathRekeyHelpSyn = `Replace the key material of the latest version of an imported key`
+
+const pathRekeyHelpDesc = `
+This path replaces the key material of the latest version of a previously
+imported named key with externally generated key material, wrapped in the same
+way as for the keys/<name>/import path, without creating a new version. It is
+meant for replacing compromised material of keys that cannot be rotated.
+Unlike rotation, anything encrypted or signed with the previous material of
+that version can no longer be decrypted or verified, so the request must set
+i_understand_this_invalidates_ciphertext. Keys generated by Vault cannot be
+re-keyed.
+`
@@ -0,0 +1,71 @@
+package transit
+
+import (
+	"regexp"
+
+	"github.com/hashicorp/vault/helper/errutil"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+var keyNameRegex = regexp.MustCompile("^" + framework.GenericNameRegex("name") + "$")
+
+func (b *backend) pathRename() *framework.Path {
+	return &framework.Path{
+		Pattern: "keys/" + framework.GenericNameRegex("name") + "/rename",
+		Fields: map[string]*framework.FieldSchema{
+			"name": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "Name of the key",
+			},
+
+			"new_name": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "New name for the key. Must not already be in use.",
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.pathRenameUpdate,
+		},
+
+		HelpSynopsis:    pathRenameHelpSyn,
+		HelpDescription: pathRenameHelpDesc,
+	}
+}
+
+func (b *backend) pathRenameUpdate(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+	newName := d.Get("new_name").(string)
+
+	if newName == "" {
+		return logical.ErrorResponse("missing new_name"), logical.ErrInvalidRequest
+	}
+	if !keyNameRegex.MatchString(newName) {
+		return logical.ErrorResponse("invalid new_name"), logical.ErrInvalidRequest
+	}
+
+	err := b.lm.RenamePolicy(req.Storage, name, newName)
+	if err != nil {
+		switch err.(type) {
+		case errutil.UserError:
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		default:
+			return nil, err
+		}
+	}
+	b.idempotency.forget(name)
+	b.rateLimiter.forget(name)
+
+	return nil, nil
+}
+
+const pathRenameHelpSyn = `Rename a named key`
+
+const pathRenameHelpDesc = `
+This path is used to move a named key, including all of its versions, to
+a new name. Existing ciphertext remains decryptable using the new name;
+the old name is no longer usable once the rename completes. The rename
+is rejected if a key already exists under the new name.
+`
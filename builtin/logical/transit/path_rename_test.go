@@ -0,0 +1,93 @@
+package transit
+
+import (
+	"testing"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestTransit_Rename(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	req := &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "keys/old",
+	}
+	mustHandleRequest(t, b, req)
+	req.Path = "keys/taken"
+	mustHandleRequest(t, b, req)
+
+	req.Path = "encrypt/old"
+	req.Data = map[string]interface{}{
+		"plaintext": "dGhlIHF1aWNrIGJyb3duIGZveA==",
+	}
+	ciphertext := mustHandleRequest(t, b, req).Data["ciphertext"].(string)
+
+	req.Path = "keys/old/rotate"
+	req.Data = nil
+	mustHandleRequest(t, b, req)
+	mustHandleRequest(t, b, req)
+
+	// Renaming onto an existing key, to an invalid name, or without a name
+	// must fail
+	req.Path = "keys/old/rename"
+	req.Data = map[string]interface{}{
+		"new_name": "taken",
+	}
+	mustFailRequest(t, b, req)
+	req.Data["new_name"] = "bad/name"
+	mustFailRequest(t, b, req)
+	req.Data = nil
+	mustFailRequest(t, b, req)
+
+	req.Data = map[string]interface{}{
+		"new_name": "new",
+	}
+	mustHandleRequest(t, b, req)
+
+	// The old name is gone, including from storage
+	req.Path = "keys/old"
+	req.Operation = logical.ReadOperation
+	req.Data = nil
+	if resp := mustHandleRequest(t, b, req); resp != nil {
+		t.Fatalf("expected old key to be gone, got %#v", resp)
+	}
+	for _, key := range []string{"policy/old", "archive/old"} {
+		entry, err := storage.Get(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry != nil {
+			t.Fatalf("expected %s to be removed", key)
+		}
+	}
+
+	req.Path = "keys/new"
+	resp := mustHandleRequest(t, b, req)
+	if resp.Data["name"] != "new" || resp.Data["latest_version"] != 3 {
+		t.Fatalf("bad renamed key: %#v", resp.Data)
+	}
+
+	// Existing ciphertext decrypts under the new name, both from the cache
+	// and after the cache has been cleared
+	for i := 0; i < 2; i++ {
+		req.Path = "decrypt/new"
+		req.Operation = logical.UpdateOperation
+		req.Data = map[string]interface{}{
+			"ciphertext": ciphertext,
+		}
+		resp = mustHandleRequest(t, b, req)
+		if resp.Data["plaintext"] != "dGhlIHF1aWNrIGJyb3duIGZveA==" {
+			t.Fatalf("bad plaintext: %#v", resp.Data)
+		}
+		b.invalidate("policy/new")
+	}
+
+	// Renaming a missing key fails
+	req.Path = "keys/old/rename"
+	req.Data = map[string]interface{}{
+		"new_name": "other",
+	}
+	mustFailRequest(t, b, req)
+}
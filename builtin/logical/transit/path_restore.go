@@ -0,0 +1,90 @@
+package transit
+
+import (
+	"github.com/hashicorp/vault/helper/errutil"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+func (b *backend) pathRestore() *framework.Path {
+	return &framework.Path{
+		Pattern: "restore" + framework.OptionalParamRegex("name"),
+		Fields: map[string]*framework.FieldSchema{
+			"name": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "If set, the name to restore the key under instead of the name stored in the backup",
+			},
+
+			"backup": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "Backup of the key, as returned by the backup endpoint",
+			},
+
+			"force": &framework.FieldSchema{
+				Type:        framework.TypeBool,
+				Description: "If set, an existing key with the same name is overwritten if its deletion is allowed",
+			},
+
+			"merge": &framework.FieldSchema{
+				Type: framework.TypeBool,
+				Description: `If set, the versions of the backup are merged
+into an existing key with the same name, keeping
+the union of the versions of both. Versions
+present in both must have the same key material.`,
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.pathRestoreUpdate,
+		},
+
+		HelpSynopsis:    pathRestoreHelpSyn,
+		HelpDescription: pathRestoreHelpDesc,
+	}
+}
+
+func (b *backend) pathRestoreUpdate(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	backup := d.Get("backup").(string)
+	if backup == "" {
+		return logical.ErrorResponse("missing backup"), logical.ErrInvalidRequest
+	}
+
+	name := d.Get("name").(string)
+	if name != "" && !keyNameRegex.MatchString(name) {
+		return logical.ErrorResponse("invalid name"), logical.ErrInvalidRequest
+	}
+
+	force := d.Get("force").(bool)
+	merge := d.Get("merge").(bool)
+	if force && merge {
+		return logical.ErrorResponse("force and merge cannot both be set"), logical.ErrInvalidRequest
+	}
+
+	var err error
+	if merge {
+		err = b.lm.MergeRestorePolicy(req.Storage, name, backup)
+	} else {
+		err = b.lm.RestorePolicy(req.Storage, name, backup, force)
+	}
+	if err != nil {
+		switch err.(type) {
+		case errutil.UserError:
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		default:
+			return nil, err
+		}
+	}
+
+	return nil, nil
+}
+
+const pathRestoreHelpSyn = `Restore a key from a backup`
+
+const pathRestoreHelpDesc = `
+This path is used to restore a key from a backup taken with the backup
+endpoint. The key is restored under the name stored in the backup unless a
+name is given in the path. An existing key is not overwritten unless force
+is set and the key allows deletion, or has the versions of the backup merged
+into it if merge is set.
+`
@@ -43,7 +43,7 @@ to the min_encryption_version configured on the key.`,
 		},
 
 		Callbacks: map[logical.Operation]framework.OperationFunc{
-			logical.UpdateOperation: b.pathRewrapWrite,
+			logical.UpdateOperation: b.requireEnabledKey(b.pathRewrapWrite),
 		},
 
 		HelpSynopsis:    pathRewrapHelpSyn,
@@ -123,6 +123,15 @@ func (b *backend) pathRewrapWrite(
 	if p == nil {
 		return logical.ErrorResponse("encryption key not found"), logical.ErrInvalidRequest
 	}
+	if !p.OperationAllowed("decrypt") {
+		return logical.ErrorResponse(fmt.Sprintf("key %s does not allow the decrypt operation", p.Name)), logical.ErrInvalidRequest
+	}
+	if !p.OperationAllowed("encrypt") {
+		return logical.ErrorResponse(fmt.Sprintf("key %s does not allow the encrypt operation", p.Name)), logical.ErrInvalidRequest
+	}
+	if resp, err := b.checkRateLimit(p, len(batchInputItems)); err != nil {
+		return resp, err
+	}
 
 	for i, item := range batchInputItems {
 		if batchResponseItems[i].Error != "" {
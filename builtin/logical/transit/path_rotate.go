@@ -1,6 +1,11 @@
 package transit
 
 import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/vault/helper/errutil"
+	"github.com/hashicorp/vault/helper/keysutil"
 	"github.com/hashicorp/vault/logical"
 	"github.com/hashicorp/vault/logical/framework"
 )
@@ -24,26 +29,120 @@ func (b *backend) pathRotate() *framework.Path {
 	}
 }
 
+func (b *backend) pathRotatePrefix() *framework.Path {
+	return &framework.Path{
+		Pattern: "rotate",
+		Fields: map[string]*framework.FieldSchema{
+			"prefix": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "Prefix of the names of the keys to rotate",
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.pathRotatePrefixWrite,
+		},
+
+		HelpSynopsis:    pathRotatePrefixHelpSyn,
+		HelpDescription: pathRotatePrefixHelpDesc,
+	}
+}
+
 func (b *backend) pathRotateWrite(
 	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
-	name := d.Get("name").(string)
+	latestVersion, warning, err := b.rotateKey(req.Storage, d.Get("name").(string))
+	if err != nil {
+		switch err.(type) {
+		case errutil.UserError:
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		default:
+			return nil, err
+		}
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"latest_version": latestVersion,
+		},
+	}
+	if warning != "" {
+		resp.AddWarning(warning)
+	}
+	return resp, nil
+}
+
+func (b *backend) pathRotatePrefixWrite(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	prefix := d.Get("prefix").(string)
+	if prefix == "" {
+		return logical.ErrorResponse("missing prefix"), logical.ErrInvalidRequest
+	}
+
+	names, err := req.Storage.List("policy/")
+	if err != nil {
+		return nil, err
+	}
+
+	// Each key is rotated under its own lock, and a failure to rotate one key
+	// is reported without stopping the others from being rotated
+	results := map[string]interface{}{}
+	for _, name := range names {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+
+		latestVersion, warning, err := b.rotateKey(req.Storage, name)
+		if err != nil {
+			results[name] = map[string]interface{}{
+				"rotated": false,
+				"error":   err.Error(),
+			}
+			continue
+		}
+		result := map[string]interface{}{
+			"rotated":        true,
+			"latest_version": latestVersion,
+		}
+		if warning != "" {
+			result["warning"] = warning
+		}
+		results[name] = result
+	}
 
-	// Get the policy
-	p, lock, err := b.lm.GetPolicyExclusive(req.Storage, name)
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"keys": results,
+		},
+	}, nil
+}
+
+// rotateKey rotates the named key under an exclusive lock, returning the new
+// latest version and a warning if the key's version cap could not be honored
+func (b *backend) rotateKey(storage logical.Storage, name string) (int, string, error) {
+	p, lock, err := b.lm.GetPolicyExclusive(storage, name)
 	if lock != nil {
 		defer lock.Unlock()
 	}
 	if err != nil {
-		return nil, err
+		return 0, "", err
 	}
 	if p == nil {
-		return logical.ErrorResponse("key not found"), logical.ErrInvalidRequest
+		return 0, "", errutil.UserError{Err: "key not found"}
 	}
 
-	// Rotate the policy
-	err = p.Rotate(req.Storage)
+	if err := p.Rotate(storage); err != nil {
+		return 0, "", err
+	}
+	return p.LatestVersion, maxVersionsWarning(p), nil
+}
 
-	return nil, err
+// maxVersionsWarning returns a warning if the key has more versions available
+// than its version cap allows, because they may still be used
+func maxVersionsWarning(p *keysutil.Policy) string {
+	if p.MaxVersions <= 0 || p.AvailableVersions() <= p.MaxVersions {
+		return ""
+	}
+	return fmt.Sprintf("key has %d versions available, more than max_versions of %d; versions still allowed for decryption or encryption are not trimmed, so raise min_decryption_version to allow older versions to be trimmed", p.AvailableVersions(), p.MaxVersions)
 }
 
 const pathRotateHelpSyn = `Rotate named encryption key`
@@ -53,3 +152,11 @@ This path is used to rotate the named key. After rotation,
 new encryption requests using this name will use the new key,
 but decryption will still be supported for older versions.
 `
+
+const pathRotatePrefixHelpSyn = `Rotate all named encryption keys with a given prefix`
+
+const pathRotatePrefixHelpDesc = `
+This path is used to rotate every key whose name starts with the given
+prefix. Keys are rotated independently; the response reports for each
+matching key whether it was rotated, along with the error if it was not.
+`
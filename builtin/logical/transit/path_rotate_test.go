@@ -0,0 +1,198 @@
+package transit
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestTransit_RotatePrefix(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	for _, name := range []string{"app1-a", "app1-b", "app1-imported", "app2-a"} {
+		resp, err := b.HandleRequest(&logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "keys/" + name,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("got err:\n%#v\nresp:\n%#v\n", err, resp)
+		}
+	}
+
+	// Imported keys cannot be rotated unless explicitly allowed
+	p, lock, err := b.lm.GetPolicyExclusive(storage, "app1-imported")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Imported = true
+	err = p.Persist(storage)
+	lock.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "rotate",
+	}
+	resp, err := b.HandleRequest(req)
+	if err == nil && (resp == nil || !resp.IsError()) {
+		t.Fatal("expected error without a prefix")
+	}
+
+	req.Data = map[string]interface{}{
+		"prefix": "app1-",
+	}
+	resp, err = b.HandleRequest(req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("got err:\n%#v\nresp:\n%#v\n", err, resp)
+	}
+	results := resp.Data["keys"].(map[string]interface{})
+	if len(results) != 3 {
+		t.Fatalf("expected results for 3 keys, got %#v", results)
+	}
+	for _, name := range []string{"app1-a", "app1-b"} {
+		result := results[name].(map[string]interface{})
+		if result["rotated"] != true {
+			t.Fatalf("expected %s to be rotated, got %#v", name, result)
+		}
+	}
+	result := results["app1-imported"].(map[string]interface{})
+	if result["rotated"] != false || result["error"] == "" {
+		t.Fatalf("expected rotation of app1-imported to fail, got %#v", result)
+	}
+
+	expected := map[string]int{
+		"app1-a":        2,
+		"app1-b":        2,
+		"app1-imported": 1,
+		"app2-a":        1,
+	}
+	for name, version := range expected {
+		p, lock, err := b.lm.GetPolicyShared(storage, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		latest := p.LatestVersion
+		lock.RUnlock()
+		if latest != version {
+			t.Fatalf("%s: expected latest version %d, got %d", name, version, latest)
+		}
+	}
+}
+
+func TestTransit_Rotate(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	plaintext := "dGhlIHF1aWNrIGJyb3duIGZveA=="
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo", nil)
+	var ciphertexts []string
+	for ver := 1; ver <= 3; ver++ {
+		ciphertext := mustHandle(t, b, storage, logical.UpdateOperation, "encrypt/foo", map[string]interface{}{
+			"plaintext": plaintext,
+		}).Data["ciphertext"].(string)
+		if !strings.HasPrefix(ciphertext, "vault:v"+strconv.Itoa(ver)+":") {
+			t.Fatalf("expected ciphertext from version %d, got %s", ver, ciphertext)
+		}
+		ciphertexts = append(ciphertexts, ciphertext)
+
+		if ver < 3 {
+			resp := mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo/rotate", nil)
+			if resp.Data["latest_version"] != ver+1 {
+				t.Fatalf("expected latest version %d, got %#v", ver+1, resp.Data)
+			}
+		}
+	}
+
+	// Ciphertext from every version remains decryptable
+	for _, ciphertext := range ciphertexts {
+		resp := mustHandle(t, b, storage, logical.UpdateOperation, "decrypt/foo", map[string]interface{}{
+			"ciphertext": ciphertext,
+		})
+		if resp.Data["plaintext"] != plaintext {
+			t.Fatalf("bad plaintext for %s: %#v", ciphertext, resp.Data)
+		}
+	}
+
+	resp, err := b.HandleRequest(&logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "keys/missing/rotate",
+	})
+	if err != logical.ErrInvalidRequest || resp == nil || !resp.IsError() {
+		t.Fatalf("expected error rotating a missing key, got %#v (err: %v)", resp, err)
+	}
+}
+
+func TestTransit_RotateMaxVersions(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo", map[string]interface{}{
+		"max_versions": 2,
+	})
+	if resp := mustHandle(t, b, storage, logical.ReadOperation, "keys/foo", nil); resp.Data["max_versions"] != 2 {
+		t.Fatalf("bad max versions: %#v", resp.Data["max_versions"])
+	}
+
+	plaintext := "dGhlIHF1aWNrIGJyb3duIGZveA=="
+	ciphertext := mustHandle(t, b, storage, logical.UpdateOperation, "encrypt/foo", map[string]interface{}{
+		"plaintext": plaintext,
+	}).Data["ciphertext"].(string)
+
+	// Versions still allowed for decryption are kept, with a warning
+	resp := mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo/rotate", nil)
+	if len(resp.Warnings) != 0 {
+		t.Fatalf("unexpected warnings within the cap: %v", resp.Warnings)
+	}
+	resp = mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo/rotate", nil)
+	if len(resp.Warnings) != 1 || !strings.Contains(resp.Warnings[0], "max_versions") {
+		t.Fatalf("expected max versions warning, got %v", resp.Warnings)
+	}
+	resp = mustHandle(t, b, storage, logical.ReadOperation, "keys/foo", nil)
+	if len(resp.Data["keys"].(map[string]int64)) != 3 {
+		t.Fatalf("expected no versions to be trimmed, got %#v", resp.Data["keys"])
+	}
+
+	// Once older versions can no longer be used, rotation trims them
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo/config", map[string]interface{}{
+		"min_decryption_version": 3,
+	})
+	resp = mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo/rotate", nil)
+	if len(resp.Warnings) != 0 || resp.Data["latest_version"] != 4 {
+		t.Fatalf("bad rotation: %#v", resp)
+	}
+
+	resp = mustHandle(t, b, storage, logical.ReadOperation, "keys/foo", nil)
+	if resp.Data["min_available_version"] != 3 || len(resp.Data["keys"].(map[string]int64)) != 2 {
+		t.Fatalf("expected versions below 3 to be trimmed, got %#v", resp.Data)
+	}
+
+	// Ciphertext from a trimmed version can no longer be decrypted
+	resp, err := b.HandleRequest(&logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "decrypt/foo",
+		Data: map[string]interface{}{
+			"ciphertext": ciphertext,
+		},
+	})
+	if err == nil && (resp == nil || !resp.IsError()) {
+		t.Fatal("expected decryption with a trimmed version to fail")
+	}
+
+	resp, err = b.HandleRequest(&logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "keys/bar",
+		Data: map[string]interface{}{
+			"max_versions": -1,
+		},
+	})
+	if err == nil && (resp == nil || !resp.IsError()) {
+		t.Fatal("expected error for negative max versions")
+	}
+}
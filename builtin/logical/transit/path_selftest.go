@@ -0,0 +1,160 @@
+package transit
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+
+	uuid "github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/helper/keysutil"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+func (b *backend) pathSelfTest() *framework.Path {
+	return &framework.Path{
+		Pattern: "keys/" + framework.GenericNameRegex("name") + "/test",
+		Fields: map[string]*framework.FieldSchema{
+			"name": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "Name of the key",
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.requireEnabledKey(b.pathSelfTestRead),
+		},
+
+		HelpSynopsis:    pathSelfTestHelpSyn,
+		HelpDescription: pathSelfTestHelpDesc,
+	}
+}
+
+func (b *backend) pathSelfTestRead(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+
+	p, lock, err := b.lm.GetPolicyShared(req.Storage, name)
+	if lock != nil {
+		defer lock.RUnlock()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return logical.ErrorResponse("key not found"), logical.ErrInvalidRequest
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"version":   p.LatestVersion,
+			"algorithm": p.Type.String(),
+			"passed":    true,
+		},
+	}
+
+	var tested []string
+	if p.Type.EncryptionSupported() && p.Type.DecryptionSupported() {
+		tested = append(tested, "encrypt", "decrypt")
+		err = selfTestEncryption(p)
+	}
+	if err == nil && p.Type.SigningSupported() {
+		tested = append(tested, "sign", "verify")
+		err = selfTestSigning(p)
+	}
+	resp.Data["operations"] = tested
+	if err != nil {
+		resp.Data["passed"] = false
+		resp.Data["error"] = err.Error()
+	}
+
+	return resp, nil
+}
+
+// selfTestEncryption encrypts random data with the latest key version and
+// checks that it decrypts back to the same data
+func selfTestEncryption(p *keysutil.Policy) error {
+	plaintext, err := uuid.GenerateRandomBytes(32)
+	if err != nil {
+		return err
+	}
+	context, nonce, err := selfTestContextAndNonce(p)
+	if err != nil {
+		return err
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(plaintext)
+	ciphertext, err := p.Encrypt(p.LatestVersion, context, nonce, encoded)
+	if err != nil {
+		return fmt.Errorf("encryption failed: %v", err)
+	}
+	decrypted, err := p.Decrypt(context, nonce, ciphertext)
+	if err != nil {
+		return fmt.Errorf("decryption failed: %v", err)
+	}
+	if decrypted != encoded {
+		return fmt.Errorf("decrypted data does not match the original plaintext")
+	}
+	return nil
+}
+
+// selfTestSigning signs random data with the latest key version and checks
+// that the signature verifies
+func selfTestSigning(p *keysutil.Policy) error {
+	input, err := uuid.GenerateRandomBytes(32)
+	if err != nil {
+		return err
+	}
+	context, _, err := selfTestContextAndNonce(p)
+	if err != nil {
+		return err
+	}
+
+	algorithm := "sha2-256"
+	if p.Type.HashSignatureInput() {
+		sum := sha256.Sum256(input)
+		input = sum[:]
+	}
+
+	sig, err := p.Sign(p.LatestVersion, context, input, algorithm)
+	if err != nil {
+		return fmt.Errorf("signing failed: %v", err)
+	}
+	valid, err := p.VerifySignature(context, input, sig.Signature, algorithm)
+	if err != nil {
+		return fmt.Errorf("verification failed: %v", err)
+	}
+	if !valid {
+		return fmt.Errorf("signature did not verify")
+	}
+	return nil
+}
+
+// selfTestContextAndNonce returns a random context for derived keys and a
+// random nonce for keys using the first version of convergent encryption,
+// which requires one to be supplied
+func selfTestContextAndNonce(p *keysutil.Policy) ([]byte, []byte, error) {
+	if !p.Derived {
+		return nil, nil, nil
+	}
+	context, err := uuid.GenerateRandomBytes(16)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !p.ConvergentEncryption || p.KeyConvergentVersion(p.LatestVersion) != 1 {
+		return context, nil, nil
+	}
+	nonce, err := uuid.GenerateRandomBytes(12)
+	if err != nil {
+		return nil, nil, err
+	}
+	return context, nonce, nil
+}
+
+const pathSelfTestHelpSyn = `Check that a named key is usable`
+
+const pathSelfTestHelpDesc = `
+This path performs an encrypt-then-decrypt and/or sign-then-verify round
+trip with the latest version of the named key, using random data, and
+reports whether it passed. No user data is involved.
+`
@@ -0,0 +1,87 @@
+package transit
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestTransit_SelfTest(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	cases := []struct {
+		name       string
+		data       map[string]interface{}
+		operations string
+	}{
+		{"aes", map[string]interface{}{"type": "aes256-gcm96"}, "encrypt,decrypt"},
+		{"chacha", map[string]interface{}{"type": "chacha20-poly1305"}, "encrypt,decrypt"},
+		{"convergent", map[string]interface{}{"derived": true, "convergent_encryption": true}, "encrypt,decrypt"},
+		{"ecdsa", map[string]interface{}{"type": "ecdsa-p384"}, "sign,verify"},
+		{"ed25519", map[string]interface{}{"type": "ed25519", "derived": true}, "sign,verify"},
+		{"rsa", map[string]interface{}{"type": "rsa-2048"}, "encrypt,decrypt,sign,verify"},
+	}
+	for _, tc := range cases {
+		resp, err := b.HandleRequest(&logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "keys/" + tc.name,
+			Data:      tc.data,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("%s: got err:\n%#v\nresp:\n%#v\n", tc.name, err, resp)
+		}
+		resp, err = b.HandleRequest(&logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "keys/" + tc.name + "/rotate",
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("%s: got err:\n%#v\nresp:\n%#v\n", tc.name, err, resp)
+		}
+
+		resp, err = b.HandleRequest(&logical.Request{
+			Storage:   storage,
+			Operation: logical.ReadOperation,
+			Path:      "keys/" + tc.name + "/test",
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("%s: got err:\n%#v\nresp:\n%#v\n", tc.name, err, resp)
+		}
+		if resp.Data["passed"] != true {
+			t.Fatalf("%s: self-test failed: %#v", tc.name, resp.Data)
+		}
+		if resp.Data["version"] != 2 {
+			t.Fatalf("%s: expected version 2 to be tested, got %v", tc.name, resp.Data["version"])
+		}
+		if tc.data["type"] != nil && resp.Data["algorithm"] != tc.data["type"] {
+			t.Fatalf("%s: bad algorithm %v", tc.name, resp.Data["algorithm"])
+		}
+		if ops := strings.Join(resp.Data["operations"].([]string), ","); ops != tc.operations {
+			t.Fatalf("%s: bad operations %s", tc.name, ops)
+		}
+	}
+
+	// A key whose latest version is unusable fails the self-test
+	p, lock, err := b.lm.GetPolicyExclusive(storage, "aes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := p.Keys[p.LatestVersion]
+	entry.Key = []byte("short")
+	p.Keys[p.LatestVersion] = entry
+	lock.Unlock()
+
+	resp, err := b.HandleRequest(&logical.Request{
+		Storage:   storage,
+		Operation: logical.ReadOperation,
+		Path:      "keys/aes/test",
+	})
+	if err != nil || resp == nil {
+		t.Fatalf("got err:\n%#v\nresp:\n%#v\n", err, resp)
+	}
+	if resp.Data["passed"] != false || resp.Data["error"] == nil {
+		t.Fatalf("expected self-test to fail, got %#v", resp.Data)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/base64"
 	"fmt"
 	"hash"
+	"strings"
 
 	"github.com/hashicorp/vault/helper/errutil"
 	"github.com/hashicorp/vault/logical"
@@ -29,7 +30,7 @@ func (b *backend) pathSign() *framework.Path {
 			"context": &framework.FieldSchema{
 				Type: framework.TypeString,
 				Description: `Base64 encoded context for key derivation. Required if key
-derivation is enabled; currently only available with ed25519 keys.`,
+derivation is enabled; currently only available with ed25519 and ed448 keys.`,
 			},
 
 			"algorithm": &framework.FieldSchema{
@@ -42,8 +43,9 @@ derivation is enabled; currently only available with ed25519 keys.`,
 * sha2-384
 * sha2-512
 
-Defaults to "sha2-256". Not valid for all key types,
-including ed25519.`,
+Defaults to "sha2-256", or "sha2-384" and "sha2-512"
+for ecdsa-p384 and ecdsa-p521 keys respectively. Not
+valid for all key types, including ed25519 and ed448.`,
 			},
 
 			"urlalgorithm": &framework.FieldSchema{
@@ -60,12 +62,12 @@ to the min_encryption_version configured on the key.`,
 
 			"prehashed": &framework.FieldSchema{
 				Type:        framework.TypeBool,
-				Description: `Set to 'true' when the input is already hashed. If the key type is 'rsa-2048' or 'rsa-4096', then the algorithm used to hash the input should be indicated by the 'algorithm' parameter.`,
+				Description: `Set to 'true' when the input is already hashed. If the key type is 'rsa-2048', 'rsa-3072' or 'rsa-4096', then the algorithm used to hash the input should be indicated by the 'algorithm' parameter.`,
 			},
 		},
 
 		Callbacks: map[logical.Operation]framework.OperationFunc{
-			logical.UpdateOperation: b.pathSignWrite,
+			logical.UpdateOperation: b.requireEnabledKey(b.pathSignWrite),
 		},
 
 		HelpSynopsis:    pathSignHelpSyn,
@@ -85,7 +87,7 @@ func (b *backend) pathVerify() *framework.Path {
 			"context": &framework.FieldSchema{
 				Type: framework.TypeString,
 				Description: `Base64 encoded context for key derivation. Required if key
-derivation is enabled; currently only available with ed25519 keys.`,
+derivation is enabled; currently only available with ed25519 and ed448 keys.`,
 			},
 
 			"signature": &framework.FieldSchema{
@@ -118,17 +120,19 @@ derivation is enabled; currently only available with ed25519 keys.`,
 * sha2-384
 * sha2-512
 
-Defaults to "sha2-256". Not valid for all key types.`,
+Defaults to "sha2-256", or "sha2-384" and "sha2-512"
+for ecdsa-p384 and ecdsa-p521 keys respectively. Not
+valid for all key types.`,
 			},
 
 			"prehashed": &framework.FieldSchema{
 				Type:        framework.TypeBool,
-				Description: `Set to 'true' when the input is already hashed. If the key type is 'rsa-2048' or 'rsa-4096', then the algorithm used to hash the input should be indicated by the 'algorithm' parameter.`,
+				Description: `Set to 'true' when the input is already hashed. If the key type is 'rsa-2048', 'rsa-3072' or 'rsa-4096', then the algorithm used to hash the input should be indicated by the 'algorithm' parameter.`,
 			},
 		},
 
 		Callbacks: map[logical.Operation]framework.OperationFunc{
-			logical.UpdateOperation: b.pathVerifyWrite,
+			logical.UpdateOperation: b.requireEnabledKey(b.pathVerifyWrite),
 		},
 
 		HelpSynopsis:    pathVerifyHelpSyn,
@@ -141,10 +145,6 @@ func (b *backend) pathSignWrite(
 	name := d.Get("name").(string)
 	ver := d.Get("key_version").(int)
 	inputB64 := d.Get("input").(string)
-	algorithm := d.Get("urlalgorithm").(string)
-	if algorithm == "" {
-		algorithm = d.Get("algorithm").(string)
-	}
 	prehashed := d.Get("prehashed").(bool)
 
 	input, err := base64.StdEncoding.DecodeString(inputB64)
@@ -163,11 +163,27 @@ func (b *backend) pathSignWrite(
 	if p == nil {
 		return logical.ErrorResponse("encryption key not found"), logical.ErrInvalidRequest
 	}
+	if !p.OperationAllowed("sign") {
+		return logical.ErrorResponse(fmt.Sprintf("key %s does not allow the sign operation", p.Name)), logical.ErrInvalidRequest
+	}
+	if resp, err := b.checkRateLimit(p, 1); err != nil {
+		return resp, err
+	}
 
 	if !p.Type.SigningSupported() {
 		return logical.ErrorResponse(fmt.Sprintf("key type %v does not support signing", p.Type)), logical.ErrInvalidRequest
 	}
 
+	algorithm := d.Get("urlalgorithm").(string)
+	if algorithm == "" {
+		algorithmRaw, ok := d.GetOk("algorithm")
+		if ok {
+			algorithm = algorithmRaw.(string)
+		} else {
+			algorithm = p.Type.DefaultHashAlgorithm()
+		}
+	}
+
 	contextRaw := d.Get("context").(string)
 	var context []byte
 	if len(contextRaw) != 0 {
@@ -213,6 +229,9 @@ func (b *backend) pathSignWrite(
 	if len(sig.PublicKey) > 0 {
 		resp.Data["public_key"] = sig.PublicKey
 	}
+	if p.Type.SignatureHashWeak(algorithm) {
+		resp.AddWarning(fmt.Sprintf("hash algorithm %s is weaker than key type %s; use one of %s instead", algorithm, p.Type, strings.Join(p.Type.AllowedSignatureHashes(), ", ")))
+	}
 
 	return resp, nil
 }
@@ -235,10 +254,6 @@ func (b *backend) pathVerifyWrite(
 
 	name := d.Get("name").(string)
 	inputB64 := d.Get("input").(string)
-	algorithm := d.Get("urlalgorithm").(string)
-	if algorithm == "" {
-		algorithm = d.Get("algorithm").(string)
-	}
 	prehashed := d.Get("prehashed").(bool)
 
 	input, err := base64.StdEncoding.DecodeString(inputB64)
@@ -257,11 +272,27 @@ func (b *backend) pathVerifyWrite(
 	if p == nil {
 		return logical.ErrorResponse("encryption key not found"), logical.ErrInvalidRequest
 	}
+	if !p.OperationAllowed("verify") {
+		return logical.ErrorResponse(fmt.Sprintf("key %s does not allow the verify operation", p.Name)), logical.ErrInvalidRequest
+	}
+	if resp, err := b.checkRateLimit(p, 1); err != nil {
+		return resp, err
+	}
 
 	if !p.Type.SigningSupported() {
 		return logical.ErrorResponse(fmt.Sprintf("key type %v does not support verification", p.Type)), logical.ErrInvalidRequest
 	}
 
+	algorithm := d.Get("urlalgorithm").(string)
+	if algorithm == "" {
+		algorithmRaw, ok := d.GetOk("algorithm")
+		if ok {
+			algorithm = algorithmRaw.(string)
+		} else {
+			algorithm = p.Type.DefaultHashAlgorithm()
+		}
+	}
+
 	contextRaw := d.Get("context").(string)
 	var context []byte
 	if len(contextRaw) != 0 {
@@ -1,12 +1,19 @@
 package transit
 
 import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/x509"
 	"encoding/base64"
+	"encoding/pem"
+	"reflect"
 	"strings"
 	"testing"
 
 	"golang.org/x/crypto/ed25519"
 
+	"github.com/cloudflare/circl/sign/ed448"
 	"github.com/hashicorp/vault/logical"
 	"github.com/mitchellh/mapstructure"
 )
@@ -416,3 +423,237 @@ func TestTransit_SignVerify_ED25519(t *testing.T) {
 	verifyRequest(req, false, "bar", sig)
 	verifyRequest(req, true, "bar", v1sig)
 }
+
+func TestTransit_SignVerify_ED448(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo", map[string]interface{}{
+		"type": "ed448",
+	})
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/bar", map[string]interface{}{
+		"type":    "ed448",
+		"derived": true,
+	})
+	mustFail(t, b, storage, logical.UpdateOperation, "keys/baz", map[string]interface{}{
+		"type":                  "ed448",
+		"derived":               true,
+		"convergent_encryption": true,
+	})
+
+	input := "dGhlIHF1aWNrIGJyb3duIGZveA=="
+	rawInput, _ := base64.StdEncoding.DecodeString(input)
+	context := "abcd"
+	decodeSignature := func(sig string) []byte {
+		raw, err := base64.StdEncoding.DecodeString(strings.Split(sig, ":")[2])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return raw
+	}
+	// readPublicKey returns the public key of version 1 of the key as
+	// returned by a read of the key
+	readPublicKey := func(name string, data map[string]interface{}) ed448.PublicKey {
+		resp := mustHandle(t, b, storage, logical.ReadOperation, "keys/"+name, data)
+		key := resp.Data["keys"].(map[string]map[string]interface{})["1"]
+		if key["name"] != "ed448" {
+			t.Fatalf("bad key name: %#v", key)
+		}
+		pubKey, err := base64.StdEncoding.DecodeString(key["public_key"].(string))
+		if err != nil || len(pubKey) != ed448.PublicKeySize {
+			t.Fatalf("bad public key: %#v (err: %v)", key, err)
+		}
+		return ed448.PublicKey(pubKey)
+	}
+
+	// Signatures verify both through the API and independently with the
+	// public key of the key
+	sig := mustHandle(t, b, storage, logical.UpdateOperation, "sign/foo", map[string]interface{}{
+		"input": input,
+	}).Data["signature"].(string)
+	if !strings.HasPrefix(sig, "vault:v1:") || len(decodeSignature(sig)) != ed448.SignatureSize {
+		t.Fatalf("bad signature: %s", sig)
+	}
+	if !ed448.Verify(readPublicKey("foo", nil), rawInput, decodeSignature(sig), "") {
+		t.Fatal("signature does not verify with the public key")
+	}
+	resp := mustHandle(t, b, storage, logical.UpdateOperation, "verify/foo", map[string]interface{}{
+		"input":     input,
+		"signature": sig,
+	})
+	if resp.Data["valid"] != true {
+		t.Fatalf("expected signature to be valid: %#v", resp.Data)
+	}
+	resp = mustHandle(t, b, storage, logical.UpdateOperation, "verify/foo", map[string]interface{}{
+		"input":     base64.StdEncoding.EncodeToString([]byte("another input")),
+		"signature": sig,
+	})
+	if resp.Data["valid"] != false {
+		t.Fatalf("expected signature of another input to be invalid: %#v", resp.Data)
+	}
+
+	// Derived keys have a public key per context, which is returned with the
+	// signature and by reads with the context
+	resp = mustHandle(t, b, storage, logical.UpdateOperation, "sign/bar", map[string]interface{}{
+		"input":   input,
+		"context": context,
+	})
+	sig = resp.Data["signature"].(string)
+	derivedPubKey := ed448.PublicKey(resp.Data["public_key"].([]byte))
+	if !ed448.Verify(derivedPubKey, rawInput, decodeSignature(sig), "") {
+		t.Fatal("signature does not verify with the derived public key")
+	}
+	if !bytes.Equal(readPublicKey("bar", map[string]interface{}{"context": context}), derivedPubKey) {
+		t.Fatal("read public key does not match the derived public key")
+	}
+	resp = mustHandle(t, b, storage, logical.ReadOperation, "keys/bar/version/1", map[string]interface{}{
+		"context": context,
+	})
+	if resp.Data["public_key"] != base64.StdEncoding.EncodeToString(derivedPubKey) {
+		t.Fatalf("bad derived public key for the version: %#v", resp.Data)
+	}
+	resp = mustHandle(t, b, storage, logical.UpdateOperation, "verify/bar", map[string]interface{}{
+		"input":     input,
+		"context":   context,
+		"signature": sig,
+	})
+	if resp.Data["valid"] != true {
+		t.Fatalf("expected signature to be valid: %#v", resp.Data)
+	}
+	resp = mustHandle(t, b, storage, logical.UpdateOperation, "verify/bar", map[string]interface{}{
+		"input":     input,
+		"context":   "ZWZnaA==",
+		"signature": sig,
+	})
+	if resp.Data["valid"] != false {
+		t.Fatalf("expected signature to be invalid with another context: %#v", resp.Data)
+	}
+	mustFail(t, b, storage, logical.UpdateOperation, "sign/bar", map[string]interface{}{
+		"input": input,
+	})
+}
+
+func TestTransit_SignVerify_ECDSA(t *testing.T) {
+	testTransit_SignVerify_ECDSA(t, "ecdsa-p384", elliptic.P384(), "sha2-384")
+	testTransit_SignVerify_ECDSA(t, "ecdsa-p521", elliptic.P521(), "sha2-512")
+}
+
+func testTransit_SignVerify_ECDSA(t *testing.T, keyType string, curve elliptic.Curve, defaultAlgorithm string) {
+	b, storage := createBackendWithStorage(t)
+
+	req := &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "keys/foo",
+		Data: map[string]interface{}{
+			"type": keyType,
+		},
+	}
+	mustHandleRequest(t, b, req)
+
+	// The public key returned by a read must be on the right curve
+	req.Operation = logical.ReadOperation
+	req.Data = nil
+	resp := mustHandleRequest(t, b, req)
+	if resp.Data["type"] != keyType {
+		t.Fatalf("%s: bad type: %#v", keyType, resp.Data["type"])
+	}
+	keyData := resp.Data["keys"].(map[string]map[string]interface{})["1"]
+	if keyData["name"] != curve.Params().Name {
+		t.Fatalf("%s: bad curve name: %#v", keyType, keyData["name"])
+	}
+	block, _ := pem.Decode([]byte(keyData["public_key"].(string)))
+	if block == nil {
+		t.Fatalf("%s: could not decode public key", keyType)
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pub.(*ecdsa.PublicKey).Curve != curve {
+		t.Fatalf("%s: public key is on the wrong curve", keyType)
+	}
+
+	input := base64.StdEncoding.EncodeToString([]byte("the quick brown fox"))
+
+	// Sign with the default algorithm for the curve
+	req.Operation = logical.UpdateOperation
+	req.Path = "sign/foo"
+	req.Data = map[string]interface{}{
+		"input": input,
+	}
+	sig := mustHandleRequest(t, b, req).Data["signature"].(string)
+
+	verify := func(algorithm string) bool {
+		req.Path = "verify/foo"
+		req.Data = map[string]interface{}{
+			"input":     input,
+			"signature": sig,
+		}
+		if algorithm != "" {
+			req.Data["algorithm"] = algorithm
+		}
+		return mustHandleRequest(t, b, req).Data["valid"].(bool)
+	}
+
+	if !verify("") {
+		t.Fatalf("%s: signature did not verify with the default algorithm", keyType)
+	}
+	if !verify(defaultAlgorithm) {
+		t.Fatalf("%s: signature did not verify with %s", keyType, defaultAlgorithm)
+	}
+	if verify("sha2-256") {
+		t.Fatalf("%s: signature unexpectedly verified with sha2-256", keyType)
+	}
+
+	// An explicit algorithm still takes precedence
+	req.Path = "sign/foo/sha2-256"
+	req.Data = map[string]interface{}{
+		"input": input,
+	}
+	sig = mustHandleRequest(t, b, req).Data["signature"].(string)
+	if !verify("sha2-256") {
+		t.Fatalf("%s: signature did not verify with sha2-256", keyType)
+	}
+}
+
+func TestTransit_RSASignatureHashes(t *testing.T) {
+	testTransit_RSASignatureHashes(t, "rsa-2048", []string{"sha2-224", "sha2-256", "sha2-384", "sha2-512"})
+	testTransit_RSASignatureHashes(t, "rsa-4096", []string{"sha2-256", "sha2-384", "sha2-512"})
+}
+
+func testTransit_RSASignatureHashes(t *testing.T, keyType string, allowed []string) {
+	b, storage := createBackendWithStorage(t)
+
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/foo", map[string]interface{}{
+		"type": keyType,
+	})
+	resp := mustHandle(t, b, storage, logical.ReadOperation, "keys/foo", nil)
+	if !reflect.DeepEqual(resp.Data["allowed_signature_hashes"], allowed) || resp.Data["recommended_signature_hash"] != "sha2-256" {
+		t.Fatalf("%s: bad signature hashes: %#v", keyType, resp.Data)
+	}
+
+	// Only hashes weaker than the key are warned about when signing
+	input := base64.StdEncoding.EncodeToString([]byte("the quick brown fox"))
+	for _, algorithm := range []string{"sha2-224", "sha2-256", "sha2-384", "sha2-512"} {
+		resp = mustHandle(t, b, storage, logical.UpdateOperation, "sign/foo/"+algorithm, map[string]interface{}{
+			"input": input,
+		})
+		weak := true
+		for _, a := range allowed {
+			if a == algorithm {
+				weak = false
+			}
+		}
+		if warned := len(resp.Warnings) != 0; warned != weak {
+			t.Fatalf("%s: expected a warning for %s: %t, got %v", keyType, algorithm, weak, resp.Warnings)
+		}
+	}
+
+	// Other key types have no restrictions
+	mustHandle(t, b, storage, logical.UpdateOperation, "keys/ecdsa", map[string]interface{}{
+		"type": "ecdsa-p256",
+	})
+	if resp := mustHandle(t, b, storage, logical.ReadOperation, "keys/ecdsa", nil); resp.Data["allowed_signature_hashes"] != nil {
+		t.Fatalf("unexpected signature hashes for ecdsa key: %#v", resp.Data)
+	}
+}
@@ -0,0 +1,125 @@
+package transit
+
+import (
+	"testing"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestTransit_Trim(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	req := &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "keys/aes",
+	}
+	mustHandleRequest(t, b, req)
+
+	// Encrypt something with the first version so we can tell when it is gone
+	req.Path = "encrypt/aes"
+	req.Data = map[string]interface{}{
+		"plaintext": "dGhlIHF1aWNrIGJyb3duIGZveA==",
+	}
+	v1Ciphertext := mustHandleRequest(t, b, req).Data["ciphertext"].(string)
+
+	req.Path = "keys/aes/rotate"
+	req.Data = nil
+	for i := 0; i < 4; i++ {
+		mustHandleRequest(t, b, req)
+	}
+
+	req.Path = "encrypt/aes"
+	req.Data = map[string]interface{}{
+		"plaintext": "dGhlIHF1aWNrIGJyb3duIGZveA==",
+	}
+	v5Ciphertext := mustHandleRequest(t, b, req).Data["ciphertext"].(string)
+
+	// Versions still allowed for decryption cannot be trimmed
+	req.Path = "keys/aes/trim"
+	req.Data = map[string]interface{}{
+		"min_available_version": 2,
+	}
+	mustFailRequest(t, b, req)
+
+	req.Path = "keys/aes/config"
+	req.Data = map[string]interface{}{
+		"min_decryption_version": 5,
+		"min_encryption_version": 5,
+	}
+	mustHandleRequest(t, b, req)
+
+	req.Path = "keys/aes/trim"
+	req.Data = map[string]interface{}{
+		"min_available_version": 6,
+	}
+	mustFailRequest(t, b, req)
+	req.Data["min_available_version"] = 0
+	mustFailRequest(t, b, req)
+
+	// Trim down to a single version
+	req.Data["min_available_version"] = 5
+	mustHandleRequest(t, b, req)
+
+	p, lock, err := b.lm.GetPolicyShared(storage, "aes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive, err := p.LoadArchive(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archive.Keys) != 1 {
+		t.Fatalf("expected 1 archived key, got %d", len(archive.Keys))
+	}
+	if p.MinAvailableVersion != 5 {
+		t.Fatalf("bad min available version: %d", p.MinAvailableVersion)
+	}
+	lock.RUnlock()
+
+	// The minimum available version cannot go backwards
+	req.Data["min_available_version"] = 4
+	mustFailRequest(t, b, req)
+
+	// Trimmed versions cannot be made decryptable again
+	req.Path = "keys/aes/config"
+	req.Data = map[string]interface{}{
+		"min_decryption_version": 4,
+	}
+	mustFailRequest(t, b, req)
+
+	req.Path = "decrypt/aes"
+	req.Data = map[string]interface{}{
+		"ciphertext": v5Ciphertext,
+	}
+	mustHandleRequest(t, b, req)
+	req.Data["ciphertext"] = v1Ciphertext
+	mustFailRequest(t, b, req)
+
+	// Rotation continues to archive relative to the trimmed minimum
+	req.Path = "keys/aes/rotate"
+	req.Data = nil
+	mustHandleRequest(t, b, req)
+
+	p, lock, err = b.lm.GetPolicyShared(storage, "aes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.RUnlock()
+	archive, err = p.LoadArchive(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archive.Keys) != 2 {
+		t.Fatalf("expected 2 archived keys, got %d", len(archive.Keys))
+	}
+	if p.LatestVersion != 6 || p.ArchiveVersion != 6 {
+		t.Fatalf("bad versions: latest %d, archive %d", p.LatestVersion, p.ArchiveVersion)
+	}
+
+	req.Path = "decrypt/aes"
+	req.Data = map[string]interface{}{
+		"ciphertext": v5Ciphertext,
+	}
+	mustHandleRequest(t, b, req)
+}
@@ -0,0 +1,100 @@
+package transit
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+
+	"github.com/hashicorp/vault/helper/jsonutil"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+const wrappingKeyStorageKey = "wrapping_key"
+
+// wrappingKeyEntry is the storage format of the key used to wrap imported
+// key material
+type wrappingKeyEntry struct {
+	Key []byte `json:"key"`
+}
+
+func (b *backend) pathWrappingKey() *framework.Path {
+	return &framework.Path{
+		Pattern: "wrapping_key",
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.pathWrappingKeyRead,
+		},
+
+		HelpSynopsis:    pathWrappingKeyHelpSyn,
+		HelpDescription: pathWrappingKeyHelpDesc,
+	}
+}
+
+func (b *backend) pathWrappingKeyRead(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	key, err := b.getWrappingKey(req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	derBytes, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling wrapping public key: %s", err)
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"public_key": string(pem.EncodeToMemory(&pem.Block{
+				Type:  "PUBLIC KEY",
+				Bytes: derBytes,
+			})),
+		},
+	}, nil
+}
+
+// getWrappingKey returns the RSA key used to unwrap imported key material,
+// generating and storing it on first use
+func (b *backend) getWrappingKey(storage logical.Storage) (*rsa.PrivateKey, error) {
+	b.wrappingKeyLock.Lock()
+	defer b.wrappingKeyLock.Unlock()
+
+	raw, err := storage.Get(wrappingKeyStorageKey)
+	if err != nil {
+		return nil, err
+	}
+	if raw != nil {
+		var entry wrappingKeyEntry
+		if err := jsonutil.DecodeJSON(raw.Value, &entry); err != nil {
+			return nil, err
+		}
+		return x509.ParsePKCS1PrivateKey(entry.Key)
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 4096)
+	if err != nil {
+		return nil, err
+	}
+
+	storageEntry, err := logical.StorageEntryJSON(wrappingKeyStorageKey, &wrappingKeyEntry{
+		Key: x509.MarshalPKCS1PrivateKey(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := storage.Put(storageEntry); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+const pathWrappingKeyHelpSyn = `Returns the public key to use for wrapping imported keys`
+
+const pathWrappingKeyHelpDesc = `
+This path is used to retrieve the RSA-4096 public key that key material
+must be wrapped with before it is imported via the keys/<name>/import and
+keys/<name>/import_version paths. The key is generated on first use.
+`
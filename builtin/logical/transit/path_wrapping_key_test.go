@@ -0,0 +1,58 @@
+package transit
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestTransit_WrappingKey(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	readWrappingKey := func(b *backend) string {
+		resp, err := b.HandleRequest(&logical.Request{
+			Storage:   storage,
+			Operation: logical.ReadOperation,
+			Path:      "wrapping_key",
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("got err:\n%#v\nresp:\n%#v\n", err, resp)
+		}
+		return resp.Data["public_key"].(string)
+	}
+
+	pubKey := readWrappingKey(b)
+	block, _ := pem.Decode([]byte(pubKey))
+	if block == nil || block.Type != "PUBLIC KEY" {
+		t.Fatalf("bad wrapping key PEM: %q", pubKey)
+	}
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, ok := parsed.(*rsa.PublicKey)
+	if !ok {
+		t.Fatalf("expected an RSA public key, got %T", parsed)
+	}
+	if rsaKey.N.BitLen() != 4096 {
+		t.Fatalf("expected a 4096-bit key, got %d bits", rsaKey.N.BitLen())
+	}
+
+	if readWrappingKey(b) != pubKey {
+		t.Fatal("wrapping key changed between reads")
+	}
+
+	// A new backend on the same storage must load the persisted key
+	config := logical.TestBackendConfig()
+	config.StorageView = storage
+	b2 := Backend(config)
+	if err := b2.Backend.Setup(config); err != nil {
+		t.Fatal(err)
+	}
+	if readWrappingKey(b2) != pubKey {
+		t.Fatal("wrapping key was not persisted")
+	}
+}
@@ -0,0 +1,99 @@
+package transit
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/helper/keysutil"
+	"github.com/hashicorp/vault/logical"
+)
+
+// keyRateLimiter enforces the operation rate limits of keys with a token
+// bucket per key name. The buckets are kept in memory only, so each node
+// enforces the limits separately and they are reset on restart.
+type keyRateLimiter struct {
+	lock    sync.Mutex
+	buckets map[string]*tokenBucket
+
+	// Returns the current time; replaced in tests
+	now func() time.Time
+}
+
+// tokenBucket holds up to limit tokens, refilled at limit tokens per second,
+// so that bursts of up to one second's worth of operations are allowed
+type tokenBucket struct {
+	limit  int
+	tokens float64
+	last   time.Time
+}
+
+func newKeyRateLimiter() *keyRateLimiter {
+	return &keyRateLimiter{
+		buckets: map[string]*tokenBucket{},
+		now:     time.Now,
+	}
+}
+
+// allow takes the given number of tokens from the bucket of the named key,
+// returning false and taking none if fewer are left. A limit of 0 or less
+// means that the key is not limited.
+func (l *keyRateLimiter) allow(name string, limit int, tokens int) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if limit <= 0 {
+		delete(l.buckets, name)
+		return true
+	}
+
+	now := l.now()
+	bucket, ok := l.buckets[name]
+	if !ok || bucket.limit != limit {
+		// Start with a full bucket whenever the limit is set or changed
+		bucket = &tokenBucket{
+			limit:  limit,
+			tokens: float64(limit),
+			last:   now,
+		}
+		l.buckets[name] = bucket
+	}
+
+	if elapsed := now.Sub(bucket.last); elapsed > 0 {
+		bucket.tokens += elapsed.Seconds() * float64(limit)
+		if bucket.tokens > float64(limit) {
+			bucket.tokens = float64(limit)
+		}
+	}
+	bucket.last = now
+
+	if bucket.tokens < float64(tokens) {
+		return false
+	}
+	bucket.tokens -= float64(tokens)
+	return true
+}
+
+// forget drops the bucket of the named key, so that a key created later with
+// the same name starts with a full bucket
+func (l *keyRateLimiter) forget(name string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	delete(l.buckets, name)
+}
+
+// checkRateLimit returns an error response, which is reported with a 429
+// status code, if the given number of operations would exceed the operation
+// rate limit of the key. Each item of a batch request counts as an operation.
+// Batches with more items than the limit could never be allowed, so they are
+// rejected as invalid rather than rate limited, which clients would retry.
+func (b *backend) checkRateLimit(p *keysutil.Policy, operations int) (*logical.Response, error) {
+	if p.OperationRateLimit > 0 && operations > p.OperationRateLimit {
+		return logical.ErrorResponse(fmt.Sprintf("batch of %d operations exceeds the operation rate limit of %d per second for key %s; split it into batches of at most %d items", operations, p.OperationRateLimit, p.Name, p.OperationRateLimit)), logical.ErrInvalidRequest
+	}
+	if b.rateLimiter.allow(p.Name, p.OperationRateLimit, operations) {
+		return nil, nil
+	}
+	return logical.ErrorResponse(fmt.Sprintf("operation rate limit of %d per second exceeded for key %s", p.OperationRateLimit, p.Name)), logical.ErrRateLimited
+}
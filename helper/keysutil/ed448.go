@@ -0,0 +1,69 @@
+package keysutil
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+
+	"github.com/cloudflare/circl/sign/ed448"
+)
+
+// oidEd448 identifies Ed448 keys in PKCS#8 and SubjectPublicKeyInfo
+// structures (RFC 8410). The x509 package does not know about Ed448, so these
+// structures are encoded here.
+var oidEd448 = asn1.ObjectIdentifier{1, 3, 101, 113}
+
+type ed448PKCS8 struct {
+	Version    int
+	Algo       pkix.AlgorithmIdentifier
+	PrivateKey []byte
+	// optional attributes and public key omitted
+}
+
+type ed448SPKI struct {
+	Algo      pkix.AlgorithmIdentifier
+	PublicKey asn1.BitString
+}
+
+// parseEd448PKCS8PrivateKey returns the Ed448 private key held in the given
+// DER-encoded PKCS#8 structure
+func parseEd448PKCS8PrivateKey(der []byte) (ed448.PrivateKey, error) {
+	var privKey ed448PKCS8
+	rest, err := asn1.Unmarshal(der, &privKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("trailing data after private key")
+	}
+	if !privKey.Algo.Algorithm.Equal(oidEd448) {
+		return nil, fmt.Errorf("private key algorithm is not ed448")
+	}
+	if len(privKey.Algo.Parameters.FullBytes) != 0 {
+		return nil, fmt.Errorf("ed448 private key must not have algorithm parameters")
+	}
+
+	// The private key is itself an octet string holding the seed
+	var seed []byte
+	if rest, err := asn1.Unmarshal(privKey.PrivateKey, &seed); err != nil || len(rest) != 0 {
+		return nil, fmt.Errorf("invalid ed448 private key")
+	}
+	if len(seed) != ed448.SeedSize {
+		return nil, fmt.Errorf("ed448 private key must be %d bytes long, got %d", ed448.SeedSize, len(seed))
+	}
+	return ed448.NewKeyFromSeed(seed), nil
+}
+
+// MarshalEd448PublicKey returns the DER-encoded SubjectPublicKeyInfo of the
+// given Ed448 public key
+func MarshalEd448PublicKey(pubKey ed448.PublicKey) ([]byte, error) {
+	return asn1.Marshal(ed448SPKI{
+		Algo: pkix.AlgorithmIdentifier{
+			Algorithm: oidEd448,
+		},
+		PublicKey: asn1.BitString{
+			Bytes:     pubKey,
+			BitLength: 8 * len(pubKey),
+		},
+	})
+}
@@ -1,12 +1,20 @@
 package keysutil
 
 import (
+	"container/list"
+	"encoding/base64"
 	"errors"
 	"fmt"
+	"regexp"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	uuid "github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/helper/errutil"
 	"github.com/hashicorp/vault/helper/jsonutil"
 	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
 )
 
 const (
@@ -36,14 +44,68 @@ type PolicyRequest struct {
 	// Whether to enable convergent encryption
 	Convergent bool
 
+	// The convergent encryption scheme to use; zero uses
+	// LatestConvergentVersion
+	ConvergentVersion int
+
 	// Whether to allow export
 	Exportable bool
 
+	// Key versions to allow export of when the key as a whole is not
+	// exportable
+	AllowedExportVersions []int
+
+	// Whether to allow plaintext backup of the key
+	AllowPlaintextBackup bool
+
+	// Whether an imported key may be rotated to Vault-generated key material
+	AllowImportedKeyRotation bool
+
+	// The operations the key may be used for; empty allows all operations
+	AllowedOperations []string
+
+	// The maximum number of versions to keep available; zero for no limit
+	MaxVersions int
+
+	// Informational key/value tags to attach to the key
+	Tags map[string]string
+
+	// An informational description of the key
+	Description string
+
+	// The identity of the caller creating the key, if any
+	CreatedBy string
+
+	// How often the key should be automatically rotated; zero disables
+	// automatic rotation
+	AutoRotatePeriod time.Duration
+
+	// The source of randomness to generate key material from; empty uses the
+	// platform's
+	EntropySource string
+
+	// The number of versions to create a new key with; zero or one creates
+	// only the first version. At most MaxInitialVersions.
+	NumVersions int
+
+	// If set, the key material is held outside of Vault under this name by
+	// the managed key backend
+	ManagedKeyName string
+
 	// Whether to upsert
 	Upsert bool
 }
 
+// MaxInitialVersions is the largest number of versions a new policy can be
+// created with. All of them are generated while the policy is locked.
+const MaxInitialVersions = 100
+
 type LockManager struct {
+	// Counts of policies served from and missing from the cache. These are
+	// first in the struct so that they are 64-bit aligned for atomic access.
+	cacheHits   uint64
+	cacheMisses uint64
+
 	// A lock for each named key
 	locks map[string]*sync.RWMutex
 
@@ -53,8 +115,24 @@ type LockManager struct {
 	// If caching is enabled, the map of name to in-memory policy cache
 	cache map[string]*Policy
 
+	// The names of the cached policies from the most to the least recently
+	// used, and the element of each name in the list. Guarded by
+	// cacheOrderMutex, since lookups served from the cache only hold
+	// cacheMutex for reading.
+	cacheOrder      *list.List
+	cacheElements   map[string]*list.Element
+	cacheOrderMutex sync.Mutex
+
+	// The maximum number of cached policies; zero means that the cache is
+	// not limited. Guarded by cacheMutex.
+	cacheMaxSize int
+
 	// Used for global locking, and as the cache map mutex
 	cacheMutex sync.RWMutex
+
+	// Performs operations with managed keys; if nil, operations with
+	// managed keys fail
+	managedKeys ManagedKeyBackend
 }
 
 func NewLockManager(cacheDisabled bool) *LockManager {
@@ -63,20 +141,158 @@ func NewLockManager(cacheDisabled bool) *LockManager {
 	}
 	if !cacheDisabled {
 		lm.cache = map[string]*Policy{}
+		lm.cacheOrder = list.New()
+		lm.cacheElements = map[string]*list.Element{}
 	}
 	return lm
 }
 
+// SetManagedKeyBackend sets the backend performing operations with managed
+// keys. It must be called before any policies are loaded.
+func (lm *LockManager) SetManagedKeyBackend(managedKeys ManagedKeyBackend) {
+	lm.managedKeys = managedKeys
+}
+
 func (lm *LockManager) CacheActive() bool {
 	return lm.cache != nil
 }
 
+// CacheStats holds statistics about the policy cache
+type CacheStats struct {
+	// The number of policy lookups served from the cache, and the number that
+	// had to be loaded from storage
+	Hits   uint64
+	Misses uint64
+
+	// The number of policies currently cached
+	Entries int
+
+	// The maximum number of cached policies; zero means that the cache is
+	// not limited
+	MaxSize int
+}
+
+// CacheStats returns statistics about the policy cache. The counts are only
+// kept while caching is enabled.
+func (lm *LockManager) CacheStats() CacheStats {
+	stats := CacheStats{
+		Hits:   atomic.LoadUint64(&lm.cacheHits),
+		Misses: atomic.LoadUint64(&lm.cacheMisses),
+	}
+	if lm.CacheActive() {
+		lm.cacheMutex.RLock()
+		stats.Entries = len(lm.cache)
+		stats.MaxSize = lm.cacheMaxSize
+		lm.cacheMutex.RUnlock()
+	}
+	return stats
+}
+
+// SetCacheMaxSize sets the maximum number of cached policies, where zero
+// means that the cache is not limited. If more policies are cached, the least
+// recently used policies are evicted until the cache fits the new size. Evicting a policy is safe
+// while it is in use, as the policy lock is held by name: the next lookup
+// loads it from storage again once the lock is free.
+func (lm *LockManager) SetCacheMaxSize(size int) {
+	if !lm.CacheActive() {
+		return
+	}
+
+	lm.cacheMutex.Lock()
+	defer lm.cacheMutex.Unlock()
+	lm.cacheMaxSize = size
+	lm.makeCacheRoom(0)
+}
+
+// cachePolicy adds the policy to the cache as the most recently used one,
+// evicting the least recently used policies if the cache is full. The caller
+// must hold the cache mutex exclusively.
+func (lm *LockManager) cachePolicy(name string, p *Policy) {
+	if _, ok := lm.cache[name]; !ok {
+		lm.makeCacheRoom(1)
+	}
+	lm.cache[name] = p
+	lm.touchCachedPolicy(name)
+}
+
+// touchCachedPolicy marks the named policy as the most recently used one.
+// The caller must hold the cache mutex, for reading at least.
+func (lm *LockManager) touchCachedPolicy(name string) {
+	lm.cacheOrderMutex.Lock()
+	defer lm.cacheOrderMutex.Unlock()
+	if elem, ok := lm.cacheElements[name]; ok {
+		lm.cacheOrder.MoveToFront(elem)
+		return
+	}
+	lm.cacheElements[name] = lm.cacheOrder.PushFront(name)
+}
+
+// uncachePolicy removes the named policy from the cache. The caller must hold
+// the cache mutex exclusively.
+func (lm *LockManager) uncachePolicy(name string) {
+	delete(lm.cache, name)
+	lm.cacheOrderMutex.Lock()
+	defer lm.cacheOrderMutex.Unlock()
+	if elem, ok := lm.cacheElements[name]; ok {
+		lm.cacheOrder.Remove(elem)
+		delete(lm.cacheElements, name)
+	}
+}
+
+// makeCacheRoom evicts the least recently used policies until the given
+// number of policies can be added without exceeding the maximum cache size.
+// The caller must hold the cache mutex exclusively.
+func (lm *LockManager) makeCacheRoom(room int) {
+	if lm.cacheMaxSize <= 0 {
+		return
+	}
+	for len(lm.cache) > 0 && len(lm.cache)+room > lm.cacheMaxSize {
+		lm.cacheOrderMutex.Lock()
+		elem := lm.cacheOrder.Back()
+		lm.cacheOrderMutex.Unlock()
+		if elem == nil {
+			// Only policies not cached through cachePolicy can be missing
+			// from the order; they are evicted in no particular order
+			for name := range lm.cache {
+				delete(lm.cache, name)
+				break
+			}
+			continue
+		}
+		lm.uncachePolicy(elem.Value.(string))
+	}
+}
+
+// PolicyCached returns whether the named policy is currently cached
+func (lm *LockManager) PolicyCached(name string) bool {
+	if !lm.CacheActive() {
+		return false
+	}
+	lm.cacheMutex.RLock()
+	defer lm.cacheMutex.RUnlock()
+	return lm.cache[name] != nil
+}
+
 func (lm *LockManager) InvalidatePolicy(name string) {
 	// Check if it's in our cache. If so, return right away.
 	if lm.CacheActive() {
 		lm.cacheMutex.Lock()
 		defer lm.cacheMutex.Unlock()
-		delete(lm.cache, name)
+		lm.uncachePolicy(name)
+	}
+}
+
+// InvalidateAllPolicies removes every policy from the cache, for instance
+// when the storage the policies are loaded from changes
+func (lm *LockManager) InvalidateAllPolicies() {
+	if lm.CacheActive() {
+		lm.cacheMutex.Lock()
+		defer lm.cacheMutex.Unlock()
+		lm.cache = map[string]*Policy{}
+		lm.cacheOrderMutex.Lock()
+		lm.cacheOrder.Init()
+		lm.cacheElements = map[string]*list.Element{}
+		lm.cacheOrderMutex.Unlock()
 	}
 }
 
@@ -214,10 +430,13 @@ func (lm *LockManager) getPolicyCommon(req PolicyRequest, lockType bool) (*Polic
 		lm.cacheMutex.RLock()
 		p = lm.cache[req.Name]
 		if p != nil {
+			lm.touchCachedPolicy(req.Name)
 			lm.cacheMutex.RUnlock()
+			atomic.AddUint64(&lm.cacheHits, 1)
 			return p, lock, false, nil
 		}
 		lm.cacheMutex.RUnlock()
+		atomic.AddUint64(&lm.cacheMisses, 1)
 	}
 
 	// Load it from storage
@@ -240,53 +459,41 @@ func (lm *LockManager) getPolicyCommon(req PolicyRequest, lockType bool) (*Polic
 			return nil, nil, false, errNeedExclusiveLock
 		}
 
-		switch req.KeyType {
-		case KeyType_AES256_GCM96:
-			if req.Convergent && !req.Derived {
-				lm.UnlockPolicy(lock, lockType)
-				return nil, nil, false, fmt.Errorf("convergent encryption requires derivation to be enabled")
-			}
-
-		case KeyType_ECDSA_P256:
-			if req.Derived || req.Convergent {
-				lm.UnlockPolicy(lock, lockType)
-				return nil, nil, false, fmt.Errorf("key derivation and convergent encryption not supported for keys of type %v", req.KeyType)
-			}
-
-		case KeyType_ED25519:
-			if req.Convergent {
-				lm.UnlockPolicy(lock, lockType)
-				return nil, nil, false, fmt.Errorf("convergent encryption not supported for keys of type %v", req.KeyType)
-			}
-
-		case KeyType_RSA2048, KeyType_RSA4096:
-			if req.Derived || req.Convergent {
-				lm.UnlockPolicy(lock, lockType)
-				return nil, nil, false, fmt.Errorf("key derivation and convergent encryption not supported for keys of type %v", req.KeyType)
-			}
-
-		default:
+		if err := validatePolicyRequest(req); err != nil {
 			lm.UnlockPolicy(lock, lockType)
-			return nil, nil, false, fmt.Errorf("unsupported key type %v", req.KeyType)
-		}
-
-		p = &Policy{
-			Name:       req.Name,
-			Type:       req.KeyType,
-			Derived:    req.Derived,
-			Exportable: req.Exportable,
+			return nil, nil, false, err
 		}
-		if req.Derived {
-			p.KDF = Kdf_hkdf_sha256
-			p.ConvergentEncryption = req.Convergent
-			p.ConvergentVersion = 2
+		if req.ManagedKeyName != "" && lm.managedKeys == nil {
+			lm.UnlockPolicy(lock, lockType)
+			return nil, nil, false, errutil.UserError{Err: "no managed key backend is configured, so managed keys cannot be created"}
 		}
 
-		err = p.Rotate(req.Storage)
+		p, err = newPolicy(req)
 		if err != nil {
 			lm.UnlockPolicy(lock, lockType)
 			return nil, nil, false, err
 		}
+		p.managedKeys = lm.managedKeys
+
+		// Managed keys have a single version without local key material
+		if p.ManagedKeyName != "" {
+			err = p.initManagedVersion(req.Storage)
+			if err != nil {
+				lm.UnlockPolicy(lock, lockType)
+				return nil, nil, false, err
+			}
+		}
+
+		// All requested versions are generated before the key is first
+		// stored, so the key is never seen with fewer versions and a failure
+		// leaves no key behind
+		if p.LatestVersion == 0 {
+			err = p.initVersions(req.Storage, req.NumVersions)
+			if err != nil {
+				lm.UnlockPolicy(lock, lockType)
+				return nil, nil, false, err
+			}
+		}
 
 		if lm.CacheActive() {
 			// Since we didn't have the policy in the cache, if there was no
@@ -300,7 +507,7 @@ func (lm *LockManager) getPolicyCommon(req PolicyRequest, lockType bool) (*Polic
 				return exp, lock, false, nil
 			}
 			if err == nil {
-				lm.cache[req.Name] = p
+				lm.cachePolicy(req.Name, p)
 			}
 		}
 
@@ -333,14 +540,147 @@ func (lm *LockManager) getPolicyCommon(req PolicyRequest, lockType bool) (*Polic
 			return exp, lock, false, nil
 		}
 		if err == nil {
-			lm.cache[req.Name] = p
+			lm.cachePolicy(req.Name, p)
 		}
 	}
 
 	return p, lock, false, nil
 }
 
-func (lm *LockManager) DeletePolicy(storage logical.Storage, name string) error {
+// validatePolicyRequest checks that the settings requested for a new policy
+// are supported by its key type
+func validatePolicyRequest(req PolicyRequest) error {
+	switch req.KeyType {
+	case KeyType_AES128_GCM96, KeyType_AES256_GCM96, KeyType_ChaCha20_Poly1305:
+		if req.Convergent && !req.Derived {
+			return errutil.UserError{Err: "convergent encryption requires derivation to be enabled"}
+		}
+		if req.ConvergentVersion != 0 && !req.Convergent {
+			return errutil.UserError{Err: "a convergent version requires convergent encryption to be enabled"}
+		}
+		if req.ConvergentVersion < 0 || req.ConvergentVersion > LatestConvergentVersion {
+			return errutil.UserError{Err: fmt.Sprintf("unsupported convergent version %d; supported versions are 1 to %d", req.ConvergentVersion, LatestConvergentVersion)}
+		}
+
+	case KeyType_ECDSA_P256, KeyType_ECDSA_P384, KeyType_ECDSA_P521:
+		if req.Derived || req.Convergent {
+			return errutil.UserError{Err: fmt.Sprintf("key derivation and convergent encryption not supported for keys of type %v", req.KeyType)}
+		}
+
+	case KeyType_ED25519, KeyType_ED448:
+		if req.Convergent {
+			return errutil.UserError{Err: fmt.Sprintf("convergent encryption not supported for keys of type %v", req.KeyType)}
+		}
+
+	case KeyType_RSA2048, KeyType_RSA3072, KeyType_RSA4096:
+		if req.Derived || req.Convergent {
+			return errutil.UserError{Err: fmt.Sprintf("key derivation and convergent encryption not supported for keys of type %v", req.KeyType)}
+		}
+
+	default:
+		return errutil.UserError{Err: fmt.Sprintf("unsupported key type %v", req.KeyType)}
+	}
+
+	if req.NumVersions > MaxInitialVersions {
+		return errutil.UserError{Err: fmt.Sprintf("a key can be created with at most %d versions", MaxInitialVersions)}
+	}
+
+	if req.ManagedKeyName != "" {
+		if err := validateManagedKeyRequest(req); err != nil {
+			return err
+		}
+	}
+
+	return ValidateEntropySource(req.EntropySource)
+}
+
+// newPolicy returns a policy without any key versions using the settings
+// from the request
+func newPolicy(req PolicyRequest) (*Policy, error) {
+	fingerprint, err := uuid.GenerateUUID()
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Policy{
+		Fingerprint:              fingerprint,
+		Name:                     req.Name,
+		Type:                     req.KeyType,
+		Derived:                  req.Derived,
+		Exportable:               req.Exportable,
+		AllowedExportVersions:    req.AllowedExportVersions,
+		AllowPlaintextBackup:     req.AllowPlaintextBackup,
+		AutoRotatePeriod:         req.AutoRotatePeriod,
+		AllowImportedKeyRotation: req.AllowImportedKeyRotation,
+		AllowedOperations:        req.AllowedOperations,
+		MaxVersions:              req.MaxVersions,
+		Tags:                     req.Tags,
+		Description:              req.Description,
+		CreatedBy:                req.CreatedBy,
+		EntropySource:            req.EntropySource,
+		ManagedKeyName:           req.ManagedKeyName,
+	}
+	if req.Derived {
+		p.KDF = Kdf_hkdf_sha256
+		p.ConvergentEncryption = req.Convergent
+		p.ConvergentVersion = LatestConvergentVersion
+		if req.ConvergentVersion != 0 {
+			p.ConvergentVersion = req.ConvergentVersion
+		}
+	}
+	return p, nil
+}
+
+// ImportPolicy creates a new policy whose first version uses the given key
+// material instead of a generated key. It is an error if the policy already
+// exists.
+func (lm *LockManager) ImportPolicy(req PolicyRequest, key []byte) error {
+	if err := validatePolicyRequest(req); err != nil {
+		return err
+	}
+
+	lock := lm.policyLock(req.Name, exclusive)
+	defer lock.Unlock()
+
+	var existing *Policy
+	var err error
+	if lm.CacheActive() {
+		lm.cacheMutex.RLock()
+		existing = lm.cache[req.Name]
+		lm.cacheMutex.RUnlock()
+	}
+	if existing == nil {
+		existing, err = lm.getStoredPolicy(req.Storage, req.Name)
+		if err != nil {
+			return err
+		}
+	}
+	if existing != nil {
+		return errutil.UserError{Err: fmt.Sprintf("key %s already exists", req.Name)}
+	}
+
+	p, err := newPolicy(req)
+	if err != nil {
+		return err
+	}
+	err = p.ImportKeyVersion(req.Storage, key)
+	if err != nil {
+		return err
+	}
+
+	if lm.CacheActive() {
+		lm.cacheMutex.Lock()
+		lm.cachePolicy(req.Name, p)
+		lm.cacheMutex.Unlock()
+	}
+
+	return nil
+}
+
+// DeletePolicy deletes the named policy and its archive if deletion is allowed
+// for it and, when requireDecommission is set, it has been decommissioned.
+// Both are checked under the exclusive lock held for the deletion.
+func (lm *LockManager) DeletePolicy(storage logical.Storage, name string, requireDecommission bool) error {
 	lm.cacheMutex.Lock()
 	lock := lm.policyLock(name, exclusive)
 	defer lock.Unlock()
@@ -358,12 +698,18 @@ func (lm *LockManager) DeletePolicy(storage logical.Storage, name string) error
 			return err
 		}
 		if p == nil {
-			return fmt.Errorf("could not delete policy; not found")
+			return errutil.UserError{Err: "could not delete policy; not found"}
 		}
 	}
 
 	if !p.DeletionAllowed {
-		return fmt.Errorf("deletion is not allowed for this policy")
+		return errutil.UserError{Err: "deletion is not allowed for this policy; deletion_allowed must first be set on the key's config"}
+	}
+
+	// A key is decommissioned once its minimum decryption version has been
+	// raised to its latest version, the highest value it can be set to
+	if requireDecommission && p.MinDecryptionVersion < p.LatestVersion {
+		return errutil.UserError{Err: fmt.Sprintf("key %s must be decommissioned before it can be deleted; first set min_decryption_version to the latest version %d via keys/%s/config", name, p.LatestVersion, name)}
 	}
 
 	err = storage.Delete("policy/" + name)
@@ -377,7 +723,286 @@ func (lm *LockManager) DeletePolicy(storage logical.Storage, name string) error
 	}
 
 	if lm.CacheActive() {
-		delete(lm.cache, name)
+		lm.uncachePolicy(name)
+	}
+
+	return nil
+}
+
+// RenamePolicy moves the named policy and its archive to a new name. Both
+// names are locked for the duration of the move, so operations against the
+// old name will find it missing once the rename completes.
+func (lm *LockManager) RenamePolicy(storage logical.Storage, name, newName string) error {
+	if name == newName {
+		return errutil.UserError{Err: "new name must differ from the current name"}
+	}
+
+	lm.cacheMutex.Lock()
+	lock := lm.policyLock(name, exclusive)
+	defer lock.Unlock()
+	newLock := lm.policyLock(newName, exclusive)
+	defer newLock.Unlock()
+	defer lm.cacheMutex.Unlock()
+
+	var p *Policy
+	var err error
+
+	if lm.CacheActive() {
+		p = lm.cache[name]
+	}
+	if p == nil {
+		p, err = lm.getStoredPolicy(storage, name)
+		if err != nil {
+			return err
+		}
+		if p == nil {
+			return errutil.UserError{Err: "could not rename policy; not found"}
+		}
+	}
+
+	var existing *Policy
+	if lm.CacheActive() {
+		existing = lm.cache[newName]
+	}
+	if existing == nil {
+		existing, err = lm.getStoredPolicy(storage, newName)
+		if err != nil {
+			return err
+		}
+	}
+	if existing != nil {
+		return errutil.UserError{Err: fmt.Sprintf("could not rename policy; a policy named %s already exists", newName)}
+	}
+
+	archive, err := storage.Get("archive/" + name)
+	if err != nil {
+		return fmt.Errorf("error reading archive %s: %s", name, err)
+	}
+	if archive != nil {
+		err = storage.Put(&logical.StorageEntry{
+			Key:   "archive/" + newName,
+			Value: archive.Value,
+		})
+		if err != nil {
+			return fmt.Errorf("error writing archive %s: %s", newName, err)
+		}
+	}
+
+	p.Name = newName
+	err = p.Persist(storage)
+	if err != nil {
+		p.Name = name
+		storage.Delete("archive/" + newName)
+		return fmt.Errorf("error writing policy %s: %s", newName, err)
+	}
+
+	err = storage.Delete("policy/" + name)
+	if err != nil {
+		return fmt.Errorf("error deleting policy %s: %s", name, err)
+	}
+
+	err = storage.Delete("archive/" + name)
+	if err != nil {
+		return fmt.Errorf("error deleting archive %s: %s", name, err)
+	}
+
+	if lm.CacheActive() {
+		lm.uncachePolicy(name)
+		lm.cachePolicy(newName, p)
+	}
+
+	return nil
+}
+
+// RestorePolicy recreates a policy from a backup produced by Policy.Backup.
+// If name is empty, the name stored in the backup is used. An existing policy
+// is only overwritten if force is set and the policy allows deletion.
+func (lm *LockManager) RestorePolicy(storage logical.Storage, name, backup string, force bool) error {
+	keyData, err := decodeBackup(backup)
+	if err != nil {
+		return err
+	}
+
+	p := keyData.Policy
+	p.managedKeys = lm.managedKeys
+	if name == "" {
+		name = p.Name
+	}
+	if name == "" {
+		return errutil.UserError{Err: "backup does not contain a policy name"}
+	}
+	if err := validateRestoredName(name); err != nil {
+		return err
+	}
+	p.Name = name
+
+	lm.cacheMutex.Lock()
+	lock := lm.policyLock(name, exclusive)
+	defer lock.Unlock()
+	defer lm.cacheMutex.Unlock()
+
+	existing, err := lm.getCachedOrStoredPolicy(storage, name)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		if !force {
+			return errutil.UserError{Err: fmt.Sprintf("key %s already exists; set force to overwrite it", name)}
+		}
+		// Overwriting discards the existing key material just like a
+		// deletion does, so it is only allowed where a deletion would be
+		if !existing.DeletionAllowed {
+			return errutil.UserError{Err: fmt.Sprintf("key %s cannot be overwritten as deletion is not allowed for it; deletion_allowed must first be set on the key's config", name)}
+		}
+	}
+
+	return lm.storeRestoredPolicy(storage, p, keyData.ArchivedKeys)
+}
+
+// MergeRestorePolicy restores a backup produced by Policy.Backup into an
+// existing policy, keeping the union of the versions of both. It fails
+// without changing the policy if the same version has different key material
+// in the backup, or if the policies are not compatible. If the policy does
+// not exist, the backup is restored as with RestorePolicy.
+func (lm *LockManager) MergeRestorePolicy(storage logical.Storage, name, backup string) error {
+	keyData, err := decodeBackup(backup)
+	if err != nil {
+		return err
+	}
+
+	restored := keyData.Policy
+	restored.managedKeys = lm.managedKeys
+	if name == "" {
+		name = restored.Name
+	}
+	if name == "" {
+		return errutil.UserError{Err: "backup does not contain a policy name"}
+	}
+	if err := validateRestoredName(name); err != nil {
+		return err
+	}
+	restored.Name = name
+
+	lm.cacheMutex.Lock()
+	lock := lm.policyLock(name, exclusive)
+	defer lock.Unlock()
+	defer lm.cacheMutex.Unlock()
+
+	existing, err := lm.getCachedOrStoredPolicy(storage, name)
+	if err != nil {
+		return err
+	}
+	if existing == nil {
+		return lm.storeRestoredPolicy(storage, restored, keyData.ArchivedKeys)
+	}
+
+	// The merge is done on a copy so that the cached policy is left as it
+	// was if the merge fails
+	buf, err := existing.Serialize()
+	if err != nil {
+		return err
+	}
+	p := &Policy{
+		Keys: keyEntryMap{},
+	}
+	if err := jsonutil.DecodeJSON(buf, p); err != nil {
+		return err
+	}
+	p.managedKeys = lm.managedKeys
+
+	archive, err := p.LoadArchive(storage)
+	if err != nil {
+		return err
+	}
+	merged, err := p.mergeBackup(archive, restored, keyData.ArchivedKeys)
+	if err != nil {
+		return err
+	}
+
+	return lm.storeRestoredPolicy(storage, p, merged)
+}
+
+// restoredNameRegex matches the policy names the key paths of the transit
+// backend accept
+var restoredNameRegex = regexp.MustCompile("^" + framework.GenericNameRegex("name") + "$")
+
+// validateRestoredName rejects policy names that could not have been created
+// through a key path. The name of a restore may come from the backup itself,
+// so it is checked here rather than by the path pattern of the caller; a
+// policy with any other name could not be read, changed or deleted through
+// the key paths.
+func validateRestoredName(name string) error {
+	if !restoredNameRegex.MatchString(name) {
+		return errutil.UserError{Err: fmt.Sprintf("invalid policy name %q in restore", name)}
+	}
+	return nil
+}
+
+// decodeBackup decodes a backup produced by Policy.Backup
+func decodeBackup(backup string) (*KeyData, error) {
+	backupBytes, err := base64.StdEncoding.DecodeString(backup)
+	if err != nil {
+		return nil, errutil.UserError{Err: fmt.Sprintf("failed to decode backup: %v", err)}
+	}
+
+	// The keys map must exist before decoding since it is filled in place
+	keyData := &KeyData{
+		Policy: &Policy{
+			Keys: keyEntryMap{},
+		},
+	}
+	err = jsonutil.DecodeJSON(backupBytes, keyData)
+	if err != nil {
+		return nil, errutil.UserError{Err: fmt.Sprintf("failed to parse backup: %v", err)}
+	}
+	if keyData.Policy == nil || keyData.Policy.LatestVersion < 1 || len(keyData.Policy.Keys) == 0 {
+		return nil, errutil.UserError{Err: "backup does not contain a valid policy"}
+	}
+	if keyData.ArchivedKeys == nil {
+		keyData.ArchivedKeys = &archivedKeys{
+			Keys: make([]KeyEntry, 0),
+		}
+	}
+	return keyData, nil
+}
+
+// getCachedOrStoredPolicy returns the named policy from the cache, or from
+// storage if it is not cached. The caller must hold the policy's lock.
+func (lm *LockManager) getCachedOrStoredPolicy(storage logical.Storage, name string) (*Policy, error) {
+	if lm.CacheActive() {
+		if p := lm.cache[name]; p != nil {
+			return p, nil
+		}
+	}
+	return lm.getStoredPolicy(storage, name)
+}
+
+// storeRestoredPolicy writes a restored policy and its archive to storage and
+// caches it. The caller must hold the policy's lock.
+func (lm *LockManager) storeRestoredPolicy(storage logical.Storage, p *Policy, archive *archivedKeys) error {
+	var err error
+
+	// Backups taken before fingerprints were introduced do not carry one; it
+	// is derived as when loading such a key
+	if p.Fingerprint == "" {
+		p.Fingerprint, err = p.legacyFingerprint()
+		if err != nil {
+			return err
+		}
+	}
+
+	err = p.storeArchive(archive, storage)
+	if err != nil {
+		return fmt.Errorf("error writing archive %s: %s", p.Name, err)
+	}
+
+	err = p.Persist(storage)
+	if err != nil {
+		return fmt.Errorf("error writing policy %s: %s", p.Name, err)
+	}
+
+	if lm.CacheActive() {
+		lm.cachePolicy(p.Name, p)
 	}
 
 	return nil
@@ -401,6 +1026,17 @@ func (lm *LockManager) getStoredPolicy(storage logical.Storage, name string) (*P
 	if err != nil {
 		return nil, err
 	}
+	policy.managedKeys = lm.managedKeys
+
+	// The fingerprint of keys created before fingerprints were introduced is
+	// only written along with the next change to the key, as loading a key
+	// must not require a write
+	if policy.Fingerprint == "" {
+		policy.Fingerprint, err = policy.legacyFingerprint()
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	return policy, nil
 }
@@ -0,0 +1,110 @@
+package keysutil
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/vault/helper/errutil"
+	"github.com/hashicorp/vault/logical"
+)
+
+// ManagedKeyBackend performs operations with keys whose material is held
+// outside of Vault, such as in an HSM. Keys are referred to by the name they
+// have in the managed key backend.
+type ManagedKeyBackend interface {
+	Encrypt(keyName string, plaintext []byte) ([]byte, error)
+	Decrypt(keyName string, ciphertext []byte) ([]byte, error)
+	Sign(keyName string, input []byte, algorithm string) ([]byte, error)
+	Verify(keyName string, input, sig []byte, algorithm string) (bool, error)
+}
+
+// validateManagedKeyRequest checks that a policy request for a managed key
+// does not ask for anything requiring local key material
+func validateManagedKeyRequest(req PolicyRequest) error {
+	switch {
+	case req.Derived || req.Convergent:
+		return errutil.UserError{Err: "key derivation and convergent encryption are not supported for managed keys"}
+	case req.Exportable || len(req.AllowedExportVersions) != 0 || req.AllowPlaintextBackup:
+		return errutil.UserError{Err: "managed keys cannot be exported or backed up in plaintext"}
+	case req.AutoRotatePeriod != 0 || req.NumVersions > 1:
+		return errutil.UserError{Err: "managed keys cannot be rotated, so they only have a single version"}
+	}
+	return nil
+}
+
+// initManagedVersion creates the single version of a managed key. It holds
+// no key material; operations with it are performed by the managed key
+// backend.
+func (p *Policy) initManagedVersion(storage logical.Storage) error {
+	if p.Keys == nil {
+		p.Keys = keyEntryMap{}
+	}
+
+	now := time.Now()
+	p.LatestVersion = 1
+	return p.addVersion(storage, KeyEntry{
+		CreationTime:           now,
+		DeprecatedCreationTime: now.Unix(),
+	})
+}
+
+// managedKeyBackend returns the backend performing operations with the
+// managed key
+func (p *Policy) managedKeyBackend() (ManagedKeyBackend, error) {
+	if p.managedKeys == nil {
+		return nil, errutil.InternalError{Err: fmt.Sprintf("no managed key backend is available to perform operations with managed key %s", p.ManagedKeyName)}
+	}
+	return p.managedKeys, nil
+}
+
+func (p *Policy) managedEncrypt(ver int, plaintext []byte) (string, error) {
+	managedKeys, err := p.managedKeyBackend()
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := managedKeys.Encrypt(p.ManagedKeyName, plaintext)
+	if err != nil {
+		return "", errutil.InternalError{Err: fmt.Sprintf("failed to encrypt with managed key %s: %v", p.ManagedKeyName, err)}
+	}
+	return p.Type.CiphertextPrefix() + strconv.Itoa(ver) + ":" + base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+func (p *Policy) managedDecrypt(ciphertext []byte) (string, error) {
+	managedKeys, err := p.managedKeyBackend()
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := managedKeys.Decrypt(p.ManagedKeyName, ciphertext)
+	if err != nil {
+		return "", errutil.UserError{Err: fmt.Sprintf("invalid ciphertext: unable to decrypt with managed key %s: %v", p.ManagedKeyName, err)}
+	}
+	return base64.StdEncoding.EncodeToString(plaintext), nil
+}
+
+func (p *Policy) managedSign(ver int, input []byte, algorithm string) (*SigningResult, error) {
+	managedKeys, err := p.managedKeyBackend()
+	if err != nil {
+		return nil, err
+	}
+	sig, err := managedKeys.Sign(p.ManagedKeyName, input, algorithm)
+	if err != nil {
+		return nil, errutil.InternalError{Err: fmt.Sprintf("failed to sign with managed key %s: %v", p.ManagedKeyName, err)}
+	}
+	return &SigningResult{
+		Signature: "vault:v" + strconv.Itoa(ver) + ":" + base64.StdEncoding.EncodeToString(sig),
+	}, nil
+}
+
+func (p *Policy) managedVerify(input, sig []byte, algorithm string) (bool, error) {
+	managedKeys, err := p.managedKeyBackend()
+	if err != nil {
+		return false, err
+	}
+	valid, err := managedKeys.Verify(p.ManagedKeyName, input, sig, algorithm)
+	if err != nil {
+		return false, errutil.InternalError{Err: fmt.Sprintf("failed to verify with managed key %s: %v", p.ManagedKeyName, err)}
+	}
+	return valid, nil
+}
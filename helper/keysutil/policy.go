@@ -6,6 +6,7 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/ecdsa"
+	stded25519 "crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/hmac"
 	"crypto/rand"
@@ -14,18 +15,22 @@ import (
 	"crypto/x509"
 	"encoding/asn1"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"encoding/pem"
 	"fmt"
 	"io"
 	"math/big"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
+	"golang.org/x/crypto/chacha20poly1305"
 	"golang.org/x/crypto/ed25519"
 	"golang.org/x/crypto/hkdf"
 
+	"github.com/cloudflare/circl/sign/ed448"
 	uuid "github.com/hashicorp/go-uuid"
 	"github.com/hashicorp/vault/helper/errutil"
 	"github.com/hashicorp/vault/helper/jsonutil"
@@ -47,10 +52,36 @@ const (
 	KeyType_ED25519
 	KeyType_RSA2048
 	KeyType_RSA4096
+	KeyType_RSA3072
+	KeyType_ECDSA_P384
+	KeyType_ECDSA_P521
+	KeyType_ChaCha20_Poly1305
+	KeyType_AES128_GCM96
+	KeyType_ED448
 )
 
 const ErrTooOld = "ciphertext or signature version is disallowed by policy (too old)"
 
+// The prefixes of ciphertext, which are followed by the key version
+const (
+	ciphertextPrefix       = "vault:v"
+	chaChaCiphertextPrefix = "vault:cc:v"
+)
+
+// The sources of randomness that key material can be generated from
+const (
+	// The operating system's random number generator
+	EntropySourcePlatform = "platform"
+
+	// Entropy augmentation from the seal, which requires an HSM seal
+	EntropySourceSeal = "seal"
+)
+
+// LatestConvergentVersion is the convergent encryption scheme used by new
+// keys. Version 1 requires the nonce to be supplied by the caller; version 2
+// derives it from the context and plaintext and stores it in the ciphertext.
+const LatestConvergentVersion = 2
+
 type SigningResult struct {
 	Signature string
 	PublicKey []byte
@@ -62,9 +93,35 @@ type ecdsaSignature struct {
 
 type KeyType int
 
+// KeyTypes are the key types that can be created, in the order in which they
+// are listed to users
+var KeyTypes = []KeyType{
+	KeyType_AES128_GCM96,
+	KeyType_AES256_GCM96,
+	KeyType_ChaCha20_Poly1305,
+	KeyType_ECDSA_P256,
+	KeyType_ECDSA_P384,
+	KeyType_ECDSA_P521,
+	KeyType_ED25519,
+	KeyType_ED448,
+	KeyType_RSA2048,
+	KeyType_RSA3072,
+	KeyType_RSA4096,
+}
+
+// ParseKeyType returns the key type with the given name
+func ParseKeyType(name string) (KeyType, bool) {
+	for _, kt := range KeyTypes {
+		if kt.String() == name {
+			return kt, true
+		}
+	}
+	return 0, false
+}
+
 func (kt KeyType) EncryptionSupported() bool {
 	switch kt {
-	case KeyType_AES256_GCM96, KeyType_RSA2048, KeyType_RSA4096:
+	case KeyType_AES128_GCM96, KeyType_AES256_GCM96, KeyType_ChaCha20_Poly1305, KeyType_RSA2048, KeyType_RSA3072, KeyType_RSA4096:
 		return true
 	}
 	return false
@@ -72,7 +129,7 @@ func (kt KeyType) EncryptionSupported() bool {
 
 func (kt KeyType) DecryptionSupported() bool {
 	switch kt {
-	case KeyType_AES256_GCM96, KeyType_RSA2048, KeyType_RSA4096:
+	case KeyType_AES128_GCM96, KeyType_AES256_GCM96, KeyType_ChaCha20_Poly1305, KeyType_RSA2048, KeyType_RSA3072, KeyType_RSA4096:
 		return true
 	}
 	return false
@@ -80,7 +137,7 @@ func (kt KeyType) DecryptionSupported() bool {
 
 func (kt KeyType) SigningSupported() bool {
 	switch kt {
-	case KeyType_ECDSA_P256, KeyType_ED25519, KeyType_RSA2048, KeyType_RSA4096:
+	case KeyType_ECDSA_P256, KeyType_ECDSA_P384, KeyType_ECDSA_P521, KeyType_ED25519, KeyType_ED448, KeyType_RSA2048, KeyType_RSA3072, KeyType_RSA4096:
 		return true
 	}
 	return false
@@ -88,30 +145,172 @@ func (kt KeyType) SigningSupported() bool {
 
 func (kt KeyType) HashSignatureInput() bool {
 	switch kt {
-	case KeyType_ECDSA_P256, KeyType_RSA2048, KeyType_RSA4096:
+	case KeyType_ECDSA_P256, KeyType_ECDSA_P384, KeyType_ECDSA_P521, KeyType_RSA2048, KeyType_RSA3072, KeyType_RSA4096:
+		return true
+	}
+	return false
+}
+
+// FIPSApproved returns whether the key type uses an algorithm approved for
+// use in a FIPS 140-2 context. Ed25519, Ed448 and ChaCha20-Poly1305 are not.
+func (kt KeyType) FIPSApproved() bool {
+	switch kt {
+	case KeyType_AES128_GCM96, KeyType_AES256_GCM96, KeyType_ECDSA_P256, KeyType_ECDSA_P384, KeyType_ECDSA_P521, KeyType_RSA2048, KeyType_RSA3072, KeyType_RSA4096:
 		return true
 	}
 	return false
 }
 
+// NonceSize returns the size in bytes of the nonces used by symmetric key
+// types, or 0 for any other key type. Both AEADs use 96 bit nonces.
+func (kt KeyType) NonceSize() int {
+	switch kt {
+	case KeyType_AES128_GCM96, KeyType_AES256_GCM96:
+		return 12
+	case KeyType_ChaCha20_Poly1305:
+		return chacha20poly1305.NonceSize
+	}
+	return 0
+}
+
+// ECDSACurve returns the elliptic curve used by ECDSA key types, or nil for
+// any other key type.
+func (kt KeyType) ECDSACurve() elliptic.Curve {
+	switch kt {
+	case KeyType_ECDSA_P256:
+		return elliptic.P256()
+	case KeyType_ECDSA_P384:
+		return elliptic.P384()
+	case KeyType_ECDSA_P521:
+		return elliptic.P521()
+	}
+	return nil
+}
+
+// DefaultHashAlgorithm returns the hash algorithm used for signing when none
+// is requested. ECDSA keys on larger curves default to a hash with a matching
+// security level.
+func (kt KeyType) DefaultHashAlgorithm() string {
+	switch kt {
+	case KeyType_ECDSA_P384:
+		return "sha2-384"
+	case KeyType_ECDSA_P521:
+		return "sha2-512"
+	}
+	return "sha2-256"
+}
+
+// SignatureHashAlgorithms are the hash algorithms supported for signing with
+// key types that hash the signature input, in increasing order of strength
+var SignatureHashAlgorithms = []string{"sha2-224", "sha2-256", "sha2-384", "sha2-512"}
+
+// signatureHashStrengths are the collision resistance in bits of the hash
+// algorithms supported for signing
+var signatureHashStrengths = map[string]int{
+	"sha2-224": 112,
+	"sha2-256": 128,
+	"sha2-384": 192,
+	"sha2-512": 256,
+}
+
+// AllowedSignatureHashes returns the hash algorithms that are strong enough
+// to sign with RSA keys of the type, in increasing order of strength, or nil
+// for any other key type. A hash is strong enough if its collision
+// resistance is at least the security strength of the key per NIST SP
+// 800-57, which is 112 bits for 2048 bit keys and 128 bits for larger ones.
+func (kt KeyType) AllowedSignatureHashes() []string {
+	var keyStrength int
+	switch kt {
+	case KeyType_RSA2048:
+		keyStrength = 112
+	case KeyType_RSA3072, KeyType_RSA4096:
+		keyStrength = 128
+	default:
+		return nil
+	}
+
+	var allowed []string
+	for _, algorithm := range SignatureHashAlgorithms {
+		if signatureHashStrengths[algorithm] >= keyStrength {
+			allowed = append(allowed, algorithm)
+		}
+	}
+	return allowed
+}
+
+// SignatureHashWeak returns whether signing with keys of the type using the
+// given hash algorithm would be weaker than the key itself
+func (kt KeyType) SignatureHashWeak(algorithm string) bool {
+	allowed := kt.AllowedSignatureHashes()
+	if allowed == nil {
+		return false
+	}
+	for _, a := range allowed {
+		if a == algorithm {
+			return false
+		}
+	}
+	return true
+}
+
 func (kt KeyType) DerivationSupported() bool {
 	switch kt {
-	case KeyType_AES256_GCM96, KeyType_ED25519:
+	case KeyType_AES128_GCM96, KeyType_AES256_GCM96, KeyType_ChaCha20_Poly1305, KeyType_ED25519, KeyType_ED448:
 		return true
 	}
 	return false
 }
 
+// EdDSA returns whether the key type signs with EdDSA. The private keys of
+// these types hold their public keys, so derived keys have a public key per
+// context.
+func (kt KeyType) EdDSA() bool {
+	return kt == KeyType_ED25519 || kt == KeyType_ED448
+}
+
+// Known returns whether the key type is one this version of Vault can use;
+// keys created by newer versions may have types it does not know about
+func (kt KeyType) Known() bool {
+	for _, known := range KeyTypes {
+		if kt == known {
+			return true
+		}
+	}
+	return false
+}
+
+// CiphertextPrefix returns the prefix, followed by the key version, of the
+// ciphertext produced by keys of the type. ChaCha20-Poly1305 ciphertext has
+// its own prefix so that it cannot be mistaken for AES-GCM ciphertext.
+func (kt KeyType) CiphertextPrefix() string {
+	if kt == KeyType_ChaCha20_Poly1305 {
+		return chaChaCiphertextPrefix
+	}
+	return ciphertextPrefix
+}
+
 func (kt KeyType) String() string {
 	switch kt {
+	case KeyType_AES128_GCM96:
+		return "aes128-gcm96"
 	case KeyType_AES256_GCM96:
 		return "aes256-gcm96"
+	case KeyType_ChaCha20_Poly1305:
+		return "chacha20-poly1305"
 	case KeyType_ECDSA_P256:
 		return "ecdsa-p256"
+	case KeyType_ECDSA_P384:
+		return "ecdsa-p384"
+	case KeyType_ECDSA_P521:
+		return "ecdsa-p521"
 	case KeyType_ED25519:
 		return "ed25519"
+	case KeyType_ED448:
+		return "ed448"
 	case KeyType_RSA2048:
 		return "rsa-2048"
+	case KeyType_RSA3072:
+		return "rsa-3072"
 	case KeyType_RSA4096:
 		return "rsa-4096"
 	}
@@ -121,7 +320,7 @@ func (kt KeyType) String() string {
 
 // KeyEntry stores the key and metadata
 type KeyEntry struct {
-	// AES or some other kind that is a pure byte slice like ED25519
+	// AES or some other kind that is a pure byte slice like ED25519 or ED448
 	Key []byte `json:"key"`
 
 	// Key used for HMAC functions
@@ -142,6 +341,12 @@ type KeyEntry struct {
 	// This is deprecated (but still filled) in favor of the value above which
 	// is more precise
 	DeprecatedCreationTime int64 `json:"creation_time"`
+
+	// The version of convergent encryption used with this key version. Zero
+	// means that the policy's convergent version is used; it is set when the
+	// policy's convergent version is upgraded, so that ciphertext produced
+	// with older schemes remains decryptable.
+	ConvergentVersion int `json:"convergent_version"`
 }
 
 // keyEntryMap is used to allow JSON marshal/unmarshal
@@ -189,12 +394,88 @@ type Policy struct {
 	// Whether the key is exportable
 	Exportable bool `json:"exportable"`
 
+	// The source of randomness used to generate key material; empty means
+	// the platform's
+	EntropySource string `json:"entropy_source"`
+
+	// Whether exportability was turned off after having been enabled. It
+	// cannot be enabled again, so that a key does not flip between being
+	// exportable and not.
+	ExportRevoked bool `json:"export_revoked"`
+
+	// Key versions that are exportable even if the key as a whole is not.
+	// Versions are only ever added to this list, never removed.
+	AllowedExportVersions []int `json:"allowed_export_versions"`
+
+	// Whether the key may be backed up in plaintext. Unlike exportability,
+	// this can be turned off again to stop future backups.
+	AllowPlaintextBackup bool `json:"allow_plaintext_backup"`
+
+	// Whether the key material was imported rather than generated by Vault,
+	// and if so whether it may be rotated to generated key material
+	Imported                 bool `json:"imported"`
+	AllowImportedKeyRotation bool `json:"allow_imported_key_rotation"`
+
+	// The maximum number of versions to keep available; older versions are
+	// trimmed on rotation once they are no longer allowed to be used. Zero
+	// means that there is no limit.
+	MaxVersions int `json:"max_versions"`
+
+	// A random identifier assigned when the key is created. It does not change
+	// on rotation or rename and reveals nothing about the key material.
+	Fingerprint string `json:"fingerprint"`
+
+	// The operations the key may be used for; empty means that every
+	// operation supported by the key type is allowed
+	AllowedOperations []string `json:"allowed_operations"`
+
+	// Arbitrary key/value tags, such as an owning team. They are purely
+	// informational and never used in any cryptographic operation.
+	Tags map[string]string `json:"tags"`
+
+	// A free-text description of the key's purpose. Like tags it is purely
+	// informational.
+	Description string `json:"description"`
+
+	// The entity ID or, without one, the display name of the caller that
+	// created the key; empty if the request carried neither. It is set once
+	// at creation and never changed.
+	CreatedBy string `json:"created_by"`
+
+	// Whether the key has been disabled. A disabled key can still be read and
+	// configured but cannot be used for any cryptographic operation.
+	Disabled bool `json:"disabled"`
+
+	// The maximum number of cryptographic operations per second allowed with
+	// the key; zero means that operations are not limited
+	OperationRateLimit int `json:"operation_rate_limit"`
+
 	// The minimum version of the key allowed to be used for decryption
 	MinDecryptionVersion int `json:"min_decryption_version"`
 
+	// The reason given for the latest change of the minimum decryption
+	// version, such as an incident. It is purely informational.
+	MinDecryptionChangeReason string `json:"min_decryption_change_reason"`
+
 	// The minimum version of the key allowed to be used for encryption
 	MinEncryptionVersion int `json:"min_encryption_version"`
 
+	// Versions of the key that may not be used for encryption even though
+	// they are at or above the minimum encryption version. They can still be
+	// used for decryption.
+	DisabledEncryptionVersions []int `json:"disabled_encryption_versions"`
+
+	// Versions of the key that may not be used for decryption even though
+	// they are at or above the minimum decryption version, such as a version
+	// known to be compromised
+	DisabledDecryptionVersions []int `json:"disabled_decryption_versions"`
+
+	// Archived versions whose key material has been deleted individually.
+	// Their archive entries are left empty so that the archive stays indexed
+	// by version, and the minimum decryption version cannot be lowered to
+	// include them again.
+	DeletedVersions []int `json:"deleted_versions"`
+
 	// The latest key version in this policy
 	LatestVersion int `json:"latest_version"`
 
@@ -202,6 +483,11 @@ type Policy struct {
 	// a max.
 	ArchiveVersion int `json:"archive_version"`
 
+	// The minimum key version still present in the archive; older versions
+	// have been trimmed. Zero means that no trimming has occurred. When set,
+	// archived keys are indexed relative to this version.
+	MinAvailableVersion int `json:"min_available_version"`
+
 	// Whether the key is allowed to be deleted
 	DeletionAllowed bool `json:"deletion_allowed"`
 
@@ -210,6 +496,25 @@ type Policy struct {
 
 	// The type of key
 	Type KeyType `json:"type"`
+
+	// How often the key should be automatically rotated; zero disables
+	// automatic rotation
+	AutoRotatePeriod time.Duration `json:"auto_rotate_period"`
+
+	// If set, the key material is held outside of Vault under this name and
+	// operations with the key are performed by the managed key backend
+	ManagedKeyName string `json:"managed_key_name"`
+
+	// The backend performing operations with managed keys, set when the
+	// policy is loaded
+	managedKeys ManagedKeyBackend
+}
+
+// KeyData holds a policy and its archived keys; it is the format used for
+// backups.
+type KeyData struct {
+	Policy       *Policy       `json:"policy"`
+	ArchivedKeys *archivedKeys `json:"archived_keys"`
 }
 
 // ArchivedKeys stores old keys. This is used to keep the key loading time sane
@@ -218,6 +523,64 @@ type archivedKeys struct {
 	Keys []KeyEntry `json:"keys"`
 }
 
+// KeyConvergentVersion returns the version of convergent encryption used with
+// the given key version
+func (p *Policy) KeyConvergentVersion(ver int) int {
+	if entry, ok := p.Keys[ver]; ok && entry.ConvergentVersion != 0 {
+		return entry.ConvergentVersion
+	}
+	return p.ConvergentVersion
+}
+
+// RequiresNonce returns whether encrypting with the latest version of the key
+// requires the caller to supply a nonce, which is the case for convergent
+// encryption version 1
+func (p *Policy) RequiresNonce() bool {
+	return p.ConvergentEncryption && p.KeyConvergentVersion(p.LatestVersion) == 1
+}
+
+// UpgradeConvergentVersion moves the policy to the latest convergent
+// encryption scheme, returning false if it already uses it. The ciphertext
+// of the schemes cannot be told apart, so existing key versions keep using
+// their current scheme and the key is rotated so that new encryptions use a
+// version with the latest scheme.
+func (p *Policy) UpgradeConvergentVersion(storage logical.Storage) (bool, error) {
+	if !p.ConvergentEncryption {
+		return false, errutil.UserError{Err: "key does not use convergent encryption"}
+	}
+	if p.ConvergentVersion >= LatestConvergentVersion {
+		return false, nil
+	}
+	if p.Imported && !p.AllowImportedKeyRotation {
+		return false, errutil.UserError{Err: "upgrading convergent encryption requires rotating the key, which is not allowed for this imported key"}
+	}
+
+	// Archived versions are pinned as well, since they are moved back into
+	// the policy if the min decryption version is lowered
+	archive, err := p.LoadArchive(storage)
+	if err != nil {
+		return false, err
+	}
+	for i := range archive.Keys {
+		if archive.Keys[i].ConvergentVersion == 0 {
+			archive.Keys[i].ConvergentVersion = p.ConvergentVersion
+		}
+	}
+	if err := p.storeArchive(archive, storage); err != nil {
+		return false, err
+	}
+
+	for ver, entry := range p.Keys {
+		if entry.ConvergentVersion == 0 {
+			entry.ConvergentVersion = p.ConvergentVersion
+			p.Keys[ver] = entry
+		}
+	}
+	p.ConvergentVersion = LatestConvergentVersion
+
+	return true, p.Rotate(storage)
+}
+
 func (p *Policy) LoadArchive(storage logical.Storage) (*archivedKeys, error) {
 	archive := &archivedKeys{}
 
@@ -286,6 +649,9 @@ func (p *Policy) handleArchiving(storage logical.Storage) error {
 	case p.MinDecryptionVersion > p.LatestVersion:
 		return fmt.Errorf("minimum decryption version of %d is greater than the latest version %d",
 			p.MinDecryptionVersion, p.LatestVersion)
+	case p.MinAvailableVersion > p.MinDecryptionVersion:
+		return fmt.Errorf("minimum available version of %d is greater than minimum decryption version %d",
+			p.MinAvailableVersion, p.MinDecryptionVersion)
 	}
 
 	archive, err := p.LoadArchive(storage)
@@ -297,7 +663,7 @@ func (p *Policy) handleArchiving(storage logical.Storage) error {
 		// Need to move keys *from* archive
 
 		for i := p.MinDecryptionVersion; i <= p.LatestVersion; i++ {
-			p.Keys[i] = archive.Keys[i]
+			p.Keys[i] = archive.Keys[i-p.MinAvailableVersion]
 		}
 
 		return nil
@@ -307,10 +673,10 @@ func (p *Policy) handleArchiving(storage logical.Storage) error {
 
 	// We need a size that is equivalent to the latest version (number of keys)
 	// but adding one since slice numbering starts at 0 and we're indexing by
-	// key version
-	if len(archive.Keys) < p.LatestVersion+1 {
+	// key version, less any versions that have been trimmed
+	if len(archive.Keys)+p.MinAvailableVersion < p.LatestVersion+1 {
 		// Increase the size of the archive slice
-		newKeys := make([]KeyEntry, p.LatestVersion+1)
+		newKeys := make([]KeyEntry, p.LatestVersion-p.MinAvailableVersion+1)
 		copy(newKeys, archive.Keys)
 		archive.Keys = newKeys
 	}
@@ -318,7 +684,7 @@ func (p *Policy) handleArchiving(storage logical.Storage) error {
 	// We are storing all keys in the archive, so we ensure that it is up to
 	// date up to p.LatestVersion
 	for i := p.ArchiveVersion + 1; i <= p.LatestVersion; i++ {
-		archive.Keys[i] = p.Keys[i]
+		archive.Keys[i-p.MinAvailableVersion] = p.Keys[i]
 		p.ArchiveVersion = i
 	}
 
@@ -336,6 +702,109 @@ func (p *Policy) handleArchiving(storage logical.Storage) error {
 	return nil
 }
 
+// Trim permanently removes all archived key versions below
+// minAvailableVersion. Versions that are still allowed to be used for
+// decryption or encryption cannot be trimmed.
+func (p *Policy) Trim(storage logical.Storage, minAvailableVersion int) error {
+	switch {
+	case minAvailableVersion < 1:
+		return errutil.UserError{Err: "minimum available version must be at least 1"}
+	case minAvailableVersion < p.MinAvailableVersion:
+		return errutil.UserError{Err: fmt.Sprintf("minimum available version cannot be decreased; versions below %d have already been trimmed", p.MinAvailableVersion)}
+	case minAvailableVersion > p.MinDecryptionVersion:
+		return errutil.UserError{Err: fmt.Sprintf("cannot trim versions still allowed for decryption; minimum available version of %d is greater than minimum decryption version %d", minAvailableVersion, p.MinDecryptionVersion)}
+	case p.MinEncryptionVersion > 0 && minAvailableVersion > p.MinEncryptionVersion:
+		return errutil.UserError{Err: fmt.Sprintf("cannot trim versions still allowed for encryption; minimum available version of %d is greater than minimum encryption version %d", minAvailableVersion, p.MinEncryptionVersion)}
+	case minAvailableVersion == p.MinAvailableVersion:
+		return nil
+	}
+
+	archive, err := p.LoadArchive(storage)
+	if err != nil {
+		return err
+	}
+
+	// Drop the trimmed versions from the front of the archive so that it is
+	// indexed relative to the new minimum
+	trimCount := minAvailableVersion - p.MinAvailableVersion
+	if trimCount > len(archive.Keys) {
+		trimCount = len(archive.Keys)
+	}
+	archive.Keys = archive.Keys[trimCount:]
+
+	// Deleted versions that have now been trimmed no longer need to be
+	// remembered
+	var deleted []int
+	for _, ver := range p.DeletedVersions {
+		if ver >= minAvailableVersion {
+			deleted = append(deleted, ver)
+		}
+	}
+	p.DeletedVersions = deleted
+
+	err = p.storeArchive(archive, storage)
+	if err != nil {
+		return err
+	}
+
+	p.MinAvailableVersion = minAvailableVersion
+
+	return p.Persist(storage)
+}
+
+// DeleteVersion deletes the key material of a single archived version. Only
+// versions below the minimum decryption version can be deleted, as they can
+// no longer be used for any operation; the latest version is never deleted.
+func (p *Policy) DeleteVersion(storage logical.Storage, ver int) error {
+	minAvailableVersion := p.MinAvailableVersion
+	if minAvailableVersion < 1 {
+		minAvailableVersion = 1
+	}
+
+	switch {
+	case ver < 1 || ver > p.LatestVersion:
+		return errutil.UserError{Err: fmt.Sprintf("version %d of the key does not exist", ver)}
+	case ver == p.LatestVersion:
+		return errutil.UserError{Err: "the latest version of a key cannot be deleted"}
+	case ver >= p.MinDecryptionVersion:
+		return errutil.UserError{Err: fmt.Sprintf("version %d can still be used for decryption; raise the min decryption version above it before deleting it", ver)}
+	case ver < minAvailableVersion:
+		return errutil.UserError{Err: fmt.Sprintf("version %d has already been trimmed", ver)}
+	case p.VersionDeleted(ver):
+		return errutil.UserError{Err: fmt.Sprintf("version %d has already been deleted", ver)}
+	}
+
+	archive, err := p.LoadArchive(storage)
+	if err != nil {
+		return err
+	}
+	if ver-p.MinAvailableVersion >= len(archive.Keys) {
+		return fmt.Errorf("version %d is missing from the archive", ver)
+	}
+	archive.Keys[ver-p.MinAvailableVersion] = KeyEntry{}
+
+	err = p.storeArchive(archive, storage)
+	if err != nil {
+		return err
+	}
+
+	p.DeletedVersions = append(p.DeletedVersions, ver)
+	sort.Ints(p.DeletedVersions)
+
+	return p.Persist(storage)
+}
+
+// VersionDeleted returns whether the key material of the given version has
+// been deleted with DeleteVersion
+func (p *Policy) VersionDeleted(ver int) bool {
+	for _, deleted := range p.DeletedVersions {
+		if deleted == ver {
+			return true
+		}
+	}
+	return false
+}
+
 func (p *Policy) Persist(storage logical.Storage) error {
 	err := p.handleArchiving(storage)
 	if err != nil {
@@ -360,6 +829,143 @@ func (p *Policy) Persist(storage logical.Storage) error {
 	return nil
 }
 
+// Backup returns a base64-encoded serialization of the policy together with
+// all of its archived key versions, suitable for passing to RestorePolicy.
+func (p *Policy) Backup(storage logical.Storage) (string, error) {
+	archive, err := p.LoadArchive(storage)
+	if err != nil {
+		return "", err
+	}
+
+	buf, err := json.Marshal(&KeyData{
+		Policy:       p,
+		ArchivedKeys: archive,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(buf), nil
+}
+
+// mergeBackup merges the key versions of a restored policy and its archive
+// into p, whose current archive is given, keeping the union of the versions
+// of both. Versions present in both must have the same key material. The
+// settings of p are kept, except that the minimum decryption version is
+// lowered if the restored policy allows older versions to be used. The
+// archive to store along with the merged policy is returned.
+func (p *Policy) mergeBackup(archive *archivedKeys, restored *Policy, restoredArchive *archivedKeys) (*archivedKeys, error) {
+	switch {
+	case p.ManagedKeyName != "" || restored.ManagedKeyName != "":
+		return nil, errutil.UserError{Err: "managed keys have no key material in Vault and cannot be merged"}
+	case p.Type != restored.Type:
+		return nil, errutil.UserError{Err: fmt.Sprintf("cannot merge a backup of type %s into key %s of type %s", restored.Type, p.Name, p.Type)}
+	case p.Derived != restored.Derived || (p.Derived && p.KDF != restored.KDF):
+		return nil, errutil.UserError{Err: fmt.Sprintf("cannot merge a backup with different key derivation settings into key %s", p.Name)}
+	case p.ConvergentEncryption != restored.ConvergentEncryption || p.ConvergentVersion != restored.ConvergentVersion:
+		return nil, errutil.UserError{Err: fmt.Sprintf("cannot merge a backup with different convergent encryption settings into key %s", p.Name)}
+	case len(p.DeletedVersions) > 0 || len(restored.DeletedVersions) > 0:
+		return nil, errutil.UserError{Err: "keys with individually deleted versions cannot be merged, as the deleted versions could be restored"}
+	}
+
+	versions := p.allVersions(archive)
+	for ver, entry := range restored.allVersions(restoredArchive) {
+		existing, ok := versions[ver]
+		if !ok {
+			versions[ver] = entry
+			continue
+		}
+		if !existing.sameMaterial(entry) {
+			return nil, errutil.UserError{Err: fmt.Sprintf("version %d of the backup has different key material than version %d of key %s", ver, ver, p.Name)}
+		}
+	}
+
+	// Versions are numbered from the same origin on both sides, so the
+	// union has to be contiguous to be usable as a single key
+	lowest, latest := 0, 0
+	for ver := range versions {
+		if lowest == 0 || ver < lowest {
+			lowest = ver
+		}
+		if ver > latest {
+			latest = ver
+		}
+	}
+	for ver := lowest; ver <= latest; ver++ {
+		if _, ok := versions[ver]; !ok {
+			return nil, errutil.UserError{Err: fmt.Sprintf("merged key %s would be missing version %d", p.Name, ver)}
+		}
+	}
+
+	minAvailableVersion := 0
+	if lowest > 1 {
+		minAvailableVersion = lowest
+	}
+	minDecryptionVersion := p.MinDecryptionVersion
+	if restored.MinDecryptionVersion < minDecryptionVersion {
+		minDecryptionVersion = restored.MinDecryptionVersion
+	}
+	if minDecryptionVersion < lowest {
+		minDecryptionVersion = lowest
+	}
+
+	merged := &archivedKeys{
+		Keys: make([]KeyEntry, latest-minAvailableVersion+1),
+	}
+	p.Keys = keyEntryMap{}
+	for ver, entry := range versions {
+		merged.Keys[ver-minAvailableVersion] = entry
+		if ver >= minDecryptionVersion {
+			p.Keys[ver] = entry
+		}
+	}
+	p.LatestVersion = latest
+	p.ArchiveVersion = latest
+	p.MinAvailableVersion = minAvailableVersion
+	p.MinDecryptionVersion = minDecryptionVersion
+
+	return merged, nil
+}
+
+// allVersions returns every available version of the key, from both the
+// policy and its archive
+func (p *Policy) allVersions(archive *archivedKeys) map[int]KeyEntry {
+	versions := map[int]KeyEntry{}
+	for i, entry := range archive.Keys {
+		ver := i + p.MinAvailableVersion
+		if ver < 1 || ver > p.ArchiveVersion {
+			continue
+		}
+		versions[ver] = entry
+	}
+	for ver, entry := range p.Keys {
+		versions[ver] = entry
+	}
+	return versions
+}
+
+// sameMaterial returns whether two key entries hold the same key material
+func (ke KeyEntry) sameMaterial(other KeyEntry) bool {
+	bigEqual := func(a, b *big.Int) bool {
+		if a == nil || b == nil {
+			return a == b
+		}
+		return a.Cmp(b) == 0
+	}
+
+	switch {
+	case !bytes.Equal(ke.Key, other.Key), !bytes.Equal(ke.HMACKey, other.HMACKey):
+		return false
+	case !bigEqual(ke.EC_X, other.EC_X), !bigEqual(ke.EC_Y, other.EC_Y), !bigEqual(ke.EC_D, other.EC_D):
+		return false
+	case ke.ConvergentVersion != other.ConvergentVersion:
+		return false
+	case ke.RSAKey == nil || other.RSAKey == nil:
+		return ke.RSAKey == other.RSAKey
+	}
+	return bigEqual(ke.RSAKey.N, other.RSAKey.N) && bigEqual(ke.RSAKey.D, other.RSAKey.D)
+}
+
 func (p *Policy) Serialize() ([]byte, error) {
 	return json.Marshal(p)
 }
@@ -392,7 +998,8 @@ func (p *Policy) NeedsUpgrade() bool {
 		return true
 	}
 
-	if p.Keys[p.LatestVersion].HMACKey == nil || len(p.Keys[p.LatestVersion].HMACKey) == 0 {
+	// Managed keys have no local key material, including HMAC keys
+	if p.ManagedKeyName == "" && (p.Keys[p.LatestVersion].HMACKey == nil || len(p.Keys[p.LatestVersion].HMACKey) == 0) {
 		return true
 	}
 
@@ -431,7 +1038,7 @@ func (p *Policy) Upgrade(storage logical.Storage) error {
 		persistNeeded = true
 	}
 
-	if p.Keys[p.LatestVersion].HMACKey == nil || len(p.Keys[p.LatestVersion].HMACKey) == 0 {
+	if p.ManagedKeyName == "" && (p.Keys[p.LatestVersion].HMACKey == nil || len(p.Keys[p.LatestVersion].HMACKey) == 0) {
 		entry := p.Keys[p.LatestVersion]
 		hmacKey, err := uuid.GenerateRandomBytes(32)
 		if err != nil {
@@ -452,6 +1059,37 @@ func (p *Policy) Upgrade(storage logical.Storage) error {
 	return nil
 }
 
+// legacyFingerprint returns the fingerprint of a key created before keys were
+// assigned one. It is derived from the key's earliest version, which is
+// unaffected by rotation, so that every node computes the same value without
+// having to write it.
+func (p *Policy) legacyFingerprint() (string, error) {
+	var material []byte
+	if len(p.Keys) == 0 {
+		// Keys from before versioning that have not yet been migrated
+		material = p.Key
+	} else {
+		ver := p.LatestVersion
+		for k := range p.Keys {
+			if k < ver {
+				ver = k
+			}
+		}
+		// The HMAC key of the latest version may still be generated on upgrade
+		entry := p.Keys[ver]
+		entry.HMACKey = nil
+		var err error
+		material, err = json.Marshal(entry)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	mac := hmac.New(sha256.New, material)
+	mac.Write([]byte("vault transit key fingerprint"))
+	return uuid.FormatUUID(mac.Sum(nil)[:16])
+}
+
 // DeriveKey is used to derive the encryption key that should be used depending
 // on the policy. If derivation is disabled the raw key is used and no context
 // is required, otherwise the KDF mode is used with the context to derive the
@@ -495,7 +1133,19 @@ func (p *Policy) DeriveKey(context []byte, ver int) ([]byte, error) {
 		}
 
 		switch p.Type {
-		case KeyType_AES256_GCM96:
+		case KeyType_AES128_GCM96:
+			// Only the first 128 bits of the derived output are used
+			limReader.N = 16
+			n, err := derBytes.ReadFrom(limReader)
+			if err != nil {
+				return nil, errutil.InternalError{Err: fmt.Sprintf("error reading returned derived bytes: %v", err)}
+			}
+			if n != 16 {
+				return nil, errutil.InternalError{Err: fmt.Sprintf("unable to read enough derived bytes, needed 16, got %d", n)}
+			}
+			return derBytes.Bytes(), nil
+
+		case KeyType_AES256_GCM96, KeyType_ChaCha20_Poly1305:
 			n, err := derBytes.ReadFrom(limReader)
 			if err != nil {
 				return nil, errutil.InternalError{Err: fmt.Sprintf("error reading returned derived bytes: %v", err)}
@@ -514,6 +1164,15 @@ func (p *Policy) DeriveKey(context []byte, ver int) ([]byte, error) {
 			}
 			return pri, nil
 
+		case KeyType_ED448:
+			// Ed448 seeds are longer than the other derived keys, so they
+			// are read from the HKDF reader directly
+			seed := make([]byte, ed448.SeedSize)
+			if _, err := io.ReadFull(reader, seed); err != nil {
+				return nil, errutil.InternalError{Err: fmt.Sprintf("error reading returned derived bytes: %v", err)}
+			}
+			return ed448.NewKeyFromSeed(seed), nil
+
 		default:
 			return nil, errutil.InternalError{Err: "unsupported key type for derivation"}
 		}
@@ -523,6 +1182,31 @@ func (p *Policy) DeriveKey(context []byte, ver int) ([]byte, error) {
 	}
 }
 
+// DerivedKeyFingerprint returns a fingerprint of the symmetric key derived
+// from the given context for a key version. It is computed as an HMAC of a
+// fixed label rather than over the derived key itself, so it reveals nothing
+// about the key bytes but lets clients check that a context yields the key
+// they expect.
+func (p *Policy) DerivedKeyFingerprint(context []byte, ver int) (string, error) {
+	switch p.Type {
+	case KeyType_AES128_GCM96, KeyType_AES256_GCM96, KeyType_ChaCha20_Poly1305:
+	default:
+		return "", errutil.UserError{Err: fmt.Sprintf("derived key fingerprints not supported for key type %v", p.Type)}
+	}
+	if !p.Derived {
+		return "", errutil.UserError{Err: "derived key fingerprints are only available for keys with derivation enabled"}
+	}
+
+	key, err := p.DeriveKey(context, ver)
+	if err != nil {
+		return "", err
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("vault transit derived key fingerprint"))
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
 func (p *Policy) Encrypt(ver int, context, nonce []byte, value string) (string, error) {
 	if !p.Type.EncryptionSupported() {
 		return "", errutil.UserError{Err: fmt.Sprintf("message encryption not supported for key type %v", p.Type)}
@@ -544,58 +1228,63 @@ func (p *Policy) Encrypt(ver int, context, nonce []byte, value string) (string,
 	case ver < p.MinEncryptionVersion:
 		return "", errutil.UserError{Err: "requested version for encryption is less than the minimum encryption key version"}
 	}
+	if p.EncryptionVersionDisabled(ver) {
+		return "", errutil.UserError{Err: fmt.Sprintf("version %d of the key is disabled for encryption", ver)}
+	}
+	// The ciphertext could never be decrypted
+	if p.DecryptionVersionDisabled(ver) {
+		return "", errutil.UserError{Err: fmt.Sprintf("version %d of the key is disabled for decryption and cannot be used for encryption", ver)}
+	}
+
+	if p.ManagedKeyName != "" {
+		return p.managedEncrypt(ver, plaintext)
+	}
 
 	var ciphertext []byte
 
 	switch p.Type {
-	case KeyType_AES256_GCM96:
+	case KeyType_AES128_GCM96, KeyType_AES256_GCM96, KeyType_ChaCha20_Poly1305:
 		// Derive the key that should be used
 		key, err := p.DeriveKey(context, ver)
 		if err != nil {
 			return "", err
 		}
 
-		// Setup the cipher
-		aesCipher, err := aes.NewCipher(key)
-		if err != nil {
-			return "", errutil.InternalError{Err: err.Error()}
-		}
-
-		// Setup the GCM AEAD
-		gcm, err := cipher.NewGCM(aesCipher)
+		aead, err := p.symmetricAEAD(key)
 		if err != nil {
-			return "", errutil.InternalError{Err: err.Error()}
+			return "", err
 		}
 
+		convergentVersion := p.KeyConvergentVersion(ver)
 		if p.ConvergentEncryption {
-			switch p.ConvergentVersion {
+			switch convergentVersion {
 			case 1:
-				if len(nonce) != gcm.NonceSize() {
-					return "", errutil.UserError{Err: fmt.Sprintf("base64-decoded nonce must be %d bytes long when using convergent encryption with this key", gcm.NonceSize())}
+				if len(nonce) != aead.NonceSize() {
+					return "", errutil.UserError{Err: fmt.Sprintf("base64-decoded nonce must be %d bytes long when using convergent encryption with this key", aead.NonceSize())}
 				}
 			default:
 				nonceHmac := hmac.New(sha256.New, context)
 				nonceHmac.Write(plaintext)
 				nonceSum := nonceHmac.Sum(nil)
-				nonce = nonceSum[:gcm.NonceSize()]
+				nonce = nonceSum[:aead.NonceSize()]
 			}
 		} else {
 			// Compute random nonce
-			nonce, err = uuid.GenerateRandomBytes(gcm.NonceSize())
+			nonce, err = uuid.GenerateRandomBytes(aead.NonceSize())
 			if err != nil {
 				return "", errutil.InternalError{Err: err.Error()}
 			}
 		}
 
-		// Encrypt and tag with GCM
-		ciphertext = gcm.Seal(nil, nonce, plaintext, nil)
+		// Encrypt and tag with the AEAD
+		ciphertext = aead.Seal(nil, nonce, plaintext, nil)
 
 		// Place the encrypted data after the nonce
-		if !p.ConvergentEncryption || p.ConvergentVersion > 1 {
+		if !p.ConvergentEncryption || convergentVersion > 1 {
 			ciphertext = append(nonce, ciphertext...)
 		}
 
-	case KeyType_RSA2048, KeyType_RSA4096:
+	case KeyType_RSA2048, KeyType_RSA3072, KeyType_RSA4096:
 		key := p.Keys[ver].RSAKey
 		ciphertext, err = rsa.EncryptOAEP(sha256.New(), rand.Reader, &key.PublicKey, plaintext, nil)
 		if err != nil {
@@ -610,33 +1299,39 @@ func (p *Policy) Encrypt(ver int, context, nonce []byte, value string) (string,
 	encoded := base64.StdEncoding.EncodeToString(ciphertext)
 
 	// Prepend some information
-	encoded = "vault:v" + strconv.Itoa(ver) + ":" + encoded
+	encoded = p.Type.CiphertextPrefix() + strconv.Itoa(ver) + ":" + encoded
 
 	return encoded, nil
 }
 
-func (p *Policy) Decrypt(context, nonce []byte, value string) (string, error) {
-	if !p.Type.DecryptionSupported() {
-		return "", errutil.UserError{Err: fmt.Sprintf("message decryption not supported for key type %v", p.Type)}
+// ParseCiphertext splits a ciphertext of any key type into the version of the
+// key that produced it and the base64-encoded payload, without decrypting it
+func ParseCiphertext(value string) (int, string, error) {
+	if strings.HasPrefix(value, chaChaCiphertextPrefix) {
+		return parseCiphertext(value, chaChaCiphertextPrefix)
 	}
+	return parseCiphertext(value, ciphertextPrefix)
+}
 
+// parseCiphertext is like ParseCiphertext, but only accepts ciphertext with
+// the given prefix
+func parseCiphertext(value, prefix string) (int, string, error) {
 	// Verify the prefix
-	if !strings.HasPrefix(value, "vault:v") {
-		return "", errutil.UserError{Err: "invalid ciphertext: no prefix"}
-	}
-
-	if p.ConvergentEncryption && p.ConvergentVersion == 1 && (nonce == nil || len(nonce) == 0) {
-		return "", errutil.UserError{Err: "invalid convergent nonce supplied"}
+	if !strings.HasPrefix(value, prefix) {
+		if strings.HasPrefix(value, chaChaCiphertextPrefix) || strings.HasPrefix(value, ciphertextPrefix) {
+			return 0, "", errutil.UserError{Err: "invalid ciphertext: produced by a key of a different type"}
+		}
+		return 0, "", errutil.UserError{Err: "invalid ciphertext: no prefix"}
 	}
 
-	splitVerCiphertext := strings.SplitN(strings.TrimPrefix(value, "vault:v"), ":", 2)
+	splitVerCiphertext := strings.SplitN(strings.TrimPrefix(value, prefix), ":", 2)
 	if len(splitVerCiphertext) != 2 {
-		return "", errutil.UserError{Err: "invalid ciphertext: wrong number of fields"}
+		return 0, "", errutil.UserError{Err: "invalid ciphertext: wrong number of fields"}
 	}
 
 	ver, err := strconv.Atoi(splitVerCiphertext[0])
 	if err != nil {
-		return "", errutil.UserError{Err: "invalid ciphertext: version number could not be decoded"}
+		return 0, "", errutil.UserError{Err: "invalid ciphertext: version number could not be decoded"}
 	}
 
 	if ver == 0 {
@@ -645,61 +1340,80 @@ func (p *Policy) Decrypt(context, nonce []byte, value string) (string, error) {
 		ver = 1
 	}
 
-	if ver > p.LatestVersion {
-		return "", errutil.UserError{Err: "invalid ciphertext: version is too new"}
+	return ver, splitVerCiphertext[1], nil
+}
+
+func (p *Policy) Decrypt(context, nonce []byte, value string) (string, error) {
+	if !p.Type.DecryptionSupported() {
+		return "", errutil.UserError{Err: fmt.Sprintf("message decryption not supported for key type %v", p.Type)}
 	}
 
-	if p.MinDecryptionVersion > 0 && ver < p.MinDecryptionVersion {
+	ver, encoded, err := parseCiphertext(value, p.Type.CiphertextPrefix())
+	if err != nil {
+		return "", err
+	}
+
+	if ver > p.LatestVersion {
+		return "", errutil.UserError{Err: "invalid ciphertext: version is too new"}
+	}
+
+	if p.MinDecryptionVersion > 0 && ver < p.MinDecryptionVersion {
 		return "", errutil.UserError{Err: ErrTooOld}
 	}
 
+	if p.DecryptionVersionDisabled(ver) {
+		return "", errutil.UserError{Err: fmt.Sprintf("version %d of the key is disabled for decryption", ver)}
+	}
+
+	convergentVersion := p.KeyConvergentVersion(ver)
+	if p.ConvergentEncryption && convergentVersion == 1 && (nonce == nil || len(nonce) == 0) {
+		return "", errutil.UserError{Err: "invalid convergent nonce supplied"}
+	}
+
 	// Decode the base64
-	decoded, err := base64.StdEncoding.DecodeString(splitVerCiphertext[1])
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
 	if err != nil {
 		return "", errutil.UserError{Err: "invalid ciphertext: could not decode base64"}
 	}
 
+	if p.ManagedKeyName != "" {
+		return p.managedDecrypt(decoded)
+	}
+
 	var plain []byte
 
 	switch p.Type {
-	case KeyType_AES256_GCM96:
+	case KeyType_AES128_GCM96, KeyType_AES256_GCM96, KeyType_ChaCha20_Poly1305:
 		key, err := p.DeriveKey(context, ver)
 		if err != nil {
 			return "", err
 		}
 
-		// Setup the cipher
-		aesCipher, err := aes.NewCipher(key)
+		aead, err := p.symmetricAEAD(key)
 		if err != nil {
-			return "", errutil.InternalError{Err: err.Error()}
-		}
-
-		// Setup the GCM AEAD
-		gcm, err := cipher.NewGCM(aesCipher)
-		if err != nil {
-			return "", errutil.InternalError{Err: err.Error()}
+			return "", err
 		}
 
-		if len(decoded) < gcm.NonceSize() {
+		if len(decoded) < aead.NonceSize() {
 			return "", errutil.UserError{Err: "invalid ciphertext length"}
 		}
 
 		// Extract the nonce and ciphertext
 		var ciphertext []byte
-		if p.ConvergentEncryption && p.ConvergentVersion < 2 {
+		if p.ConvergentEncryption && convergentVersion < 2 {
 			ciphertext = decoded
 		} else {
-			nonce = decoded[:gcm.NonceSize()]
-			ciphertext = decoded[gcm.NonceSize():]
+			nonce = decoded[:aead.NonceSize()]
+			ciphertext = decoded[aead.NonceSize():]
 		}
 
 		// Verify and Decrypt
-		plain, err = gcm.Open(nil, nonce, ciphertext, nil)
+		plain, err = aead.Open(nil, nonce, ciphertext, nil)
 		if err != nil {
 			return "", errutil.UserError{Err: "invalid ciphertext: unable to decrypt"}
 		}
 
-	case KeyType_RSA2048, KeyType_RSA4096:
+	case KeyType_RSA2048, KeyType_RSA3072, KeyType_RSA4096:
 		key := p.Keys[ver].RSAKey
 		plain, err = rsa.DecryptOAEP(sha256.New(), rand.Reader, key, decoded, nil)
 		if err != nil {
@@ -713,6 +1427,36 @@ func (p *Policy) Decrypt(context, nonce []byte, value string) (string, error) {
 	return base64.StdEncoding.EncodeToString(plain), nil
 }
 
+// symmetricAEAD returns the AEAD cipher for the policy's symmetric key type
+// using the given (possibly derived) key
+func (p *Policy) symmetricAEAD(key []byte) (cipher.AEAD, error) {
+	switch p.Type {
+	case KeyType_AES128_GCM96, KeyType_AES256_GCM96:
+		// Setup the cipher
+		aesCipher, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, errutil.InternalError{Err: err.Error()}
+		}
+
+		// Setup the GCM AEAD
+		gcm, err := cipher.NewGCM(aesCipher)
+		if err != nil {
+			return nil, errutil.InternalError{Err: err.Error()}
+		}
+		return gcm, nil
+
+	case KeyType_ChaCha20_Poly1305:
+		aead, err := chacha20poly1305.New(key)
+		if err != nil {
+			return nil, errutil.InternalError{Err: err.Error()}
+		}
+		return aead, nil
+
+	default:
+		return nil, errutil.InternalError{Err: fmt.Sprintf("unsupported key type %v", p.Type)}
+	}
+}
+
 func (p *Policy) HMACKey(version int) ([]byte, error) {
 	switch {
 	case version < 0:
@@ -744,15 +1488,19 @@ func (p *Policy) Sign(ver int, context, input []byte, algorithm string) (*Signin
 		return nil, errutil.UserError{Err: "requested version for signing is less than the minimum encryption key version"}
 	}
 
+	if p.ManagedKeyName != "" {
+		return p.managedSign(ver, input, algorithm)
+	}
+
 	var sig []byte
 	var pubKey []byte
 	var err error
 	switch p.Type {
-	case KeyType_ECDSA_P256:
+	case KeyType_ECDSA_P256, KeyType_ECDSA_P384, KeyType_ECDSA_P521:
 		keyParams := p.Keys[ver]
 		key := &ecdsa.PrivateKey{
 			PublicKey: ecdsa.PublicKey{
-				Curve: elliptic.P256(),
+				Curve: p.Type.ECDSACurve(),
 				X:     keyParams.EC_X,
 				Y:     keyParams.EC_Y,
 			},
@@ -793,7 +1541,26 @@ func (p *Policy) Sign(ver int, context, input []byte, algorithm string) (*Signin
 			return nil, err
 		}
 
-	case KeyType_RSA2048, KeyType_RSA4096:
+	case KeyType_ED448:
+		var key ed448.PrivateKey
+
+		if p.Derived {
+			// Derive the key that should be used
+			var err error
+			key, err = p.DeriveKey(context, ver)
+			if err != nil {
+				return nil, errutil.InternalError{Err: fmt.Sprintf("error deriving key: %v", err)}
+			}
+			pubKey = key.Public().(ed448.PublicKey)
+		} else {
+			key = ed448.PrivateKey(p.Keys[ver].Key)
+		}
+
+		// As with ed25519, the input is not pre-hashed; this is pure Ed448
+		// with an empty context string
+		sig = ed448.Sign(key, input, "")
+
+	case KeyType_RSA2048, KeyType_RSA3072, KeyType_RSA4096:
 		key := p.Keys[ver].RSAKey
 
 		var algo crypto.Hash
@@ -863,8 +1630,12 @@ func (p *Policy) VerifySignature(context, input []byte, sig, algorithm string) (
 		return false, errutil.UserError{Err: "invalid base64 signature value"}
 	}
 
+	if p.ManagedKeyName != "" {
+		return p.managedVerify(input, sigBytes, algorithm)
+	}
+
 	switch p.Type {
-	case KeyType_ECDSA_P256:
+	case KeyType_ECDSA_P256, KeyType_ECDSA_P384, KeyType_ECDSA_P521:
 		var ecdsaSig ecdsaSignature
 		rest, err := asn1.Unmarshal(sigBytes, &ecdsaSig)
 		if err != nil {
@@ -876,7 +1647,7 @@ func (p *Policy) VerifySignature(context, input []byte, sig, algorithm string) (
 
 		keyParams := p.Keys[ver]
 		key := &ecdsa.PublicKey{
-			Curve: elliptic.P256(),
+			Curve: p.Type.ECDSACurve(),
 			X:     keyParams.EC_X,
 			Y:     keyParams.EC_Y,
 		}
@@ -899,7 +1670,23 @@ func (p *Policy) VerifySignature(context, input []byte, sig, algorithm string) (
 
 		return ed25519.Verify(key.Public().(ed25519.PublicKey), input, sigBytes), nil
 
-	case KeyType_RSA2048, KeyType_RSA4096:
+	case KeyType_ED448:
+		var key ed448.PrivateKey
+
+		if p.Derived {
+			// Derive the key that should be used
+			var err error
+			key, err = p.DeriveKey(context, ver)
+			if err != nil {
+				return false, errutil.InternalError{Err: fmt.Sprintf("error deriving key: %v", err)}
+			}
+		} else {
+			key = ed448.PrivateKey(p.Keys[ver].Key)
+		}
+
+		return ed448.Verify(key.Public().(ed448.PublicKey), input, sigBytes, ""), nil
+
+	case KeyType_RSA2048, KeyType_RSA3072, KeyType_RSA4096:
 		key := p.Keys[ver].RSAKey
 
 		var algo crypto.Hash
@@ -928,6 +1715,14 @@ func (p *Policy) VerifySignature(context, input []byte, sig, algorithm string) (
 }
 
 func (p *Policy) Rotate(storage logical.Storage) error {
+	if p.ManagedKeyName != "" {
+		return errutil.UserError{Err: fmt.Sprintf("managed keys cannot be rotated since their material is held by the managed key backend; rotate the managed key %s there instead", p.ManagedKeyName)}
+	}
+
+	if p.Imported && !p.AllowImportedKeyRotation {
+		return errutil.UserError{Err: "imported keys cannot be rotated to Vault-generated key material unless allow_rotation was set when importing; import a new version instead"}
+	}
+
 	if p.Keys == nil {
 		// This is an initial key rotation when generating a new policy. We
 		// don't need to call migrate here because if we've called getPolicy to
@@ -935,39 +1730,81 @@ func (p *Policy) Rotate(storage logical.Storage) error {
 		p.Keys = keyEntryMap{}
 	}
 
+	entry, err := p.generateKeyEntry()
+	if err != nil {
+		return err
+	}
+
 	p.LatestVersion += 1
-	now := time.Now()
-	entry := KeyEntry{
-		CreationTime:           now,
-		DeprecatedCreationTime: now.Unix(),
+	return p.addVersion(storage, entry)
+}
+
+// initVersions generates the first versions of a new policy, up to the given
+// number, and only then persists the policy, so that a failure to generate
+// any of them leaves nothing stored
+func (p *Policy) initVersions(storage logical.Storage, numVersions int) error {
+	if p.Keys == nil {
+		p.Keys = keyEntryMap{}
 	}
 
-	hmacKey, err := uuid.GenerateRandomBytes(32)
-	if err != nil {
+	for p.LatestVersion == 0 || p.LatestVersion < numVersions {
+		entry, err := p.generateKeyEntry()
+		if err != nil {
+			return err
+		}
+		p.LatestVersion += 1
+		p.Keys[p.LatestVersion] = entry
+	}
+	p.MinDecryptionVersion = 1
+
+	if err := p.Persist(storage); err != nil {
 		return err
 	}
-	entry.HMACKey = hmacKey
+
+	return p.trimToMaxVersions(storage)
+}
+
+// generateKeyEntry returns a key entry with newly generated key material of
+// the policy's type
+func (p *Policy) generateKeyEntry() (KeyEntry, error) {
+	random, err := p.entropyReader()
+	if err != nil {
+		return KeyEntry{}, err
+	}
+
+	entry, err := newKeyEntry(random)
+	if err != nil {
+		return entry, err
+	}
 
 	switch p.Type {
-	case KeyType_AES256_GCM96:
+	case KeyType_AES128_GCM96:
+		// Generate a 128bit key
+		newKey, err := randomBytes(random, 16)
+		if err != nil {
+			return entry, err
+		}
+		entry.Key = newKey
+
+	case KeyType_AES256_GCM96, KeyType_ChaCha20_Poly1305:
 		// Generate a 256bit key
-		newKey, err := uuid.GenerateRandomBytes(32)
+		newKey, err := randomBytes(random, 32)
 		if err != nil {
-			return err
+			return entry, err
 		}
 		entry.Key = newKey
 
-	case KeyType_ECDSA_P256:
-		privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KeyType_ECDSA_P256, KeyType_ECDSA_P384, KeyType_ECDSA_P521:
+		privKey, err := ecdsa.GenerateKey(p.Type.ECDSACurve(), random)
 		if err != nil {
-			return err
+			return entry, err
 		}
 		entry.EC_D = privKey.D
 		entry.EC_X = privKey.X
 		entry.EC_Y = privKey.Y
 		derBytes, err := x509.MarshalPKIXPublicKey(privKey.Public())
 		if err != nil {
-			return fmt.Errorf("error marshaling public key: %s", err)
+			return entry, fmt.Errorf("error marshaling public key: %s", err)
 		}
 		pemBlock := &pem.Block{
 			Type:  "PUBLIC KEY",
@@ -975,30 +1812,247 @@ func (p *Policy) Rotate(storage logical.Storage) error {
 		}
 		pemBytes := pem.EncodeToMemory(pemBlock)
 		if pemBytes == nil || len(pemBytes) == 0 {
-			return fmt.Errorf("error PEM-encoding public key")
+			return entry, fmt.Errorf("error PEM-encoding public key")
 		}
 		entry.FormattedPublicKey = string(pemBytes)
 
 	case KeyType_ED25519:
-		pub, pri, err := ed25519.GenerateKey(rand.Reader)
+		pub, pri, err := ed25519.GenerateKey(random)
 		if err != nil {
-			return err
+			return entry, err
 		}
 		entry.Key = pri
 		entry.FormattedPublicKey = base64.StdEncoding.EncodeToString(pub)
 
-	case KeyType_RSA2048, KeyType_RSA4096:
+	case KeyType_ED448:
+		pub, pri, err := ed448.GenerateKey(random)
+		if err != nil {
+			return entry, err
+		}
+		entry.Key = pri
+		entry.FormattedPublicKey = base64.StdEncoding.EncodeToString(pub)
+
+	case KeyType_RSA2048, KeyType_RSA3072, KeyType_RSA4096:
 		bitSize := 2048
-		if p.Type == KeyType_RSA4096 {
+		switch p.Type {
+		case KeyType_RSA3072:
+			bitSize = 3072
+		case KeyType_RSA4096:
 			bitSize = 4096
 		}
 
-		entry.RSAKey, err = rsa.GenerateKey(rand.Reader, bitSize)
+		entry.RSAKey, err = rsa.GenerateKey(random, bitSize)
 		if err != nil {
+			return entry, err
+		}
+	}
+
+	return entry, nil
+}
+
+// ImportKeyVersion adds a new version of the key using externally generated
+// key material. Symmetric keys are given as raw bytes and asymmetric keys as
+// PKCS#8 DER-encoded private keys.
+func (p *Policy) ImportKeyVersion(storage logical.Storage, key []byte) error {
+	entry, err := p.importedKeyEntry(key)
+	if err != nil {
+		return err
+	}
+
+	if p.Keys == nil {
+		p.Keys = keyEntryMap{}
+	}
+	p.Imported = true
+	p.LatestVersion += 1
+
+	return p.addVersion(storage, entry)
+}
+
+// RekeyLatestVersion replaces the key material of the latest version with
+// externally generated key material, given in the same format as for
+// ImportKeyVersion, without adding a new version. Anything encrypted or signed
+// with the previous material of that version can no longer be decrypted or
+// verified.
+func (p *Policy) RekeyLatestVersion(storage logical.Storage, key []byte) error {
+	if !p.Imported {
+		return errutil.UserError{Err: "only imported keys can be re-keyed"}
+	}
+	if _, ok := p.Keys[p.LatestVersion]; !ok {
+		return fmt.Errorf("latest version %d of the key is missing", p.LatestVersion)
+	}
+
+	entry, err := p.importedKeyEntry(key)
+	if err != nil {
+		return err
+	}
+
+	// The latest version is already archived, and archiving only copies
+	// versions newer than the archive version, so the archived copy is
+	// replaced here. Otherwise lowering the min decryption version, which
+	// loads every version from it up to the latest from the archive, would
+	// bring the previous material back.
+	archive, err := p.LoadArchive(storage)
+	if err != nil {
+		return err
+	}
+	if index := p.LatestVersion - p.MinAvailableVersion; index < len(archive.Keys) {
+		archive.Keys[index] = entry
+		if err := p.storeArchive(archive, storage); err != nil {
 			return err
 		}
 	}
+	p.Keys[p.LatestVersion] = entry
+
+	return p.Persist(storage)
+}
+
+// importedKeyEntry returns a key entry for the given externally generated key
+// material
+func (p *Policy) importedKeyEntry(key []byte) (KeyEntry, error) {
+	random, err := p.entropyReader()
+	if err != nil {
+		return KeyEntry{}, err
+	}
+	entry, err := newKeyEntry(random)
+	if err != nil {
+		return entry, err
+	}
 
+	switch p.Type {
+	case KeyType_AES128_GCM96, KeyType_AES256_GCM96, KeyType_ChaCha20_Poly1305:
+		keySize := 32
+		if p.Type == KeyType_AES128_GCM96 {
+			keySize = 16
+		}
+		if len(key) != keySize {
+			return entry, errutil.UserError{Err: fmt.Sprintf("key material for keys of type %v must be %d bytes long, got %d", p.Type, keySize, len(key))}
+		}
+		entry.Key = key
+
+	case KeyType_ECDSA_P256, KeyType_ECDSA_P384, KeyType_ECDSA_P521:
+		parsed, err := x509.ParsePKCS8PrivateKey(key)
+		if err != nil {
+			return entry, errutil.UserError{Err: fmt.Sprintf("error parsing PKCS#8 private key: %v", err)}
+		}
+		privKey, ok := parsed.(*ecdsa.PrivateKey)
+		if !ok || privKey.Curve != p.Type.ECDSACurve() {
+			return entry, errutil.UserError{Err: fmt.Sprintf("key material is not a private key for keys of type %v", p.Type)}
+		}
+		entry.EC_D = privKey.D
+		entry.EC_X = privKey.X
+		entry.EC_Y = privKey.Y
+		derBytes, err := x509.MarshalPKIXPublicKey(privKey.Public())
+		if err != nil {
+			return entry, fmt.Errorf("error marshaling public key: %s", err)
+		}
+		pemBytes := pem.EncodeToMemory(&pem.Block{
+			Type:  "PUBLIC KEY",
+			Bytes: derBytes,
+		})
+		if pemBytes == nil || len(pemBytes) == 0 {
+			return entry, fmt.Errorf("error PEM-encoding public key")
+		}
+		entry.FormattedPublicKey = string(pemBytes)
+
+	case KeyType_ED25519:
+		parsed, err := x509.ParsePKCS8PrivateKey(key)
+		if err != nil {
+			return entry, errutil.UserError{Err: fmt.Sprintf("error parsing PKCS#8 private key: %v", err)}
+		}
+		privKey, ok := parsed.(stded25519.PrivateKey)
+		if !ok {
+			return entry, errutil.UserError{Err: fmt.Sprintf("key material is not a private key for keys of type %v", p.Type)}
+		}
+		entry.Key = []byte(privKey)
+		entry.FormattedPublicKey = base64.StdEncoding.EncodeToString(privKey.Public().(stded25519.PublicKey))
+
+	case KeyType_ED448:
+		privKey, err := parseEd448PKCS8PrivateKey(key)
+		if err != nil {
+			return entry, errutil.UserError{Err: fmt.Sprintf("error parsing PKCS#8 private key: %v", err)}
+		}
+		entry.Key = []byte(privKey)
+		entry.FormattedPublicKey = base64.StdEncoding.EncodeToString(privKey.Public().(ed448.PublicKey))
+
+	case KeyType_RSA2048, KeyType_RSA3072, KeyType_RSA4096:
+		bitSize := 2048
+		switch p.Type {
+		case KeyType_RSA3072:
+			bitSize = 3072
+		case KeyType_RSA4096:
+			bitSize = 4096
+		}
+
+		parsed, err := x509.ParsePKCS8PrivateKey(key)
+		if err != nil {
+			return entry, errutil.UserError{Err: fmt.Sprintf("error parsing PKCS#8 private key: %v", err)}
+		}
+		privKey, ok := parsed.(*rsa.PrivateKey)
+		if !ok || privKey.N.BitLen() != bitSize {
+			return entry, errutil.UserError{Err: fmt.Sprintf("key material is not a private key for keys of type %v", p.Type)}
+		}
+		entry.RSAKey = privKey
+
+	default:
+		return entry, errutil.UserError{Err: fmt.Sprintf("import not supported for keys of type %v", p.Type)}
+	}
+
+	return entry, nil
+}
+
+// ValidateEntropySource returns an error if key material cannot be generated
+// from the given source of randomness in this build
+func ValidateEntropySource(source string) error {
+	switch source {
+	case "", EntropySourcePlatform:
+		return nil
+	case EntropySourceSeal:
+		return errutil.UserError{Err: fmt.Sprintf("entropy source %q is not supported by this build of Vault", source)}
+	default:
+		return errutil.UserError{Err: fmt.Sprintf("unknown entropy source %q", source)}
+	}
+}
+
+// entropyReader returns the source of randomness to generate key material
+// from
+func (p *Policy) entropyReader() (io.Reader, error) {
+	if err := ValidateEntropySource(p.EntropySource); err != nil {
+		return nil, err
+	}
+	return rand.Reader, nil
+}
+
+// randomBytes reads size bytes from the given source of randomness
+func randomBytes(random io.Reader, size int) ([]byte, error) {
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(random, buf); err != nil {
+		return nil, fmt.Errorf("failed to read random bytes: %v", err)
+	}
+	return buf, nil
+}
+
+// newKeyEntry returns a key entry for a new version with its creation time
+// and HMAC key set, generating the HMAC key from the given source of
+// randomness
+func newKeyEntry(random io.Reader) (KeyEntry, error) {
+	now := time.Now()
+	entry := KeyEntry{
+		CreationTime:           now,
+		DeprecatedCreationTime: now.Unix(),
+	}
+
+	hmacKey, err := randomBytes(random, 32)
+	if err != nil {
+		return entry, err
+	}
+	entry.HMACKey = hmacKey
+
+	return entry, nil
+}
+
+// addVersion stores the entry as the latest version of the key and persists
+// the policy
+func (p *Policy) addVersion(storage logical.Storage, entry KeyEntry) error {
 	p.Keys[p.LatestVersion] = entry
 
 	// This ensures that with new key creations min decryption version is set
@@ -1008,7 +2062,137 @@ func (p *Policy) Rotate(storage logical.Storage) error {
 		p.MinDecryptionVersion = 1
 	}
 
-	return p.Persist(storage)
+	if err := p.Persist(storage); err != nil {
+		return err
+	}
+
+	return p.trimToMaxVersions(storage)
+}
+
+// AvailableVersions returns the number of key versions that have not been
+// trimmed
+func (p *Policy) AvailableVersions() int {
+	minVersion := p.MinAvailableVersion
+	if minVersion < 1 {
+		minVersion = 1
+	}
+	return p.LatestVersion - minVersion + 1
+}
+
+// trimToMaxVersions trims the oldest versions so that no more than
+// MaxVersions remain available. Versions still allowed to be used for
+// decryption or encryption are never trimmed, so more versions may remain.
+func (p *Policy) trimToMaxVersions(storage logical.Storage) error {
+	if p.MaxVersions <= 0 || p.AvailableVersions() <= p.MaxVersions {
+		return nil
+	}
+
+	minAvailableVersion := p.LatestVersion - p.MaxVersions + 1
+	if minAvailableVersion > p.MinDecryptionVersion {
+		minAvailableVersion = p.MinDecryptionVersion
+	}
+	if p.MinEncryptionVersion > 0 && minAvailableVersion > p.MinEncryptionVersion {
+		minAvailableVersion = p.MinEncryptionVersion
+	}
+	if minAvailableVersion <= 1 || minAvailableVersion <= p.MinAvailableVersion {
+		return nil
+	}
+
+	return p.Trim(storage, minAvailableVersion)
+}
+
+// VersionExportable returns whether the given key version may be exported,
+// either because the whole key is exportable or because the version was
+// explicitly allowed to be exported. Versions of managed keys are never
+// exportable.
+func (p *Policy) VersionExportable(ver int) bool {
+	if p.ManagedKeyName != "" {
+		return false
+	}
+	if p.Exportable {
+		return true
+	}
+	for _, allowed := range p.AllowedExportVersions {
+		if allowed == ver {
+			return true
+		}
+	}
+	return false
+}
+
+// KeyExportable returns whether every version of the key may be exported.
+// Managed keys hold no key material in Vault, so they are never exportable.
+func (p *Policy) KeyExportable() bool {
+	return p.Exportable && p.ManagedKeyName == ""
+}
+
+// EncryptionVersionDisabled returns whether the given key version has been
+// explicitly disabled for encryption
+func (p *Policy) EncryptionVersionDisabled(ver int) bool {
+	for _, disabled := range p.DisabledEncryptionVersions {
+		if disabled == ver {
+			return true
+		}
+	}
+	return false
+}
+
+// DecryptionVersionDisabled returns whether the given key version has been
+// explicitly disabled for decryption
+func (p *Policy) DecryptionVersionDisabled(ver int) bool {
+	for _, disabled := range p.DisabledDecryptionVersions {
+		if disabled == ver {
+			return true
+		}
+	}
+	return false
+}
+
+// FIPSCompliant returns whether the key is usable in a FIPS 140-2 context.
+// Besides an approved key type, this requires that nonces are generated
+// randomly: the nonces of convergent encryption are derived from the
+// plaintext, which is not an approved way of constructing GCM nonces. Both
+// supported key derivation functions are approved. All compliance decisions
+// are made here so that they stay consistent across the backend.
+func (p *Policy) FIPSCompliant() bool {
+	if !p.Type.FIPSApproved() {
+		return false
+	}
+	if p.ConvergentEncryption {
+		return false
+	}
+	return true
+}
+
+// OperationAllowed returns whether the key may be used for the given
+// operation, e.g. "encrypt" or "sign".
+func (p *Policy) OperationAllowed(op string) bool {
+	if len(p.AllowedOperations) == 0 {
+		return true
+	}
+	for _, allowed := range p.AllowedOperations {
+		if allowed == op {
+			return true
+		}
+	}
+	return false
+}
+
+// NextRotationTime returns when the policy is next due to be automatically
+// rotated, based on the creation time of the latest key version. A zero time
+// is returned if automatic rotation is disabled.
+func (p *Policy) NextRotationTime() time.Time {
+	if p.AutoRotatePeriod <= 0 {
+		return time.Time{}
+	}
+
+	latest := p.Keys[p.LatestVersion]
+	creationTime := latest.CreationTime
+	if creationTime.IsZero() {
+		creationTime = time.Unix(latest.DeprecatedCreationTime, 0)
+	}
+
+	return creationTime.Add(p.AutoRotatePeriod)
 }
 
 func (p *Policy) MigrateKeyToKeysMap() {
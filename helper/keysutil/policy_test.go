@@ -1,9 +1,19 @@
 package keysutil
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"reflect"
+	"strings"
 	"testing"
 
+	"github.com/hashicorp/vault/helper/errutil"
+	"github.com/hashicorp/vault/helper/jsonutil"
 	"github.com/hashicorp/vault/logical"
 )
 
@@ -146,7 +156,7 @@ func testArchivingUpgradeCommon(t *testing.T, lm *LockManager) {
 	}
 
 	// First we'll do this wrong, by not setting the deletion flag
-	err = lm.DeletePolicy(storage, "test")
+	err = lm.DeletePolicy(storage, "test", false)
 	if err == nil {
 		t.Fatal("got nil error, but should not have been able to delete since we didn't set the deletion flag on the policy")
 	}
@@ -171,7 +181,7 @@ func testArchivingUpgradeCommon(t *testing.T, lm *LockManager) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = lm.DeletePolicy(storage, "test")
+	err = lm.DeletePolicy(storage, "test", false)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -349,3 +359,380 @@ func checkKeys(t *testing.T,
 		}
 	}
 }
+
+func Test_DerivedKeyFingerprint(t *testing.T) {
+	storage := &logical.InmemStorage{}
+	lm := NewLockManager(false)
+	p, lock, _, err := lm.GetPolicyUpsert(PolicyRequest{
+		Storage: storage,
+		KeyType: KeyType_AES256_GCM96,
+		Name:    "test",
+		Derived: true,
+	})
+	if lock != nil {
+		defer lock.RUnlock()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	context := []byte("context")
+	fingerprint, err := p.DerivedKeyFingerprint(context, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The fingerprint must not expose the derived key or a plain hash of it
+	derived, err := p.DeriveKey(context, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := sha256.Sum256(derived)
+	if strings.Contains(fingerprint, hex.EncodeToString(derived)) || fingerprint == hex.EncodeToString(hash[:]) {
+		t.Fatal("fingerprint leaks the derived key")
+	}
+
+	if _, err := p.DerivedKeyFingerprint(nil, 1); err == nil {
+		t.Fatal("expected error without a context")
+	}
+
+	p.Derived = false
+	if _, err := p.DerivedKeyFingerprint(context, 1); err == nil {
+		t.Fatal("expected error for a non-derived key")
+	}
+}
+
+func Test_FingerprintUpgrade(t *testing.T) {
+	storage := &logical.InmemStorage{}
+	p, lock, _, err := NewLockManager(true).GetPolicyUpsert(PolicyRequest{
+		Storage: storage,
+		KeyType: KeyType_AES256_GCM96,
+		Name:    "test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock.RUnlock()
+	if p.Fingerprint == "" {
+		t.Fatal("expected a fingerprint on a new key")
+	}
+
+	// Simulate a key created before fingerprints existed
+	p.Fingerprint = ""
+	if err := p.Persist(storage); err != nil {
+		t.Fatal(err)
+	}
+
+	load := func() *Policy {
+		p, lock, err := NewLockManager(true).GetPolicyShared(storage, "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer lock.RUnlock()
+		return p
+	}
+	stored := func() string {
+		raw, err := storage.Get("policy/test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		stored := Policy{
+			Keys: keyEntryMap{},
+		}
+		if err := jsonutil.DecodeJSON(raw.Value, &stored); err != nil {
+			t.Fatal(err)
+		}
+		return stored.Fingerprint
+	}
+
+	// The fingerprint is the same on every load but loading does not write it
+	fingerprint := load().Fingerprint
+	if fingerprint == "" {
+		t.Fatal("expected a fingerprint to be assigned on load")
+	}
+	if load().Fingerprint != fingerprint {
+		t.Fatal("fingerprint changed between loads")
+	}
+	if stored() != "" {
+		t.Fatal("expected loading not to write the fingerprint")
+	}
+
+	// It survives rotation and is written along with it
+	p = load()
+	if err := p.Rotate(storage); err != nil {
+		t.Fatal(err)
+	}
+	if stored() != fingerprint || load().Fingerprint != fingerprint {
+		t.Fatal("fingerprint was not persisted unchanged on rotation")
+	}
+}
+
+func Test_ChaChaCiphertextPrefix(t *testing.T) {
+	storage := &logical.InmemStorage{}
+	lm := NewLockManager(false)
+
+	policies := map[KeyType]*Policy{}
+	for _, keyType := range []KeyType{KeyType_ChaCha20_Poly1305, KeyType_AES256_GCM96} {
+		p, lock, _, err := lm.GetPolicyUpsert(PolicyRequest{
+			Storage: storage,
+			KeyType: keyType,
+			Name:    keyType.String(),
+		})
+		if lock != nil {
+			lock.RUnlock()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		policies[keyType] = p
+	}
+	chacha := policies[KeyType_ChaCha20_Poly1305]
+	aes := policies[KeyType_AES256_GCM96]
+
+	plaintext := "dGhlIHF1aWNrIGJyb3duIGZveA=="
+	chachaCiphertext, err := chacha.Encrypt(0, nil, nil, plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(chachaCiphertext, "vault:cc:v1:") {
+		t.Fatalf("bad chacha20-poly1305 ciphertext prefix: %s", chachaCiphertext)
+	}
+	aesCiphertext, err := aes.Encrypt(0, nil, nil, plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(aesCiphertext, "vault:v1:") {
+		t.Fatalf("bad aes256-gcm96 ciphertext prefix: %s", aesCiphertext)
+	}
+
+	if decrypted, err := chacha.Decrypt(nil, nil, chachaCiphertext); err != nil || decrypted != plaintext {
+		t.Fatalf("bad chacha20-poly1305 decryption: %q (err: %v)", decrypted, err)
+	}
+
+	// Ciphertext is rejected by keys of the other type, even when it is
+	// relabeled with the prefix of the other type
+	relabeled := map[*Policy]string{
+		aes:    strings.Replace(chachaCiphertext, "vault:cc:v", "vault:v", 1),
+		chacha: strings.Replace(aesCiphertext, "vault:v", "vault:cc:v", 1),
+	}
+	for p, ciphertext := range map[*Policy]string{aes: chachaCiphertext, chacha: aesCiphertext} {
+		_, err := p.Decrypt(nil, nil, ciphertext)
+		if err == nil || !strings.Contains(err.Error(), "different type") {
+			t.Fatalf("%s: expected key type error, got %v", p.Type, err)
+		}
+		if _, err := p.Decrypt(nil, nil, relabeled[p]); err == nil {
+			t.Fatalf("%s: expected relabeled ciphertext not to decrypt", p.Type)
+		}
+	}
+
+	// Both kinds of ciphertext can be parsed without a key
+	for _, ciphertext := range []string{chachaCiphertext, aesCiphertext} {
+		if ver, _, err := ParseCiphertext(ciphertext); err != nil || ver != 1 {
+			t.Fatalf("bad parsed version %d of %s (err: %v)", ver, ciphertext, err)
+		}
+	}
+}
+
+func Test_Ed448(t *testing.T) {
+	// The "1 octet" test vector for Ed448 from RFC 8032, section 7.4
+	seed, _ := hex.DecodeString("c4eab05d357007c632f3dbb48489924d552b08fe0c353a0d4a1f00acda2c463afbea67c5e8d2877c5e3bc397a659949ef8021e954e0a12274e")
+	pubKey, _ := hex.DecodeString("43ba28f430cdff456ae531545f7ecd0ac834a55d9358c0372bfa0c6c6798c0866aea01eb00742802b8438ea4cb82169c235160627b4c3a9480")
+	message := []byte{0x03}
+	signature, _ := hex.DecodeString("26b8f91727bd62897af15e41eb43c377efb9c610d48f2335cb0bd0087810f4352541b143c4b981b7e18f62de8ccdf633fc1bf037ab7cd779805e0dbcc0aae1cbcee1afb2e027df36bc04dcecbf154336c19f0af7e0a6472905e799f1953d2a0ff3348ab21aa4adafd1d234441cf807c03a00")
+
+	encodedSeed, err := asn1.Marshal(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8, err := asn1.Marshal(ed448PKCS8{
+		Algo:       pkix.AlgorithmIdentifier{Algorithm: oidEd448},
+		PrivateKey: encodedSeed,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storage := &logical.InmemStorage{}
+	lm := NewLockManager(false)
+	if err := lm.ImportPolicy(PolicyRequest{
+		Storage: storage,
+		KeyType: KeyType_ED448,
+		Name:    "vector",
+	}, pkcs8); err != nil {
+		t.Fatal(err)
+	}
+	p, lock, err := lm.GetPolicyShared(storage, "vector")
+	if lock != nil {
+		defer lock.RUnlock()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.Keys[1].FormattedPublicKey != base64.StdEncoding.EncodeToString(pubKey) {
+		t.Fatalf("bad public key: %s", p.Keys[1].FormattedPublicKey)
+	}
+	sig, err := p.Sign(0, nil, message, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sig.Signature != "vault:v1:"+base64.StdEncoding.EncodeToString(signature) {
+		t.Fatalf("signature does not match the test vector: %s", sig.Signature)
+	}
+	if valid, err := p.VerifySignature(nil, message, sig.Signature, ""); err != nil || !valid {
+		t.Fatalf("expected the test vector to verify (err: %v)", err)
+	}
+	if valid, err := p.VerifySignature(nil, []byte{0x04}, sig.Signature, ""); err != nil || valid {
+		t.Fatalf("expected a signature of another message not to verify (err: %v)", err)
+	}
+
+	// Key material of another type is rejected
+	if err := lm.ImportPolicy(PolicyRequest{
+		Storage: storage,
+		KeyType: KeyType_ED448,
+		Name:    "wrong",
+	}, encodedSeed); err == nil {
+		t.Fatal("expected error importing a bare seed")
+	}
+
+	// Derived keys sign with a key per context
+	derived, lock, _, err := lm.GetPolicyUpsert(PolicyRequest{
+		Storage: storage,
+		KeyType: KeyType_ED448,
+		Name:    "derived",
+		Derived: true,
+	})
+	if lock != nil {
+		defer lock.RUnlock()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	sigA, err := derived.Sign(0, []byte("context-a"), message, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sigB, err := derived.Sign(0, []byte("context-b"), message, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(sigA.PublicKey, sigB.PublicKey) {
+		t.Fatal("expected a public key per context")
+	}
+	if valid, err := derived.VerifySignature([]byte("context-a"), message, sigA.Signature, ""); err != nil || !valid {
+		t.Fatalf("expected the signature to verify with its context (err: %v)", err)
+	}
+	if valid, err := derived.VerifySignature([]byte("context-b"), message, sigA.Signature, ""); err != nil || valid {
+		t.Fatalf("expected the signature not to verify with another context (err: %v)", err)
+	}
+}
+
+// countingStorage counts the writes to the wrapped storage, and fails the
+// writes of policies while failPolicyPuts is set
+type countingStorage struct {
+	logical.InmemStorage
+	puts           int
+	failPolicyPuts bool
+}
+
+func (s *countingStorage) Put(entry *logical.StorageEntry) error {
+	if s.failPolicyPuts && strings.HasPrefix(entry.Key, "policy/") {
+		return errors.New("failed to write policy")
+	}
+	s.puts++
+	return s.InmemStorage.Put(entry)
+}
+
+func Test_CreateWithNumVersions(t *testing.T) {
+	storage := &countingStorage{}
+	lm := NewLockManager(false)
+	req := PolicyRequest{
+		Storage:     storage,
+		KeyType:     KeyType_AES256_GCM96,
+		Name:        "test",
+		NumVersions: 5,
+	}
+
+	// Too many versions are refused before anything is generated
+	req.NumVersions = MaxInitialVersions + 1
+	_, _, _, err := lm.GetPolicyUpsert(req)
+	if _, ok := err.(errutil.UserError); !ok {
+		t.Fatalf("expected a user error, got %v", err)
+	}
+	if storage.puts != 0 {
+		t.Fatalf("expected no writes, got %d", storage.puts)
+	}
+
+	// A failure to store the key leaves no key behind
+	req.NumVersions = 5
+	storage.failPolicyPuts = true
+	if _, _, _, err := lm.GetPolicyUpsert(req); err == nil {
+		t.Fatal("expected the creation to fail")
+	}
+	if raw, err := storage.Get("policy/test"); err != nil || raw != nil {
+		t.Fatalf("expected no stored policy, got %#v (err: %v)", raw, err)
+	}
+
+	// All versions are written at once, so a retry creates the whole key
+	storage.failPolicyPuts = false
+	storage.puts = 0
+	p, lock, upserted, err := lm.GetPolicyUpsert(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock.RUnlock()
+	if !upserted || p.LatestVersion != 5 || p.MinDecryptionVersion != 1 || len(p.Keys) != 5 {
+		t.Fatalf("bad policy: upserted %t, latest version %d, min decryption version %d, %d keys", upserted, p.LatestVersion, p.MinDecryptionVersion, len(p.Keys))
+	}
+	if storage.puts != 2 {
+		t.Fatalf("expected the archive and the policy to be written once, got %d writes", storage.puts)
+	}
+	archive, err := p.LoadArchive(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ver := 1; ver <= 5; ver++ {
+		if !bytes.Equal(archive.Keys[ver].Key, p.Keys[ver].Key) || len(p.Keys[ver].Key) != 32 {
+			t.Fatalf("bad archived version %d", ver)
+		}
+	}
+}
+
+func Test_DeleteRequireDecommission(t *testing.T) {
+	storage := &logical.InmemStorage{}
+	lm := NewLockManager(true)
+	p, lock, _, err := lm.GetPolicyUpsert(PolicyRequest{
+		Storage:     storage,
+		KeyType:     KeyType_AES256_GCM96,
+		Name:        "test",
+		NumVersions: 3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock.RUnlock()
+	p.DeletionAllowed = true
+	if err := p.Persist(storage); err != nil {
+		t.Fatal(err)
+	}
+
+	// Older versions can still be decrypted, so the key is not decommissioned
+	err = lm.DeletePolicy(storage, "test", true)
+	if _, ok := err.(errutil.UserError); !ok || !strings.Contains(err.Error(), "decommissioned") {
+		t.Fatalf("expected a decommission error, got %v", err)
+	}
+	if raw, err := storage.Get("policy/test"); err != nil || raw == nil {
+		t.Fatalf("expected the policy to still be stored (err: %v)", err)
+	}
+
+	p.MinDecryptionVersion = 3
+	if err := p.Persist(storage); err != nil {
+		t.Fatal(err)
+	}
+	if err := lm.DeletePolicy(storage, "test", true); err != nil {
+		t.Fatal(err)
+	}
+	if raw, err := storage.Get("policy/test"); err != nil || raw != nil {
+		t.Fatalf("expected the policy to be deleted (err: %v)", err)
+	}
+}
@@ -276,6 +276,12 @@ var (
 	// ErrPermissionDenied is returned if the client is not authorized
 	ErrPermissionDenied = errors.New("permission denied")
 
+	// ErrRateLimited is returned if the request exceeds a rate limit
+	ErrRateLimited = errors.New("rate limit exceeded")
+
+	// ErrConflict is returned if the request conflicts with an earlier one
+	ErrConflict = errors.New("conflict")
+
 	// ErrMultiAuthzPending is returned if the the request needs more
 	// authorizations
 	ErrMultiAuthzPending = errors.New("request needs further approval")
@@ -80,6 +80,10 @@ func RespondErrorCommon(req *Request, resp *Response, err error) (int, error) {
 			statusCode = http.StatusNotFound
 		case errwrap.Contains(err, ErrInvalidRequest.Error()):
 			statusCode = http.StatusBadRequest
+		case errwrap.Contains(err, ErrRateLimited.Error()):
+			statusCode = http.StatusTooManyRequests
+		case errwrap.Contains(err, ErrConflict.Error()):
+			statusCode = http.StatusConflict
 		}
 	}
 